@@ -43,14 +43,19 @@ globalStatement
 declaration
     :   decl (NEWLINE|SEMICOLON)
     |   IMPORT MODULENAME MODULENAMEEND
-    |   INLINE? FUNC IDENT LEFTPARA (IDENT (COMMA IDENT)* )? RIGHTPARA VOID? block NEWLINE
+    |   (INLINE|testModifier)? FUNC IDENT LEFTPARA (IDENT (COMMA IDENT)* )? RIGHTPARA VOID? block NEWLINE
     |   NEWLINE|SEMICOLON
     ;
 
+// 'test' is not a keyword to keep it available for names
+testModifier
+    :   IDENT
+    ;
+
 // named rules for tree-walking only
 condition
     :   IF condIfExpr condTrueBlock (NEWLINE? ELSE condFalseBlock)?   # IfStatement
-    |   FOR condForExpr condTrueBlock                                 # ForStatement
+    |   (IDENT COLON)? FOR condForExpr condTrueBlock                  # ForStatement
     ;
 
 condTrueBlock
@@ -73,7 +78,8 @@ termination
     :   ERR (NEWLINE|SEMICOLON)                     # TermError
     |   RET expr? (NEWLINE|SEMICOLON)               # TermReturn
     |   ASSERT LEFTPARA expr RIGHTPARA              # TermAssert
-    |   BREAK (NEWLINE|SEMICOLON)                   # Break
+    |   BREAK IDENT? (NEWLINE|SEMICOLON)            # Break
+    |   CONTINUE IDENT? (NEWLINE|SEMICOLON)         # Continue
     ;
 
 decl
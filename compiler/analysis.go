@@ -0,0 +1,578 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Source analysis for editors
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/algorand/go-algorand/data/transactions/logic"
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+
+	gen "github.com/pzbitskiy/tealang/gen/go"
+	"github.com/pzbitskiy/tealang/stdlib"
+)
+
+type symbolKind int
+
+const (
+	variableSymbol symbolKind = iota
+	argumentSymbol
+	constantSymbol
+	functionSymbol
+	moduleSymbol
+)
+
+// symbol is an occurrence of a name in sources resolved to its declaration
+type symbol struct {
+	name   string
+	kind   symbolKind
+	start  SourceLocation
+	end    int // column right after the name
+	decl   SourceLocation
+	detail string     // function signature
+	ctx    *context   // scope the name is used in
+	node   ExprNodeIf // expression of the reference if any
+}
+
+// symbolTable collects declarations and references during parsing, see Analyze
+type symbolTable struct {
+	declarations map[*context]map[string]symbol
+	symbols      []symbol
+	files        map[string]string // source name to file path
+	modules      map[string]string // module name to source name
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{
+		declarations: make(map[*context]map[string]symbol),
+		files:        make(map[string]string),
+		modules:      make(map[string]string),
+	}
+}
+
+// addModule remembers where the imported module comes from.
+// Standard library modules are embedded and do not have files
+func (t *symbolTable) addModule(moduleName string, input InputDesc) {
+	t.modules[moduleName] = input.SourceFile
+	if input.SourceDir != "" && !strings.HasPrefix(moduleName, stdlib.StdLibName) {
+		t.files[input.SourceFile] = path.Join(input.SourceDir, input.SourceFile)
+	}
+}
+
+func tokenLocation(parser antlr.Parser, token antlr.Token) SourceLocation {
+	return SourceLocation{parser.GetTokenStream().GetSourceName(), token.GetLine(), token.GetColumn()}
+}
+
+// declareSymbol records a declaration of the name in the scope
+func (ctx *context) declareSymbol(name string, kind symbolKind, detail string, parser antlr.Parser, token antlr.Token) {
+	table := ctx.symbols
+	if table == nil {
+		return
+	}
+	loc := tokenLocation(parser, token)
+	sym := symbol{name: name, kind: kind, start: loc, end: loc.Column + len(token.GetText()), decl: loc, detail: detail, ctx: ctx}
+	scope, ok := table.declarations[ctx]
+	if !ok {
+		scope = make(map[string]symbol)
+		table.declarations[ctx] = scope
+	}
+	scope[name] = sym
+	table.symbols = append(table.symbols, sym)
+}
+
+// funcSignature renders function declaration header like "inline function f(a, b)"
+func funcSignature(ctx *gen.DeclarationContext) string {
+	idents := ctx.AllIDENT()
+	args := make([]string, 0, len(idents)-1)
+	for _, ident := range idents[1:] {
+		args = append(args, ident.GetText())
+	}
+	keyword := "function"
+	if ctx.INLINE() != nil {
+		keyword = "inline function"
+	} else if ctx.TestModifier() != nil {
+		keyword = "test function"
+	}
+	return fmt.Sprintf("%s %s(%s)", keyword, idents[0].GetText(), strings.Join(args, ", "))
+}
+
+// referSymbol records a use of the name resolving it to the closest declaration
+func (ctx *context) referSymbol(name string, node ExprNodeIf, parser antlr.Parser, token antlr.Token) {
+	table := ctx.symbols
+	if table == nil {
+		return
+	}
+	loc := tokenLocation(parser, token)
+	sym := symbol{name: name, start: loc, end: loc.Column + len(token.GetText()), ctx: ctx, node: node}
+	for current := ctx; current != nil; current = current.parent {
+		if info, ok := current.vars[name]; ok {
+			if decl, ok := table.declarations[current][name]; ok {
+				sym.kind, sym.decl, sym.detail = decl.kind, decl.decl, decl.detail
+			} else if info.function() {
+				sym.kind = functionSymbol
+			} else if info.constant() {
+				sym.kind = constantSymbol
+			}
+			break
+		}
+	}
+	table.symbols = append(table.symbols, sym)
+}
+
+// referModule records an import statement pointing to the module file
+func (ctx *context) referModule(moduleName string, parser antlr.Parser, token antlr.Token) {
+	table := ctx.symbols
+	if table == nil {
+		return
+	}
+	loc := tokenLocation(parser, token)
+	sym := symbol{name: moduleName, kind: moduleSymbol, start: loc, end: loc.Column + len(token.GetText()), ctx: ctx}
+	if file, ok := table.modules[moduleName]; ok {
+		sym.decl = SourceLocation{File: file, Line: 1}
+	}
+	table.symbols = append(table.symbols, sym)
+}
+
+// Analysis is a parsed program with names resolved, it backs editor features like the language server
+type Analysis struct {
+	Diagnostics []ParserError
+
+	input   InputDesc
+	version int
+	table   *symbolTable
+	tokens  []antlr.Token
+}
+
+// Completion is a member of a builtin object like txn or global
+type Completion struct {
+	Label         string
+	Detail        string // type of the value if known
+	Documentation string
+	Method        bool
+}
+
+// Analyze parses the program and collects diagnostics and symbols without generating code.
+// Function bodies are parsed at call sites so names inside never called functions are not resolved
+func Analyze(input InputDesc, opts Options) *Analysis {
+	version := opts.TEALVersion
+	if version < minTealVersion || version > tealVersion() {
+		version = defaultTealVersion()
+	}
+	analysis := &Analysis{input: input, version: version, table: newSymbolTable()}
+	analysis.table.files[input.SourceFile] = path.Join(input.SourceDir, input.SourceFile)
+
+	entry := programRule
+	if opts.OneLiner {
+		entry = oneLineCondRule
+	}
+	var collector *errorCollector
+	_, errors := parse(input, entry, func(parseCtx *parseContext) {
+		parseCtx.moduleResolver = opts.Resolver
+		parseCtx.version = version
+		parseCtx.symbols = analysis.table
+		collector = parseCtx.collector
+	})
+	analysis.Diagnostics = errors
+	if collector != nil {
+		analysis.Diagnostics = append(analysis.Diagnostics, collector.sortedWarnings()...)
+	}
+
+	lexer := gen.NewTealangLexer(antlr.NewInputStream(input.Source))
+	lexer.RemoveErrorListeners()
+	stream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
+	stream.Fill()
+	for _, token := range stream.GetAllTokens() {
+		if token.GetChannel() == antlr.TokenDefaultChannel && token.GetTokenType() != antlr.TokenEOF {
+			analysis.tokens = append(analysis.tokens, token)
+		}
+	}
+	return analysis
+}
+
+// symbolAt finds a name in the input at 1-based line and 0-based column
+func (a *Analysis) symbolAt(line int, column int) (symbol, bool) {
+	for _, sym := range a.table.symbols {
+		if sym.start.File == a.input.SourceFile && sym.start.Line == line && sym.start.Column <= column && column < sym.end {
+			return sym, true
+		}
+	}
+	return symbol{}, false
+}
+
+// Definition returns location of a declaration of the function, variable, constant or module
+// at 1-based line and 0-based column. File of the location is a path to the source file
+func (a *Analysis) Definition(line int, column int) (SourceLocation, bool) {
+	sym, ok := a.symbolAt(line, column)
+	if !ok || sym.decl.Line == 0 {
+		return SourceLocation{}, false
+	}
+	file, ok := a.table.files[sym.decl.File]
+	if !ok {
+		return SourceLocation{}, false
+	}
+	loc := sym.decl
+	loc.File = file
+	return loc, true
+}
+
+// Hover describes a name at 1-based line and 0-based column in markdown.
+// Program names show their inferred types, builtins show opcode and field docs
+func (a *Analysis) Hover(line int, column int) (string, bool) {
+	if sym, ok := a.symbolAt(line, column); ok {
+		return a.describeSymbol(sym), true
+	}
+	idx := a.tokenAt(line, column)
+	if idx < 0 {
+		return "", false
+	}
+	token := a.tokens[idx]
+	if idx > 0 && a.tokens[idx-1].GetTokenType() == gen.TealangLexerDOT {
+		object := a.objectBefore(idx - 1)
+		for _, member := range builtinMembers(object, a.version) {
+			if member.name == token.GetText() {
+				return member.describe(object), true
+			}
+		}
+		return "", false
+	}
+	if idx+1 < len(a.tokens) && a.tokens[idx+1].GetTokenType() == gen.TealangLexerLEFTPARA {
+		return describeBuiltinFunction(token.GetText())
+	}
+	return "", false
+}
+
+// Completion lists members of a builtin object before the dot at 1-based line and 0-based column.
+// Members not available in the target TEAL version are skipped
+func (a *Analysis) Completion(line int, column int) []Completion {
+	idx := -1
+	for i, token := range a.tokens {
+		end := token.GetColumn() + len(token.GetText())
+		if token.GetLine() < line || token.GetLine() == line && end <= column {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil
+	}
+	prefix := ""
+	token := a.tokens[idx]
+	if token.GetTokenType() != gen.TealangLexerDOT {
+		// partially typed member name right before the cursor
+		if idx == 0 || token.GetLine() != line || token.GetColumn()+len(token.GetText()) != column ||
+			a.tokens[idx-1].GetTokenType() != gen.TealangLexerDOT {
+			return nil
+		}
+		prefix = token.GetText()
+		idx--
+	}
+	object := a.objectBefore(idx)
+	completions := make([]Completion, 0)
+	for _, member := range builtinMembers(object, a.version) {
+		if !strings.HasPrefix(member.name, prefix) {
+			continue
+		}
+		completions = append(completions, Completion{member.name, member.typeName(), member.doc(), member.method})
+	}
+	return completions
+}
+
+func (a *Analysis) tokenAt(line int, column int) int {
+	for i, token := range a.tokens {
+		if token.GetLine() == line && token.GetColumn() <= column && column < token.GetColumn()+len(token.GetText()) {
+			return i
+		}
+	}
+	return -1
+}
+
+// objectBefore returns builtin object name like txn or accounts preceding the dot token
+func (a *Analysis) objectBefore(dot int) string {
+	idx := dot - 1
+	if idx < 0 {
+		return ""
+	}
+	if a.tokens[idx].GetTokenType() == gen.TealangLexerRIGHTSQUARE {
+		depth := 0
+		for ; idx >= 0; idx-- {
+			switch a.tokens[idx].GetTokenType() {
+			case gen.TealangLexerRIGHTSQUARE:
+				depth++
+			case gen.TealangLexerLEFTSQUARE:
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+		}
+		idx--
+		if idx < 0 {
+			return ""
+		}
+		switch a.tokens[idx].GetTokenType() {
+		case gen.TealangLexerGTXN:
+			return "gtxn"
+		case gen.TealangLexerGINNERTXN:
+			return "gitxn"
+		case gen.TealangLexerACCOUNTS:
+			return "accounts"
+		case gen.TealangLexerAPPS:
+			return "apps"
+		case gen.TealangLexerASSETS:
+			return "assets"
+		}
+		return ""
+	}
+	switch a.tokens[idx].GetTokenType() {
+	case gen.TealangLexerTXN:
+		return "txn"
+	case gen.TealangLexerGLOBAL:
+		return "global"
+	case gen.TealangLexerINNERTXN:
+		return "itxn"
+	}
+	return ""
+}
+
+func (a *Analysis) describeSymbol(sym symbol) string {
+	var signature string
+	switch sym.kind {
+	case moduleSymbol:
+		signature = fmt.Sprintf("import %s", sym.name)
+	case functionSymbol:
+		signature = sym.detail
+		if signature == "" {
+			signature = fmt.Sprintf("function %s", sym.name)
+		}
+		// return type is known once the function is called
+		for _, other := range a.table.symbols {
+			if other.decl == sym.decl && other.node != nil {
+				if theType, err := other.node.getType(); err == nil && theType != unknownType {
+					signature = fmt.Sprintf("%s: %s", signature, theType)
+				}
+				break
+			}
+		}
+	default:
+		theType := unknownType
+		var value string
+		if sym.node != nil {
+			theType, _ = sym.node.getType()
+		}
+		if info, err := sym.ctx.lookup(sym.name); err == nil {
+			if theType == unknownType {
+				theType = info.theType
+			}
+			if info.constant() && info.value != nil {
+				value = *info.value
+			}
+		}
+		keyword := "let"
+		if sym.kind == constantSymbol {
+			keyword = "const"
+		} else if sym.kind == argumentSymbol {
+			keyword = "argument"
+		}
+		signature = fmt.Sprintf("%s %s: %s", keyword, sym.name, theType)
+		if value != "" {
+			signature = fmt.Sprintf("%s = %s", signature, value)
+		}
+	}
+	return fmt.Sprintf("```\n%s\n```", signature)
+}
+
+// describeBuiltinFunction documents builtin function by its opcode spec
+func describeBuiltinFunction(name string) (string, bool) {
+	node := &funCallNode{name: name}
+	if _, ok := langOps[name]; !ok {
+		// byte arithmetic names are remapped to opcodes
+		if remapper, ok := builtinFunRemap[name]; ok {
+			remapper(node)
+		}
+	}
+	op, ok := langOps[node.name]
+	if !ok {
+		return "", false
+	}
+	signature := name + "()"
+	if len(op.Args) > 0 {
+		signature = name + "(...)"
+	}
+	if theType, err := opTypeFromSpec(node.name, 0); err == nil && theType != unknownType {
+		signature = fmt.Sprintf("%s: %s", signature, theType)
+	}
+	return fmt.Sprintf("```\n%s\n```\n%s\n\nTEAL opcode `%s`, v%d+", signature, op.Doc, node.name, op.IntroducedVersion), true
+}
+
+// builtinMember is a field or a method of a builtin object and the opcode it is compiled to
+type builtinMember struct {
+	name   string
+	op     string
+	field  string
+	method bool
+}
+
+// builtinMethods lists members of builtin objects not following opcode fields
+var builtinMethods = map[string][]builtinMember{
+	"accounts": {
+		{"Balance", "balance", "", false},
+		{"MinimumBalance", "min_balance", "", false},
+		{"optedIn", "app_opted_in", "", true},
+		{"get", "app_local_get", "", true},
+		{"getEx", "app_local_get_ex", "", true},
+		{"put", "app_local_put", "", true},
+		{"del", "app_local_del", "", true},
+		{"assetBalance", "asset_holding_get", "AssetBalance", true},
+		{"assetIsFrozen", "asset_holding_get", "AssetFrozen", true},
+	},
+	"apps": {
+		{"get", "app_global_get", "", true},
+		{"getEx", "app_global_get_ex", "", true},
+		{"put", "app_global_put", "", true},
+		{"del", "app_global_del", "", true},
+	},
+	"itxn": {
+		{"begin", "itxn_begin", "", true},
+		{"next", "itxn_next", "", true},
+		{"submit", "itxn_submit", "", true},
+	},
+}
+
+// builtinFieldSource describes opcode fields exposed as members of a builtin object.
+// Fields are checked against lexer tokens so only names known to the grammar are offered
+type builtinFieldSource struct {
+	op     string
+	tokens []int
+	method bool // accessed as a method call
+	lower  bool // member name starts with lower case letter unlike the field
+}
+
+var builtinFields = map[string][]builtinFieldSource{
+	"txn":      {{"txn", []int{gen.TealangLexerTXNFIELD, gen.TealangLexerTXNARRAYFIELD}, false, false}},
+	"gtxn":     {{"gtxn", []int{gen.TealangLexerTXNFIELD, gen.TealangLexerTXNARRAYFIELD}, false, false}},
+	"itxn":     {{"itxn", []int{gen.TealangLexerTXNFIELD, gen.TealangLexerTXNARRAYFIELD}, false, false}},
+	"gitxn":    {{"gitxn", []int{gen.TealangLexerTXNFIELD, gen.TealangLexerTXNARRAYFIELD}, false, false}},
+	"global":   {{"global", []int{gen.TealangLexerGLOBALFIELD}, false, false}},
+	"accounts": {{"acct_params_get", []int{gen.TealangLexerACCTPARAMS}, true, true}},
+	"apps":     {{"app_params_get", []int{gen.TealangLexerAPPPARAMSFIELDS}, true, true}},
+	"assets":   {{"asset_params_get", []int{gen.TealangLexerASSETPARAMSFIELDS}, false, false}},
+}
+
+// builtinMembers lists members of the builtin object available in TEAL version
+func builtinMembers(object string, version int) []builtinMember {
+	members := make([]builtinMember, 0, 64)
+	for _, member := range builtinMethods[object] {
+		if checkOpVersion(version, member.op, member.field) == nil {
+			members = append(members, member)
+		}
+	}
+	for _, source := range builtinFields[object] {
+		op, ok := langOps[source.op]
+		if !ok || checkOpVersion(version, source.op, "") != nil {
+			continue
+		}
+		for _, field := range op.ArgEnum {
+			name := field
+			if source.lower {
+				name = strings.ToLower(field[:1]) + field[1:]
+			}
+			if !lexedAs(name, source.tokens) || checkOpVersion(version, source.op, field) != nil {
+				continue
+			}
+			members = append(members, builtinMember{name, source.op, field, source.method})
+		}
+	}
+	return members
+}
+
+// lexedAs checks the word is a single token of one of the types
+func lexedAs(word string, types []int) bool {
+	lexer := gen.NewTealangLexer(antlr.NewInputStream(word))
+	lexer.RemoveErrorListeners()
+	token := lexer.NextToken()
+	if len(token.GetText()) != len(word) {
+		return false
+	}
+	for _, tokenType := range types {
+		if token.GetTokenType() == tokenType {
+			return true
+		}
+	}
+	return false
+}
+
+func (m builtinMember) typeName() string {
+	var theType exprType
+	var err error
+	if m.field != "" {
+		theType, err = runtimeFieldTypeFromSpec(m.op, m.field)
+	} else {
+		theType, err = opTypeFromSpec(m.op, 0)
+	}
+	if err != nil || theType == unknownType {
+		return ""
+	}
+	return theType.String()
+}
+
+func (m builtinMember) doc() string {
+	if note := fieldNote(m.op, m.field); note != "" {
+		return note
+	}
+	return langOps[m.op].Doc
+}
+
+func (m builtinMember) describe(object string) string {
+	switch object {
+	case "gtxn", "gitxn", "accounts", "apps", "assets":
+		object += "[i]"
+	}
+	signature := fmt.Sprintf("%s.%s", object, m.name)
+	if m.method {
+		signature += "(...)"
+	}
+	if typeName := m.typeName(); typeName != "" {
+		signature = fmt.Sprintf("%s: %s", signature, typeName)
+	}
+	opName := m.op
+	if m.field != "" {
+		opName = fmt.Sprintf("%s %s", m.op, m.field)
+	}
+	return fmt.Sprintf("```\n%s\n```\n%s\n\nTEAL `%s`", signature, m.doc(), opName)
+}
+
+// fieldNote returns description of the opcode field from the assembler specs
+func fieldNote(op string, field string) string {
+	switch op {
+	case "txn", "gtxn", "itxn", "gitxn":
+		if spec, ok := logic.TxnFieldSpecByName[field]; ok {
+			return spec.Note()
+		}
+	case "global":
+		if spec, ok := logic.GlobalFieldSpecByName[field]; ok {
+			return spec.Note()
+		}
+	case "asset_holding_get":
+		if spec, ok := logic.AssetHoldingFieldSpecByName[field]; ok {
+			return spec.Note()
+		}
+	case "asset_params_get":
+		if spec, ok := logic.AssetParamsFieldSpecByName[field]; ok {
+			return spec.Note()
+		}
+	case "app_params_get":
+		if spec, ok := logic.AppParamsFieldSpecByName[field]; ok {
+			return spec.Note()
+		}
+	case "acct_params_get":
+		if spec, ok := logic.AcctParamsFieldSpecByName[field]; ok {
+			return spec.Note()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalysisDefinition(t *testing.T) {
+	a := require.New(t)
+
+	source := `import mymodule
+const c = 3
+function sum(x, y) { return x + y; }
+function logic() {
+	let a = sum(c, test())
+	a = a + 1
+	return a
+}
+`
+	resolver := func(moduleName string, sourceDir string, currentDir string) (InputDesc, error) {
+		return InputDesc{Source: "function test() {\n\treturn 2\n}\n", SourceFile: "mymodule.tl", SourceDir: "/lib"}, nil
+	}
+	analysis := Analyze(InputDesc{Source: source, SourceFile: "main.tl", SourceDir: "/src"}, Options{Resolver: resolver})
+	a.Empty(analysis.Diagnostics)
+
+	tests := []struct {
+		line     int
+		column   int
+		expected SourceLocation
+	}{
+		{5, 10, SourceLocation{"/src/main.tl", 3, 9}},     // sum call
+		{5, 13, SourceLocation{"/src/main.tl", 2, 6}},     // constant
+		{5, 17, SourceLocation{"/lib/mymodule.tl", 1, 9}}, // module function
+		{6, 1, SourceLocation{"/src/main.tl", 5, 5}},      // assignment
+		{7, 8, SourceLocation{"/src/main.tl", 5, 5}},      // variable
+		{3, 28, SourceLocation{"/src/main.tl", 3, 13}},    // argument
+		{1, 9, SourceLocation{"/lib/mymodule.tl", 1, 0}},  // import
+	}
+	for _, test := range tests {
+		loc, ok := analysis.Definition(test.line, test.column)
+		a.True(ok, "%d:%d", test.line, test.column)
+		a.Equal(test.expected, loc, "%d:%d", test.line, test.column)
+	}
+
+	_, ok := analysis.Definition(5, 1)
+	a.False(ok)
+
+	// standard library modules have no files to go to
+	analysis = Analyze(InputDesc{Source: "import stdlib.const\nfunction logic() {\n\treturn TxTypePayment\n}\n"}, Options{})
+	a.Empty(analysis.Diagnostics)
+	_, ok = analysis.Definition(3, 8)
+	a.False(ok)
+}
+
+func TestAnalysisHover(t *testing.T) {
+	a := require.New(t)
+
+	source := `const c = 3
+function sum(x, y) { return x + y; }
+function logic() {
+	let a = sum(c, txn.Fee)
+	let b = sha256(txn.Note)
+	if accounts[0].optedIn(0) {
+		return gtxn[1].Amount
+	}
+	return global.MinTxnFee + len(b) + a
+}
+`
+	analysis := Analyze(InputDesc{Source: source, SourceFile: "main.tl"}, Options{})
+	a.Empty(analysis.Diagnostics)
+
+	tests := []struct {
+		line     int
+		column   int
+		expected []string
+	}{
+		{4, 5, []string{"let a: uint64"}},
+		{4, 13, []string{"const c: uint64 = 3"}},
+		{4, 10, []string{"function sum(x, y): uint64"}},
+		{2, 10, []string{"function sum(x, y): uint64"}},
+		{2, 28, []string{"argument x: uint64"}},
+		{4, 20, []string{"txn.Fee: uint64", "microalgos", "TEAL `txn Fee`"}},
+		{5, 10, []string{"sha256(...): byte[]", "SHA256 hash", "TEAL opcode `sha256`"}},
+		{6, 17, []string{"accounts[i].optedIn(...): uint64", "TEAL `app_opted_in`"}},
+		{7, 18, []string{"gtxn[i].Amount: uint64", "microalgos"}},
+		{9, 16, []string{"global.MinTxnFee: uint64", "microalgos"}},
+	}
+	for _, test := range tests {
+		hover, ok := analysis.Hover(test.line, test.column)
+		a.True(ok, "%d:%d", test.line, test.column)
+		for _, expected := range test.expected {
+			a.Contains(hover, expected, "%d:%d", test.line, test.column)
+		}
+	}
+
+	_, ok := analysis.Hover(3, 0)
+	a.False(ok)
+
+	// builtins are described even in programs with errors
+	analysis = Analyze(InputDesc{Source: "function logic() {\n\treturn txn.Fee + x\n}\n"}, Options{})
+	a.NotEmpty(analysis.Diagnostics)
+	hover, ok := analysis.Hover(2, 13)
+	a.True(ok)
+	a.Contains(hover, "txn.Fee")
+
+	// members not available in the target TEAL version are not described
+	source = "function logic() {\n\treturn len(global.GroupID)\n}\n"
+	analysis = Analyze(InputDesc{Source: source}, Options{TEALVersion: 6})
+	hover, ok = analysis.Hover(2, 20)
+	a.True(ok)
+	a.Contains(hover, "global.GroupID")
+	analysis = Analyze(InputDesc{Source: source}, Options{TEALVersion: 3})
+	_, ok = analysis.Hover(2, 20)
+	a.False(ok)
+}
+
+func TestAnalysisCompletion(t *testing.T) {
+	a := require.New(t)
+
+	labels := func(completions []Completion) []string {
+		result := make([]string, 0, len(completions))
+		for _, c := range completions {
+			result = append(result, c.Label)
+		}
+		return result
+	}
+
+	source := "function logic() {\n\tlet a = txn.\n\tlet b = gtxn[a + 1].Am\n\tlet c = global.\n\tlet d = accounts[0].\n\tlet e = apps[0].\n\titxn.\n\treturn 1\n}\n"
+	analysis := Analyze(InputDesc{Source: source}, Options{TEALVersion: 6})
+	a.NotEmpty(analysis.Diagnostics)
+
+	txn := analysis.Completion(2, 13)
+	a.Contains(labels(txn), "Fee")
+	a.Contains(labels(txn), "Accounts")
+	a.NotContains(labels(txn), "MinTxnFee")
+	for _, c := range txn {
+		if c.Label == "Fee" {
+			a.Equal("uint64", c.Detail)
+			a.Contains(c.Documentation, "microalgos")
+			a.False(c.Method)
+		}
+	}
+
+	a.Equal([]string{"Amount"}, labels(analysis.Completion(3, 23)))
+
+	global := labels(analysis.Completion(4, 16))
+	a.Contains(global, "MinTxnFee")
+	a.Contains(global, "CurrentApplicationAddress")
+	a.NotContains(global, "Fee")
+
+	accounts := analysis.Completion(5, 21)
+	a.Contains(labels(accounts), "Balance")
+	a.Contains(labels(accounts), "optedIn")
+	a.Contains(labels(accounts), "acctBalance")
+	for _, c := range accounts {
+		if c.Label == "optedIn" {
+			a.True(c.Method)
+		}
+	}
+
+	apps := labels(analysis.Completion(6, 17))
+	a.Contains(apps, "getEx")
+	a.Contains(apps, "appCreator")
+
+	itxn := labels(analysis.Completion(7, 6))
+	a.Contains(itxn, "begin")
+	a.Contains(itxn, "submit")
+	a.Contains(itxn, "Receiver")
+
+	// members are limited by TEAL version
+	analysis = Analyze(InputDesc{Source: source}, Options{TEALVersion: 2})
+	global = labels(analysis.Completion(4, 16))
+	a.NotContains(global, "CurrentApplicationAddress")
+	a.Empty(analysis.Completion(7, 6))
+
+	// not after a builtin object
+	a.Empty(analysis.Completion(8, 9))
+	a.Empty(analysis.Completion(2, 10))
+
+	for _, c := range txn {
+		a.False(strings.Contains(c.Label, " "))
+	}
+}
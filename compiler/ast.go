@@ -3,8 +3,9 @@ package compiler
 import (
 	"fmt"
 	"io"
-	"sort"
 	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
 )
 
 type literalDesc struct {
@@ -20,13 +21,27 @@ type literalInfo struct {
 }
 
 type context struct {
-	name         string
-	literals     *literalInfo
-	parent       *context
-	vars         map[string]varInfo
-	functions    map[string]*funCallNode
-	addressEntry uint // first address to use on the context creation
-	addressNext  uint // next address to use
+	name     string
+	literals *literalInfo
+	parent   *context
+	vars     map[string]varInfo
+	version  int    // target TEAL version
+	frame    *frame // set inside subroutines keeping variables on the stack frame
+
+	// usage tracking for warnings
+	function     bool // function body scope, enclosing scopes belong to the call site
+	scopes       []*context
+	declarations map[string]srcRef
+	reads        map[string]bool
+
+	symbols *symbolTable // set when names are collected for editors
+}
+
+// frame describes a subroutine stack frame set up by proto opcode.
+// Arguments have negative slots, local variables are numbered from zero
+type frame struct {
+	args   int
+	locals int
 }
 
 type varKind int
@@ -43,7 +58,7 @@ type varInfo struct {
 	theType exprType
 	kind    varKind
 
-	// for variables specifies allocated memory scratch space
+	// for variables specifies scratch space slot assigned by allocateScratch after parsing
 	// for constants sets index in intc/bytec arrays
 	address uint
 
@@ -53,6 +68,10 @@ type varInfo struct {
 	// function has reference lazy parser
 	parser callDefParser
 	node   TreeNodeIf
+
+	// frame variables live in a subroutine stack frame slot instead of scratch space
+	frame *frame
+	slot  int
 }
 
 func (v varInfo) constant() bool {
@@ -63,6 +82,10 @@ func (v varInfo) function() bool {
 	return v.kind == functionKind
 }
 
+func (v varInfo) onFrame() bool {
+	return v.frame != nil
+}
+
 func newLiteralInfo() (literals *literalInfo) {
 	literals = new(literalInfo)
 	literals.literals = make(map[string]literalDesc)
@@ -76,15 +99,17 @@ func newContext(name string, parent *context) (ctx *context) {
 	ctx.name = name
 	ctx.parent = parent
 	ctx.vars = make(map[string]varInfo)
-	ctx.functions = make(map[string]*funCallNode)
+	ctx.declarations = make(map[string]srcRef)
+	ctx.reads = make(map[string]bool)
 	if parent != nil {
 		ctx.literals = parent.literals
-		ctx.addressEntry = parent.addressNext
-		ctx.addressNext = ctx.addressEntry
+		ctx.version = parent.version
+		ctx.frame = parent.frame
+		ctx.symbols = parent.symbols
+		parent.scopes = append(parent.scopes, ctx)
 	} else {
 		ctx.literals = newLiteralInfo()
-		ctx.addressEntry = 0
-		ctx.addressNext = 0
+		ctx.version = defaultTealVersion()
 
 		// global context, add internal literals
 		ctx.addLiteral(falseConstValue, intType)
@@ -105,6 +130,42 @@ func (ctx *context) lookup(name string) (varable varInfo, err error) {
 	return varInfo{}, fmt.Errorf("ident '%s' not defined", name)
 }
 
+// markRead records the name is used by the program
+func (ctx *context) markRead(name string) {
+	for current := ctx; current != nil; current = current.parent {
+		if _, ok := current.vars[name]; ok {
+			current.reads[name] = true
+			return
+		}
+	}
+}
+
+// declare remembers where the name is declared so that it can be reported if not used
+func (ctx *context) declare(name string, src srcRef) {
+	ctx.declarations[name] = src
+}
+
+// shadowed finds the name declared in an enclosing scope of the same function or in the global scope
+func (ctx *context) shadowed(name string) bool {
+	scope := ctx
+	for !scope.function && scope.parent != nil {
+		scope = scope.parent
+		if _, ok := scope.vars[name]; ok {
+			return true
+		}
+	}
+	// call site scopes are not visible from a function body, go straight to globals
+	root := scope
+	for root.parent != nil {
+		root = root.parent
+	}
+	if root == ctx {
+		return false
+	}
+	_, ok := root.vars[name]
+	return ok
+}
+
 func (ctx *context) update(name string, info varInfo) (err error) {
 	current := ctx
 	for current != nil {
@@ -118,28 +179,25 @@ func (ctx *context) update(name string, info varInfo) (err error) {
 	return fmt.Errorf("failed to update ident %s", name)
 }
 
-// remapTo remaps this context variable addresses by using newBase as a new entry address
-func (ctx *context) remapTo(newBase uint) {
-	vars := make([]varInfo, 0, len(ctx.vars))
-	for _, info := range ctx.vars {
-		vars = append(vars, info)
+func (ctx *context) newVar(name string, theType exprType) error {
+	if _, ok := ctx.vars[name]; ok {
+		return fmt.Errorf("variable '%s' already declared", name)
 	}
-	sort.Slice(vars, func(i, j int) bool { return vars[i].address < vars[j].address })
-	ctx.addressEntry = newBase
-	for _, info := range vars {
-		info.address = newBase
-		ctx.update(info.name, info)
-		newBase++
+	if ctx.frame != nil {
+		ctx.vars[name] = varInfo{name, theType, 0, 0, nil, nil, nil, ctx.frame, ctx.frame.locals}
+		ctx.frame.locals++
+		return nil
 	}
-	ctx.addressNext = newBase
+	ctx.vars[name] = varInfo{name, theType, 0, 0, nil, nil, nil, nil, 0}
+	return nil
 }
 
-func (ctx *context) newVar(name string, theType exprType) error {
+// newFrameArg declares a subroutine argument addressed by a negative frame slot
+func (ctx *context) newFrameArg(name string, theType exprType, slot int) error {
 	if _, ok := ctx.vars[name]; ok {
 		return fmt.Errorf("variable '%s' already declared", name)
 	}
-	ctx.vars[name] = varInfo{name, theType, 0, ctx.addressNext, nil, nil, nil}
-	ctx.addressNext++
+	ctx.vars[name] = varInfo{name, theType, 0, 0, nil, nil, nil, ctx.frame, slot}
 	return nil
 }
 
@@ -151,7 +209,7 @@ func (ctx *context) newConst(name string, theType exprType, value *string) error
 	if err != nil {
 		return err
 	}
-	ctx.vars[name] = varInfo{name, theType, constantKind, offset, value, nil, nil}
+	ctx.vars[name] = varInfo{name, theType, constantKind, offset, value, nil, nil, nil, 0}
 	return nil
 }
 
@@ -160,7 +218,7 @@ func (ctx *context) newFunc(name string, theType exprType, parser callDefParser)
 		return fmt.Errorf("function '%s' already defined", name)
 	}
 
-	ctx.vars[name] = varInfo{name, theType, functionKind, 0, nil, parser, nil}
+	ctx.vars[name] = varInfo{name, theType, functionKind, 0, nil, parser, nil, nil, 0}
 	return nil
 }
 
@@ -195,12 +253,10 @@ func (ctx *context) Print() {
 	}
 }
 
-func (ctx *context) EntryAddress() uint {
-	return ctx.addressEntry
-}
-
-func (ctx *context) LastAddress() uint {
-	return ctx.addressNext
+// frameSubroutines tells if non-inline functions keep arguments and locals on the stack frame
+// rather than in scratch space. It requires proto, frame_dig and frame_bury opcodes
+func (ctx *context) frameSubroutines() bool {
+	return checkOpVersion(ctx.version, "proto", "") == nil
 }
 
 type exprType int
@@ -231,7 +287,9 @@ type TreeNodeIf interface {
 	parent() TreeNodeIf
 	String() string
 	Print()
-	Codegen(ostream io.Writer)
+	Codegen(ostream io.Writer, labels *labelAllocator)
+	location() SourceLocation
+	locate(rule antlr.ParserRuleContext)
 }
 
 // ExprNodeIf extends TreeNode and can be evaluated and typed
@@ -240,6 +298,13 @@ type ExprNodeIf interface {
 	getType() (exprType, error)
 }
 
+// srcRef points to the source of a node so that passes running after parsing can report errors
+type srcRef struct {
+	parser antlr.Parser
+	token  antlr.Token
+	rule   antlr.RuleContext
+}
+
 // TreeNode contains base info about an AST node
 type TreeNode struct {
 	ctx *context
@@ -247,11 +312,13 @@ type TreeNode struct {
 	nodeName      string
 	parentNode    TreeNodeIf
 	childrenNodes []TreeNodeIf
+	source        SourceLocation
 }
 
 type programNode struct {
 	*TreeNode
 	nonInlineFunc []*funDefNode
+	version       int
 }
 
 type funArg struct {
@@ -265,6 +332,15 @@ type funDefNode struct {
 	args   []funArg
 	inline bool
 	void   bool
+	src    srcRef
+
+	// return type resolution state, see returnType
+	resolving       bool
+	recursive       bool
+	provisionalType exprType
+
+	// rejected functions are not analysed further after an error making the rest meaningless
+	rejected bool
 }
 
 type blockNode struct {
@@ -309,7 +385,12 @@ type arrayAssignInnerTxnNode struct {
 
 type breakNode struct {
 	*TreeNode
-	value ExprNodeIf
+	loop *forStatementNode
+}
+
+type continueNode struct {
+	*TreeNode
+	loop *forStatementNode
 }
 
 type assignNode struct {
@@ -384,6 +465,7 @@ type exprBinOpNode struct {
 	op       string
 	lhs      ExprNodeIf
 	rhs      ExprNodeIf
+	src      srcRef
 }
 
 type exprGroupNode struct {
@@ -395,6 +477,7 @@ type exprUnOpNode struct {
 	*TreeNode
 	op    string
 	value ExprNodeIf
+	src   srcRef
 }
 
 type ifExprNode struct {
@@ -406,6 +489,7 @@ type ifExprNode struct {
 
 type forStatementNode struct {
 	*TreeNode
+	label    string
 	condExpr ExprNodeIf
 }
 
@@ -460,10 +544,11 @@ func newNode(ctx *context, parent TreeNodeIf) (node *TreeNode) {
 	return node
 }
 
-func newProgramNode(ctx *context, parent TreeNodeIf) (node *programNode) {
+func newProgramNode(ctx *context, parent TreeNodeIf, version int) (node *programNode) {
 	node = new(programNode)
 	node.TreeNode = newNode(ctx, parent)
 	node.nodeName = "program"
+	node.version = version
 	return
 }
 
@@ -528,11 +613,19 @@ func newArrayAssignInnerTxnNode(ctx *context, parent TreeNodeIf, ident string) (
 	return
 }
 
-func newBreakNode(ctx *context, parent TreeNodeIf) (node *breakNode) {
+func newBreakNode(ctx *context, parent TreeNodeIf, loop *forStatementNode) (node *breakNode) {
 	node = new(breakNode)
 	node.TreeNode = newNode(ctx, parent)
 	node.nodeName = "break"
-	node.value = nil
+	node.loop = loop
+	return
+}
+
+func newContinueNode(ctx *context, parent TreeNodeIf, loop *forStatementNode) (node *continueNode) {
+	node = new(continueNode)
+	node.TreeNode = newNode(ctx, parent)
+	node.nodeName = "continue"
+	node.loop = loop
 	return
 }
 
@@ -668,10 +761,11 @@ func newIfStatementNode(ctx *context, parent TreeNodeIf) (node *ifStatementNode)
 	return
 }
 
-func newForStatementNode(ctx *context, parent TreeNodeIf) (node *forStatementNode) {
+func newForStatementNode(ctx *context, parent TreeNodeIf, label string) (node *forStatementNode) {
 	node = new(forStatementNode)
 	node.TreeNode = newNode(ctx, parent)
 	node.nodeName = "for stmt"
+	node.label = label
 	return
 }
 
@@ -879,6 +973,54 @@ func determineBlockReturnType(node TreeNodeIf, retTypeSeen []exprType) (exprType
 	return commonType, nil
 }
 
+// guessBlockReturnType returns type of the first return statement that can be typed
+func guessBlockReturnType(node TreeNodeIf) exprType {
+	for _, stmt := range node.children() {
+		switch tt := stmt.(type) {
+		case *returnNode:
+			if tt.value == nil {
+				continue
+			}
+			if tp, err := tt.value.getType(); err == nil && tp != unknownType {
+				return tp
+			}
+		case *ifStatementNode, *blockNode, *forStatementNode:
+			if tp := guessBlockReturnType(stmt); tp != unknownType {
+				return tp
+			}
+		}
+	}
+	return unknownType
+}
+
+// returnType determines function return type from its return statements.
+// Recursive calls met during the resolution get a type of non-recursive return statements
+func (n *funDefNode) returnType() (exprType, error) {
+	if n.resolving {
+		n.recursive = true
+		return n.provisionalType, nil
+	}
+	n.resolving = true
+	defer func() { n.resolving = false }()
+
+	tp, err := determineBlockReturnType(n, []exprType{})
+	if !n.recursive {
+		return tp, err
+	}
+	n.provisionalType = guessBlockReturnType(n)
+	return determineBlockReturnType(n, []exprType{})
+}
+
+// encloses tells if the node is a part of this function body
+func (n *funDefNode) encloses(node TreeNodeIf) bool {
+	for current := node; current != nil; current = current.parent() {
+		if current == TreeNodeIf(n) {
+			return true
+		}
+	}
+	return false
+}
+
 func ensureBlockReturns(node TreeNodeIf) bool {
 	chLength := len(node.children())
 	if chLength == 0 {
@@ -929,7 +1071,7 @@ func (n *funCallNode) getType() (exprType, error) {
 			}
 		}
 	} else {
-		tp, err = determineBlockReturnType(n.definition, []exprType{})
+		tp, err = n.definition.returnType()
 	}
 	n.funType = tp
 	return tp, err
@@ -993,6 +1135,9 @@ func (n *funCallNode) getTypeQuadruple() (exprType, exprType, exprType, exprType
 }
 
 func (n *funCallNode) checkBuiltinArgs() (argErrorPos int, err error) {
+	if err = checkOpVersion(n.ctx.version, n.name, n.field); err != nil {
+		return
+	}
 	args := n.children()
 	for i, arg := range args {
 		tp, err := argOpTypeFromSpec(n.name, i)
@@ -1016,6 +1161,9 @@ func (n *funCallNode) resolveFieldArg(field string) (err error) {
 	if err != nil {
 		return
 	}
+	if err = checkOpVersion(n.ctx.version, n.name, field); err != nil {
+		return
+	}
 	n.field = field
 	n.funType = tp
 	return
@@ -1035,6 +1183,14 @@ func (n *runtimeFieldNode) getType() (exprType, error) {
 	return tp, err
 }
 
+func (n *runtimeFieldNode) checkVersion() error {
+	return checkOpVersion(n.ctx.version, n.op, n.field)
+}
+
+func (n *runtimeArgNode) checkVersion() error {
+	return checkOpVersion(n.ctx.version, n.op, "")
+}
+
 func (n *runtimeArgNode) getType() (exprType, error) {
 	if n.exprType != unknownType {
 		return n.exprType, nil
@@ -1086,6 +1242,34 @@ func (n *TreeNode) parent() TreeNodeIf {
 	return n.parentNode
 }
 
+func (n *TreeNode) location() SourceLocation {
+	return n.source
+}
+
+// locateToken remembers the token the node is generated for, operators are located by their sign
+func (n *TreeNode) locateToken(parser antlr.Parser, token antlr.Token) {
+	if token == nil {
+		return
+	}
+	n.source = SourceLocation{Line: token.GetLine(), Column: token.GetColumn()}
+	if parser != nil {
+		n.source.File = parser.GetTokenStream().GetSourceName()
+	}
+}
+
+// locate remembers where the node starts in sources
+func (n *TreeNode) locate(rule antlr.ParserRuleContext) {
+	start := rule.GetStart()
+	if start == nil {
+		return
+	}
+	n.source = SourceLocation{Line: start.GetLine(), Column: start.GetColumn()}
+	// generated rule contexts know their parser, and the parser input is named after the source file
+	if ctx, ok := rule.(interface{ GetParser() antlr.Parser }); ok && ctx.GetParser() != nil {
+		n.source.File = ctx.GetParser().GetTokenStream().GetSourceName()
+	}
+}
+
 // root returns programNode node
 func (n *TreeNode) root() *programNode {
 	var node TreeNodeIf = n
@@ -1116,12 +1300,6 @@ func (p *programNode) registerFunction(defNode *funDefNode) {
 	}
 }
 
-func (ctx *context) registerFunCall(name string, node *funCallNode) {
-	if _, ok := ctx.functions[name]; !ok {
-		ctx.functions[name] = node
-	}
-}
-
 func (n *varDeclNode) setExpr(value ExprNodeIf) {
 	n.value = value
 }
@@ -1205,6 +1383,9 @@ func (n *ifExprNode) String() string {
 }
 
 func (n *forStatementNode) String() string {
+	if n.label != "" {
+		return fmt.Sprintf("%s: for %s {}", n.label, n.condExpr)
+	}
 	return fmt.Sprintf("for %s {}", n.condExpr)
 }
 
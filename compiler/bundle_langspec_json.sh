@@ -25,9 +25,11 @@ type operation struct {
 	Returns       string
 	ArgEnum       []string
 	ArgEnumTypes  string
+	ArgEnumVersions []int
 	Doc           string
 	ImmediateNote string
 	Group         []string
+	IntroducedVersion int
 }
 
 var langSpec spec
@@ -11,24 +11,137 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"sort"
 )
 
 const trueConstValue = "1"
 const falseConstValue = "0"
 
-// TODO: switch from global var to recursive breakNode -> forNode lookup
-var ids []interface{}
+// labelAllocator hands out jump labels for a single compilation.
+// Labels are built from the enclosing function name and a per-function counter
+// so that the same program always produces the same TEAL text
+type labelAllocator struct {
+	counters map[string]int
+	issued   map[string]bool
+	bases    map[TreeNodeIf]string
+}
+
+func newLabelAllocator() *labelAllocator {
+	return &labelAllocator{
+		counters: make(map[string]int),
+		issued:   make(map[string]bool),
+		bases:    make(map[TreeNodeIf]string),
+	}
+}
+
+// labelScope returns label prefix for a node: fun_<name> inside a function and global otherwise
+func labelScope(node TreeNodeIf) string {
+	for current := node; current != nil; current = current.parent() {
+		if fun, ok := current.(*funDefNode); ok {
+			return fmt.Sprintf("fun_%s", fun.name)
+		}
+	}
+	return "global"
+}
+
+// newLabel allocates a fresh label base like fun_approval_if3 and binds it to the node
+func (a *labelAllocator) newLabel(node TreeNodeIf, kind string) string {
+	scope := labelScope(node)
+	for {
+		a.counters[scope]++
+		base := fmt.Sprintf("%s_%s%d", scope, kind, a.counters[scope])
+		if !a.issued[base] {
+			a.issued[base] = true
+			a.bases[node] = base
+			return base
+		}
+	}
+}
+
+// label returns label base previously allocated for the node
+func (a *labelAllocator) label(node TreeNodeIf) string {
+	return a.bases[node]
+}
+
+// source location markers wrap code generated for a statement or a function,
+// expression markers wrap calls, operators and field accesses inside of statements.
+// They are consumed by splitInstructions and never appear in the output
+const sourceMarker = "//@source"
+const expressionMarker = "//@expr"
+const sourceEndMarker = "//@end"
+
+// variableMarker names the variable accessed by the next instruction for evaluation traces
+const variableMarker = "//@var"
+
+// function markers wrap code of a function body or an inline function expansion for profiling
+const functionMarker = "//@function"
+const inlineMarker = "//@inline"
+const functionEndMarker = "//@function_end"
+
+// scope markers wrap code of a function, an inline expansion, a block or the whole program
+// and list scratch slots of variables declared there as name=slot pairs
+const scopeMarker = "//@scope"
+const scopeEndMarker = "//@scope_end"
+
+// openScope emits scope marker with variables of the context kept in scratch space,
+// it tells if the scope needs to be closed since scopes without such variables are not marked
+func openScope(ostream io.Writer, ctx *context) bool {
+	names := make([]string, 0, len(ctx.vars))
+	for name, info := range ctx.vars {
+		if !info.constant() && !info.function() && !info.onFrame() {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return false
+	}
+	sort.Strings(names)
+	fmt.Fprintf(ostream, "%s", scopeMarker)
+	for _, name := range names {
+		fmt.Fprintf(ostream, " %s=%d", name, ctx.vars[name].address)
+	}
+	fmt.Fprintf(ostream, "\n")
+	return true
+}
+
+func closeScope(ostream io.Writer, opened bool) {
+	if opened {
+		fmt.Fprintf(ostream, "%s\n", scopeEndMarker)
+	}
+}
+
+// codegenLocated runs code generation for a node surrounded by its source location markers
+func codegenLocated(node TreeNodeIf, ostream io.Writer, labels *labelAllocator) {
+	codegenMarked(sourceMarker, node, ostream, labels)
+}
+
+// codegenExpr runs code generation for a subexpression, located ones are surrounded by expression markers
+// so that instructions point at the innermost subexpression they are generated for
+func codegenExpr(node TreeNodeIf, ostream io.Writer, labels *labelAllocator) {
+	codegenMarked(expressionMarker, node, ostream, labels)
+}
+
+func codegenMarked(marker string, node TreeNodeIf, ostream io.Writer, labels *labelAllocator) {
+	loc := node.location()
+	if loc.Line == 0 {
+		node.Codegen(ostream, labels)
+		return
+	}
+	fmt.Fprintf(ostream, "%s %d %d %s\n", marker, loc.Line, loc.Column, loc.File)
+	node.Codegen(ostream, labels)
+	fmt.Fprintf(ostream, "%s\n", sourceEndMarker)
+}
 
 // Codegen by default emits AST node as a comment
-func (n *TreeNode) Codegen(ostream io.Writer) {
+func (n *TreeNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	fmt.Fprintf(ostream, "// %s\n", n.String())
 }
 
 // Codegen of program node generates literals and runs code generation for children nodes
-func (n *programNode) Codegen(ostream io.Writer) {
+func (n *programNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	ctx := n.ctx
 
-	fmt.Fprintf(ostream, "#pragma version %d\n", tealVersion())
+	fmt.Fprintf(ostream, "#pragma version %d\n", n.version)
 
 	// emit literals
 	if len(ctx.literals.intc) > 0 {
@@ -55,29 +168,69 @@ func (n *programNode) Codegen(ostream io.Writer) {
 		fmt.Fprintf(ostream, "\n")
 	}
 
+	scope := openScope(ostream, ctx)
 	for _, ch := range n.children() {
-		ch.Codegen(ostream)
+		codegenLocated(ch, ostream, labels)
 	}
 
 	for _, n := range n.nonInlineFunc {
-		n.Codegen(ostream)
+		codegenLocated(n, ostream, labels)
 	}
+	closeScope(ostream, scope)
 }
 
-func (n *funDefNode) Codegen(ostream io.Writer) {
+// storeVar pops a value into variable's scratch slot or stack frame slot
+func storeVar(ostream io.Writer, info varInfo) {
+	fmt.Fprintf(ostream, "%s %s\n", variableMarker, info.name)
+	if info.onFrame() {
+		fmt.Fprintf(ostream, "frame_bury %d\n", info.slot)
+	} else {
+		fmt.Fprintf(ostream, "store %d\n", info.address)
+	}
+}
+
+func (n *funDefNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	name := n.name
+	if name == mainFuncName {
+		name = mainFuncSourceName
+	}
+	fmt.Fprintf(ostream, "%s %s\n", functionMarker, name)
 	fmt.Fprintf(ostream, "fun_%s:\n", n.name)
+	scope := openScope(ostream, n.ctx)
 	if !n.inline {
-		for i := len(n.args) - 1; i >= 0; i-- {
-			arg := n.args[i]
-			info, _ := n.ctx.lookup(arg.n)
-			fmt.Fprintf(ostream, "store %d\n", info.address)
+		if n.ctx.frame != nil {
+			n.frameCodegen(ostream)
+		} else {
+			for i := len(n.args) - 1; i >= 0; i-- {
+				arg := n.args[i]
+				info, _ := n.ctx.lookup(arg.n)
+				storeVar(ostream, info)
+			}
 		}
 	}
 
 	for _, ch := range n.children() {
-		ch.Codegen(ostream)
+		ch.Codegen(ostream, labels)
 	}
+	closeScope(ostream, scope)
 	fmt.Fprintf(ostream, "end_%s:\n", n.name)
+	fmt.Fprintf(ostream, "%s\n", functionEndMarker)
+}
+
+// frameCodegen sets up subroutine stack frame: declares arguments and return values count
+// and reserves slots for local variables
+func (n *funDefNode) frameCodegen(ostream io.Writer) {
+	returns := 1
+	if n.void {
+		returns = 0
+	}
+	fmt.Fprintf(ostream, "proto %d %d\n", n.ctx.frame.args, returns)
+	if locals := n.ctx.frame.locals; locals > 0 {
+		fmt.Fprintf(ostream, "intc %d\n", n.ctx.literals.literals[falseConstValue].offset)
+		if locals > 1 {
+			fmt.Fprintf(ostream, "dupn %d\n", locals-1)
+		}
+	}
 }
 
 func literalTypeToOpcode(theType exprType) string {
@@ -88,148 +241,155 @@ func literalTypeToOpcode(theType exprType) string {
 	return op
 }
 
-func (n *exprLiteralNode) Codegen(ostream io.Writer) {
+func (n *exprLiteralNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	op := literalTypeToOpcode(n.exprType)
 	fmt.Fprintf(ostream, "%s %d\n", op, n.ctx.literals.literals[n.value].offset)
 }
 
-func (n *exprIdentNode) Codegen(ostream io.Writer) {
+func (n *exprIdentNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	info, _ := n.ctx.lookup(n.name)
 	op := "load"
 	if info.constant() {
 		op = literalTypeToOpcode(info.theType)
+	} else {
+		fmt.Fprintf(ostream, "%s %s\n", variableMarker, info.name)
+	}
+	if info.onFrame() {
+		fmt.Fprintf(ostream, "frame_dig %d\n", info.slot)
+		return
 	}
 	fmt.Fprintf(ostream, "%s %d\n", op, info.address)
 }
 
-func (n *assignInnerTxnNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *assignInnerTxnNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 
 	//info, _ := n.ctx.lookup(n.name)
 	fmt.Fprintf(ostream, "itxn_field %s\n", n.name)
 }
 
-func (n *arrayAssignInnerTxnNode) Codegen(ostream io.Writer) {
-	n.childrenNodes[0].Codegen(ostream)
+func (n *arrayAssignInnerTxnNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.childrenNodes[0], ostream, labels)
 	fmt.Fprintf(ostream, "itxn_field %s\n", n.name)
 }
 
-func (n *assignNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *assignNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 
 	info, _ := n.ctx.lookup(n.name)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 }
 
-func (n *assignTupleNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *assignTupleNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 
 	info, _ := n.ctx.lookup(n.low)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 	info, _ = n.ctx.lookup(n.high)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 }
 
-func (n *assignQuadrupleNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *assignQuadrupleNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 
 	info, _ := n.ctx.lookup(n.rlow)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 	info, _ = n.ctx.lookup(n.rhigh)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 	info, _ = n.ctx.lookup(n.low)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 	info, _ = n.ctx.lookup(n.high)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 }
 
-func (n *returnNode) Codegen(ostream io.Writer) {
+func (n *returnNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	if n.value != nil {
-		n.value.Codegen(ostream)
+		codegenExpr(n.value, ostream, labels)
 	}
 	if n.definition.name == mainFuncName {
 		fmt.Fprintf(ostream, "return\n")
 	} else if !n.definition.inline {
+		// retsub keeps the result on top of the stack and drops arguments and locals of the frame
 		fmt.Fprintf(ostream, "retsub\n")
 	} else {
-		fmt.Fprintf(ostream, "b end_%s_%d\n", n.definition.name, &n.definition.name)
+		fmt.Fprintf(ostream, "b %s_end\n", labels.label(n.definition))
 	}
 }
 
-func (n *errorNode) Codegen(ostream io.Writer) {
+func (n *errorNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	fmt.Fprintf(ostream, "err\n")
 }
 
-func (n *exprGroupNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *exprGroupNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 }
 
-func (n *exprBinOpNode) Codegen(ostream io.Writer) {
-	n.lhs.Codegen(ostream)
-	n.rhs.Codegen(ostream)
+func (n *exprBinOpNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.lhs, ostream, labels)
+	codegenExpr(n.rhs, ostream, labels)
 
 	fmt.Fprintf(ostream, "%s\n", n.op)
 }
 
-func (n *exprUnOpNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *exprUnOpNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 
 	fmt.Fprintf(ostream, "%s\n", n.op)
 }
 
-func (n *varDeclNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *varDeclNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 
 	info, _ := n.ctx.lookup(n.name)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 }
 
-func (n *varDeclTupleNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *varDeclTupleNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 
 	info, _ := n.ctx.lookup(n.low)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 	info, _ = n.ctx.lookup(n.high)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 }
 
-func (n *varDeclQuadrupleNode) Codegen(ostream io.Writer) {
-	n.value.Codegen(ostream)
+func (n *varDeclQuadrupleNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.value, ostream, labels)
 
 	info, _ := n.ctx.lookup(n.rlow)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 	info, _ = n.ctx.lookup(n.rhigh)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 	info, _ = n.ctx.lookup(n.low)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 	info, _ = n.ctx.lookup(n.high)
-	fmt.Fprintf(ostream, "store %d\n", info.address)
+	storeVar(ostream, info)
 }
 
-func (n *runtimeFieldNode) Codegen(ostream io.Writer) {
+func (n *runtimeFieldNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	switch n.op {
 	case "gtxn":
 		fmt.Fprintf(ostream, "%s %s %s\n", n.op, n.index1, n.field)
 	case "gtxns":
 		for i := 0; i < len(n.childrenNodes); i++ {
-			n.childrenNodes[i].Codegen(ostream)
+			codegenExpr(n.childrenNodes[i], ostream, labels)
 		}
 		fmt.Fprintf(ostream, "%s %s\n", n.op, n.field)
 	case "gtxna":
 		fmt.Fprintf(ostream, "%s %s %s %s\n", n.op, n.index1, n.field, n.index2)
 	case "gtxnsa":
 		for i := 0; i < len(n.childrenNodes); i++ {
-			n.childrenNodes[i].Codegen(ostream)
+			codegenExpr(n.childrenNodes[i], ostream, labels)
 		}
 		fmt.Fprintf(ostream, "%s %s %s\n", n.op, n.field, n.index2)
 	case "gtxnas":
 		for i := 0; i < len(n.childrenNodes); i++ {
-			n.childrenNodes[i].Codegen(ostream)
+			codegenExpr(n.childrenNodes[i], ostream, labels)
 		}
 		fmt.Fprintf(ostream, "%s %s %s\n", n.op, n.index1, n.field)
 	case "gtxnsas":
 		for i := 0; i < len(n.childrenNodes); i++ {
-			n.childrenNodes[i].Codegen(ostream)
+			codegenExpr(n.childrenNodes[i], ostream, labels)
 		}
 		fmt.Fprintf(ostream, "%s %s\n", n.op, n.field)
 	case "txna":
@@ -240,7 +400,7 @@ func (n *runtimeFieldNode) Codegen(ostream io.Writer) {
 		fallthrough
 	case "itxnas":
 		for i := 0; i < len(n.childrenNodes); i++ {
-			n.childrenNodes[i].Codegen(ostream)
+			codegenExpr(n.childrenNodes[i], ostream, labels)
 		}
 		fmt.Fprintf(ostream, "%s %s\n", n.op, n.field)
 	case "gitxn":
@@ -249,7 +409,7 @@ func (n *runtimeFieldNode) Codegen(ostream io.Writer) {
 		fmt.Fprintf(ostream, "%s %s %s %s\n", n.op, n.index1, n.field, n.index2)
 	case "gitxnas":
 		for i := 0; i < len(n.childrenNodes); i++ {
-			n.childrenNodes[i].Codegen(ostream)
+			codegenExpr(n.childrenNodes[i], ostream, labels)
 		}
 		fmt.Fprintf(ostream, "%s %s %s\n", n.op, n.index1, n.field)
 	default:
@@ -257,29 +417,31 @@ func (n *runtimeFieldNode) Codegen(ostream io.Writer) {
 	}
 }
 
-func (n *runtimeArgNode) Codegen(ostream io.Writer) {
+func (n *runtimeArgNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	if n.number != "" {
 		fmt.Fprintf(ostream, "%s %s\n", n.op, n.number)
 	} else {
 		for _, ch := range n.children() {
-			ch.Codegen(ostream)
+			codegenExpr(ch, ostream, labels)
 		}
 		fmt.Fprintf(ostream, "%s\n", n.op)
 	}
 }
 
-func (n *ifExprNode) Codegen(ostream io.Writer) {
-	n.condExpr.Codegen(ostream)
-	fmt.Fprintf(ostream, "bz if_expr_false_%d\n", &n)
-	n.condTrueExpr.Codegen(ostream)
-	fmt.Fprintf(ostream, "b if_expr_end_%d\n", &n)
-	fmt.Fprintf(ostream, "if_expr_false_%d:\n", &n)
-	n.condFalseExpr.Codegen(ostream)
-	fmt.Fprintf(ostream, "if_expr_end_%d:\n", &n)
+func (n *ifExprNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	label := labels.newLabel(n, "if")
+	codegenExpr(n.condExpr, ostream, labels)
+	fmt.Fprintf(ostream, "bz %s_false\n", label)
+	codegenExpr(n.condTrueExpr, ostream, labels)
+	fmt.Fprintf(ostream, "b %s_end\n", label)
+	fmt.Fprintf(ostream, "%s_false:\n", label)
+	codegenExpr(n.condFalseExpr, ostream, labels)
+	fmt.Fprintf(ostream, "%s_end:\n", label)
 }
 
-func (n *ifStatementNode) Codegen(ostream io.Writer) {
-	n.condExpr.Codegen(ostream)
+func (n *ifStatementNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	label := labels.newLabel(n, "if")
+	codegenExpr(n.condExpr, ostream, labels)
 	ch := n.children()
 	hasFalse := false
 	if len(ch) == 2 {
@@ -287,62 +449,64 @@ func (n *ifStatementNode) Codegen(ostream io.Writer) {
 	}
 
 	if hasFalse {
-		fmt.Fprintf(ostream, "bz if_stmt_false_%d\n", &n)
+		fmt.Fprintf(ostream, "bz %s_false\n", label)
 	} else {
-		fmt.Fprintf(ostream, "bz if_stmt_end_%d\n", &n)
+		fmt.Fprintf(ostream, "bz %s_end\n", label)
 	}
 
-	ch[0].Codegen(ostream)
+	ch[0].Codegen(ostream, labels)
 
 	if hasFalse {
-		fmt.Fprintf(ostream, "b if_stmt_end_%d\n", &n)
-		fmt.Fprintf(ostream, "if_stmt_false_%d:\n", &n)
-		ch[1].Codegen(ostream)
+		fmt.Fprintf(ostream, "b %s_end\n", label)
+		fmt.Fprintf(ostream, "%s_false:\n", label)
+		ch[1].Codegen(ostream, labels)
 	}
 
-	fmt.Fprintf(ostream, "if_stmt_end_%d:\n", &n)
+	fmt.Fprintf(ostream, "%s_end:\n", label)
 }
 
-func (n *forStatementNode) Codegen(ostream io.Writer) {
-	if ids == nil {
-		ids = make([]interface{}, 0)
-	}
-	ids = append(ids, &n)
+func (n *forStatementNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	label := labels.newLabel(n, "loop")
 
-	fmt.Fprintf(ostream, "loop_start_%d:\n", &n)
-	n.condExpr.Codegen(ostream)
-	fmt.Fprintf(ostream, "bz loop_end_%d\n", &n)
+	fmt.Fprintf(ostream, "%s_start:\n", label)
+	codegenExpr(n.condExpr, ostream, labels)
+	fmt.Fprintf(ostream, "bz %s_end\n", label)
 	ch := n.children()
-	ch[0].Codegen(ostream)
-	fmt.Fprintf(ostream, "b loop_start_%d\n", &n)
-	fmt.Fprintf(ostream, "loop_end_%d:\n", &n)
+	ch[0].Codegen(ostream, labels)
+	fmt.Fprintf(ostream, "b %s_start\n", label)
+	fmt.Fprintf(ostream, "%s_end:\n", label)
 }
 
-func (n *breakNode) Codegen(ostream io.Writer) {
-
-	id := ids[len(ids)-1]
-	ids = ids[:len(ids)-1]
-
-	fmt.Fprintf(ostream, "bz loop_end_%d\n", id)
+func (n *breakNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	fmt.Fprintf(ostream, "b %s_end\n", labels.label(n.loop))
+}
 
+func (n *continueNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	fmt.Fprintf(ostream, "b %s_start\n", labels.label(n.loop))
 }
 
-func (n *blockNode) Codegen(ostream io.Writer) {
+func (n *blockNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	// function bodies share the scope with their arguments
+	scope := false
+	if fun, ok := n.parentNode.(*funDefNode); !ok || fun.ctx != n.ctx {
+		scope = openScope(ostream, n.ctx)
+	}
 	for _, ch := range n.children() {
-		ch.Codegen(ostream)
+		codegenLocated(ch, ostream, labels)
 	}
+	closeScope(ostream, scope)
 }
 
-func (n *typeCastNode) Codegen(ostream io.Writer) {
-	n.expr.Codegen(ostream)
+func (n *typeCastNode) Codegen(ostream io.Writer, labels *labelAllocator) {
+	codegenExpr(n.expr, ostream, labels)
 }
 
-func (n *funCallNode) Codegen(ostream io.Writer) {
+func (n *funCallNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	_, builtin := builtinFun[n.name]
 	if builtin {
 		// push args
 		for _, ch := range n.children() {
-			ch.Codegen(ostream)
+			codegenExpr(ch, ostream, labels)
 		}
 		field := ""
 		if len(n.field) > 0 {
@@ -356,41 +520,53 @@ func (n *funCallNode) Codegen(ostream io.Writer) {
 
 		// for each arg evaluate and store as appropriate named var
 		for idx, ch := range n.children() {
-			ch.Codegen(ostream)
+			codegenExpr(ch, ostream, labels)
 			if definitionNode.inline {
 				argName := definitionNode.args[idx].n
 				i, _ := definitionNode.ctx.lookup(argName)
-				fmt.Fprintf(ostream, "store %d\n", i.address)
+				storeVar(ostream, i)
 			}
 		}
 
 		if definitionNode.inline {
+			// each inline expansion has own definition node and therefore own end label
+			label := labels.newLabel(definitionNode, "inline")
 			// and now generate statements
+			fmt.Fprintf(ostream, "%s %s\n", inlineMarker, n.name)
+			scope := openScope(ostream, definitionNode.ctx)
 			for _, ch := range definitionNode.children() {
-				ch.Codegen(ostream)
+				ch.Codegen(ostream, labels)
 			}
-			fmt.Fprintf(ostream, "end_%s_%d:\n", n.name, &n.definition.name)
+			closeScope(ostream, scope)
+			fmt.Fprintf(ostream, "%s_end:\n", label)
+			fmt.Fprintf(ostream, "%s\n", functionEndMarker)
 		} else {
 			fmt.Fprintf(ostream, "callsub fun_%s\n", n.name)
 		}
 	}
 }
 
-func (n *itxnBeginNode) Codegen(ostream io.Writer) {
+func (n *itxnBeginNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	fmt.Fprintf(ostream, "itxn_begin\n")
 }
 
-func (n *itxnNextNode) Codegen(ostream io.Writer) {
+func (n *itxnNextNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	fmt.Fprintf(ostream, "itxn_next\n")
 }
 
-func (n *itxnEndNode) Codegen(ostream io.Writer) {
+func (n *itxnEndNode) Codegen(ostream io.Writer, labels *labelAllocator) {
 	fmt.Fprintf(ostream, "itxn_submit\n")
 }
 
-// Codegen runs code generation for a node and returns the program as a string
+// Codegen runs code generation for a node and returns the program as a string.
+// Constant blocks are emitted in first-seen order, Compile additionally lays them out by use count
 func Codegen(prog TreeNodeIf) string {
+	return renderInstructions(codegenInstructions(prog))
+}
+
+// codegenInstructions runs code generation for a node and returns the program as instruction list
+func codegenInstructions(prog TreeNodeIf) []instruction {
 	buf := new(gobytes.Buffer)
-	prog.Codegen(buf)
-	return buf.String()
+	prog.Codegen(buf, newLabelAllocator())
+	return splitInstructions(buf.String())
 }
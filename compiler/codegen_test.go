@@ -104,12 +104,12 @@ func TestCodegenIfExpr(t *testing.T) {
 	expected := `#pragma version *
 intcblock 0 1 2 3
 intc 1
-bz if_expr_false_*
+bz global_if1_false
 intc 2
-b if_expr_end_*
-if_expr_false_*
+b global_if1_end
+global_if1_false:
 intc 3
-if_expr_end_*
+global_if1_end:
 store 0`
 
 	CompareTEAL(a, expected, actual)
@@ -127,10 +127,10 @@ func TestCodegenIfStmt(t *testing.T) {
 intcblock 0 1 10
 fun_main:
 intc 1
-bz if_stmt_end_*
+bz fun_main_if1_end
 intc 2
 store 0
-if_stmt_end_*`
+fun_main_if1_end:`
 
 	CompareTEAL(a, expected, actual)
 
@@ -143,14 +143,14 @@ if_stmt_end_*`
 intcblock 0 1 10 11
 fun_main:
 intc 1
-bz if_stmt_false_*
+bz fun_main_if1_false
 intc 2
 store 0
-b if_stmt_end_*
-if_stmt_false_*
+b fun_main_if1_end
+fun_main_if1_false:
 intc 3
 store 0
-if_stmt_end_*`
+fun_main_if1_end:`
 
 	CompareTEAL(a, expected, actual)
 }
@@ -319,8 +319,8 @@ store 2
 load 1
 load 2
 +
-b end_sum_*
-end_sum_*
+b fun_sum_inline1_end
+fun_sum_inline1_end:
 store 1
 intc 3
 store 2
@@ -331,23 +331,14 @@ store 4
 load 3
 load 4
 +
-b end_sum_*
-end_sum_*
+b fun_sum_inline2_end
+fun_sum_inline2_end:
 store 3
 intc 1
 return
 end_main:
 `
 	CompareTEAL(a, expected, actual)
-	lines := strings.Split(actual, "\n")
-	a.Contains(lines[12], "b end_sum_")
-	a.Contains(lines[13], "end_sum_")
-	a.Contains(lines[24], "b end_sum_")
-	a.Contains(lines[25], "end_sum_")
-	a.NotEqual(lines[12], lines[24])
-	a.NotEqual(lines[13], lines[25])
-	a.True(lines[13][len(lines[13])-1] == ':')
-	a.True(lines[25][len(lines[25])-1] == ':')
 }
 
 func TestCodegenFunCall(t *testing.T) {
@@ -539,8 +530,8 @@ fun_main:
 intc 1
 store 0
 intc 0
-b end_NoOp_*
-end_NoOp_*
+b fun_NoOp_inline1_end
+fun_NoOp_inline1_end:
 store 0
 intc 1
 return
@@ -597,10 +588,10 @@ fun_main:
 intc 2
 store 1
 intc 1
-bz if_stmt_end_*
+bz fun_main_if1_end
 intc 3
 store 2
-if_stmt_end_*
+fun_main_if1_end:
 load 1
 return
 end_main:
@@ -626,10 +617,10 @@ function logic() {
 intcblock 0 1
 fun_main:
 intc 1
-b end_test1_*
-end_test1_*
-b end_test2_*
-end_test2_*
+b fun_test1_inline1_end
+fun_test1_inline1_end:
+b fun_test2_inline1_end
+fun_test2_inline1_end:
 return
 end_main:
 `
@@ -1158,17 +1149,17 @@ intcblock 0 1 2
 fun_main:
 intc 2
 store 0
-loop_start_*
+fun_main_loop1_start:
 load 0
 intc 0
 >
-bz loop_end_*
+bz fun_main_loop1_end
 load 0
 intc 1
 -
 store 0
-b loop_start_*
-loop_end_*
+b fun_main_loop1_start
+fun_main_loop1_end:
 intc 1
 return
 end_main:
@@ -1198,21 +1189,21 @@ intcblock 0 1 10
 fun_main:
 intc 0
 store 0
-loop_start_*
+fun_main_loop1_start:
 intc 1
-bz loop_end_*
+bz fun_main_loop1_end
 load 0
 intc 2
 ==
-bz if_stmt_end_*
-bz loop_end_*
-if_stmt_end_*
+bz fun_main_if2_end
+b fun_main_loop1_end
+fun_main_if2_end:
 load 0
 intc 1
 +
 store 0
-b loop_start_*
-loop_end_*
+b fun_main_loop1_start
+fun_main_loop1_end:
 intc 1
 return
 end_main:
@@ -1646,3 +1637,312 @@ end_test:
 `
 	CompareTEAL(a, expected, actual)
 }
+
+func TestCodegenDeterministicLabels(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+inline function max(x, y) { if x > y { return x; } return y; }
+let z = if 1 { 2 } else { 3 }
+function logic() {
+	let i = 0
+	for i < 10 {
+		if i == z { break; }
+		i = max(i, z) + 1
+	}
+	return max(i, 1)
+}
+`
+	result, errors := Parse(source)
+	a.NotEmpty(result, errors)
+	a.Empty(errors)
+	first := Codegen(result)
+
+	result, errors = Parse(source)
+	a.NotEmpty(result, errors)
+	a.Empty(errors)
+	second := Codegen(result)
+
+	a.Equal(first, second)
+	a.Contains(first, "global_if1_end:\n")
+	a.Contains(first, "fun_main_loop1_end:\n")
+	a.Contains(first, "fun_max_inline1_end:\n")
+	a.Contains(first, "fun_max_inline3_end:\n")
+}
+
+func TestContinue(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	let y = 0;
+	for y < 10 {
+		y = y + 1
+		if y == 5 { continue; }
+		if y == 7 { break; }
+	}
+	return 1
+}
+`
+	result, errors := Parse(source)
+	a.NotEmpty(result, errors)
+	a.Empty(errors)
+	actual := Codegen(result)
+	expected := `#pragma version *
+intcblock 0 1 10 5 7
+fun_main:
+intc 0
+store 0
+fun_main_loop1_start:
+load 0
+intc 2
+<
+bz fun_main_loop1_end
+load 0
+intc 1
++
+store 0
+load 0
+intc 3
+==
+bz fun_main_if2_end
+b fun_main_loop1_start
+fun_main_if2_end:
+load 0
+intc 4
+==
+bz fun_main_if3_end
+b fun_main_loop1_end
+fun_main_if3_end:
+b fun_main_loop1_start
+fun_main_loop1_end:
+intc 1
+return
+end_main:
+`
+	CompareTEAL(a, expected, actual)
+}
+
+func TestLabeledBreakContinue(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	outer: for 1 {
+		for 1 {
+			continue outer;
+			break outer;
+			break;
+		}
+	}
+	return 1
+}
+`
+	result, errors := Parse(source)
+	a.NotEmpty(result, errors)
+	a.Empty(errors)
+	actual := Codegen(result)
+	expected := `#pragma version *
+intcblock 0 1
+fun_main:
+fun_main_loop1_start:
+intc 1
+bz fun_main_loop1_end
+fun_main_loop2_start:
+intc 1
+bz fun_main_loop2_end
+b fun_main_loop1_start
+b fun_main_loop1_end
+b fun_main_loop2_end
+b fun_main_loop2_start
+fun_main_loop2_end:
+b fun_main_loop1_start
+fun_main_loop1_end:
+intc 1
+return
+end_main:
+`
+	CompareTEAL(a, expected, actual)
+}
+
+func TestCodegenConcurrent(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	let i = 0
+	for i < 10 {
+		for 1 { break; }
+		i = i + 1
+		if i == 3 { continue; }
+		if i == 5 { break; }
+	}
+	return 1
+}
+`
+	expected, err := Compile(InputDesc{Source: source}, Options{})
+	a.NoError(err)
+
+	// every compilation parses own AST, labels must not depend on other compilations
+	type compiled struct {
+		teal string
+		err  error
+	}
+	const workers = 8
+	results := make(chan compiled, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			result, err := Compile(InputDesc{Source: source}, Options{})
+			if err != nil {
+				results <- compiled{err: err}
+				return
+			}
+			results <- compiled{teal: result.TEAL}
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		r := <-results
+		a.NoError(r.err)
+		a.Equal(expected.TEAL, r.teal)
+	}
+
+	// one parsed AST generated concurrently must produce the same TEAL
+	tree, errors := Parse(source)
+	a.NotEmpty(tree, errors)
+	a.Empty(errors)
+	teal := Codegen(tree)
+
+	tealResults := make(chan string, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			tealResults <- Codegen(tree)
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		a.Equal(teal, <-tealResults)
+	}
+}
+
+func TestCodegenFrameSubroutines(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+let g = 10
+inline function twice(x) { return x * 2; }
+function sum(a, b) {
+	let s = a + b
+	s = twice(s)
+	return s + g
+}
+function noop(a) void {
+	return
+}
+function logic() {
+	let x = sum(1, 2)
+	noop(x)
+	return x
+}
+`
+	actual := codegenVersion(a, source, 8)
+
+	expected := `#pragma version 8
+intcblock 0 1 10 2
+intc 2
+store 0
+fun_main:
+intc 1
+intc 3
+callsub fun_sum
+store 1
+load 1
+callsub fun_noop
+load 1
+return
+end_main:
+fun_sum:
+proto 2 1
+intc 0
+dupn 1
+frame_dig -2
+frame_dig -1
++
+frame_bury 0
+frame_dig 0
+frame_bury 1
+frame_dig 1
+intc 3
+*
+b fun_twice_inline1_end
+fun_twice_inline1_end:
+frame_bury 0
+frame_dig 0
+load 0
++
+retsub
+end_sum:
+fun_noop:
+proto 1 0
+retsub
+end_noop:
+`
+	CompareTEAL(a, expected, actual)
+
+	// scratch space based subroutines before v8
+	actual = codegenVersion(a, source, 7)
+	a.NotContains(actual, "proto")
+	a.NotContains(actual, "frame_")
+	a.Contains(actual, "fun_sum:\nstore 2\nstore 1\n")
+}
+
+func TestCodegenRecursion(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function fact(n) {
+	if n <= 1 {
+		return 1
+	}
+	return n * fact(n - 1)
+}
+function logic() {
+	return fact(5)
+}
+`
+	actual := codegenVersion(a, source, 8)
+
+	expected := `#pragma version 8
+intcblock 0 1 5
+fun_main:
+intc 2
+callsub fun_fact
+return
+end_main:
+fun_fact:
+proto 1 1
+frame_dig -1
+intc 1
+<=
+bz fun_fact_if1_end
+intc 1
+retsub
+fun_fact_if1_end:
+frame_dig -1
+frame_dig -1
+intc 1
+-
+callsub fun_fact
+*
+retsub
+end_fact:
+`
+	CompareTEAL(a, expected, actual)
+}
+
+// codegenVersion generates TEAL for the specified version without any post-processing
+func codegenVersion(a *require.Assertions, source string, version int) string {
+	prog, errors := parse(InputDesc{Source: source}, programRule, func(parseCtx *parseContext) {
+		parseCtx.version = version
+	})
+	a.Empty(errors)
+	return Codegen(prog)
+}
@@ -0,0 +1,403 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Compilation API
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/algorand/go-algorand/crypto"
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+
+	gen "github.com/pzbitskiy/tealang/gen/go"
+)
+
+// Options controls compilation
+type Options struct {
+	// TEALVersion is a target TEAL version, zero means the latest one supported by the assembler
+	TEALVersion int
+	// OptimizationLevel selects optimization passes, zero disables them.
+	// Level 1 folds constant expressions, prunes branches with constant conditions
+	// and applies peephole optimizations to generated TEAL
+	OptimizationLevel int
+	// Resolver locates imported modules, nil means standard library and file system lookup
+	Resolver ModuleResolver
+	// Assemble requests bytecode, program hash and address in addition to TEAL
+	Assemble bool
+	// OneLiner treats the input as a single logic expression like "(txn.Fee == 1) && (global.MinTxnFee < 2000)"
+	OneLiner bool
+	// WarningsAsErrors fails compilation if there are any warnings
+	WarningsAsErrors bool
+}
+
+// SourceLocation points to a place in tealang sources
+type SourceLocation struct {
+	File   string
+	Line   int // 1-based
+	Column int // 0-based
+}
+
+func (loc SourceLocation) String() string {
+	if loc.File == "" {
+		return fmt.Sprintf("%d:%d", loc.Line, loc.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", loc.File, loc.Line, loc.Column)
+}
+
+// SourceMap links generated program back to its sources
+type SourceMap struct {
+	// OffsetToLine maps bytecode offset to 0-based line of generated TEAL
+	OffsetToLine map[int]int
+	// LineToLocation maps 0-based line of generated TEAL to the innermost statement, call, operator
+	// or field access it was generated for
+	LineToLocation map[int]SourceLocation
+	// LineToStatement maps 0-based line of generated TEAL to the statement it was generated for
+	LineToStatement map[int]SourceLocation
+	// LineToVariable maps 0-based line of generated TEAL to the variable loaded or stored there
+	LineToVariable map[int]string
+	// LineToFunction maps 0-based line of generated TEAL to the function it belongs to
+	LineToFunction map[int]FunctionRef
+	// Scopes lists variables kept in scratch slots with bytecode offsets they are visible at,
+	// enclosing scopes go before nested ones. It is set for assembled programs
+	Scopes []VariableScope
+}
+
+// VariableScope is the program, a function, an inline expansion or a block declaring variables
+type VariableScope struct {
+	// First and Last are offsets of the first and the last instruction of the scope
+	First, Last int
+	// Slots maps names of variables declared in the scope to scratch slots
+	Slots map[string]int
+}
+
+// FunctionRef names a function, Inline is set for code of inline functions expanded at call sites
+type FunctionRef struct {
+	Name   string
+	Inline bool
+	// Expansion numbers inline expansions from 1 in order of generated code, it is 0 for other functions
+	Expansion int
+}
+
+// Location returns source location of an instruction at the bytecode offset
+func (m SourceMap) Location(pc int) (SourceLocation, bool) {
+	line, ok := m.OffsetToLine[pc]
+	if !ok {
+		return SourceLocation{}, false
+	}
+	loc, ok := m.LineToLocation[line]
+	return loc, ok
+}
+
+// Statement returns source location of the statement an instruction at the bytecode offset belongs to
+func (m SourceMap) Statement(pc int) (SourceLocation, bool) {
+	line, ok := m.OffsetToLine[pc]
+	if !ok {
+		return SourceLocation{}, false
+	}
+	loc, ok := m.LineToStatement[line]
+	return loc, ok
+}
+
+// Variable returns name of the variable loaded or stored by an instruction at the bytecode offset
+func (m SourceMap) Variable(pc int) (string, bool) {
+	line, ok := m.OffsetToLine[pc]
+	if !ok {
+		return "", false
+	}
+	name, ok := m.LineToVariable[line]
+	return name, ok
+}
+
+// Function returns the function an instruction at the bytecode offset belongs to
+func (m SourceMap) Function(pc int) (FunctionRef, bool) {
+	line, ok := m.OffsetToLine[pc]
+	if !ok {
+		return FunctionRef{}, false
+	}
+	ref, ok := m.LineToFunction[line]
+	return ref, ok
+}
+
+// Slot returns scratch slot of the variable visible to an instruction at the bytecode offset,
+// variables of nested scopes shadow ones of enclosing scopes
+func (m SourceMap) Slot(name string, pc int) (int, bool) {
+	slot, found := 0, false
+	for _, scope := range m.Scopes {
+		if pc < scope.First || pc > scope.Last {
+			continue
+		}
+		if s, ok := scope.Slots[name]; ok {
+			slot, found = s, true
+		}
+	}
+	return slot, found
+}
+
+// Declared tells if any scope of the program has the variable
+func (m SourceMap) Declared(name string) bool {
+	for _, scope := range m.Scopes {
+		if _, ok := scope.Slots[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AnnotateTrace appends source location to evaluation trace lines starting with program counter
+func (m SourceMap) AnnotateTrace(trace string) string {
+	lines := strings.Split(trace, "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pc, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		if loc, ok := m.Location(pc); ok {
+			lines[i] = fmt.Sprintf("%s\t// %s", line, loc)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var pcRe = regexp.MustCompile(`pc=(\d+)`)
+
+// AnnotateError adds source location to evaluation errors like "... pc=123"
+func (m SourceMap) AnnotateError(msg string) string {
+	match := pcRe.FindStringSubmatch(msg)
+	if match == nil {
+		return msg
+	}
+	pc, _ := strconv.Atoi(match[1])
+	if loc, ok := m.Location(pc); ok {
+		return fmt.Sprintf("%s at %s", msg, loc)
+	}
+	return msg
+}
+
+// Result of a compilation
+type Result struct {
+	TEAL     string
+	Bytecode []byte
+	// Hash of the program as used in logic signature
+	Hash crypto.Digest
+	// Address is an escrow account address of the program
+	Address   basics.Address
+	SourceMap SourceMap
+	// Cost is a static estimate of the program execution cost and size
+	Cost Cost
+
+	Diagnostics []ParserError
+}
+
+// Compile parses, generates TEAL and optionally assembles the program described by input.
+// Parser errors are returned both in Result.Diagnostics and as ParserErrors error value,
+// assembler errors and warnings are listed in Result.Diagnostics as well.
+// Warnings of imported modules are reported as well except for their functions never called,
+// WarningsAsErrors applies to all of them
+func Compile(input InputDesc, opts Options) (*Result, error) {
+	version := opts.TEALVersion
+	if version == 0 {
+		version = defaultTealVersion()
+	}
+	if version < minTealVersion || version > tealVersion() {
+		return nil, fmt.Errorf("unsupported TEAL version %d, supported are %d to %d", opts.TEALVersion, minTealVersion, tealVersion())
+	}
+	if opts.Assemble && version > assemblerVersion() {
+		return nil, fmt.Errorf("TEAL v%d can't be assembled, max supported by assembler is %d, compile to TEAL only", version, assemblerVersion())
+	}
+	if opts.OptimizationLevel < 0 {
+		return nil, fmt.Errorf("invalid optimization level %d", opts.OptimizationLevel)
+	}
+
+	entry := programRule
+	if opts.OneLiner {
+		entry = oneLineCondRule
+	}
+	var collector *errorCollector
+	prog, errors := parse(input, entry, func(parseCtx *parseContext) {
+		parseCtx.moduleResolver = opts.Resolver
+		parseCtx.version = version
+		parseCtx.optimize = opts.OptimizationLevel > 0
+		collector = parseCtx.collector
+	})
+
+	result := new(Result)
+	var warnings []ParserError
+	if collector != nil {
+		warnings = collector.sortedWarnings()
+	}
+	if len(errors) > 0 {
+		result.Diagnostics = append(errors, warnings...)
+		return result, ParserErrors(errors)
+	}
+	if opts.WarningsAsErrors && len(warnings) > 0 {
+		result.Diagnostics = warningsAsErrors(warnings)
+		return result, ParserErrors(result.Diagnostics)
+	}
+	result.Diagnostics = warnings
+
+	program := codegenInstructions(prog)
+	if opts.OptimizationLevel > 0 {
+		program = peephole(program)
+	}
+	program = layoutConstants(program, version)
+	result.TEAL = renderInstructions(program)
+	result.Cost = estimateCost(program, version)
+	result.SourceMap.LineToLocation = make(map[int]SourceLocation)
+	result.SourceMap.LineToStatement = make(map[int]SourceLocation)
+	result.SourceMap.LineToVariable = make(map[int]string)
+	result.SourceMap.LineToFunction = make(map[int]FunctionRef)
+	for line, ins := range program {
+		if ins.source.Line != 0 {
+			result.SourceMap.LineToLocation[line] = ins.source
+		}
+		if ins.statement.Line != 0 {
+			result.SourceMap.LineToStatement[line] = ins.statement
+		}
+		if ins.variable != "" {
+			result.SourceMap.LineToVariable[line] = ins.variable
+		}
+		if ins.function.Name != "" {
+			result.SourceMap.LineToFunction[line] = ins.function
+		}
+	}
+	if !opts.Assemble {
+		return result, nil
+	}
+
+	op, err := logic.AssembleString(result.TEAL)
+	if err != nil {
+		lines := make([]string, 0, len(op.Errors)+1)
+		for _, lineErr := range op.Errors {
+			lines = append(lines, lineErr.Error())
+		}
+		lines = append(lines, err.Error())
+		result.Diagnostics = assemblerErrors(op, result.TEAL, result.SourceMap, input.SourceFile)
+		return result, fmt.Errorf("assembly failed: %s", strings.Join(lines, "\n"))
+	}
+
+	result.Bytecode = op.Program
+	result.Hash = crypto.HashObj(logic.Program(op.Program))
+	result.Address = basics.Address(result.Hash)
+	result.SourceMap.OffsetToLine = op.OffsetToLine
+	result.SourceMap.Scopes = variableScopes(program, op.OffsetToLine)
+	return result, nil
+}
+
+// variableScopes finds bytecode offsets of instructions of every scope including its nested scopes
+func variableScopes(program []instruction, offsetToLine map[int]int) []VariableScope {
+	type scopeRange struct {
+		scope       *variableScope
+		depth       int
+		first, last int
+	}
+	ranges := make(map[*variableScope]*scopeRange)
+	for pc, line := range offsetToLine {
+		if line >= len(program) {
+			continue
+		}
+		for scope := program[line].scope; scope != nil; scope = scope.parent {
+			r, ok := ranges[scope]
+			if !ok {
+				r = &scopeRange{scope: scope, first: pc, last: pc}
+				for parent := scope.parent; parent != nil; parent = parent.parent {
+					r.depth++
+				}
+				ranges[scope] = r
+			}
+			if pc < r.first {
+				r.first = pc
+			}
+			if pc > r.last {
+				r.last = pc
+			}
+		}
+	}
+
+	sorted := make([]*scopeRange, 0, len(ranges))
+	for _, r := range ranges {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].first != sorted[j].first {
+			return sorted[i].first < sorted[j].first
+		}
+		return sorted[i].depth < sorted[j].depth
+	})
+	scopes := make([]VariableScope, len(sorted))
+	for i, r := range sorted {
+		scopes[i] = VariableScope{First: r.first, Last: r.last, Slots: r.scope.slots}
+	}
+	return scopes
+}
+
+// assemblerErrors converts assembler line errors to diagnostics pointing to tealang sources
+// of the offending TEAL lines where known
+func assemblerErrors(op *logic.OpStream, teal string, sourceMap SourceMap, filename string) []ParserError {
+	lines := strings.Split(teal, "\n")
+	diagnostics := make([]ParserError, 0, len(op.Errors))
+	for _, lineErr := range op.Errors {
+		// line errors are formatted as "<1-based line>: <message>"
+		var line int
+		fmt.Sscanf(lineErr.Error(), "%d:", &line)
+		info := ParserError{errorType: assemblerError, filename: filename, msg: lineErr.Error()}
+		if cause := errors.Unwrap(lineErr); cause != nil {
+			info.msg = cause.Error()
+		}
+		if line > 0 && line <= len(lines) {
+			info.excerpt = []string{strings.TrimSpace(lines[line-1])}
+			if loc, ok := sourceMap.LineToLocation[line-1]; ok {
+				info.filename, info.line, info.column = loc.File, loc.Line, loc.Column
+			}
+		}
+		diagnostics = append(diagnostics, info)
+	}
+	return diagnostics
+}
+
+// TestFunction is a function declared with test modifier like "test function transfer_ok()"
+type TestFunction struct {
+	Name string
+	Line int // 1-based
+	Void bool
+}
+
+// FindTests lists test functions declared in the source
+func FindTests(source string) []TestFunction {
+	lexer := gen.NewTealangLexer(antlr.NewInputStream(source))
+	lexer.RemoveErrorListeners()
+	var tokens []antlr.Token
+	for token := lexer.NextToken(); token.GetTokenType() != antlr.TokenEOF; token = lexer.NextToken() {
+		if token.GetChannel() == antlr.TokenDefaultChannel {
+			tokens = append(tokens, token)
+		}
+	}
+
+	var tests []TestFunction
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].GetText() != "test" || tokens[i+1].GetTokenType() != gen.TealangLexerFUNC || tokens[i+2].GetTokenType() != gen.TealangLexerIDENT {
+			continue
+		}
+		test := TestFunction{Name: tokens[i+2].GetText(), Line: tokens[i].GetLine()}
+		for j := i + 3; j < len(tokens) && tokens[j].GetTokenType() != gen.TealangLexerLEFTFIGURE; j++ {
+			if tokens[j].GetTokenType() == gen.TealangLexerVOID {
+				test.Void = true
+			}
+		}
+		tests = append(tests, test)
+	}
+	return tests
+}
@@ -0,0 +1,220 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand/crypto"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	let a = 1
+	return a + 2
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{})
+	a.NoError(err)
+	a.NotEmpty(result.TEAL)
+	a.Empty(result.Bytecode)
+	a.Empty(result.Diagnostics)
+	a.True(strings.HasPrefix(result.TEAL, fmt.Sprintf("#pragma version %d\n", defaultTealVersion())))
+
+	result, err = Compile(InputDesc{Source: source}, Options{Assemble: true})
+	a.NoError(err)
+	op, err := logic.AssembleString(result.TEAL)
+	a.NoError(err)
+	a.Equal(op.Program, result.Bytecode)
+	a.Equal(crypto.HashObj(logic.Program(op.Program)), result.Hash)
+	a.Equal(result.Hash[:], result.Address[:])
+	a.NotEmpty(result.SourceMap.OffsetToLine)
+}
+
+func TestCompileErrors(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	return a
+}
+`
+	result, err := Compile(InputDesc{Source: source, SourceFile: "test.tl"}, Options{Assemble: true})
+	a.Error(err)
+	a.NotNil(result)
+	a.Empty(result.TEAL)
+	a.NotEmpty(result.Diagnostics)
+	a.Equal("test.tl", result.Diagnostics[0].filename)
+	a.Contains(result.Diagnostics[0].String(), "ident not found")
+	var parserErrors ParserErrors
+	a.True(errors.As(err, &parserErrors))
+	a.Equal(len(result.Diagnostics), len(parserErrors))
+
+	_, err = Compile(InputDesc{Source: source}, Options{TEALVersion: tealVersion() + 1})
+	a.Error(err)
+	a.Contains(err.Error(), "unsupported TEAL version")
+
+	_, err = Compile(InputDesc{Source: source}, Options{OptimizationLevel: -1})
+	a.Error(err)
+}
+
+func TestDiagnostics(t *testing.T) {
+	a := require.New(t)
+
+	source := "function logic() {\n\tlet a = addr\"ABC\"\n\treturn a\n}\n"
+	result, err := Compile(InputDesc{Source: source, SourceFile: "test.tl"}, Options{})
+	a.Error(err)
+	a.Len(result.Diagnostics, 1)
+	e := result.Diagnostics[0]
+	a.Equal(Diagnostic{
+		Severity:  "error",
+		Code:      "TL0003",
+		File:      "test.tl",
+		Line:      2,
+		Column:    9,
+		EndLine:   2,
+		EndColumn: 18,
+		Message:   "decoded bad addr: ABC",
+	}, e.Diagnostic())
+	a.Equal(`addr"ABC"`, e.Token())
+	a.Equal("    let a = addr\"ABC\"", e.Excerpt()[0])
+
+	result, err = Compile(InputDesc{Source: "function logic() {\n\tlet a = 33bbb\n\treturn a\n}\n"}, Options{})
+	a.Error(err)
+	a.NotEmpty(result.Diagnostics)
+	e = result.Diagnostics[0]
+	a.Equal("TL0001", e.Code())
+	a.Equal("", e.File())
+	a.Equal(2, e.Line())
+	a.NotEmpty(e.Message())
+}
+
+func TestAssemblerDiagnostics(t *testing.T) {
+	a := require.New(t)
+
+	teal := "#pragma version 6\npushint 1\npushint\nreturn\n"
+	op, err := logic.AssembleString(teal)
+	a.Error(err)
+	sourceMap := SourceMap{LineToLocation: map[int]SourceLocation{2: {"test.tl", 3, 1}}}
+	diagnostics := assemblerErrors(op, teal, sourceMap, "main.tl")
+	a.Len(diagnostics, 1)
+	e := diagnostics[0]
+	a.Equal("TL0004", e.Code())
+	a.Equal("test.tl", e.File())
+	a.Equal(3, e.Line())
+	a.Equal(1, e.Column())
+	a.Equal(1, e.EndColumn())
+	a.Equal([]string{"pushint"}, e.Excerpt())
+	a.Contains(e.Message(), "pushint needs one argument")
+	a.NotContains(e.Message(), "3:")
+	a.True(strings.HasPrefix(e.String(), "assembler error at test.tl line 3, col 1\npushint\n"))
+
+	// lines without known source location are reported against the main file
+	diagnostics = assemblerErrors(op, teal, SourceMap{}, "main.tl")
+	a.Equal("main.tl", diagnostics[0].File())
+	a.Equal(0, diagnostics[0].Line())
+}
+
+func TestCompileOptions(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+import mymodule
+function logic() {
+	return test()
+}
+`
+	resolver := func(moduleName string, sourceDir string, currentDir string) (InputDesc, error) {
+		a.Equal("mymodule", moduleName)
+		return InputDesc{Source: "function test() { return 1; }\n", SourceFile: moduleName}, nil
+	}
+	result, err := Compile(InputDesc{Source: source}, Options{Resolver: resolver, TEALVersion: 5, Assemble: true})
+	a.NoError(err)
+	a.True(strings.HasPrefix(result.TEAL, "#pragma version 5\n"))
+	a.Contains(result.TEAL, "callsub fun_test")
+	a.Equal(byte(5), result.Bytecode[0])
+
+	result, err = Compile(InputDesc{Source: `txn.Fee < 1000 && global.GroupSize == 1`}, Options{OneLiner: true, Assemble: true})
+	a.NoError(err)
+	a.Contains(result.TEAL, "txn Fee")
+	a.NotEmpty(result.Bytecode)
+}
+
+func TestCompileTEALVersion(t *testing.T) {
+	a := require.New(t)
+
+	tests := []struct {
+		source  string
+		version int
+		line    int
+		msg     string
+	}{
+		{"function approval() {\n\tlet a = extract(\"abc\", 1, 1)\n\treturn 1\n}\n", 4, 2, "extract is not available in TEAL v4, requires v5"},
+		{"function approval() {\n\tlet a = gitxn[0].Fee\n\treturn a\n}\n", 5, 2, "gitxn is not available in TEAL v5, requires v6"},
+		{"function approval() {\n\tlet a = 1\n\tlet b, c = accounts[0].acctBalance()\n\treturn b\n}\n", 5, 3, "acct_params_get is not available in TEAL v5, requires v6"},
+		{"function approval() {\n\treturn global.OpcodeBudget\n}\n", 5, 2, "global.OpcodeBudget is not available in TEAL v5, requires v6"},
+		{"function approval() {\n\treturn txn.Nonparticipation\n}\n", 4, 2, "txn.Nonparticipation is not available in TEAL v4, requires v5"},
+		{"function approval() {\n\tlog(\"abc\")\n\treturn 1\n}\n", 4, 2, "log is not available in TEAL v4, requires v5"},
+		{"function approval() {\n\titxn.begin()\n\titxn.submit()\n\treturn 1\n}\n", 4, 2, "itxn_begin is not available in TEAL v4, requires v5"},
+		{"function approval() {\n\tassert(1)\n\treturn 1\n}\n", 2, 2, "assert is not available in TEAL v2, requires v3"},
+		{"function test() {\n\treturn 1\n}\nfunction approval() {\n\treturn test()\n}\n", 3, 5, "function 'test' cannot be called as a subroutine"},
+	}
+	for _, test := range tests {
+		result, err := Compile(InputDesc{Source: test.source}, Options{TEALVersion: test.version})
+		a.Error(err, test.source)
+		a.NotEmpty(result.Diagnostics, test.source)
+		a.Equal(test.line, result.Diagnostics[0].line, test.source)
+		a.Contains(result.Diagnostics[0].String(), test.msg, test.source)
+
+		_, err = Compile(InputDesc{Source: test.source}, Options{TEALVersion: tealVersion()})
+		a.NoError(err, test.source)
+	}
+
+	result, err := Compile(InputDesc{Source: "function logic() {\n\treturn txn.Fee < 1000\n}\n"}, Options{TEALVersion: 2, Assemble: true})
+	a.NoError(err)
+	a.True(strings.HasPrefix(result.TEAL, "#pragma version 2\n"))
+	a.Equal(byte(2), result.Bytecode[0])
+
+	_, err = Compile(InputDesc{Source: "function logic() {\n\treturn 1\n}\n"}, Options{TEALVersion: 1})
+	a.Error(err)
+	a.Contains(err.Error(), "unsupported TEAL version")
+}
+
+func TestFindTests(t *testing.T) {
+	a := require.New(t)
+
+	source := `// test function commented()
+const test = "test function quoted()"
+
+test function first() {
+	return 1
+}
+
+function helper() {
+	return 1
+}
+
+test function second() void {
+	return
+}
+`
+	a.Equal([]TestFunction{{Name: "first", Line: 4}, {Name: "second", Line: 12, Void: true}}, FindTests(source))
+
+	result, err := Compile(InputDesc{Source: source + "function logic() {\n\tsecond()\n\treturn first() + helper()\n}\n"}, Options{})
+	a.NoError(err)
+	a.Empty(result.Diagnostics)
+
+	_, err = Compile(InputDesc{Source: "check function f() {\n\treturn 1\n}\nfunction logic() {\n\treturn f()\n}\n"}, Options{})
+	a.Error(err)
+	a.Contains(err.Error(), "unexpected 'check' before function")
+
+	_, err = Compile(InputDesc{Source: "test function f(x) {\n\treturn x\n}\nfunction logic() {\n\treturn f(1)\n}\n"}, Options{})
+	a.Error(err)
+	a.Contains(err.Error(), "test function can't have arguments")
+}
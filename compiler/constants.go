@@ -0,0 +1,216 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Constant blocks layout
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pushVersion is the first TEAL version with pushint and pushbytes opcodes
+const pushVersion = 3
+
+// constBlock describes how intcblock or bytecblock entries are referenced in the program
+type constBlock struct {
+	refOp   string // intc or bytec
+	blockOp string // intcblock or bytecblock
+	pushOp  string // pushint or pushbytes
+	// key normalizes the value so that the same constant written differently is stored once
+	key func(value string) (string, bool)
+	// size returns number of bytes the value takes in the block or as push immediate
+	size func(value string) int
+}
+
+var intConstBlock = constBlock{
+	refOp:   "intc",
+	blockOp: "intcblock",
+	pushOp:  "pushint",
+	key: func(value string) (string, bool) {
+		num, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatUint(num, 10), true
+	},
+	size: func(value string) int {
+		num, _ := strconv.ParseUint(value, 10, 64)
+		return varuintSize(num)
+	},
+}
+
+var bytesConstBlock = constBlock{
+	refOp:   "bytec",
+	blockOp: "bytecblock",
+	pushOp:  "pushbytes",
+	key: func(value string) (string, bool) {
+		if !strings.HasPrefix(value, "0x") {
+			return "", false
+		}
+		data, err := hex.DecodeString(value[2:])
+		if err != nil {
+			return "", false
+		}
+		return "0x" + hex.EncodeToString(data), true
+	},
+	size: func(value string) int {
+		length := uint64(len(value)-2) / 2
+		return varuintSize(length) + int(length)
+	},
+}
+
+func varuintSize(value uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], value)
+}
+
+type constUsage struct {
+	value string
+	uses  int
+	first int // position in the original block to keep the order stable
+}
+
+// layoutConstants rebuilds intcblock and bytecblock: unused constants are dropped,
+// the most used ones get slots 0-3 with single byte intc_N/bytec_N references
+// and constants used once become pushint/pushbytes immediates when TEAL version allows it
+func layoutConstants(program []instruction, version int) []instruction {
+	program = intConstBlock.layout(program, version)
+	return bytesConstBlock.layout(program, version)
+}
+
+func (b constBlock) layout(program []instruction, version int) []instruction {
+	blockIdx := -1
+	for i, ins := range program {
+		if ins.op == b.blockOp {
+			blockIdx = i
+			break
+		}
+	}
+	if blockIdx == -1 {
+		return program
+	}
+
+	// map block offsets to deduplicated constants
+	block := program[blockIdx].args
+	usages := make(map[string]*constUsage, len(block))
+	offsets := make([]*constUsage, len(block))
+	for i, value := range block {
+		key, ok := b.key(value)
+		if !ok {
+			// leave the block as is if a value is not recognized
+			return program
+		}
+		usage, ok := usages[key]
+		if !ok {
+			usage = &constUsage{value: key, first: i}
+			usages[key] = usage
+		}
+		offsets[i] = usage
+	}
+
+	refs := make(map[int]*constUsage)
+	for i, ins := range program {
+		idx, ok := b.refIndex(ins)
+		if !ok {
+			continue
+		}
+		if idx >= len(offsets) {
+			return program
+		}
+		offsets[idx].uses++
+		refs[i] = offsets[idx]
+	}
+
+	// constants used once are cheaper as immediates, others stay in the block
+	push := make(map[*constUsage]bool)
+	kept := make([]*constUsage, 0, len(usages))
+	for _, usage := range usages {
+		if usage.uses == 0 {
+			continue
+		}
+		if usage.uses == 1 && version >= pushVersion {
+			push[usage] = true
+			continue
+		}
+		kept = append(kept, usage)
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if kept[i].uses != kept[j].uses {
+			return kept[i].uses > kept[j].uses
+		}
+		return kept[i].first < kept[j].first
+	})
+
+	// few constants might be cheaper to push than to keep the block at all
+	if version >= pushVersion && len(kept) > 0 {
+		blockSize := 1 + varuintSize(uint64(len(kept)))
+		pushSize := 0
+		for i, usage := range kept {
+			blockSize += b.size(usage.value) + usage.uses*b.refSize(i)
+			pushSize += usage.uses * (1 + b.size(usage.value))
+		}
+		if pushSize <= blockSize {
+			for _, usage := range kept {
+				push[usage] = true
+			}
+			kept = kept[:0]
+		}
+	}
+
+	slots := make(map[*constUsage]int, len(kept))
+	values := make([]string, len(kept))
+	for i, usage := range kept {
+		slots[usage] = i
+		values[i] = usage.value
+	}
+
+	result := make([]instruction, 0, len(program))
+	for i, ins := range program {
+		if i == blockIdx {
+			if len(values) > 0 {
+				result = append(result, newInstruction(b.blockOp, values...))
+			}
+			continue
+		}
+		usage, ok := refs[i]
+		switch {
+		case !ok:
+			result = append(result, ins)
+		case push[usage]:
+			result = append(result, ins.replace(b.pushOp, usage.value))
+		case slots[usage] < 4:
+			result = append(result, ins.replace(fmt.Sprintf("%s_%d", b.refOp, slots[usage])))
+		default:
+			result = append(result, ins.replace(b.refOp, strconv.Itoa(slots[usage])))
+		}
+	}
+	return result
+}
+
+// refIndex returns block offset referenced by intc/bytec instruction
+func (b constBlock) refIndex(ins instruction) (int, bool) {
+	if ins.op == b.refOp {
+		idx, err := strconv.Atoi(ins.arg())
+		return idx, err == nil
+	}
+	if strings.HasPrefix(ins.op, b.refOp+"_") {
+		idx, err := strconv.Atoi(ins.op[len(b.refOp)+1:])
+		return idx, err == nil
+	}
+	return 0, false
+}
+
+// refSize is a size of the reference to the block slot
+func (b constBlock) refSize(slot int) int {
+	if slot < 4 {
+		return 1
+	}
+	return 2
+}
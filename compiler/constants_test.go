@@ -0,0 +1,89 @@
+package compiler
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/algorand/go-algorand/data/transactions/logic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayoutConstants(t *testing.T) {
+	a := require.New(t)
+
+	tests := []struct {
+		source   string
+		version  int
+		expected string
+	}{
+		// most used constants go first, single use ones are pushed, unused are dropped
+		{
+			"intcblock 0 1 10 20\nbytecblock 0x01 0x02\nintc 2\nintc 3\nintc 3\nintc 3\nintc 3\nbytec 1\n",
+			6,
+			"intcblock 20\npushint 10\nintc_0\nintc_0\nintc_0\nintc_0\npushbytes 0x02\n",
+		},
+		// same value written differently shares the slot
+		{
+			"intcblock 16 0x10 7\nintc 0\nintc 1\nintc 2\nintc 2\nintc 2\n",
+			6,
+			"intcblock 7 16\nintc_1\nintc_1\nintc_0\nintc_0\nintc_0\n",
+		},
+		// slots above 3 need an explicit index
+		{
+			"intcblock 1 2 3 4 5\nintc 0\nintc 0\nintc 1\nintc 1\nintc 2\nintc 2\nintc 3\nintc 3\nintc 4\nintc 4\nintc 4\n",
+			6,
+			"intcblock 5 1 2 3 4\nintc_1\nintc_1\nintc_2\nintc_2\nintc_3\nintc_3\nintc 4\nintc 4\nintc_0\nintc_0\nintc_0\n",
+		},
+		// no push opcodes before v3
+		{
+			"intcblock 0 1 10\nintc 2\nreturn\n",
+			2,
+			"intcblock 10\nintc_0\nreturn\n",
+		},
+		// a block with a single small constant is not worth it
+		{
+			"intcblock 1\nintc 0\nintc 0\n+\n",
+			6,
+			"pushint 1\npushint 1\n+\n",
+		},
+	}
+	for _, test := range tests {
+		actual := renderInstructions(layoutConstants(splitInstructions(test.source), test.version))
+		a.Equal(test.expected, actual, test.source)
+	}
+}
+
+// TestExamplesSize guards against program size regressions
+func TestExamplesSize(t *testing.T) {
+	a := require.New(t)
+
+	tests := []struct {
+		file string
+		size int
+	}{
+		{"basic.tl", 52},
+		{"imports.tl", 199},
+		{"itxn.tl", 134},
+		{"printnum.tl", 189},
+		{"nft/approval.tl", 492},
+	}
+	for _, test := range tests {
+		fullPath := path.Join("..", "examples", test.file)
+		source, err := ioutil.ReadFile(fullPath)
+		a.NoError(err)
+		input := InputDesc{Source: string(source), SourceFile: path.Base(fullPath), SourceDir: path.Dir(fullPath)}
+
+		result, err := Compile(input, Options{Assemble: true})
+		a.NoError(err, test.file)
+		a.LessOrEqual(len(result.Bytecode), test.size, test.file)
+		a.Equal(len(result.Bytecode), result.Cost.Size, test.file)
+
+		// first-seen order constant blocks as generated
+		prog, errors := ParseProgram(input)
+		a.Empty(errors, test.file)
+		op, err := logic.AssembleString(Codegen(prog))
+		a.NoError(err, test.file)
+		a.Less(len(result.Bytecode), len(op.Program), test.file)
+	}
+}
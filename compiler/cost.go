@@ -0,0 +1,470 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Static cost and size estimation
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/algorand/go-algorand/config"
+	"github.com/algorand/go-algorand/protocol"
+)
+
+// Cost is a static estimate of program execution cost and bytecode size.
+// Opcode costs come from the language spec, variable costs like "1 + 1 per 16 bytes"
+// are approximated by the base value
+type Cost struct {
+	// Size is a length of the program bytecode
+	Size int
+	// Functions lists the main function followed by subroutines in program order
+	Functions []FunctionCost
+	Limits    CostLimits
+}
+
+// FunctionCost is a worst-case cost of a single function execution
+type FunctionCost struct {
+	Name string
+	// Cost of the most expensive path through the function with every loop body executed once,
+	// including costs of called subroutines
+	Cost int
+	// Recursive is set if the function calls itself directly or indirectly,
+	// Cost accounts for a single level of the recursion then
+	Recursive bool
+	// Loops lists worst-case costs of a single iteration
+	Loops []LoopCost
+}
+
+// LoopCost is a worst-case cost of a single loop iteration
+type LoopCost struct {
+	Label string
+	Cost  int
+}
+
+// CostLimits are consensus limits of the current protocol
+type CostLimits struct {
+	LogicSigSize int
+	LogicSigCost int
+	// AppPageSize is a max size of a single app program page
+	AppPageSize int
+	// AppExtraPages is a max number of extra pages an app can have
+	AppExtraPages int
+	// AppCost is an execution budget of a single app call
+	AppCost int
+}
+
+func currentCostLimits() CostLimits {
+	proto := config.Consensus[protocol.ConsensusCurrentVersion]
+	return CostLimits{
+		LogicSigSize:  int(proto.LogicSigMaxSize),
+		LogicSigCost:  int(proto.LogicSigMaxCost),
+		AppPageSize:   proto.MaxAppProgramLen,
+		AppExtraPages: proto.MaxExtraAppProgramPages,
+		AppCost:       proto.MaxAppProgramCost,
+	}
+}
+
+// Total is a worst-case cost of the whole program with every loop body executed once
+func (c Cost) Total() int {
+	if len(c.Functions) == 0 {
+		return 0
+	}
+	return c.Functions[0].Cost
+}
+
+// AppPages returns number of pages the program needs as an app program, 1 + extra pages
+func (c Cost) AppPages() int {
+	if c.Limits.AppPageSize == 0 {
+		return 0
+	}
+	pages := (c.Size + c.Limits.AppPageSize - 1) / c.Limits.AppPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	return pages
+}
+
+// FitsLogicSig tells if the program size and worst-case cost are within logic signature limits
+func (c Cost) FitsLogicSig() bool {
+	return c.Size <= c.Limits.LogicSigSize && c.Total() <= c.Limits.LogicSigCost
+}
+
+// FitsApp tells if the program size and worst-case cost are within a single app call limits
+func (c Cost) FitsApp() bool {
+	return c.AppPages() <= 1+c.Limits.AppExtraPages && c.Total() <= c.Limits.AppCost
+}
+
+func (c Cost) String() string {
+	verdict := func(ok bool) string {
+		if ok {
+			return "ok"
+		}
+		return "exceeds"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "size: %d bytes\n", c.Size)
+	fmt.Fprintf(&sb, "  logicsig: max %d bytes, %s\n", c.Limits.LogicSigSize, verdict(c.Size <= c.Limits.LogicSigSize))
+	fmt.Fprintf(&sb, "  app: %d page(s) of %d bytes, max %d extra page(s), %s\n",
+		c.AppPages(), c.Limits.AppPageSize, c.Limits.AppExtraPages, verdict(c.AppPages() <= 1+c.Limits.AppExtraPages))
+	fmt.Fprintf(&sb, "cost (worst case, loop bodies executed once):\n")
+	for _, fun := range c.Functions {
+		recursive := ""
+		if fun.Recursive {
+			recursive = " per recursion level"
+		}
+		fmt.Fprintf(&sb, "  %s: %d%s\n", fun.Name, fun.Cost, recursive)
+		for _, loop := range fun.Loops {
+			fmt.Fprintf(&sb, "    %s: %d per iteration\n", loop.Label, loop.Cost)
+		}
+	}
+	fmt.Fprintf(&sb, "  logicsig: max %d, %s\n", c.Limits.LogicSigCost, verdict(c.Total() <= c.Limits.LogicSigCost))
+	fmt.Fprintf(&sb, "  app: max %d per call, %s\n", c.Limits.AppCost, verdict(c.Total() <= c.Limits.AppCost))
+	return sb.String()
+}
+
+// costEstimator walks control flow graph of generated TEAL.
+// Every function is a range of instructions starting at the program beginning or a callsub target
+type costEstimator struct {
+	program []instruction
+	labels  map[string]int
+	entries []int
+	costs   map[int]*FunctionCost
+	// stack of functions being estimated, callsub to any of them is a recursion
+	stack     []int
+	recursive map[int]bool
+}
+
+func estimateCost(program []instruction, version int) Cost {
+	e := &costEstimator{
+		program:   program,
+		labels:    make(map[string]int),
+		costs:     make(map[int]*FunctionCost),
+		recursive: make(map[int]bool),
+	}
+	for i, ins := range program {
+		if ins.label != "" {
+			e.labels[ins.label] = i
+		}
+	}
+	entries := map[int]bool{0: true}
+	for _, ins := range program {
+		if ins.op == "callsub" {
+			if idx, ok := e.labels[ins.arg()]; ok {
+				entries[idx] = true
+			}
+		}
+	}
+	for idx := range entries {
+		e.entries = append(e.entries, idx)
+	}
+	sort.Ints(e.entries)
+
+	cost := Cost{Size: programSize(program, version), Limits: currentCostLimits()}
+	for _, entry := range e.entries {
+		cost.Functions = append(cost.Functions, *e.function(entry))
+	}
+	return cost
+}
+
+// function returns cost of a function starting at the entry, calculating it on first use
+func (e *costEstimator) function(entry int) *FunctionCost {
+	if fun, ok := e.costs[entry]; ok {
+		return fun
+	}
+	name := mainFuncSourceName
+	if entry != 0 {
+		name = strings.TrimPrefix(e.program[entry].label, "fun_")
+	}
+	fun := &FunctionCost{Name: name}
+	e.stack = append(e.stack, entry)
+	g := e.graph(entry)
+	e.stack = e.stack[:len(e.stack)-1]
+
+	fun.Cost = g.worstPath(entry)
+	fun.Loops = g.loops()
+	fun.Recursive = e.recursive[entry]
+	e.costs[entry] = fun
+	return fun
+}
+
+// call returns cost of a subroutine call, recursive calls are not accounted
+// and mark every function in the cycle as recursive
+func (e *costEstimator) call(target int) int {
+	for i, entry := range e.stack {
+		if entry != target {
+			continue
+		}
+		for _, active := range e.stack[i:] {
+			e.recursive[active] = true
+		}
+		return 0
+	}
+	return e.function(target).Cost
+}
+
+// end returns the first instruction after the function starting at the entry
+func (e *costEstimator) end(entry int) int {
+	idx := sort.SearchInts(e.entries, entry+1)
+	if idx == len(e.entries) {
+		return len(e.program)
+	}
+	return e.entries[idx]
+}
+
+// flowGraph is a control flow graph of a function with instructions as nodes
+type flowGraph struct {
+	program []instruction
+	cost    map[int]int
+	next    map[int][]int
+	// back edges close loops, bodies are indexed by loop header
+	back    map[[2]int]bool
+	headers []int
+	bodies  map[int]map[int]bool
+}
+
+func (e *costEstimator) graph(entry int) *flowGraph {
+	end := e.end(entry)
+	g := &flowGraph{
+		program: e.program,
+		cost:    make(map[int]int),
+		next:    make(map[int][]int),
+		back:    make(map[[2]int]bool),
+		bodies:  make(map[int]map[int]bool),
+	}
+	for i := entry; i < end; i++ {
+		ins := e.program[i]
+		g.cost[i] = opcodeCost(ins)
+		if ins.op == "callsub" {
+			if target, ok := e.labels[ins.arg()]; ok {
+				g.cost[i] += e.call(target)
+			}
+		}
+
+		var next []int
+		switch ins.op {
+		case "return", "retsub", "err":
+		case "b", "bz", "bnz":
+			if target, ok := e.labels[ins.arg()]; ok {
+				next = append(next, target)
+			}
+			if ins.op == "b" {
+				break
+			}
+			fallthrough
+		default:
+			if i+1 < end {
+				next = append(next, i+1)
+			}
+		}
+		g.next[i] = next
+	}
+	g.findLoops(entry)
+	return g
+}
+
+// findLoops marks edges closing loops by depth first search
+// and collects loop bodies as nodes reaching a back edge without passing the header
+func (g *flowGraph) findLoops(entry int) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[int]int)
+	var visit func(node int)
+	visit = func(node int) {
+		state[node] = visiting
+		for _, next := range g.next[node] {
+			switch state[next] {
+			case visiting:
+				g.back[[2]int{node, next}] = true
+			case 0:
+				visit(next)
+			}
+		}
+		state[node] = done
+	}
+	visit(entry)
+
+	prev := make(map[int][]int)
+	for node, nexts := range g.next {
+		for _, next := range nexts {
+			prev[next] = append(prev[next], node)
+		}
+	}
+	for edge := range g.back {
+		header := edge[1]
+		body, ok := g.bodies[header]
+		if !ok {
+			body = map[int]bool{header: true}
+			g.bodies[header] = body
+			g.headers = append(g.headers, header)
+		}
+		stack := []int{edge[0]}
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if body[node] {
+				continue
+			}
+			body[node] = true
+			stack = append(stack, prev[node]...)
+		}
+	}
+	sort.Ints(g.headers)
+}
+
+// worstPath returns cost of the most expensive path from the entry
+// taking every back edge at most once, so loop bodies are executed once
+func (g *flowGraph) worstPath(entry int) int {
+	memo := make(map[string]int)
+	var walk func(node int, taken []int) int
+	walk = func(node int, taken []int) int {
+		// only loops enclosing the node affect paths from it
+		inside := make([]int, 0, len(taken))
+		for _, header := range taken {
+			if g.bodies[header][node] {
+				inside = append(inside, header)
+			}
+		}
+		key := fmt.Sprint(node, inside)
+		if cost, ok := memo[key]; ok {
+			return cost
+		}
+
+		best := 0
+		for _, next := range g.next[node] {
+			nextTaken := inside
+			if g.back[[2]int{node, next}] {
+				if containsInt(inside, next) {
+					continue
+				}
+				nextTaken = append(append([]int{}, inside...), next)
+			}
+			if cost := walk(next, nextTaken); cost > best {
+				best = cost
+			}
+		}
+		best += g.cost[node]
+		memo[key] = best
+		return best
+	}
+	return walk(entry, nil)
+}
+
+// iteration returns cost of the most expensive path from the loop header to any of its back edges
+func (g *flowGraph) iteration(header int) int {
+	const unreachable = -1
+	body := g.bodies[header]
+	memo := make(map[int]int)
+	var walk func(node int) int
+	walk = func(node int) int {
+		if cost, ok := memo[node]; ok {
+			return cost
+		}
+		best := unreachable
+		for _, next := range g.next[node] {
+			if g.back[[2]int{node, next}] {
+				if next == header {
+					best = 0
+				}
+				continue
+			}
+			if !body[next] {
+				continue
+			}
+			if cost := walk(next); cost > best {
+				best = cost
+			}
+		}
+		if best != unreachable {
+			best += g.cost[node]
+		}
+		memo[node] = best
+		return best
+	}
+	if cost := walk(header); cost != unreachable {
+		return cost
+	}
+	return 0
+}
+
+// loops returns per iteration cost of every loop, loops are named by header labels
+func (g *flowGraph) loops() []LoopCost {
+	result := make([]LoopCost, 0, len(g.headers))
+	for _, header := range g.headers {
+		label := g.program[header].label
+		if label == "" {
+			label = strconv.Itoa(header)
+		}
+		result = append(result, LoopCost{label, g.iteration(header)})
+	}
+	return result
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// opcodeCost returns opcode cost from the language spec, labels and directives are free
+func opcodeCost(ins instruction) int {
+	if ins.op == "" || strings.HasPrefix(ins.op, "#") {
+		return 0
+	}
+	if op, ok := langOps[ins.op]; ok {
+		return op.Cost
+	}
+	return 1
+}
+
+// programSize returns bytecode length: version prefix and opcodes with immediate arguments
+func programSize(program []instruction, version int) int {
+	size := varuintSize(uint64(version))
+	for _, ins := range program {
+		if ins.op == "" || strings.HasPrefix(ins.op, "#") {
+			continue
+		}
+		op, ok := langOps[ins.op]
+		if ok && op.Size > 0 {
+			size += op.Size
+			continue
+		}
+		size++
+		switch ins.op {
+		case "intcblock":
+			size += varuintSize(uint64(len(ins.args)))
+			for _, arg := range ins.args {
+				size += intConstBlock.immediateSize(arg)
+			}
+		case "bytecblock":
+			size += varuintSize(uint64(len(ins.args)))
+			for _, arg := range ins.args {
+				size += bytesConstBlock.immediateSize(arg)
+			}
+		case "pushint":
+			size += intConstBlock.immediateSize(ins.arg())
+		case "pushbytes":
+			size += bytesConstBlock.immediateSize(ins.arg())
+		}
+	}
+	return size
+}
+
+// immediateSize returns encoded size of a constant as written in TEAL
+func (b constBlock) immediateSize(value string) int {
+	key, ok := b.key(value)
+	if !ok {
+		return 0
+	}
+	return b.size(key)
+}
@@ -0,0 +1,100 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCostEstimate(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function double(x) {
+	return x * 2
+}
+function logic() {
+	let a = 0
+	for a < 10 {
+		if a == 5 {
+			a = double(a)
+		}
+		a = a + 1
+	}
+	if txn.Fee > 1000 {
+		return sha256("a") == "b"
+	}
+	return a
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{Assemble: true})
+	a.NoError(err)
+
+	cost := result.Cost
+	a.Equal(len(result.Bytecode), cost.Size)
+	a.Len(cost.Functions, 2)
+
+	// store and load x, multiply and retsub
+	a.Equal(FunctionCost{Name: "double", Cost: 5, Loops: []LoopCost{}}, cost.Functions[1])
+
+	// loop condition, if statement with double() call and increment
+	logic := cost.Functions[0]
+	a.Equal("logic", logic.Name)
+	a.False(logic.Recursive)
+	a.Equal([]LoopCost{{"fun_main_loop1_start", 4 + 4 + 1 + 6 + 1 + 5}}, logic.Loops)
+	// init, one iteration, loop condition again and sha256 branch
+	a.Equal(2+21+4+4+1+35+3, logic.Cost)
+	a.Equal(logic.Cost, cost.Total())
+
+	a.True(cost.FitsLogicSig())
+	a.True(cost.FitsApp())
+	a.Equal(1, cost.AppPages())
+	a.Contains(cost.String(), "fun_main_loop1_start: 21 per iteration")
+}
+
+func TestCostLimits(t *testing.T) {
+	a := require.New(t)
+
+	cost := Cost{
+		Size:      1500,
+		Functions: []FunctionCost{{Name: "logic", Cost: 1000}},
+		Limits:    CostLimits{LogicSigSize: 1000, LogicSigCost: 20000, AppPageSize: 1024, AppExtraPages: 3, AppCost: 700},
+	}
+	a.Equal(2, cost.AppPages())
+	a.False(cost.FitsLogicSig())
+	a.False(cost.FitsApp())
+
+	cost.Functions[0].Cost = 500
+	a.True(cost.FitsApp())
+
+	cost.Size = 5000
+	a.Equal(5, cost.AppPages())
+	a.False(cost.FitsApp())
+	a.Contains(cost.String(), "app: 5 page(s) of 1024 bytes, max 3 extra page(s), exceeds")
+}
+
+func TestCostRecursion(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function fact(n) {
+	if n <= 1 {
+		return 1
+	}
+	return n * fact(n - 1)
+}
+function logic() {
+	return fact(5)
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{TEALVersion: 8})
+	a.NoError(err)
+
+	a.Len(result.Cost.Functions, 2)
+	fact := result.Cost.Functions[1]
+	a.Equal("fact", fact.Name)
+	a.True(fact.Recursive)
+	a.False(result.Cost.Functions[0].Recursive)
+	a.Equal(result.Cost.Functions[0].Cost, 3+fact.Cost)
+	a.Contains(result.Cost.String(), "fact: 12 per recursion level")
+}
@@ -0,0 +1,2302 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Decompiler
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/algorand/go-algorand/data/transactions/logic"
+
+	gen "github.com/pzbitskiy/tealang/gen/go"
+)
+
+// DecompileResult is tealang source recovered from a program
+type DecompileResult struct {
+	Source string
+	// Unrecognized lists functions kept as commented TEAL listings because their structure was not recovered
+	Unrecognized []string
+}
+
+// Decompile recovers tealang source from program bytecode.
+// Branches become if-else statements and for loops, subroutines become functions
+// and scratch slots become variables named after them
+func Decompile(bytecode []byte) (*DecompileResult, error) {
+	listing, err := logic.Disassemble(bytecode)
+	if err != nil {
+		return nil, err
+	}
+	return decompileListing(listing)
+}
+
+// DecompileTEAL assembles TEAL program and decompiles the result
+func DecompileTEAL(teal string) (*DecompileResult, error) {
+	op, err := logic.AssembleString(teal)
+	if err != nil {
+		lines := make([]string, 0, len(op.Errors)+1)
+		for _, lineErr := range op.Errors {
+			lines = append(lines, lineErr.Error())
+		}
+		lines = append(lines, err.Error())
+		return nil, fmt.Errorf("assembly failed: %s", strings.Join(lines, "\n"))
+	}
+	return Decompile(op.Program)
+}
+
+func decompileListing(listing string) (*DecompileResult, error) {
+	prog, err := parseListing(listing)
+	if err != nil {
+		return nil, err
+	}
+	d := &decompiler{listing: prog, varTypes: make(map[string]exprType)}
+	d.findFunctions()
+	for _, f := range d.order {
+		d.analyzeStack(f)
+	}
+
+	// slot types are learnt from stored values, later passes make use of types found by earlier ones
+	for pass := 0; pass < 4; pass++ {
+		known := len(d.varTypes)
+		d.structure()
+		if len(d.varTypes) == known && pass > 0 {
+			break
+		}
+	}
+	return d.render(), nil
+}
+
+// programEnd is a successor of instructions falling or branching past the end of program
+const programEnd = -1
+
+// exitNode is a virtual block post-dominating all blocks of a region
+const exitNode = -2
+
+// noStop is a stop block for walks continuing until control leaves the region
+const noStop = -3
+
+//--------------------------------------------------------------------------------------------------
+//
+// Listing and control flow graph
+//
+//--------------------------------------------------------------------------------------------------
+
+type tealOp struct {
+	name string
+	args []string
+	text string
+}
+
+type tealBlock struct {
+	start, end int // instructions range
+	term       string
+	next       int // block after the last instruction
+	target     int // branch target
+}
+
+func (b *tealBlock) succs() []int {
+	switch b.term {
+	case "b":
+		return []int{b.target}
+	case "bz", "bnz":
+		return []int{b.next, b.target}
+	case "return", "err", "retsub":
+		return nil
+	}
+	return []int{b.next}
+}
+
+type tealListing struct {
+	ops     []tealOp
+	labels  map[string]int   // label to instruction index
+	names   map[int][]string // instruction index to labels
+	intc    []string
+	bytec   [][]byte
+	blocks  []tealBlock
+	blockAt map[int]int // first instruction to block
+}
+
+var terminators = map[string]bool{"b": true, "bz": true, "bnz": true, "return": true, "err": true, "retsub": true}
+
+func parseListing(text string) (*tealListing, error) {
+	l := &tealListing{labels: make(map[string]int), names: make(map[int][]string), blockAt: make(map[int]int)}
+	for _, line := range strings.Split(text, "\n") {
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, ":") {
+			label := strings.TrimSuffix(line, ":")
+			l.labels[label] = len(l.ops)
+			l.names[len(l.ops)] = append(l.names[len(l.ops)], label)
+			continue
+		}
+		fields := strings.Fields(line)
+		op := tealOp{name: fields[0], args: fields[1:], text: line}
+		switch op.name {
+		case "intcblock":
+			l.intc = op.args
+		case "bytecblock":
+			l.bytec = l.bytec[:0]
+			for _, arg := range op.args {
+				data, err := hex.DecodeString(strings.TrimPrefix(arg, "0x"))
+				if err != nil {
+					return nil, fmt.Errorf("invalid bytecblock constant %s", arg)
+				}
+				l.bytec = append(l.bytec, data)
+			}
+		}
+		l.ops = append(l.ops, op)
+	}
+
+	leaders := map[int]bool{0: true}
+	for i, op := range l.ops {
+		switch op.name {
+		case "b", "bz", "bnz", "callsub":
+			if len(op.args) != 1 {
+				return nil, fmt.Errorf("%s: missing label", op.text)
+			}
+			target, ok := l.labels[op.args[0]]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown label", op.text)
+			}
+			leaders[target] = true
+		}
+		if terminators[op.name] {
+			leaders[i+1] = true
+		}
+	}
+	starts := make([]int, 0, len(leaders))
+	for start := range leaders {
+		if start < len(l.ops) {
+			starts = append(starts, start)
+		}
+	}
+	sort.Ints(starts)
+	for i, start := range starts {
+		end := len(l.ops)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		l.blockAt[start] = i
+		l.blocks = append(l.blocks, tealBlock{start: start, end: end})
+	}
+	for i := range l.blocks {
+		b := &l.blocks[i]
+		b.next = l.blockOf(b.end)
+		b.target = programEnd
+		last := l.ops[b.end-1]
+		if terminators[last.name] {
+			b.term = last.name
+			if len(last.args) > 0 {
+				b.target = l.blockOf(l.labels[last.args[0]])
+			}
+		}
+	}
+	return l, nil
+}
+
+// blockOf returns a block starting at the instruction, instructions past the end map to programEnd
+func (l *tealListing) blockOf(idx int) int {
+	if b, ok := l.blockAt[idx]; ok {
+		return b
+	}
+	return programEnd
+}
+
+// body returns block instructions except the final branch
+func (l *tealListing) body(b int) []tealOp {
+	blk := &l.blocks[b]
+	ops := l.ops[blk.start:blk.end]
+	if blk.term != "" {
+		ops = ops[:len(ops)-1]
+	}
+	return ops
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Functions
+//
+//--------------------------------------------------------------------------------------------------
+
+type decompiledFunc struct {
+	name   string
+	entry  int
+	main   bool
+	blocks []int
+	member map[int]bool
+	calls  []*decompiledFunc
+
+	args, rets int // -1 until the stack analysis succeeds
+	err        error
+
+	paramVars  []*slotVar // variables arguments are saved to, nil if an argument stays on the stack
+	paramTypes []exprType
+	retType    exprType
+
+	params []string
+	stmts  []*dstmt
+	decls  []string // hoisted variables
+}
+
+type decompiler struct {
+	listing  *tealListing
+	funcs    map[int]*decompiledFunc // by entry block
+	order    []*decompiledFunc       // callees first
+	globals  map[int]bool            // slots shared by functions
+	varTypes map[string]exprType     // by variable key
+}
+
+// findFunctions splits blocks into the main function and subroutines called with callsub
+func (d *decompiler) findFunctions() {
+	l := d.listing
+	d.funcs = make(map[int]*decompiledFunc)
+	d.globals = make(map[int]bool)
+	if len(l.blocks) == 0 {
+		return
+	}
+	owner := make(map[int]*decompiledFunc)
+	queue := []*decompiledFunc{{name: "logic", entry: 0, main: true}}
+	d.funcs[0] = queue[0]
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		f.member = make(map[int]bool)
+		f.args, f.rets = -1, -1
+		pending := []int{f.entry}
+		for len(pending) > 0 {
+			b := pending[0]
+			pending = pending[1:]
+			if b == programEnd || f.member[b] {
+				continue
+			}
+			if other, ok := owner[b]; ok && other != f {
+				f.err = fmt.Errorf("shares code with %s", other.name)
+				other.err = fmt.Errorf("shares code with %s", f.name)
+				continue
+			}
+			owner[b] = f
+			f.member[b] = true
+			f.blocks = append(f.blocks, b)
+			for _, op := range l.ops[l.blocks[b].start:l.blocks[b].end] {
+				switch op.name {
+				case "callsub":
+					entry := l.blockOf(l.labels[op.args[0]])
+					callee, ok := d.funcs[entry]
+					if !ok {
+						callee = &decompiledFunc{entry: entry}
+						d.funcs[entry] = callee
+						queue = append(queue, callee)
+					}
+					f.calls = append(f.calls, callee)
+				}
+			}
+			pending = append(pending, l.blocks[b].succs()...)
+		}
+		sort.Ints(f.blocks)
+	}
+
+	entries := make([]int, 0, len(d.funcs))
+	for entry := range d.funcs {
+		entries = append(entries, entry)
+	}
+	sort.Ints(entries)
+	for i, entry := range entries {
+		if entry != 0 {
+			d.funcs[entry].name = fmt.Sprintf("sub%d", i)
+		}
+	}
+
+	visited := make(map[*decompiledFunc]bool)
+	var visit func(f *decompiledFunc)
+	visit = func(f *decompiledFunc) {
+		if visited[f] {
+			return
+		}
+		visited[f] = true
+		for _, callee := range f.calls {
+			visit(callee)
+		}
+		d.order = append(d.order, f)
+	}
+	visit(d.funcs[0])
+	d.findGlobals()
+}
+
+// slotFlow is a state of scratch slots at a block entry
+type slotFlow struct {
+	own     map[int]bool // written by the function on all paths
+	foreign map[int]bool // might be written by a called subroutine
+}
+
+func copySlots(slots map[int]bool) map[int]bool {
+	result := make(map[int]bool, len(slots))
+	for slot := range slots {
+		result[slot] = true
+	}
+	return result
+}
+
+// findGlobals marks slots passing values between functions.
+// Tealang reuses slots for locals of different functions, they stay local
+// unless a subroutine reads a slot before writing it or a caller reads a slot written by a subroutine
+func (d *decompiler) findGlobals() {
+	l := d.listing
+	written := make(map[*decompiledFunc]map[int]bool)
+	for _, f := range d.order {
+		slots := make(map[int]bool)
+		for _, b := range f.blocks {
+			for _, op := range l.ops[l.blocks[b].start:l.blocks[b].end] {
+				switch op.name {
+				case "store":
+					if slot, err := immediate(op, 0); err == nil {
+						slots[slot] = true
+					}
+				case "callsub":
+					for slot := range written[d.funcs[l.blockOf(l.labels[op.args[0]])]] {
+						slots[slot] = true
+					}
+				}
+			}
+		}
+		written[f] = slots
+	}
+
+	for _, f := range d.order {
+		states := map[int]*slotFlow{f.entry: {own: map[int]bool{}, foreign: map[int]bool{}}}
+		for changed := true; changed; {
+			changed = false
+			for _, b := range f.blocks {
+				state, ok := states[b]
+				if !ok {
+					continue
+				}
+				own, foreign := copySlots(state.own), copySlots(state.foreign)
+				for _, op := range l.ops[l.blocks[b].start:l.blocks[b].end] {
+					slot, _ := immediate(op, 0)
+					switch op.name {
+					case "load":
+						if !f.main && !own[slot] || foreign[slot] {
+							d.globals[slot] = true
+						}
+					case "store":
+						own[slot] = true
+						delete(foreign, slot)
+					case "callsub":
+						for slot := range written[d.funcs[l.blockOf(l.labels[op.args[0]])]] {
+							foreign[slot] = true
+						}
+					}
+				}
+				for _, s := range l.blocks[b].succs() {
+					if s == programEnd {
+						continue
+					}
+					next, ok := states[s]
+					if !ok {
+						states[s] = &slotFlow{own: copySlots(own), foreign: copySlots(foreign)}
+						changed = true
+						continue
+					}
+					for slot := range next.own {
+						if !own[slot] {
+							delete(next.own, slot)
+							changed = true
+						}
+					}
+					for slot := range foreign {
+						if !next.foreign[slot] {
+							next.foreign[slot] = true
+							changed = true
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// stackEffect returns number of values an instruction pops and pushes
+func (d *decompiler) stackEffect(op tealOp) (int, int, error) {
+	n := 0
+	if len(op.args) > 0 {
+		n, _ = strconv.Atoi(op.args[0])
+	}
+	switch op.name {
+	case "callsub":
+		callee := d.funcs[d.listing.blockOf(d.listing.labels[op.args[0]])]
+		if callee.args < 0 {
+			return 0, 0, fmt.Errorf("calls unrecognized function %s", callee.name)
+		}
+		return callee.args, callee.rets, nil
+	case "intcblock", "bytecblock":
+		return 0, 0, nil
+	case "dig":
+		return n + 1, n + 2, nil
+	case "cover", "uncover":
+		return n + 1, n + 1, nil
+	case "dupn":
+		return 1, n + 1, nil
+	case "popn":
+		return n, 0, nil
+	case "bury":
+		return n + 1, n, nil
+	}
+	spec, ok := langOps[op.name]
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported opcode %s", op.name)
+	}
+	return len(spec.Args), len(spec.Returns), nil
+}
+
+// analyzeStack finds arguments and return values count of a function
+// by checking stack height is the same on all paths
+func (d *decompiler) analyzeStack(f *decompiledFunc) {
+	if f.err != nil {
+		return
+	}
+	l := d.listing
+	heights := map[int]int{f.entry: 0}
+	low, ret, returns := 0, 0, false
+	pending := []int{f.entry}
+	for len(pending) > 0 {
+		b := pending[0]
+		pending = pending[1:]
+		blk := &l.blocks[b]
+		h := heights[b]
+		for _, op := range l.ops[blk.start:blk.end] {
+			pops, pushes, err := d.stackEffect(op)
+			if err != nil {
+				f.err = err
+				return
+			}
+			h -= pops
+			if h < low {
+				low = h
+			}
+			h += pushes
+		}
+		switch blk.term {
+		case "retsub":
+			if f.main {
+				f.err = fmt.Errorf("retsub outside of subroutine")
+				return
+			}
+			if returns && ret != h {
+				f.err = fmt.Errorf("returns different number of values")
+				return
+			}
+			ret, returns = h, true
+		case "return":
+			if !f.main {
+				f.err = fmt.Errorf("program exit inside subroutine")
+				return
+			}
+		}
+		for _, s := range blk.succs() {
+			if s == programEnd {
+				if !f.main {
+					f.err = fmt.Errorf("subroutine runs past the end of program")
+					return
+				}
+				continue
+			}
+			if prev, ok := heights[s]; ok {
+				if prev != h {
+					f.err = fmt.Errorf("stack height differs at %s", l.ops[l.blocks[s].start].text)
+					return
+				}
+				continue
+			}
+			heights[s] = h
+			pending = append(pending, s)
+		}
+	}
+	if f.main {
+		if low < 0 {
+			f.err = fmt.Errorf("stack underflow")
+			return
+		}
+		f.args, f.rets = 0, 1
+		return
+	}
+	f.args = -low
+	f.rets = 0
+	if returns {
+		f.rets = ret - low
+	}
+	if f.rets > 1 {
+		f.err = fmt.Errorf("returns %d values", f.rets)
+		f.args = -1
+	}
+}
+
+// structure recovers statements of all functions
+func (d *decompiler) structure() {
+	for _, f := range d.order {
+		f.params, f.stmts, f.decls = nil, nil, nil
+		if f.err != nil || f.args < 0 {
+			continue
+		}
+		fd := newFuncDecompiler(d, f)
+		if err := fd.run(); err != nil {
+			f.err = err
+			f.stmts = nil
+		}
+	}
+}
+
+// slotVar is a variable made of slot stores and loads connected by values they pass.
+// Tealang reuses slots for variables of different scopes and types, they become different variables
+type slotVar struct {
+	name   string
+	key    string // unique in the program
+	global bool
+}
+
+func globalVar(slot int) *slotVar {
+	name := fmt.Sprintf("s%d", slot)
+	return &slotVar{name: name, key: name, global: true}
+}
+
+// varType is a type of the variable, variables are typed once and all values stored are casted to the type
+func (d *decompiler) varType(v *slotVar) exprType {
+	if tp, ok := d.varTypes[v.key]; ok {
+		return tp
+	}
+	return intType
+}
+
+func (d *decompiler) learnType(v *slotVar, tp exprType) {
+	if _, ok := d.varTypes[v.key]; !ok && tp != unknownType {
+		d.varTypes[v.key] = tp
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Expressions and statements
+//
+//--------------------------------------------------------------------------------------------------
+
+const (
+	precIf = iota
+	precAndOr
+	precBit
+	precRel
+	precAdd
+	precMul
+	precUnary
+	precAtom
+)
+
+var decompiledBinOps = map[string]int{
+	"*": precMul, "/": precMul, "%": precMul,
+	"+": precAdd, "-": precAdd,
+	"<": precRel, ">": precRel, "<=": precRel, ">=": precRel, "==": precRel, "!=": precRel,
+	"|": precBit, "^": precBit, "&": precBit,
+	"&&": precAndOr, "||": precAndOr,
+}
+
+var invertedRelations = map[string]string{"==": "!=", "!=": "==", "<": ">=", ">=": "<", ">": "<=", "<=": ">"}
+
+var decompiledByteOps = map[string]string{
+	"b+": "badd", "b-": "bsub", "b/": "bdiv", "b*": "bmul", "b%": "bmod",
+	"b<": "blt", "b>": "bgt", "b<=": "ble", "b>=": "bge", "b==": "beq", "b!=": "bne",
+	"b|": "bor", "b&": "band", "b^": "bxor",
+}
+
+var decompiledBuiltins = map[string]string{
+	"sha256": "sha256", "keccak256": "keccak256", "sha512_256": "sha512_256", "ed25519verify": "ed25519verify",
+	"len": "len", "itob": "itob", "btoi": "btoi", "concat": "concat", "exp": "exp",
+	"getbit": "getbit", "getbyte": "getbyte", "setbit": "setbit", "setbyte": "setbyte",
+	"shl": "shl", "shr": "shr", "sqrt": "sqrt", "bitlen": "bitlen", "bzero": "bzero", "bsqrt": "bsqrt",
+	"divw": "divw", "substring3": "substring", "extract3": "extract", "gaids": "gaid",
+}
+
+var decompiledTuples = map[string]bool{"mulw": true, "addw": true, "expw": true, "divmodw": true}
+
+type dexpr struct {
+	text   string
+	prec   int
+	typ    exprType
+	stable bool               // literals, temporaries and arguments never change their value
+	impure bool               // depends on state changed by statements
+	reads  map[string]bool    // variables
+	param  int                // argument number starting from 1, 0 for other values
+	hint   func(typ exprType) // learns type of a variable from its usage
+
+	op       string // operator for negation
+	lhs, rhs *dexpr
+}
+
+func atomExpr(text string, typ exprType) *dexpr {
+	return &dexpr{text: text, prec: precAtom, typ: typ}
+}
+
+func stableExpr(text string, typ exprType) *dexpr {
+	return &dexpr{text: text, prec: precAtom, typ: typ, stable: true}
+}
+
+func (e *dexpr) atomic() bool {
+	return e.prec == precAtom && (e.stable || len(e.reads) > 0 && !strings.ContainsAny(e.text, "[(."))
+}
+
+func (e *dexpr) readsAny(vars []string) bool {
+	for _, name := range vars {
+		if e.reads[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// derive makes an expression computed from the arguments
+func derive(text string, prec int, typ exprType, args ...*dexpr) *dexpr {
+	e := &dexpr{text: text, prec: prec, typ: typ}
+	for _, arg := range args {
+		e.impure = e.impure || arg.impure
+		for name := range arg.reads {
+			if e.reads == nil {
+				e.reads = make(map[string]bool)
+			}
+			e.reads[name] = true
+		}
+	}
+	return e
+}
+
+// coerce casts an expression of unknown type, casts are not present in bytecode
+func coerce(e *dexpr, typ exprType) *dexpr {
+	if e.typ != unknownType || typ == unknownType {
+		return e
+	}
+	expect(e, typ)
+	cast := "toint"
+	if typ == bytesType {
+		cast = "tobyte"
+	}
+	return derive(fmt.Sprintf("%s(%s)", cast, e.text), precAtom, typ, e)
+}
+
+// expect passes the expected type to a variable of not yet known type
+func expect(e *dexpr, typ exprType) {
+	if e.hint != nil && e.typ == unknownType && typ != unknownType {
+		e.hint(typ)
+	}
+}
+
+func paren(e *dexpr, prec int) string {
+	if e.prec < prec {
+		return "(" + e.text + ")"
+	}
+	return e.text
+}
+
+func binaryExpr(op string, lhs, rhs *dexpr) *dexpr {
+	lt, _ := argOpTypeFromSpec(op, 0)
+	rt, _ := argOpTypeFromSpec(op, 1)
+	lhs, rhs = coerce(lhs, lt), coerce(rhs, rt)
+	lhs, rhs = coerce(lhs, rhs.typ), coerce(rhs, lhs.typ)
+	if lhs.typ == unknownType && rhs.typ == unknownType {
+		lhs, rhs = coerce(lhs, intType), coerce(rhs, intType)
+	}
+	prec := decompiledBinOps[op]
+	typ, _ := opTypeFromSpec(op, 0)
+	e := derive(fmt.Sprintf("%s %s %s", paren(lhs, prec), op, paren(rhs, prec+1)), prec, typ, lhs, rhs)
+	e.op, e.lhs, e.rhs = op, lhs, rhs
+	return e
+}
+
+func unaryExpr(op string, value *dexpr) *dexpr {
+	value = coerce(value, intType)
+	e := derive(op+paren(value, precUnary), precUnary, intType, value)
+	e.op, e.lhs = op, value
+	return e
+}
+
+// negate makes a condition with opposite truth value
+func negate(cond *dexpr) *dexpr {
+	if inverted, ok := invertedRelations[cond.op]; ok {
+		return binaryExpr(inverted, cond.lhs, cond.rhs)
+	}
+	if cond.op == "!" {
+		return cond.lhs
+	}
+	return unaryExpr("!", cond)
+}
+
+func ifExpr(cond, a, b *dexpr) *dexpr {
+	cond = coerce(cond, intType)
+	a, b = coerce(a, b.typ), coerce(b, a.typ)
+	text := fmt.Sprintf("if %s { %s } else { %s }", conditionText(cond), a.text, b.text)
+	return derive(text, precIf, a.typ, cond, a, b)
+}
+
+func conditionText(cond *dexpr) string {
+	return paren(cond, precAndOr)
+}
+
+func callExpr(name string, typ exprType, args ...*dexpr) *dexpr {
+	texts := make([]string, len(args))
+	for i, arg := range args {
+		texts[i] = arg.text
+	}
+	return derive(fmt.Sprintf("%s(%s)", name, strings.Join(texts, ", ")), precAtom, typ, args...)
+}
+
+func zeroValue(typ exprType) string {
+	if typ == bytesType {
+		return `""`
+	}
+	return "0"
+}
+
+func bytesLiteral(data []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, b := range data {
+		if b >= 0x20 && b < 0x7f && b != '"' && b != '\\' {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "\\x%02x", b)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+const (
+	simpleStmt = iota
+	ifStmt
+	forStmt
+)
+
+type dstmt struct {
+	kind      int
+	text      string // simple statement or condition
+	then, els []*dstmt
+	loop      *decompiledLoop
+}
+
+func simple(format string, args ...interface{}) *dstmt {
+	return &dstmt{kind: simpleStmt, text: fmt.Sprintf(format, args...)}
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Control flow structuring
+//
+//--------------------------------------------------------------------------------------------------
+
+type decompiledLoop struct {
+	header int
+	body   map[int]bool
+	exit   int // noStop if the loop only ends the function
+	label  string
+	index  int
+	stack  []*dexpr // stack on entry, it must be the same on break and continue
+}
+
+// region is a sequence of statements being built and the symbolic stack after them
+type region struct {
+	stmts []*dstmt
+	stack []*dexpr
+	depth int
+}
+
+func (r *region) child() *region {
+	return &region{stack: append([]*dexpr{}, r.stack...), depth: r.depth + 1}
+}
+
+type funcDecompiler struct {
+	d *decompiler
+	l *tealListing
+	f *decompiledFunc
+
+	loops  map[int]*decompiledLoop // by header
+	active []*decompiledLoop
+	ipdoms map[*decompiledLoop]map[int]int
+	reach  map[int]int
+
+	vars     map[int]*slotVar // by load and store instruction
+	visited  map[int]bool
+	declared map[string]bool
+	hoisted  map[string]*slotVar
+	read     map[string]bool
+	temps    int
+	prologue bool
+}
+
+func newFuncDecompiler(d *decompiler, f *decompiledFunc) *funcDecompiler {
+	return &funcDecompiler{
+		d: d, l: d.listing, f: f,
+		loops:    make(map[int]*decompiledLoop),
+		ipdoms:   make(map[*decompiledLoop]map[int]int),
+		reach:    make(map[int]int),
+		visited:  make(map[int]bool),
+		declared: make(map[string]bool),
+		hoisted:  make(map[string]*slotVar),
+		read:     make(map[string]bool),
+		prologue: !f.main,
+	}
+}
+
+func (fd *funcDecompiler) run() error {
+	if err := fd.findLoops(); err != nil {
+		return err
+	}
+	fd.findVars()
+	f := fd.f
+	r := &region{}
+	f.params = make([]string, f.args)
+	f.paramTypes = make([]exprType, f.args)
+	f.paramVars = make([]*slotVar, f.args)
+	for i := 0; i < f.args; i++ {
+		f.params[i] = fmt.Sprintf("a%d", i)
+		f.paramTypes[i] = unknownType
+		r.stack = append(r.stack, &dexpr{text: f.params[i], prec: precAtom, typ: unknownType, stable: true, param: i + 1})
+	}
+	if _, err := fd.walk(r, f.entry, noStop, false); err != nil {
+		return err
+	}
+	f.stmts = r.stmts
+
+	for _, v := range fd.hoisted {
+		f.decls = append(f.decls, fmt.Sprintf("let %s = %s", v.name, zeroValue(fd.d.varType(v))))
+	}
+	sort.Strings(f.decls)
+	return nil
+}
+
+// findVars splits local slots into variables, stores and loads are connected by reaching definitions.
+// Values stored before the function are marked by a negative slot number
+func (fd *funcDecompiler) findVars() {
+	l := fd.l
+	fd.vars = make(map[int]*slotVar)
+	entryDef := func(slot int) int {
+		return -slot - 1
+	}
+	defs := func(state map[int]map[int]bool, slot int) map[int]bool {
+		if result, ok := state[slot]; ok {
+			return result
+		}
+		return map[int]bool{entryDef(slot): true}
+	}
+	parent := make(map[int]int)
+	var find func(x int) int
+	find = func(x int) int {
+		if p, ok := parent[x]; ok && p != x {
+			root := find(p)
+			parent[x] = root
+			return root
+		}
+		parent[x] = x
+		return x
+	}
+
+	states := map[int]map[int]map[int]bool{fd.f.entry: {}}
+	for pass := 0; pass < 2; pass++ {
+		// the first pass propagates stores, the second one links loads to them
+		for changed := true; changed; {
+			changed = false
+			for _, b := range fd.f.blocks {
+				state, ok := states[b]
+				if !ok {
+					continue
+				}
+				current := make(map[int]map[int]bool, len(state))
+				for slot, set := range state {
+					current[slot] = set
+				}
+				for idx := l.blocks[b].start; idx < l.blocks[b].end; idx++ {
+					op := l.ops[idx]
+					slot, err := immediate(op, 0)
+					if err != nil || fd.d.globals[slot] {
+						continue
+					}
+					switch {
+					case op.name == "store":
+						current[slot] = map[int]bool{idx: true}
+						find(idx)
+					case op.name == "load" && pass == 1:
+						root := -1
+						for def := range defs(current, slot) {
+							if root == -1 {
+								root = find(def)
+							} else {
+								parent[find(def)] = root
+							}
+						}
+						parent[idx+len(l.ops)] = root
+					}
+				}
+				if pass == 1 {
+					continue
+				}
+				for _, s := range l.blocks[b].succs() {
+					if s == programEnd {
+						continue
+					}
+					next, ok := states[s]
+					if !ok {
+						states[s] = current
+						changed = true
+						continue
+					}
+					slots := make(map[int]bool)
+					for slot := range current {
+						slots[slot] = true
+					}
+					for slot := range next {
+						slots[slot] = true
+					}
+					for slot := range slots {
+						old := defs(next, slot)
+						merged := make(map[int]bool, len(old))
+						for def := range old {
+							merged[def] = true
+						}
+						for def := range defs(current, slot) {
+							merged[def] = true
+						}
+						if len(merged) != len(old) {
+							next[slot] = merged
+							changed = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// variables of a slot are numbered in order of their first store
+	type web struct {
+		root, first int
+	}
+	webs := make(map[int][]*web)
+	byRoot := make(map[int]*web)
+	for idx := 0; idx < len(l.ops); idx++ {
+		for _, key := range []int{idx, idx + len(l.ops)} {
+			if _, ok := parent[key]; !ok {
+				continue
+			}
+			root := find(key)
+			if byRoot[root] != nil {
+				continue
+			}
+			slot, _ := immediate(l.ops[idx], 0)
+			first := idx
+			if root < 0 {
+				first = -1
+			}
+			w := &web{root, first}
+			byRoot[root] = w
+			webs[slot] = append(webs[slot], w)
+		}
+	}
+	names := make(map[int]*slotVar)
+	for slot, list := range webs {
+		sort.Slice(list, func(i, j int) bool { return list[i].first < list[j].first })
+		for n, w := range list {
+			name := fmt.Sprintf("s%d", slot)
+			if n > 0 {
+				name = fmt.Sprintf("s%d_%d", slot, n+1)
+			}
+			names[w.root] = &slotVar{name: name, key: fd.f.name + "." + name}
+		}
+	}
+	for idx, op := range l.ops {
+		slot, err := immediate(op, 0)
+		if err != nil || op.name != "load" && op.name != "store" {
+			continue
+		}
+		if fd.d.globals[slot] {
+			fd.vars[idx] = globalVar(slot)
+			continue
+		}
+		key := idx
+		if op.name == "load" {
+			key = idx + len(l.ops)
+		}
+		if _, ok := parent[key]; ok {
+			fd.vars[idx] = names[find(key)]
+		}
+	}
+}
+
+// findLoops detects natural loops of back edges to dominating blocks
+func (fd *funcDecompiler) findLoops() error {
+	blocks := fd.f.blocks
+	index := make(map[int]int, len(blocks))
+	for i, b := range blocks {
+		index[b] = i
+	}
+	preds := make([][]int, len(blocks))
+	for i, b := range blocks {
+		for _, s := range fd.l.blocks[b].succs() {
+			if j, ok := index[s]; ok {
+				preds[j] = append(preds[j], i)
+			}
+		}
+	}
+
+	// dom[i][j] is true if block j dominates block i
+	entry := index[fd.f.entry]
+	dom := make([][]bool, len(blocks))
+	for i := range dom {
+		dom[i] = make([]bool, len(blocks))
+		for j := range dom[i] {
+			dom[i][j] = i != entry || j == entry
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := range blocks {
+			if i == entry {
+				continue
+			}
+			next := make([]bool, len(blocks))
+			for j := range next {
+				next[j] = len(preds[i]) > 0
+			}
+			for _, p := range preds[i] {
+				for j := range next {
+					next[j] = next[j] && dom[p][j]
+				}
+			}
+			next[i] = true
+			for j := range next {
+				if next[j] != dom[i][j] {
+					dom[i] = next
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	for i, b := range blocks {
+		for _, s := range fd.l.blocks[b].succs() {
+			h, ok := index[s]
+			if !ok || !dom[i][h] {
+				continue
+			}
+			loop, ok := fd.loops[s]
+			if !ok {
+				loop = &decompiledLoop{header: s, body: map[int]bool{s: true}}
+				fd.loops[s] = loop
+			}
+			pending := []int{i}
+			for len(pending) > 0 {
+				x := pending[len(pending)-1]
+				pending = pending[:len(pending)-1]
+				if loop.body[blocks[x]] {
+					continue
+				}
+				loop.body[blocks[x]] = true
+				pending = append(pending, preds[x]...)
+			}
+		}
+	}
+
+	headers := make([]int, 0, len(fd.loops))
+	for h := range fd.loops {
+		headers = append(headers, h)
+	}
+	sort.Ints(headers)
+	for n, h := range headers {
+		loop := fd.loops[h]
+		loop.index = n + 1
+		loop.exit = noStop
+		// prefer the exit of the loop condition, then the most used one
+		counts := make(map[int]int)
+		for b := range loop.body {
+			for _, s := range fd.l.blocks[b].succs() {
+				if s != programEnd && !loop.body[s] {
+					counts[s]++
+					if b == h {
+						counts[s] += len(fd.l.ops)
+					}
+				}
+			}
+		}
+		for s, count := range counts {
+			if loop.exit == noStop || count > counts[loop.exit] || count == counts[loop.exit] && s < loop.exit {
+				loop.exit = s
+			}
+		}
+	}
+	return nil
+}
+
+// ipdom returns immediate post-dominator of a block in the innermost loop or in the function.
+// Edges leaving the loop or going to its header lead to the virtual exit node
+func (fd *funcDecompiler) ipdom(b int) int {
+	var loop *decompiledLoop
+	if len(fd.active) > 0 {
+		loop = fd.active[len(fd.active)-1]
+	}
+	result, ok := fd.ipdoms[loop]
+	if !ok {
+		result = fd.postDominators(loop)
+		fd.ipdoms[loop] = result
+	}
+	if p, ok := result[b]; ok {
+		return p
+	}
+	return exitNode
+}
+
+func (fd *funcDecompiler) postDominators(loop *decompiledLoop) map[int]int {
+	var nodes []int
+	if loop == nil {
+		nodes = fd.f.blocks
+	} else {
+		for b := range loop.body {
+			nodes = append(nodes, b)
+		}
+		sort.Ints(nodes)
+	}
+	index := make(map[int]int, len(nodes))
+	for i, b := range nodes {
+		index[b] = i
+	}
+	exit := len(nodes)
+	succs := make([][]int, len(nodes))
+	for i, b := range nodes {
+		for _, s := range fd.l.blocks[b].succs() {
+			j, ok := index[s]
+			if !ok || loop != nil && s == loop.header {
+				j = exit
+			}
+			succs[i] = append(succs[i], j)
+		}
+		if len(succs[i]) == 0 {
+			succs[i] = []int{exit}
+		}
+	}
+
+	// pdom[i][j] is true if node j post-dominates node i
+	pdom := make([][]bool, len(nodes)+1)
+	for i := range pdom {
+		pdom[i] = make([]bool, len(nodes)+1)
+		for j := range pdom[i] {
+			pdom[i][j] = i != exit || j == exit
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(nodes) - 1; i >= 0; i-- {
+			next := make([]bool, len(nodes)+1)
+			for j := range next {
+				next[j] = true
+			}
+			for _, s := range succs[i] {
+				for j := range next {
+					next[j] = next[j] && pdom[s][j]
+				}
+			}
+			next[i] = true
+			for j := range next {
+				if next[j] != pdom[i][j] {
+					pdom[i] = next
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	count := func(set []bool) int {
+		n := 0
+		for _, v := range set {
+			if v {
+				n++
+			}
+		}
+		return n
+	}
+	result := make(map[int]int, len(nodes))
+	for i, b := range nodes {
+		result[b] = exitNode
+		total := count(pdom[i])
+		for j, v := range pdom[i] {
+			if v && j != i && count(pdom[j]) == total-1 {
+				if j != exit {
+					result[b] = nodes[j]
+				}
+				break
+			}
+		}
+	}
+	return result
+}
+
+// reachable counts blocks reachable from the block, smaller branches are nested
+func (fd *funcDecompiler) reachable(b int) int {
+	if n, ok := fd.reach[b]; ok {
+		return n
+	}
+	seen := make(map[int]bool)
+	pending := []int{b}
+	for len(pending) > 0 {
+		x := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if x == programEnd || seen[x] || !fd.f.member[x] {
+			continue
+		}
+		seen[x] = true
+		pending = append(pending, fd.l.blocks[x].succs()...)
+	}
+	fd.reach[b] = len(seen)
+	return len(seen)
+}
+
+// maxTail limits instructions of shared ending blocks like "return 0" repeated in every branch jumping to them
+const maxTail = 8
+
+// tail checks the block starts a short path ending the function without loops
+func (fd *funcDecompiler) tail(b int) bool {
+	size := 0
+	for b != programEnd {
+		if _, ok := fd.loops[b]; ok {
+			return false
+		}
+		blk := &fd.l.blocks[b]
+		size += blk.end - blk.start
+		if size > maxTail {
+			return false
+		}
+		switch blk.term {
+		case "return", "err", "retsub":
+			return true
+		case "b":
+			b = blk.target
+		case "":
+			b = blk.next
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (fd *funcDecompiler) isActive(loop *decompiledLoop) bool {
+	for _, active := range fd.active {
+		if active == loop {
+			return true
+		}
+	}
+	return false
+}
+
+// walk structures blocks starting from b until the stop block is reached.
+// It reports if the stop block was reached, otherwise all paths end with terminating statements
+func (fd *funcDecompiler) walk(r *region, b int, stop int, enter bool) (bool, error) {
+	for {
+		if b == stop {
+			return true, nil
+		}
+		if !enter {
+			if done, err := fd.loopEdge(r, b); done || err != nil {
+				return false, err
+			}
+			if loop, ok := fd.loops[b]; ok && !fd.isActive(loop) {
+				next, err := fd.walkLoop(r, loop)
+				if err != nil || next == noStop {
+					return false, err
+				}
+				b = next
+				continue
+			}
+		}
+		enter = false
+
+		if b == programEnd {
+			if len(r.stack) == 0 {
+				return false, fmt.Errorf("program ends with empty stack")
+			}
+			value := fd.pop(r, 1)[0]
+			fd.emit(r, simple("return %s", coerce(value, intType).text))
+			return false, nil
+		}
+		if fd.visited[b] && !fd.tail(b) {
+			return false, fmt.Errorf("code at %s is reached from several places", fd.l.ops[fd.l.blocks[b].start].text)
+		}
+		fd.visited[b] = true
+
+		ops := fd.l.body(b)
+		for i := 0; i < len(ops); {
+			n, err := fd.exec(r, fd.l.blocks[b].start, ops, i)
+			if err != nil {
+				return false, err
+			}
+			i += n
+		}
+		blk := &fd.l.blocks[b]
+		if blk.term != "" {
+			fd.prologue = false
+		}
+		switch blk.term {
+		case "return":
+			if len(r.stack) == 0 {
+				return false, fmt.Errorf("return with empty stack")
+			}
+			value := fd.pop(r, 1)[0]
+			fd.emit(r, simple("return %s", coerce(value, intType).text))
+			return false, nil
+		case "err":
+			fd.emit(r, simple("error"))
+			return false, nil
+		case "retsub":
+			if len(r.stack) != fd.f.rets {
+				return false, fmt.Errorf("stack is not empty on return")
+			}
+			if fd.f.rets == 1 {
+				value := fd.pop(r, 1)[0]
+				if fd.f.retType == unknownType {
+					fd.f.retType = value.typ
+				}
+				fd.emit(r, simple("return %s", coerce(value, fd.f.retType).text))
+			} else {
+				fd.emit(r, simple("return"))
+			}
+			return false, nil
+		case "b":
+			b = blk.target
+		case "bz", "bnz":
+			next, err := fd.branch(r, b, stop)
+			if err != nil || next == noStop {
+				return false, err
+			}
+			b = next
+		default:
+			b = blk.next
+		}
+	}
+}
+
+// loopEdge emits break or continue for jumps to active loops headers and exits
+func (fd *funcDecompiler) loopEdge(r *region, b int) (bool, error) {
+	for i := len(fd.active) - 1; i >= 0; i-- {
+		loop := fd.active[i]
+		word := ""
+		if b == loop.header {
+			word = "continue"
+		} else if b == loop.exit {
+			word = "break"
+		} else {
+			continue
+		}
+		if len(r.stack) != len(loop.stack) {
+			return true, fmt.Errorf("loop leaves values on stack")
+		}
+		for j := range r.stack {
+			if r.stack[j] != loop.stack[j] {
+				return true, fmt.Errorf("loop changes values on stack")
+			}
+		}
+		if i != len(fd.active)-1 {
+			loop.label = fmt.Sprintf("loop%d", loop.index)
+			word += " " + loop.label
+		}
+		fd.emit(r, simple(word))
+		return true, nil
+	}
+	return false, nil
+}
+
+// walkLoop makes for statement and returns the block following the loop
+func (fd *funcDecompiler) walkLoop(r *region, loop *decompiledLoop) (int, error) {
+	fd.saveAll(r)
+	fd.prologue = false
+	loop.stack = append([]*dexpr{}, r.stack...)
+	loop.label = ""
+	stmt := &dstmt{kind: forStmt, text: "1", loop: loop}
+	body := r.child()
+	fd.active = append(fd.active, loop)
+	defer func() {
+		fd.active = fd.active[:len(fd.active)-1]
+	}()
+
+	if cond, inside, ok := fd.loopCondition(r, loop); ok {
+		fd.visited[loop.header] = true
+		stmt.text = conditionText(coerce(cond, intType))
+		if _, err := fd.walk(body, inside, noStop, false); err != nil {
+			return noStop, err
+		}
+	} else if _, err := fd.walk(body, loop.header, noStop, true); err != nil {
+		return noStop, err
+	}
+	if n := len(body.stmts); n > 0 && body.stmts[n-1].kind == simpleStmt && body.stmts[n-1].text == "continue" {
+		body.stmts = body.stmts[:n-1]
+	}
+	stmt.then = body.stmts
+	r.stmts = append(r.stmts, stmt)
+	return loop.exit, nil
+}
+
+// loopCondition checks the loop header only computes the condition of leaving the loop
+func (fd *funcDecompiler) loopCondition(r *region, loop *decompiledLoop) (*dexpr, int, bool) {
+	blk := &fd.l.blocks[loop.header]
+	if blk.term != "bz" && blk.term != "bnz" || loop.exit == noStop {
+		return nil, 0, false
+	}
+	ops := fd.l.body(loop.header)
+	for _, op := range ops {
+		if op.name == "store" {
+			return nil, 0, false
+		}
+	}
+	trial := r.child()
+	temps := fd.temps
+	for i := 0; i < len(ops); {
+		n, err := fd.exec(trial, blk.start, ops, i)
+		if err != nil || len(trial.stmts) > 0 {
+			fd.temps = temps
+			return nil, 0, false
+		}
+		i += n
+	}
+	if len(trial.stack) != len(r.stack)+1 {
+		fd.temps = temps
+		return nil, 0, false
+	}
+	for i := range r.stack {
+		if trial.stack[i] != r.stack[i] {
+			return nil, 0, false
+		}
+	}
+	cond := trial.stack[len(trial.stack)-1]
+	trueB, falseB := blk.next, blk.target
+	if blk.term == "bnz" {
+		trueB, falseB = falseB, trueB
+	}
+	switch {
+	case falseB == loop.exit && loop.body[trueB]:
+		return cond, trueB, true
+	case trueB == loop.exit && loop.body[falseB]:
+		return negate(cond), falseB, true
+	}
+	return nil, 0, false
+}
+
+// branch makes if statement or expression out of conditional branch and returns the block where branches join
+func (fd *funcDecompiler) branch(r *region, b int, stop int) (int, error) {
+	blk := &fd.l.blocks[b]
+	if len(r.stack) == 0 {
+		return noStop, fmt.Errorf("branch with empty stack")
+	}
+	cond := coerce(fd.pop(r, 1)[0], intType)
+	trueB, falseB := blk.next, blk.target
+	if blk.term == "bnz" {
+		trueB, falseB = falseB, trueB
+	}
+	if trueB == falseB {
+		fd.discard(r, cond)
+		return trueB, nil
+	}
+	fd.saveAll(r)
+
+	join := fd.ipdom(b)
+	if join == exitNode {
+		join = stop
+	}
+	first, second, firstCond := trueB, falseB, cond
+	if fd.reachable(falseB) < fd.reachable(trueB) {
+		first, second, firstCond = falseB, trueB, negate(cond)
+	}
+
+	// a branch not reaching the join ends the function or the loop iteration,
+	// it becomes a guard and the other branch continues at this level
+	firstR := r.child()
+	reached, err := fd.walk(firstR, first, join, false)
+	if err != nil {
+		return noStop, err
+	}
+	if !reached {
+		r.stmts = append(r.stmts, fd.ifStatement(firstCond, firstR.stmts, nil))
+		return second, nil
+	}
+	secondR := r.child()
+	reached, err = fd.walk(secondR, second, join, false)
+	if err != nil {
+		return noStop, err
+	}
+	thenR, elseR := firstR, secondR
+	if first != trueB {
+		thenR, elseR = secondR, firstR
+	}
+	if !reached {
+		r.stack = firstR.stack
+		r.stmts = append(r.stmts, fd.ifStatement(cond, thenR.stmts, elseR.stmts))
+		return join, nil
+	}
+	if err := fd.merge(r, cond, thenR, elseR); err != nil {
+		return noStop, err
+	}
+	return join, nil
+}
+
+// merge joins stacks of both branches, values differing are passed with if expression or variables
+func (fd *funcDecompiler) merge(r *region, cond *dexpr, thenR, elseR *region) error {
+	if len(thenR.stack) != len(elseR.stack) {
+		return fmt.Errorf("branches leave different number of values")
+	}
+	n := len(thenR.stack)
+	var diff []int
+	for i := 0; i < n; i++ {
+		if thenR.stack[i] != elseR.stack[i] {
+			diff = append(diff, i)
+		}
+	}
+	stack := append([]*dexpr{}, thenR.stack...)
+	if len(diff) == 1 && diff[0] == n-1 && len(thenR.stmts) == 0 && len(elseR.stmts) == 0 {
+		stack[n-1] = ifExpr(cond, thenR.stack[n-1], elseR.stack[n-1])
+		r.stack = stack
+		return nil
+	}
+	for _, i := range diff {
+		typ := thenR.stack[i].typ
+		if typ == unknownType {
+			typ = elseR.stack[i].typ
+		}
+		if typ == unknownType {
+			typ = intType
+		}
+		name := fd.temp()
+		r.stmts = append(r.stmts, simple("let %s = %s", name, zeroValue(typ)))
+		thenR.stmts = append(thenR.stmts, simple("%s = %s", name, coerce(thenR.stack[i], typ).text))
+		elseR.stmts = append(elseR.stmts, simple("%s = %s", name, coerce(elseR.stack[i], typ).text))
+		stack[i] = stableExpr(name, typ)
+	}
+	r.stack = stack
+	r.stmts = append(r.stmts, fd.ifStatement(cond, thenR.stmts, elseR.stmts))
+	return nil
+}
+
+func (fd *funcDecompiler) ifStatement(cond *dexpr, then, els []*dstmt) *dstmt {
+	if len(then) == 0 && len(els) > 0 {
+		cond, then, els = negate(cond), els, nil
+	}
+	return &dstmt{kind: ifStmt, text: conditionText(cond), then: then, els: els}
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Symbolic execution
+//
+//--------------------------------------------------------------------------------------------------
+
+func (fd *funcDecompiler) temp() string {
+	fd.temps++
+	return fmt.Sprintf("t%d", fd.temps)
+}
+
+func (fd *funcDecompiler) pop(r *region, n int) []*dexpr {
+	values := append([]*dexpr{}, r.stack[len(r.stack)-n:]...)
+	r.stack = r.stack[:len(r.stack)-n]
+	return values
+}
+
+func (fd *funcDecompiler) push(r *region, values ...*dexpr) {
+	r.stack = append(r.stack, values...)
+}
+
+// saveOne stores stack value into a temporary variable
+func (fd *funcDecompiler) saveOne(r *region, idx int) {
+	e := r.stack[idx]
+	name := fd.temp()
+	r.stmts = append(r.stmts, simple("let %s = %s", name, e.text))
+	r.stack[idx] = stableExpr(name, e.typ)
+}
+
+// save stores stack value into a temporary variable keeping evaluation order of values below it
+func (fd *funcDecompiler) save(r *region, idx int) {
+	for i := 0; i < idx; i++ {
+		if r.stack[i].impure {
+			fd.saveOne(r, i)
+		}
+	}
+	if !r.stack[idx].stable {
+		fd.saveOne(r, idx)
+	}
+}
+
+// saveAll stores all values that might change, used before branches and loops
+func (fd *funcDecompiler) saveAll(r *region) {
+	for i := range r.stack {
+		if !r.stack[i].stable {
+			fd.saveOne(r, i)
+		}
+	}
+}
+
+// emit appends a statement, values it might affect are evaluated before it
+func (fd *funcDecompiler) emit(r *region, stmt *dstmt, writes ...string) {
+	for i, e := range r.stack {
+		if e.impure || e.readsAny(writes) {
+			fd.saveOne(r, i)
+		}
+	}
+	r.stmts = append(r.stmts, stmt)
+}
+
+// discard evaluates a dropped value unless it is trivial
+func (fd *funcDecompiler) discard(r *region, e *dexpr) {
+	if !e.atomic() {
+		fd.emit(r, simple("let _%s = %s", fd.temp(), e.text))
+	}
+}
+
+// slotVar returns the variable of load or store instruction
+func (fd *funcDecompiler) slotVar(idx int) (*slotVar, error) {
+	v, ok := fd.vars[idx]
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid slot", fd.l.ops[idx].text)
+	}
+	return v, nil
+}
+
+// declarable checks a variable is not used yet and it can be declared at the current level
+func (fd *funcDecompiler) declarable(r *region, v *slotVar) bool {
+	return !v.global && !fd.declared[v.name] && !fd.read[v.name] && fd.hoisted[v.name] == nil && r.depth == 0
+}
+
+// use marks variable as used, variables used before declaration are declared at the function start
+func (fd *funcDecompiler) use(v *slotVar) {
+	if !v.global && !fd.declared[v.name] {
+		fd.hoisted[v.name] = v
+	}
+}
+
+func (fd *funcDecompiler) load(v *slotVar) *dexpr {
+	fd.use(v)
+	fd.read[v.name] = true
+	// type of the variable is unknown until it is assigned or used
+	typ, ok := fd.d.varTypes[v.key]
+	if !ok {
+		typ = unknownType
+	}
+	e := atomExpr(v.name, typ)
+	e.reads = map[string]bool{v.name: true}
+	e.hint = func(typ exprType) {
+		fd.d.learnType(v, typ)
+	}
+	return e
+}
+
+// assign makes a declaration of a new local variable or an assignment
+func (fd *funcDecompiler) assign(r *region, v *slotVar, value *dexpr) {
+	fd.d.learnType(v, value.typ)
+	value = coerce(value, fd.d.varType(v))
+	if fd.declarable(r, v) {
+		fd.declared[v.name] = true
+		fd.emit(r, simple("let %s = %s", v.name, value.text), v.name)
+		return
+	}
+	fd.use(v)
+	fd.emit(r, simple("%s = %s", v.name, value.text), v.name)
+}
+
+func immediate(op tealOp, n int) (int, error) {
+	if len(op.args) <= n {
+		return 0, fmt.Errorf("%s: missing immediate argument", op.text)
+	}
+	value, err := strconv.Atoi(op.args[n])
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid immediate argument", op.text)
+	}
+	return value, nil
+}
+
+// constIndex returns index of intc_N, bytec_N and arg_N forms or the immediate argument
+func constIndex(op tealOp) (int, error) {
+	if idx := strings.LastIndex(op.name, "_"); idx >= 0 {
+		return strconv.Atoi(op.name[idx+1:])
+	}
+	return immediate(op, 0)
+}
+
+// exec applies an instruction to the stack and returns number of instructions consumed.
+// Instructions are block ops starting at start index of the program
+func (fd *funcDecompiler) exec(r *region, start int, ops []tealOp, i int) (int, error) {
+	op := ops[i]
+	pops, pushes, err := fd.d.stackEffect(op)
+	if err != nil {
+		return 0, err
+	}
+	if len(r.stack) < pops {
+		return 0, fmt.Errorf("%s: stack underflow", op.text)
+	}
+	if op.name != "store" {
+		fd.prologue = false
+	}
+	if _, ok := decompiledBinOps[op.name]; ok {
+		args := fd.pop(r, 2)
+		fd.push(r, binaryExpr(op.name, args[0], args[1]))
+		return 1, nil
+	}
+
+	switch op.name {
+	case "!", "~":
+		fd.push(r, unaryExpr(op.name, fd.pop(r, 1)[0]))
+		return 1, nil
+	case "intcblock", "bytecblock":
+		return 1, nil
+	case "intc", "intc_0", "intc_1", "intc_2", "intc_3":
+		idx, err := constIndex(op)
+		if err != nil || idx >= len(fd.l.intc) {
+			return 0, fmt.Errorf("%s: invalid constant", op.text)
+		}
+		fd.push(r, stableExpr(fd.l.intc[idx], intType))
+		return 1, nil
+	case "pushint":
+		fd.push(r, stableExpr(op.args[0], intType))
+		return 1, nil
+	case "bytec", "bytec_0", "bytec_1", "bytec_2", "bytec_3":
+		idx, err := constIndex(op)
+		if err != nil || idx >= len(fd.l.bytec) {
+			return 0, fmt.Errorf("%s: invalid constant", op.text)
+		}
+		fd.push(r, stableExpr(bytesLiteral(fd.l.bytec[idx]), bytesType))
+		return 1, nil
+	case "pushbytes":
+		data, err := hex.DecodeString(strings.TrimPrefix(op.args[0], "0x"))
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid constant", op.text)
+		}
+		fd.push(r, stableExpr(bytesLiteral(data), bytesType))
+		return 1, nil
+	case "arg", "arg_0", "arg_1", "arg_2", "arg_3":
+		idx, err := constIndex(op)
+		if err != nil {
+			return 0, err
+		}
+		fd.push(r, stableExpr(fmt.Sprintf("args[%d]", idx), bytesType))
+		return 1, nil
+
+	case "load":
+		v, err := fd.slotVar(start + i)
+		if err != nil {
+			return 0, err
+		}
+		fd.push(r, fd.load(v))
+		return 1, nil
+	case "store":
+		v, err := fd.slotVar(start + i)
+		if err != nil {
+			return 0, err
+		}
+		value := fd.pop(r, 1)[0]
+		if fd.prologue && value.param != 0 && fd.declarable(r, v) {
+			// function prologue saving arguments into scratch slots
+			fd.f.params[value.param-1] = v.name
+			fd.f.paramTypes[value.param-1] = fd.d.varType(v)
+			fd.f.paramVars[value.param-1] = v
+			fd.declared[v.name] = true
+			return 1, nil
+		}
+		fd.prologue = false
+		fd.assign(r, v, value)
+		return 1, nil
+
+	case "pop":
+		fd.discard(r, fd.pop(r, 1)[0])
+		return 1, nil
+	case "popn":
+		for _, e := range fd.pop(r, pops) {
+			fd.discard(r, e)
+		}
+		return 1, nil
+	case "dup", "dig":
+		idx := len(r.stack) - pops
+		if !r.stack[idx].atomic() {
+			fd.save(r, idx)
+		}
+		fd.push(r, r.stack[idx])
+		return 1, nil
+	case "dup2":
+		n := len(r.stack)
+		for idx := n - 2; idx < n; idx++ {
+			if !r.stack[idx].atomic() {
+				fd.save(r, idx)
+			}
+		}
+		fd.push(r, r.stack[n-2], r.stack[n-1])
+		return 1, nil
+	case "dupn":
+		idx := len(r.stack) - 1
+		if !r.stack[idx].atomic() {
+			fd.save(r, idx)
+		}
+		for n := 1; n < pushes; n++ {
+			fd.push(r, r.stack[idx])
+		}
+		return 1, nil
+	case "swap", "cover", "uncover":
+		values := fd.pop(r, pops)
+		for _, e := range values {
+			if e.impure {
+				fd.push(r, values...)
+				fd.saveAll(r)
+				values = fd.pop(r, pops)
+				break
+			}
+		}
+		switch op.name {
+		case "uncover":
+			values = append(values[1:], values[0])
+		default:
+			// swap is cover 1
+			last := values[len(values)-1]
+			values = append([]*dexpr{last}, values[:len(values)-1]...)
+		}
+		fd.push(r, values...)
+		return 1, nil
+	case "select":
+		values := fd.pop(r, 3)
+		if values[0].impure || values[1].impure {
+			fd.push(r, values...)
+			fd.saveAll(r)
+			values = fd.pop(r, 3)
+		}
+		fd.push(r, ifExpr(values[2], values[1], values[0]))
+		return 1, nil
+
+	case "assert":
+		fd.emit(r, simple("assert(%s)", fd.pop(r, 1)[0].text))
+		return 1, nil
+	case "log":
+		fd.emit(r, simple("log(%s)", fd.pop(r, 1)[0].text))
+		return 1, nil
+	case "itxn_begin":
+		fd.emit(r, simple("itxn.begin()"))
+		return 1, nil
+	case "itxn_next":
+		fd.emit(r, simple("itxn.next()"))
+		return 1, nil
+	case "itxn_submit":
+		fd.emit(r, simple("itxn.submit()"))
+		return 1, nil
+	case "itxn_field":
+		value := fd.pop(r, 1)[0]
+		field := op.args[0]
+		switch {
+		case lexedAs(field, []int{gen.TealangLexerTXNARRAYFIELD}):
+			fd.emit(r, simple("itxn.%s.push(%s)", field, value.text))
+		case lexedAs(field, []int{gen.TealangLexerTXNFIELD}):
+			fd.emit(r, simple("itxn.%s = %s", field, value.text))
+		default:
+			return 0, fmt.Errorf("%s: unsupported field", op.text)
+		}
+		return 1, nil
+	case "app_global_put":
+		args := fd.pop(r, 2)
+		fd.emit(r, simple("apps[0].put(%s, %s)", args[0].text, args[1].text))
+		return 1, nil
+	case "app_local_put":
+		args := fd.pop(r, 3)
+		fd.emit(r, simple("accounts[%s].put(%s, %s)", args[0].text, args[1].text, args[2].text))
+		return 1, nil
+	case "app_global_del":
+		fd.emit(r, simple("apps[0].del(%s)", fd.pop(r, 1)[0].text))
+		return 1, nil
+	case "app_local_del":
+		args := fd.pop(r, 2)
+		fd.emit(r, simple("accounts[%s].del(%s)", args[0].text, args[1].text))
+		return 1, nil
+	case "callsub":
+		callee := fd.d.funcs[fd.l.blockOf(fd.l.labels[op.args[0]])]
+		args := fd.pop(r, pops)
+		for i := range args {
+			if i < len(callee.paramTypes) {
+				// arguments types are set by calls
+				if callee.paramVars[i] != nil {
+					fd.d.learnType(callee.paramVars[i], args[i].typ)
+				}
+				args[i] = coerce(args[i], callee.paramTypes[i])
+			}
+		}
+		call := callExpr(callee.name, callee.retType, args...)
+		if pushes == 0 {
+			fd.emit(r, simple("%s", call.text))
+			return 1, nil
+		}
+		call.impure = true
+		fd.push(r, call)
+		return 1, nil
+	}
+
+	args := fd.pop(r, pops)
+	results, text, err := fd.expression(op, args)
+	if err != nil {
+		return 0, err
+	}
+	if len(results) == 1 {
+		e := derive(text, precAtom, results[0], args...)
+		e.impure = e.impure || impureOps[op.name]
+		fd.push(r, e)
+		return 1, nil
+	}
+	return fd.tuple(r, start, ops, i, text, results)
+}
+
+// impureOps read state changed by other statements of the program
+var impureOps = map[string]bool{
+	"balance": true, "min_balance": true, "app_opted_in": true, "app_local_get": true, "app_global_get": true,
+	"app_local_get_ex": true, "app_global_get_ex": true, "asset_holding_get": true, "asset_params_get": true,
+	"app_params_get": true, "acct_params_get": true, "itxn": true, "itxna": true, "itxnas": true,
+	"gitxn": true, "gitxna": true, "gitxnas": true,
+}
+
+// tuple declares variables for multiple values, stores following the instruction name them
+func (fd *funcDecompiler) tuple(r *region, start int, ops []tealOp, i int, text string, results []exprType) (int, error) {
+	k := len(results)
+	if k != 2 && k != 4 {
+		return 0, fmt.Errorf("%s: unsupported number of results", ops[i].text)
+	}
+	// values are declared only if variables have the same types, otherwise temporaries are casted
+	vars := make([]*slotVar, k)
+	stored := true
+	seen := make(map[string]bool)
+	for j := 0; j < k; j++ {
+		// the first store takes the top value
+		pos := i + k - j
+		if pos >= len(ops) || ops[pos].name != "store" {
+			stored = false
+			break
+		}
+		v, err := fd.slotVar(start + pos)
+		if err != nil || seen[v.name] {
+			stored = false
+			break
+		}
+		fd.d.learnType(v, results[j])
+		if fd.d.varType(v) != results[j] {
+			stored = false
+			break
+		}
+		seen[v.name] = true
+		vars[j] = v
+	}
+
+	names := make([]string, k)
+	if !stored {
+		for j := range names {
+			names[j] = fd.temp()
+		}
+		fd.emit(r, simple("let %s = %s", strings.Join(names, ", "), text))
+		for j, name := range names {
+			fd.push(r, stableExpr(name, results[j]))
+		}
+		return 1, nil
+	}
+
+	declare := true
+	for j, v := range vars {
+		names[j] = v.name
+		declare = declare && fd.declarable(r, v)
+	}
+	for _, v := range vars {
+		if declare {
+			fd.declared[v.name] = true
+		} else {
+			fd.use(v)
+		}
+	}
+	format := "%s = %s"
+	if declare {
+		format = "let %s = %s"
+	}
+	fd.emit(r, simple(format, strings.Join(names, ", "), text), names...)
+	return 1 + k, nil
+}
+
+// expression renders an instruction taking arguments as a call or a member access
+// and returns types of its results
+func (fd *funcDecompiler) expression(op tealOp, args []*dexpr) ([]exprType, string, error) {
+	name := op.name
+
+	field := ""
+	if len(op.args) > 0 {
+		field = op.args[len(op.args)-1]
+	}
+	fieldType := func() []exprType {
+		tp, err := runtimeFieldTypeFromSpec(name, field)
+		if err != nil {
+			tp = unknownType
+		}
+		return []exprType{tp}
+	}
+	specTypes := func() []exprType {
+		spec := langOps[name]
+		types := make([]exprType, len(spec.Returns))
+		for i := range types {
+			types[i], _ = opTypeFromSpec(name, i)
+		}
+		return types
+	}
+	texts := make([]string, len(args))
+	for i, arg := range args {
+		if tp, err := argOpTypeFromSpec(name, i); err == nil {
+			expect(arg, tp)
+		}
+		texts[i] = arg.text
+	}
+	checkField := func(tokens ...int) error {
+		if !lexedAs(field, tokens) {
+			return fmt.Errorf("%s: unsupported field", op.text)
+		}
+		return nil
+	}
+	txnField := func(prefix string, index string) (string, error) {
+		if index == "" {
+			return prefix + "." + field, checkField(gen.TealangLexerTXNFIELD)
+		}
+		return fmt.Sprintf("%s.%s[%s]", prefix, field, index), checkField(gen.TealangLexerTXNARRAYFIELD)
+	}
+	lowerFirst := func(s string) string {
+		return strings.ToLower(s[:1]) + s[1:]
+	}
+
+	var text string
+	var err error
+	switch name {
+	case "txn":
+		text, err = txnField("txn", "")
+	case "txna":
+		field = op.args[0]
+		text, err = txnField("txn", op.args[1])
+	case "txnas":
+		text, err = txnField("txn", texts[0])
+	case "gtxn":
+		text, err = txnField(fmt.Sprintf("gtxn[%s]", op.args[0]), "")
+	case "gtxna":
+		field = op.args[1]
+		text, err = txnField(fmt.Sprintf("gtxn[%s]", op.args[0]), op.args[2])
+	case "gtxnas":
+		text, err = txnField(fmt.Sprintf("gtxn[%s]", op.args[0]), texts[0])
+	case "gtxns":
+		text, err = txnField(fmt.Sprintf("gtxn[%s]", texts[0]), "")
+	case "gtxnsa":
+		field = op.args[0]
+		text, err = txnField(fmt.Sprintf("gtxn[%s]", texts[0]), op.args[1])
+	case "gtxnsas":
+		text, err = txnField(fmt.Sprintf("gtxn[%s]", texts[0]), texts[1])
+	case "itxn":
+		text, err = txnField("itxn", "")
+	case "itxna":
+		field = op.args[0]
+		text, err = txnField("itxn", op.args[1])
+	case "itxnas":
+		text, err = txnField("itxn", texts[0])
+	case "gitxn":
+		text, err = txnField(fmt.Sprintf("gitxn[%s]", op.args[0]), "")
+	case "gitxna":
+		field = op.args[1]
+		text, err = txnField(fmt.Sprintf("gitxn[%s]", op.args[0]), op.args[2])
+	case "gitxnas":
+		text, err = txnField(fmt.Sprintf("gitxn[%s]", op.args[0]), texts[0])
+	case "global":
+		text, err = "global."+field, checkField(gen.TealangLexerGLOBALFIELD)
+	case "args":
+		return []exprType{bytesType}, fmt.Sprintf("args[%s]", texts[0]), nil
+	case "balance":
+		return specTypes(), fmt.Sprintf("accounts[%s].Balance", texts[0]), nil
+	case "min_balance":
+		return specTypes(), fmt.Sprintf("accounts[%s].MinimumBalance", texts[0]), nil
+	case "app_opted_in":
+		return specTypes(), fmt.Sprintf("accounts[%s].optedIn(%s)", texts[0], texts[1]), nil
+	case "app_local_get":
+		return specTypes(), fmt.Sprintf("accounts[%s].get(%s)", texts[0], texts[1]), nil
+	case "app_global_get":
+		return specTypes(), fmt.Sprintf("apps[0].get(%s)", texts[0]), nil
+	case "app_local_get_ex":
+		return specTypes(), fmt.Sprintf("accounts[%s].getEx(%s, %s)", texts[0], texts[1], texts[2]), nil
+	case "app_global_get_ex":
+		return specTypes(), fmt.Sprintf("apps[%s].getEx(%s)", texts[0], texts[1]), nil
+	case "asset_holding_get":
+		method := map[string]string{"AssetBalance": "assetBalance", "AssetFrozen": "assetIsFrozen"}[field]
+		if method == "" {
+			return nil, "", fmt.Errorf("%s: unsupported field", op.text)
+		}
+		return []exprType{fieldType()[0], intType}, fmt.Sprintf("accounts[%s].%s(%s)", texts[0], method, texts[1]), nil
+	case "acct_params_get":
+		if !lexedAs(lowerFirst(field), []int{gen.TealangLexerACCTPARAMS}) {
+			return nil, "", fmt.Errorf("%s: unsupported field", op.text)
+		}
+		return []exprType{fieldType()[0], intType}, fmt.Sprintf("accounts[%s].%s()", texts[0], lowerFirst(field)), nil
+	case "app_params_get":
+		if !lexedAs(lowerFirst(field), []int{gen.TealangLexerAPPPARAMSFIELDS}) {
+			return nil, "", fmt.Errorf("%s: unsupported field", op.text)
+		}
+		return []exprType{fieldType()[0], intType}, fmt.Sprintf("apps[%s].%s()", texts[0], lowerFirst(field)), nil
+	case "asset_params_get":
+		if err := checkField(gen.TealangLexerASSETPARAMSFIELDS); err != nil {
+			return nil, "", err
+		}
+		return []exprType{fieldType()[0], intType}, fmt.Sprintf("assets[%s].%s", texts[0], field), nil
+	case "gaid":
+		return specTypes(), fmt.Sprintf("gaid(%s)", op.args[0]), nil
+	case "substring", "extract":
+		return specTypes(), fmt.Sprintf("%s(%s, %s, %s)", name, texts[0], op.args[0], op.args[1]), nil
+	case "extract_uint16", "extract_uint32", "extract_uint64":
+		kind := strings.ToUpper(strings.TrimPrefix(name, "extract_"))
+		return specTypes(), fmt.Sprintf("extract(%s, %s, %s)", kind, texts[0], texts[1]), nil
+	default:
+		if builtin, ok := decompiledBuiltins[name]; ok {
+			return specTypes(), fmt.Sprintf("%s(%s)", builtin, strings.Join(texts, ", ")), nil
+		}
+		if builtin, ok := decompiledByteOps[name]; ok {
+			return specTypes(), fmt.Sprintf("%s(%s)", builtin, strings.Join(texts, ", ")), nil
+		}
+		if decompiledTuples[name] {
+			return specTypes(), fmt.Sprintf("%s(%s)", name, strings.Join(texts, ", ")), nil
+		}
+		return nil, "", fmt.Errorf("unsupported opcode %s", name)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return fieldType(), text, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Rendering
+//
+//--------------------------------------------------------------------------------------------------
+
+func (d *decompiler) render() *DecompileResult {
+	result := &DecompileResult{}
+	var sb strings.Builder
+
+	slots := make([]int, 0, len(d.globals))
+	for slot := range d.globals {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	for _, slot := range slots {
+		fmt.Fprintf(&sb, "let s%d = %s\n", slot, zeroValue(d.varType(globalVar(slot))))
+	}
+	if len(slots) > 0 {
+		sb.WriteString("\n")
+	}
+
+	// functions with unrecognized code become stubs failing at runtime
+	stubs := make(map[string]bool)
+	for _, f := range d.order {
+		if f.err != nil && f.args >= 0 {
+			stubs[f.name] = true
+		}
+	}
+
+	for i, f := range d.order {
+		if i > 0 || len(slots) > 0 {
+			sb.WriteString("\n")
+		}
+		if f.err != nil {
+			result.Unrecognized = append(result.Unrecognized, f.name)
+			d.renderListing(&sb, f)
+			if f.args < 0 {
+				// nothing calls functions with unknown stack effect, callers fail as well
+				continue
+			}
+			// a stub failing at runtime keeps calls of the function compilable
+			f.params, f.decls = make([]string, f.args), nil
+			for i := range f.params {
+				f.params[i] = fmt.Sprintf("a%d", i)
+			}
+			f.stmts = []*dstmt{simple("error")}
+		}
+		void := ""
+		if !f.main && f.rets == 0 {
+			void = " void"
+		}
+		fmt.Fprintf(&sb, "function %s(%s)%s {\n", f.name, strings.Join(f.params, ", "), void)
+		for _, decl := range f.decls {
+			fmt.Fprintf(&sb, "%s%s\n", formatIndent, decl)
+		}
+		renderStmts(&sb, f.stmts, formatIndent, stubs)
+		sb.WriteString("}\n")
+	}
+
+	result.Source = sb.String()
+	if formatted, err := Format(result.Source); err == nil {
+		result.Source = formatted
+	}
+	return result
+}
+
+// renderListing keeps TEAL of a function as a comment
+func (d *decompiler) renderListing(sb *strings.Builder, f *decompiledFunc) {
+	l := d.listing
+	reason := "not analyzed"
+	if f.err != nil {
+		reason = f.err.Error()
+	}
+	fmt.Fprintf(sb, "// unrecognized function %s: %s\n", f.name, reason)
+	for _, b := range f.blocks {
+		blk := &l.blocks[b]
+		for i := blk.start; i < blk.end; i++ {
+			for _, label := range l.names[i] {
+				fmt.Fprintf(sb, "// %s:\n", label)
+			}
+			fmt.Fprintf(sb, "//     %s\n", l.ops[i].text)
+		}
+	}
+}
+
+// renderStmts writes statements marking ones calling stubs of unrecognized functions
+func renderStmts(sb *strings.Builder, stmts []*dstmt, indent string, stubs map[string]bool) {
+	for _, stmt := range stmts {
+		if callsStub(stmt.text, stubs) {
+			fmt.Fprintf(sb, "%s// unrecognized: always fails\n", indent)
+		}
+		switch stmt.kind {
+		case simpleStmt:
+			fmt.Fprintf(sb, "%s%s\n", indent, stmt.text)
+		case ifStmt:
+			fmt.Fprintf(sb, "%sif %s {\n", indent, stmt.text)
+			renderStmts(sb, stmt.then, indent+formatIndent, stubs)
+			if len(stmt.els) > 0 {
+				fmt.Fprintf(sb, "%s} else {\n", indent)
+				renderStmts(sb, stmt.els, indent+formatIndent, stubs)
+			}
+			fmt.Fprintf(sb, "%s}\n", indent)
+		case forStmt:
+			label := ""
+			if stmt.loop.label != "" {
+				label = stmt.loop.label + ": "
+			}
+			fmt.Fprintf(sb, "%s%sfor %s {\n", indent, label, stmt.text)
+			renderStmts(sb, stmt.then, indent+formatIndent, stubs)
+			fmt.Fprintf(sb, "%s}\n", indent)
+		}
+	}
+}
+
+var callPattern = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)\(`)
+
+// callsStub reports the statement text contains a call of any stub
+func callsStub(text string, stubs map[string]bool) bool {
+	for _, call := range callPattern.FindAllStringSubmatch(text, -1) {
+		if stubs[call[1]] {
+			return true
+		}
+	}
+	return false
+}
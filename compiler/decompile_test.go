@@ -0,0 +1,106 @@
+package compiler
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDecompileExamples checks decompiled examples compile again
+func TestDecompileExamples(t *testing.T) {
+	a := require.New(t)
+
+	for _, file := range []string{"basic.tl", "imports.tl", "itxn.tl", "printnum.tl", "nft/approval.tl"} {
+		fullPath := path.Join("..", "examples", file)
+		source, err := ioutil.ReadFile(fullPath)
+		a.NoError(err)
+		input := InputDesc{Source: string(source), SourceFile: path.Base(fullPath), SourceDir: path.Dir(fullPath)}
+		result, err := Compile(input, Options{Assemble: true})
+		a.NoError(err, file)
+
+		decompiled, err := Decompile(result.Bytecode)
+		a.NoError(err, file)
+		a.Empty(decompiled.Unrecognized, "%s:\n%s", file, decompiled.Source)
+
+		_, err = Compile(InputDesc{Source: decompiled.Source}, Options{Assemble: true})
+		a.NoError(err, "%s:\n%s", file, decompiled.Source)
+	}
+}
+
+func TestDecompileTEAL(t *testing.T) {
+	a := require.New(t)
+
+	teal := `#pragma version 6
+int 0
+store 0
+int 0
+store 1
+loop:
+load 1
+int 10
+<
+bz done
+load 0
+load 1
+callsub double
++
+store 0
+load 1
+int 1
++
+store 1
+b loop
+done:
+load 0
+int 90
+==
+bz fail
+int 1
+return
+fail:
+int 0
+return
+double:
+store 2
+load 2
+int 2
+*
+retsub
+`
+	result, err := DecompileTEAL(teal)
+	a.NoError(err)
+	a.Empty(result.Unrecognized, result.Source)
+	a.Contains(result.Source, "function sub1(s2) {")
+	a.Contains(result.Source, "for s1 < 10 {")
+	a.Contains(result.Source, "s0 = s0 + sub1(s1)")
+
+	_, err = Compile(InputDesc{Source: result.Source}, Options{Assemble: true})
+	a.NoError(err, result.Source)
+}
+
+// TestDecompileUnrecognized checks functions with unsupported code are kept as TEAL comments next to failing stubs
+func TestDecompileUnrecognized(t *testing.T) {
+	a := require.New(t)
+
+	teal := `#pragma version 6
+int 1
+callsub indirect
+return
+indirect:
+loads
+retsub
+`
+	result, err := DecompileTEAL(teal)
+	a.NoError(err)
+	a.Equal([]string{"sub1"}, result.Unrecognized)
+	a.Contains(result.Source, "// unrecognized function sub1: unsupported opcode loads")
+	a.Contains(result.Source, "//     loads")
+	// callers are still recovered and call a stub
+	a.Contains(result.Source, "// unrecognized: always fails\n    return toint(sub1(1))")
+	a.Contains(result.Source, "function sub1(a0) {\n    error\n}")
+
+	_, err = Compile(InputDesc{Source: result.Source}, Options{Assemble: true})
+	a.NoError(err, result.Source)
+}
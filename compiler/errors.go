@@ -2,7 +2,10 @@ package compiler
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/antlr/antlr4/runtime/Go/antlr"
 )
@@ -13,6 +16,8 @@ const (
 	syntaxError    parserErrorType = 1
 	ambiguityError parserErrorType = 2
 	semanticError  parserErrorType = 3
+	assemblerError parserErrorType = 4
+	lintWarning    parserErrorType = 5
 )
 
 // ParserError provides generic info about the error
@@ -26,10 +31,36 @@ type ParserError struct {
 	token     string
 	filename  string
 	excerpt   []string
+	code      string // stable identifier of warnings
+}
+
+// Diagnostic is a serializable form of ParserError for editors and CI tools.
+// Lines are 1-based, columns are 0-based, the end position points right after the offending token
+type Diagnostic struct {
+	Severity  string `json:"severity"`
+	Code      string `json:"code"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+	Message   string `json:"message"`
+}
+
+// ParserErrors wraps parser errors into a single error value
+type ParserErrors []ParserError
+
+func (errs ParserErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i := range errs {
+		lines[i] = errs[i].String()
+	}
+	return strings.Join(lines, "\n")
 }
 
 type errorCollector struct {
 	errors   []ParserError
+	warnings []ParserError
 	source   string
 	filename string
 }
@@ -41,6 +72,7 @@ type tealangBaseRecognitionException struct {
 	offendingState int
 	ctx            antlr.RuleContext
 	input          antlr.IntStream
+	code           string // set for warnings
 }
 
 // copy of Antlr's NewBaseRecognitionException
@@ -95,6 +127,16 @@ func (er *errorCollector) copyErrors(other *errorCollector) {
 	er.errors = append(er.errors, other.errors...)
 }
 
+// copyWarnings adds warnings of an imported module.
+// Modules are libraries so their functions are not expected to be called
+func (er *errorCollector) copyWarnings(other *errorCollector) {
+	for _, w := range other.warnings {
+		if w.code != unusedFunctionWarning {
+			er.warnings = append(er.warnings, w)
+		}
+	}
+}
+
 func (er *errorCollector) formatExcerpt(start, end int) []string {
 	maxExcerptOffset := 50
 	src := er.source
@@ -161,6 +203,81 @@ func (er *errorCollector) formatExcerpt(start, end int) []string {
 	return excerpt
 }
 
+// ignoreDirective matches comments like "// tealang:ignore TL1001 TL1003" suppressing warnings,
+// all warnings are suppressed if no codes listed
+var ignoreDirective = regexp.MustCompile(`//\s*tealang:ignore\b([\s,]*(?:TL\d+[\s,]*)*)`)
+
+// warn records a warning unless it is suppressed or already reported.
+// Inline functions are parsed at every call so the same warning may come several times
+func (er *errorCollector) warn(info ParserError) {
+	if er.suppressed(info.line, info.code) {
+		return
+	}
+	for _, w := range er.warnings {
+		if w.line == info.line && w.column == info.column && w.code == info.code {
+			return
+		}
+	}
+	er.warnings = append(er.warnings, info)
+}
+
+// suppressed checks for ignore directive on the line itself or on a comment line right above it
+func (er *errorCollector) suppressed(line int, code string) bool {
+	lines := strings.Split(er.source, "\n")
+	matches := func(idx int, standalone bool) bool {
+		if idx < 0 || idx >= len(lines) {
+			return false
+		}
+		text := lines[idx]
+		if standalone && !strings.HasPrefix(strings.TrimSpace(text), "//") {
+			return false
+		}
+		m := ignoreDirective.FindStringSubmatch(text)
+		if m == nil {
+			return false
+		}
+		codes := strings.FieldsFunc(m[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(codes) == 0 {
+			return true
+		}
+		for _, c := range codes {
+			if c == code {
+				return true
+			}
+		}
+		return false
+	}
+	return matches(line-1, false) || matches(line-2, true)
+}
+
+// sortedWarnings returns warnings in order of appearance in the source, warnings of modules go after the main file
+func (er *errorCollector) sortedWarnings() []ParserError {
+	warnings := append([]ParserError{}, er.warnings...)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].filename != warnings[j].filename {
+			if warnings[i].filename == er.filename || warnings[j].filename == er.filename {
+				return warnings[i].filename == er.filename
+			}
+			return warnings[i].filename < warnings[j].filename
+		}
+		if warnings[i].line != warnings[j].line {
+			return warnings[i].line < warnings[j].line
+		}
+		return warnings[i].column < warnings[j].column
+	})
+	return warnings
+}
+
+// warningsAsErrors turns warnings into errors keeping their codes
+func warningsAsErrors(warnings []ParserError) []ParserError {
+	errors := make([]ParserError, len(warnings))
+	for i, w := range warnings {
+		w.errorType = semanticError
+		errors[i] = w
+	}
+	return errors
+}
+
 func (er *errorCollector) filterAmbiguity() {
 	var filtered []ParserError
 	for _, err := range er.errors {
@@ -206,6 +323,13 @@ func (er *errorCollector) SyntaxError(recognizer antlr.Recognizer, offendingSymb
 		token,
 		er.filename,
 		er.formatExcerpt(start, end),
+		"",
+	}
+	if te, ok := e.(*tealangBaseRecognitionException); ok && te.code != "" {
+		info.errorType = lintWarning
+		info.code = te.code
+		er.warn(info)
+		return
 	}
 	er.errors = append(er.errors, info)
 }
@@ -221,6 +345,7 @@ func (er *errorCollector) ReportAmbiguity(recognizer antlr.Parser, dfa *antlr.DF
 		"",
 		er.filename,
 		er.formatExcerpt(startIndex, stopIndex),
+		"",
 	}
 	er.errors = append(er.errors, info)
 }
@@ -237,6 +362,7 @@ func (er *errorCollector) ReportAttemptingFullContext(recognizer antlr.Parser, d
 		"",
 		er.filename,
 		er.formatExcerpt(startIndex, stopIndex),
+		"",
 	}
 	er.errors = append(er.errors, info)
 }
@@ -252,6 +378,7 @@ func (er *errorCollector) ReportContextSensitivity(recognizer antlr.Parser, dfa
 		"",
 		er.filename,
 		er.formatExcerpt(startIndex, stopIndex),
+		"",
 	}
 	er.errors = append(er.errors, info)
 }
@@ -265,12 +392,22 @@ func (err *ParserError) String() (msg string) {
 	case semanticError:
 		msg = fmt.Sprintf("error at %sline %d, col %d near token '%s'", filename, err.line, err.column, err.token)
 		lines := append([]string{msg}, err.excerpt...)
-		lines = append(lines, err.msg)
+		lines = append(lines, err.codedMessage())
+		msg = strings.Join(lines, "\n")
+	case lintWarning:
+		msg = fmt.Sprintf("warning at %sline %d, col %d near token '%s'", filename, err.line, err.column, err.token)
+		lines := append([]string{msg}, err.excerpt...)
+		lines = append(lines, err.codedMessage())
 		msg = strings.Join(lines, "\n")
 	case syntaxError:
 		msg = fmt.Sprintf("syntax error at %sline %d, col %d near token '%s'", filename, err.line, err.column, err.token)
 		lines := append([]string{msg}, err.excerpt...)
 		msg = strings.Join(lines, "\n")
+	case assemblerError:
+		msg = fmt.Sprintf("assembler error at %sline %d, col %d", filename, err.line, err.column)
+		lines := append([]string{msg}, err.excerpt...)
+		lines = append(lines, err.msg)
+		msg = strings.Join(lines, "\n")
 	case ambiguityError:
 		msg = fmt.Sprintf("ambiguity error at %soffset %d", filename, err.start)
 		lines := append([]string{msg}, err.excerpt...)
@@ -282,3 +419,97 @@ func (err *ParserError) String() (msg string) {
 	}
 	return msg
 }
+
+// Severity of the error, either "error" or "warning"
+func (err *ParserError) Severity() string {
+	if err.errorType == lintWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Code is a stable identifier of the error kind.
+// Errors are numbered TL0xxx, warnings TL1xxx
+func (err *ParserError) Code() string {
+	if err.code != "" {
+		return err.code
+	}
+	switch err.errorType {
+	case syntaxError:
+		return "TL0001"
+	case ambiguityError:
+		return "TL0002"
+	case semanticError:
+		return "TL0003"
+	case assemblerError:
+		return "TL0004"
+	}
+	return "TL0000"
+}
+
+// File is a name of the source file, empty for unnamed sources
+func (err *ParserError) File() string {
+	return err.filename
+}
+
+// Line is 1-based line of the offending token
+func (err *ParserError) Line() int {
+	return err.line
+}
+
+// Column is 0-based column of the offending token
+func (err *ParserError) Column() int {
+	return err.column
+}
+
+// EndLine is a line where the offending token ends
+func (err *ParserError) EndLine() int {
+	return err.line + strings.Count(err.token, "\n")
+}
+
+// EndColumn is 0-based column right after the offending token
+func (err *ParserError) EndColumn() int {
+	if idx := strings.LastIndexByte(err.token, '\n'); idx >= 0 {
+		return utf8.RuneCountInString(err.token[idx+1:])
+	}
+	return err.column + utf8.RuneCountInString(err.token)
+}
+
+// Token is a text of the offending token
+func (err *ParserError) Token() string {
+	return err.token
+}
+
+// Excerpt is a source line with the offending token emphasized
+func (err *ParserError) Excerpt() []string {
+	return err.excerpt
+}
+
+// Message describes the error without location
+func (err *ParserError) Message() string {
+	if err.msg == "" && err.errorType == ambiguityError {
+		return "ambiguous input"
+	}
+	return err.msg
+}
+
+func (err *ParserError) codedMessage() string {
+	if err.code == "" {
+		return err.msg
+	}
+	return fmt.Sprintf("%s [%s]", err.msg, err.code)
+}
+
+// Diagnostic converts the error to a serializable form
+func (err *ParserError) Diagnostic() Diagnostic {
+	return Diagnostic{
+		Severity:  err.Severity(),
+		Code:      err.Code(),
+		File:      err.File(),
+		Line:      err.Line(),
+		Column:    err.Column(),
+		EndLine:   err.EndLine(),
+		EndColumn: err.EndColumn(),
+		Message:   err.Message(),
+	}
+}
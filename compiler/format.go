@@ -0,0 +1,184 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Source formatter
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+
+	gen "github.com/pzbitskiy/tealang/gen/go"
+)
+
+const formatIndent = "    "
+
+// keywords followed by an expression, they are separated from an opening parenthesis
+var formatKeywords = map[string]bool{
+	"if": true, "else": true, "for": true, "return": true, "let": true, "const": true,
+	"error": true, "break": true, "continue": true,
+}
+
+var wordRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Format pretty-prints tealang source in the canonical style keeping comments.
+// Line breaks are kept, indentation, spaces between tokens and blank lines are normalized
+// and semicolons ending lines are dropped.
+// Both programs and modules are accepted, sources with syntax errors are returned as ParserErrors
+func Format(source string) (string, error) {
+	if strings.TrimSpace(source) == "" {
+		return "", nil
+	}
+	// declarations end with a line break, the last one might be missing like in imported modules
+	if !strings.HasSuffix(source, "\n") {
+		source += "\n"
+	}
+	tokens, errors := formatTokens(source)
+	if len(errors) > 0 {
+		return "", ParserErrors(errors)
+	}
+
+	// comments are skipped by the lexer, recover them from gaps between tokens
+	runes := []rune(source)
+	items := make([]formatItem, 0, len(tokens))
+	last := 0
+	addComment := func(gap string) {
+		if idx := strings.Index(gap, "//"); idx >= 0 {
+			items = append(items, formatItem{text: strings.TrimRight(gap[idx:], " \t\r"), comment: true})
+		}
+	}
+	for _, token := range tokens {
+		if token.GetChannel() != antlr.TokenDefaultChannel || token.GetTokenType() == antlr.TokenEOF {
+			continue
+		}
+		addComment(string(runes[last:token.GetStart()]))
+		last = token.GetStop() + 1
+		item := formatItem{text: token.GetText(), tokenType: token.GetTokenType()}
+		if item.tokenType == gen.TealangLexerNEWLINE || item.tokenType == gen.TealangLexerMODULENAMEEND {
+			item.newlines = strings.Count(strings.ReplaceAll(item.text, "\r\n", "\n"), "\n")
+			item.newlines += strings.Count(strings.ReplaceAll(item.text, "\r\n", ""), "\r")
+		}
+		items = append(items, item)
+	}
+	if last < len(runes) {
+		addComment(string(runes[last:]))
+	}
+
+	f := formatter{}
+	for i, item := range items {
+		if item.newlines > 0 {
+			f.newlines += item.newlines
+			continue
+		}
+		if item.tokenType == gen.TealangLexerSEMICOLON && endsLine(items[i+1:]) {
+			continue
+		}
+		f.write(item)
+	}
+	if f.sb.Len() == 0 {
+		return "", nil
+	}
+	f.sb.WriteString("\n")
+	return f.sb.String(), nil
+}
+
+// formatTokens checks the source is either a program or a module and returns its tokens
+func formatTokens(source string) ([]antlr.Token, []ParserError) {
+	entries := []func(*gen.TealangParser) antlr.ParserRuleContext{
+		programRule,
+		func(parser *gen.TealangParser) antlr.ParserRuleContext { return parser.Module() },
+	}
+	var errors []ParserError
+	for _, entry := range entries {
+		collector := newErrorCollector(source, "")
+		parser := newParser(source, collector)
+		entry(parser)
+		collector.filterAmbiguity()
+		if len(collector.errors) == 0 {
+			return parser.GetTokenStream().(*antlr.CommonTokenStream).GetAllTokens(), nil
+		}
+		if errors == nil {
+			errors = collector.errors
+		}
+	}
+	return nil, errors
+}
+
+type formatItem struct {
+	text      string
+	tokenType int
+	newlines  int  // for line breaks
+	comment   bool // comment till the end of line
+}
+
+// endsLine reports nothing but a comment follows on the line
+func endsLine(items []formatItem) bool {
+	for _, item := range items {
+		if item.newlines > 0 {
+			return true
+		}
+		if !item.comment {
+			return false
+		}
+	}
+	return true
+}
+
+type formatter struct {
+	sb       strings.Builder
+	depth    int
+	newlines int // pending line breaks
+	prev     formatItem
+}
+
+func (f *formatter) write(item formatItem) {
+	closing := item.tokenType == gen.TealangLexerRIGHTFIGURE
+	if closing && f.depth > 0 {
+		f.depth--
+	}
+	if f.sb.Len() > 0 && f.newlines > 0 {
+		// keep at most one blank line, none at block edges
+		lines := f.newlines
+		if lines > 2 {
+			lines = 2
+		}
+		if closing || f.prev.tokenType == gen.TealangLexerLEFTFIGURE {
+			lines = 1
+		}
+		f.sb.WriteString(strings.Repeat("\n", lines))
+		f.sb.WriteString(strings.Repeat(formatIndent, f.depth))
+	} else if f.sb.Len() > 0 && spaced(f.prev, item) {
+		f.sb.WriteString(" ")
+	}
+	f.newlines = 0
+	f.sb.WriteString(item.text)
+	if item.tokenType == gen.TealangLexerLEFTFIGURE {
+		f.depth++
+	}
+	f.prev = item
+}
+
+// spaced decides if tokens on the same line are separated by a space
+func spaced(prev formatItem, next formatItem) bool {
+	if next.comment {
+		return true
+	}
+	switch prev.text {
+	case "(", "[", ".", "!", "~":
+		return false
+	}
+	switch next.text {
+	case ")", "]", ",", ";", ".", ":", "[":
+		return false
+	case "(":
+		// calls are not separated from the function name
+		return !wordRe.MatchString(prev.text) || formatKeywords[prev.text]
+	case "}":
+		return prev.text != "{"
+	}
+	return true
+}
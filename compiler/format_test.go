@@ -0,0 +1,126 @@
+package compiler
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/stretchr/testify/require"
+
+	gen "github.com/pzbitskiy/tealang/gen/go"
+)
+
+func TestFormat(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+
+// header comment
+const a=1;
+const b = "a  b" ;   // trailing comment
+
+
+inline function f(x,y) { return x*y; }
+function logic()  {
+	let  x=f( a ,2)+btoi(txn.ApplicationArgs [0])
+    let c = if x>1 {x-1} else {!x}
+
+	if (x == 1) && txn.Fee<1000 {
+
+	  // inside
+	  return gtxn[ 0 ].Amount ; // end
+	}
+	else {
+		error
+	}
+	loop: for x > 0 {
+		x = x - 1
+		if x == 2 { break loop; }
+	}
+	return sha256 ("abc") == b
+}`
+	expected := `// header comment
+const a = 1
+const b = "a  b" // trailing comment
+
+inline function f(x, y) { return x * y; }
+function logic() {
+    let x = f(a, 2) + btoi(txn.ApplicationArgs[0])
+    let c = if x > 1 { x - 1 } else { !x }
+
+    if (x == 1) && txn.Fee < 1000 {
+        // inside
+        return gtxn[0].Amount // end
+    }
+    else {
+        error
+    }
+    loop: for x > 0 {
+        x = x - 1
+        if x == 2 { break loop; }
+    }
+    return sha256("abc") == b
+}
+`
+	formatted, err := Format(source)
+	a.NoError(err)
+	a.Equal(expected, formatted)
+
+	formatted, err = Format("")
+	a.NoError(err)
+	a.Empty(formatted)
+
+	// modules have no main function
+	formatted, err = Format("const  x = 1\nfunction f() {\nreturn x\n}")
+	a.NoError(err)
+	a.Equal("const x = 1\nfunction f() {\n    return x\n}\n", formatted)
+
+	_, err = Format("function logic() {\n\treturn 1 +\n}\n")
+	a.Error(err)
+	var parserErrors ParserErrors
+	a.ErrorAs(err, &parserErrors)
+	a.Equal(2, parserErrors[0].Line())
+}
+
+// significantTokens lists tokens of the source except line breaks and semicolons
+func significantTokens(t *testing.T, source string) []string {
+	tokens, errors := formatTokens(source + "\n")
+	require.Empty(t, errors)
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		switch token.GetTokenType() {
+		case gen.TealangLexerNEWLINE, gen.TealangLexerMODULENAMEEND, gen.TealangLexerSEMICOLON, antlr.TokenEOF:
+			continue
+		}
+		if token.GetChannel() == antlr.TokenDefaultChannel {
+			result = append(result, token.GetText())
+		}
+	}
+	return result
+}
+
+func TestFormatExamples(t *testing.T) {
+	a := require.New(t)
+
+	files := make([]string, 0, 16)
+	for _, pattern := range []string{"../examples/*.tl", "../examples/*/*.tl", "../stdlib/*.tl"} {
+		matches, err := filepath.Glob(pattern)
+		a.NoError(err)
+		files = append(files, matches...)
+	}
+	a.NotEmpty(files)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		a.NoError(err)
+		formatted, err := Format(string(data))
+		a.NoError(err, file)
+
+		again, err := Format(formatted)
+		a.NoError(err, file)
+		a.Equal(formatted, again, file)
+
+		a.Equal(significantTokens(t, string(data)), significantTokens(t, formatted), file)
+	}
+}
@@ -0,0 +1,232 @@
+//go:build ignore
+// +build ignore
+
+// import_langspec converts per-version language specs published by go-algorand
+// (data/transactions/logic/langspec_vN.json) into langspec.json used by the compiler.
+// Opcodes and fields are annotated with TEAL versions they were introduced in.
+//
+// Usage: go run import_langspec.go <go-algorand logic dir> <max TEAL version> langspec.json
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type namedType struct {
+	Name    string
+	AVMType string
+}
+
+type immediate struct {
+	Comment  string
+	Encoding string
+}
+
+type sourceOp struct {
+	Opcode            byte
+	Name              string
+	Args              []string
+	Returns           []string
+	Size              int
+	DocCost           string
+	ArgEnum           []string
+	ArgEnumTypes      []string
+	Doc               string
+	DocExtra          string
+	ImmediateNote     []immediate
+	IntroducedVersion int
+	Groups            []string
+}
+
+type sourceSpec struct {
+	Version         int
+	LogicSigVersion int
+	NamedTypes      []namedType
+	Ops             []sourceOp
+}
+
+type opRecord struct {
+	Opcode  byte
+	Name    string
+	Args    string `json:",omitempty"`
+	Returns string `json:",omitempty"`
+	Cost    int
+	Size    int
+
+	ArgEnum         []string `json:",omitempty"`
+	ArgEnumTypes    string   `json:",omitempty"`
+	ArgEnumVersions []int    `json:",omitempty"`
+
+	Doc               string
+	DocExtra          string `json:",omitempty"`
+	ImmediateNote     string `json:",omitempty"`
+	Groups            []string
+	IntroducedVersion int
+}
+
+type languageSpec struct {
+	EvalMaxVersion  int
+	LogicSigVersion int
+	Ops             []opRecord
+}
+
+// leading number or the first curve specific value like in "Secp256k1=1700; Secp256r1=2500"
+var costRe = regexp.MustCompile(`^(\d+)|=(\d+)`)
+
+func load(dir string, version int) (spec sourceSpec, err error) {
+	data, err := ioutil.ReadFile(path.Join(dir, fmt.Sprintf("langspec_v%d.json", version)))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &spec)
+	return
+}
+
+func typeString(types []string, named map[string]string) (string, error) {
+	out := make([]byte, 0, len(types))
+	for _, name := range types {
+		avmType, ok := named[name]
+		if !ok && strings.HasPrefix(name, "[") {
+			// fixed size byte arrays like [32]byte are not listed in named types
+			avmType = "[]byte"
+		}
+		switch avmType {
+		case "uint64":
+			out = append(out, 'U')
+		case "[]byte":
+			out = append(out, 'B')
+		case "any":
+			out = append(out, '.')
+		case "none":
+		default:
+			return "", fmt.Errorf("unknown type %s", name)
+		}
+	}
+	return string(out), nil
+}
+
+func immediateNote(imms []immediate) string {
+	notes := make([]string, len(imms))
+	for i, imm := range imms {
+		notes[i] = fmt.Sprintf("{%s %s}", imm.Encoding, imm.Comment)
+	}
+	return strings.Join(notes, " ")
+}
+
+func convert(dir string, maxVersion int) (*languageSpec, error) {
+	// fieldVersions[op][field] is the earliest version the field is listed for the op
+	fieldVersions := make(map[string]map[string]int)
+	var last sourceSpec
+	for v := 1; v <= maxVersion; v++ {
+		spec, err := load(dir, v)
+		if err != nil {
+			return nil, err
+		}
+		for _, op := range spec.Ops {
+			fields, ok := fieldVersions[op.Name]
+			if !ok {
+				fields = make(map[string]int)
+				fieldVersions[op.Name] = fields
+			}
+			for _, field := range op.ArgEnum {
+				if _, ok := fields[field]; !ok {
+					fields[field] = v
+				}
+			}
+		}
+		last = spec
+	}
+
+	named := make(map[string]string, len(last.NamedTypes))
+	for _, tp := range last.NamedTypes {
+		named[tp.Name] = tp.AVMType
+	}
+
+	records := make([]opRecord, len(last.Ops))
+	for i, op := range last.Ops {
+		rec := &records[i]
+		var err error
+		rec.Opcode = op.Opcode
+		rec.Name = op.Name
+		if rec.Args, err = typeString(op.Args, named); err != nil {
+			return nil, fmt.Errorf("%s args: %s", op.Name, err.Error())
+		}
+		if rec.Returns, err = typeString(op.Returns, named); err != nil {
+			return nil, fmt.Errorf("%s returns: %s", op.Name, err.Error())
+		}
+		// variable costs like "1 + 1 per 16 bytes of A" are approximated by the base cost
+		cost := costRe.FindStringSubmatch(op.DocCost)
+		if cost == nil {
+			return nil, fmt.Errorf("%s cost %s not recognized", op.Name, op.DocCost)
+		}
+		if rec.Cost, err = strconv.Atoi(cost[1] + cost[2]); err != nil {
+			return nil, fmt.Errorf("%s cost %s: %s", op.Name, op.DocCost, err.Error())
+		}
+		rec.Size = op.Size
+		if len(op.ArgEnum) > 0 {
+			rec.ArgEnum = op.ArgEnum
+			if rec.ArgEnumTypes, err = typeString(op.ArgEnumTypes, named); err != nil {
+				return nil, fmt.Errorf("%s fields: %s", op.Name, err.Error())
+			}
+			rec.ArgEnumVersions = make([]int, len(op.ArgEnum))
+			for idx, field := range op.ArgEnum {
+				rec.ArgEnumVersions[idx] = fieldVersions[op.Name][field]
+			}
+		}
+		rec.Doc = op.Doc
+		rec.DocExtra = op.DocExtra
+		rec.ImmediateNote = immediateNote(op.ImmediateNote)
+		rec.Groups = op.Groups
+		rec.IntroducedVersion = op.IntroducedVersion
+	}
+
+	logicSigVersion := last.LogicSigVersion
+	if logicSigVersion > maxVersion {
+		logicSigVersion = maxVersion
+	}
+	return &languageSpec{
+		EvalMaxVersion:  maxVersion,
+		LogicSigVersion: logicSigVersion,
+		Ops:             records,
+	}, nil
+}
+
+func main() {
+	if len(os.Args) != 4 {
+		fmt.Fprintf(os.Stderr, "usage: %s <go-algorand logic dir> <max TEAL version> langspec.json\n", os.Args[0])
+		os.Exit(1)
+	}
+	maxVersion, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	spec, err := convert(os.Args[1], maxVersion)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(spec); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if err = ioutil.WriteFile(os.Args[3], buf.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
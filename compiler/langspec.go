@@ -1,6 +1,10 @@
 package compiler
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/algorand/go-algorand/data/transactions/logic"
+)
 
 func opTypeFromSpec(name string, ret int) (exprType, error) {
 	if op, ok := langOps[name]; ok && len(op.Returns) != 0 {
@@ -51,6 +55,42 @@ func runtimeFieldTypeFromSpec(name string, field string) (exprType, error) {
 	return invalidType, fmt.Errorf("can't get type for %s.%s", name, field)
 }
 
+// minTealVersion is the lowest TEAL version with branching and return opcodes needed by generated code
+const minTealVersion = 2
+
 func tealVersion() int {
 	return langSpec.EvalMaxVersion
 }
+
+// assemblerVersion is the latest TEAL version supported by the bundled assembler and dry run evaluator
+func assemblerVersion() int {
+	return logic.LogicVersion
+}
+
+// defaultTealVersion is a version programs are compiled for unless requested otherwise
+func defaultTealVersion() int {
+	if assemblerVersion() < tealVersion() {
+		return assemblerVersion()
+	}
+	return tealVersion()
+}
+
+// checkOpVersion ensures the opcode and its field (if any) are available in the target TEAL version
+func checkOpVersion(version int, name string, field string) error {
+	op, ok := langOps[name]
+	if !ok {
+		return nil
+	}
+	if op.IntroducedVersion > version {
+		return fmt.Errorf("%s is not available in TEAL v%d, requires v%d", name, version, op.IntroducedVersion)
+	}
+	if field == "" {
+		return nil
+	}
+	for idx, entry := range op.ArgEnum {
+		if entry == field && idx < len(op.ArgEnumVersions) && op.ArgEnumVersions[idx] > version {
+			return fmt.Errorf("%s.%s is not available in TEAL v%d, requires v%d", name, field, version, op.ArgEnumVersions[idx])
+		}
+	}
+	return nil
+}
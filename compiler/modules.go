@@ -10,6 +10,9 @@ import (
 	"github.com/pzbitskiy/tealang/stdlib"
 )
 
+// ModuleResolver locates a module imported by a program and returns its source and location
+type ModuleResolver func(moduleName string, sourceDir string, currentDir string) (InputDesc, error)
+
 func resolveModule(moduleName string, sourceDir string, currentDir string) (InputDesc, error) {
 	// search for module
 	var source string
@@ -0,0 +1,351 @@
+//--------------------------------------------------------------------------------------------------
+//
+// AST optimizations
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maxByteLength is the longest byte string AVM can hold on the stack
+const maxByteLength = 4096
+
+// astOptimizer folds constant expressions and prunes branches with constant conditions.
+// Errors like overflow or division by zero in constant expressions are reported
+// the same way as parser errors
+type astOptimizer struct {
+	visited map[*funDefNode]bool
+}
+
+func optimizeAST(root TreeNodeIf) {
+	o := &astOptimizer{visited: make(map[*funDefNode]bool)}
+	o.node(root)
+	if prog, ok := root.(*programNode); ok {
+		for _, fun := range prog.nonInlineFunc {
+			o.function(fun)
+		}
+	}
+}
+
+func (o *astOptimizer) function(n *funDefNode) {
+	if o.visited[n] {
+		return
+	}
+	o.visited[n] = true
+	o.node(n)
+}
+
+// node optimizes statement and returns its replacement, nil if the statement is eliminated
+func (o *astOptimizer) node(node TreeNodeIf) TreeNodeIf {
+	switch n := node.(type) {
+	case ExprNodeIf:
+		return o.expr(n)
+	case *returnNode:
+		if n.value != nil {
+			n.value = o.expr(n.value)
+		}
+	case *assignNode:
+		n.value = o.expr(n.value)
+	case *assignTupleNode:
+		n.value = o.expr(n.value)
+	case *assignQuadrupleNode:
+		n.value = o.expr(n.value)
+	case *varDeclNode:
+		n.value = o.expr(n.value)
+	case *varDeclTupleNode:
+		n.value = o.expr(n.value)
+	case *varDeclQuadrupleNode:
+		n.value = o.expr(n.value)
+	case *assignInnerTxnNode:
+		n.value = o.expr(n.value)
+	case *arrayAssignInnerTxnNode:
+		n.value = o.expr(n.value)
+	case *forStatementNode:
+		n.condExpr = o.expr(n.condExpr)
+	case *ifStatementNode:
+		n.condExpr = o.expr(n.condExpr)
+		o.children(n.TreeNode)
+		if cond, ok := intValue(n.condExpr); ok {
+			ch := n.children()
+			var block TreeNodeIf
+			if cond != 0 {
+				block = ch[0]
+			} else if len(ch) == 2 {
+				block = ch[1]
+			}
+			// the surviving block takes place of the statement
+			if tn := treeNode(block); tn != nil {
+				tn.parentNode = n.parentNode
+			}
+			return block
+		}
+		return n
+	}
+
+	if tn := treeNode(node); tn != nil {
+		o.children(tn)
+	}
+	return node
+}
+
+// children optimizes child nodes in place and drops eliminated ones
+func (o *astOptimizer) children(n *TreeNode) {
+	children := make([]TreeNodeIf, 0, len(n.childrenNodes))
+	for _, ch := range n.childrenNodes {
+		if replacement := o.node(ch); replacement != nil {
+			children = append(children, replacement)
+		}
+	}
+	n.childrenNodes = children
+}
+
+// expr folds an expression and returns its replacement
+func (o *astOptimizer) expr(node ExprNodeIf) ExprNodeIf {
+	switch n := node.(type) {
+	case *exprGroupNode:
+		n.value = o.expr(n.value)
+		if _, ok := n.value.(*exprLiteralNode); ok {
+			return n.value
+		}
+	case *exprBinOpNode:
+		n.lhs = o.expr(n.lhs)
+		n.rhs = o.expr(n.rhs)
+		return o.binOp(n)
+	case *exprUnOpNode:
+		n.value = o.expr(n.value)
+		return o.unOp(n)
+	case *ifExprNode:
+		n.condExpr = o.expr(n.condExpr)
+		n.condTrueExpr = o.expr(n.condTrueExpr)
+		n.condFalseExpr = o.expr(n.condFalseExpr)
+		if cond, ok := intValue(n.condExpr); ok {
+			if cond != 0 {
+				return n.condTrueExpr
+			}
+			return n.condFalseExpr
+		}
+	case *typeCastNode:
+		n.expr = o.expr(n.expr)
+	case *funCallNode:
+		o.children(n.TreeNode)
+		if n.definition != nil {
+			if n.definition.inline {
+				// every inline expansion has own definition
+				o.function(n.definition)
+			}
+			return n
+		}
+		if n.name == "concat" {
+			ch := n.children()
+			lhs, lok := bytesValue(ch[0])
+			rhs, rok := bytesValue(ch[1])
+			if lok && rok && len(lhs)+len(rhs) <= maxByteLength {
+				return newBytesLiteral(n.ctx, n.parent(), append(append([]byte{}, lhs...), rhs...))
+			}
+		}
+	case *runtimeFieldNode:
+		o.children(n.TreeNode)
+	case *runtimeArgNode:
+		o.children(n.TreeNode)
+	}
+	return node
+}
+
+func (o *astOptimizer) binOp(n *exprBinOpNode) ExprNodeIf {
+	lhs, lok := intValue(n.lhs)
+	rhs, rok := intValue(n.rhs)
+	if !lok || !rok {
+		// x + 0, 0 + x, x * 1, 1 * x
+		switch {
+		case n.op == "+" && rok && rhs == 0, n.op == "*" && rok && rhs == 1:
+			return n.lhs
+		case n.op == "+" && lok && lhs == 0, n.op == "*" && lok && lhs == 1:
+			return n.rhs
+		}
+		if n.op == "==" || n.op == "!=" {
+			lhs, lok := bytesValue(n.lhs)
+			rhs, rok := bytesValue(n.rhs)
+			if lok && rok {
+				equal := string(lhs) == string(rhs)
+				return newIntLiteral(n.ctx, n.parent(), boolToUint(equal == (n.op == "==")))
+			}
+		}
+		return n
+	}
+
+	var result uint64
+	switch n.op {
+	case "+":
+		if lhs > math.MaxUint64-rhs {
+			return o.fail(n.src, fmt.Sprintf("constant expression '%s' overflows uint64", n), n)
+		}
+		result = lhs + rhs
+	case "-":
+		if lhs < rhs {
+			return o.fail(n.src, fmt.Sprintf("constant expression '%s' results in negative value", n), n)
+		}
+		result = lhs - rhs
+	case "*":
+		if lhs != 0 && rhs > math.MaxUint64/lhs {
+			return o.fail(n.src, fmt.Sprintf("constant expression '%s' overflows uint64", n), n)
+		}
+		result = lhs * rhs
+	case "/", "%":
+		if rhs == 0 {
+			return o.fail(n.src, fmt.Sprintf("constant expression '%s' divides by zero", n), n)
+		}
+		if n.op == "/" {
+			result = lhs / rhs
+		} else {
+			result = lhs % rhs
+		}
+	case "<":
+		result = boolToUint(lhs < rhs)
+	case "<=":
+		result = boolToUint(lhs <= rhs)
+	case ">":
+		result = boolToUint(lhs > rhs)
+	case ">=":
+		result = boolToUint(lhs >= rhs)
+	case "==":
+		result = boolToUint(lhs == rhs)
+	case "!=":
+		result = boolToUint(lhs != rhs)
+	case "&&":
+		result = boolToUint(lhs != 0 && rhs != 0)
+	case "||":
+		result = boolToUint(lhs != 0 || rhs != 0)
+	case "&":
+		result = lhs & rhs
+	case "|":
+		result = lhs | rhs
+	case "^":
+		result = lhs ^ rhs
+	default:
+		return n
+	}
+	return newIntLiteral(n.ctx, n.parent(), result)
+}
+
+func (o *astOptimizer) unOp(n *exprUnOpNode) ExprNodeIf {
+	value, ok := intValue(n.value)
+	if !ok {
+		return n
+	}
+	switch n.op {
+	case "!":
+		return newIntLiteral(n.ctx, n.parent(), boolToUint(value == 0))
+	case "~":
+		return newIntLiteral(n.ctx, n.parent(), ^value)
+	}
+	return n
+}
+
+// fail reports an error at the source location and leaves the expression as is
+func (o *astOptimizer) fail(src srcRef, msg string, n ExprNodeIf) ExprNodeIf {
+	if src.parser != nil {
+		reportError(msg, src.parser, src.token, src.rule)
+	}
+	return n
+}
+
+func treeNode(node TreeNodeIf) *TreeNode {
+	switch n := node.(type) {
+	case *programNode:
+		return n.TreeNode
+	case *funDefNode:
+		return n.TreeNode
+	case *blockNode:
+		return n.TreeNode
+	case *forStatementNode:
+		return n.TreeNode
+	}
+	return nil
+}
+
+func boolToUint(value bool) uint64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// intValue returns value of integer literal or constant
+func intValue(node TreeNodeIf) (uint64, bool) {
+	var value string
+	switch n := node.(type) {
+	case *exprLiteralNode:
+		if n.exprType != intType {
+			return 0, false
+		}
+		value = n.value
+	case *exprIdentNode:
+		info, err := n.ctx.lookup(n.name)
+		if err != nil || !info.constant() || info.theType != intType || info.value == nil {
+			return 0, false
+		}
+		value = *info.value
+	default:
+		return 0, false
+	}
+	result, err := strconv.ParseUint(value, 0, 64)
+	if err != nil {
+		return 0, false
+	}
+	return result, true
+}
+
+// bytesValue returns value of byte string literal or constant
+func bytesValue(node TreeNodeIf) ([]byte, bool) {
+	var value string
+	switch n := node.(type) {
+	case *exprLiteralNode:
+		if n.exprType != bytesType {
+			return nil, false
+		}
+		value = n.value
+	case *exprIdentNode:
+		info, err := n.ctx.lookup(n.name)
+		if err != nil || !info.constant() || info.theType != bytesType || info.value == nil {
+			return nil, false
+		}
+		value = *info.value
+	default:
+		return nil, false
+	}
+	result, err := parseStringLiteral(value)
+	if err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+func newIntLiteral(ctx *context, parent TreeNodeIf, value uint64) ExprNodeIf {
+	literal := strconv.FormatUint(value, 10)
+	ctx.addLiteral(literal, intType)
+	return newExprLiteralNode(ctx, parent, intType, literal)
+}
+
+func newBytesLiteral(ctx *context, parent TreeNodeIf, value []byte) ExprNodeIf {
+	literal := fmt.Sprintf("%s\"%s\"", prefixBase64, base64.StdEncoding.EncodeToString(value))
+	// reuse the same value written differently in the source
+	for offset, existing := range ctx.literals.bytec {
+		if !bytes.Equal(existing, value) {
+			continue
+		}
+		for key, desc := range ctx.literals.literals {
+			if desc.theType == bytesType && desc.offset == uint(offset) {
+				return newExprLiteralNode(ctx, parent, bytesType, key)
+			}
+		}
+	}
+	ctx.addLiteral(literal, bytesType)
+	return newExprLiteralNode(ctx, parent, bytesType, literal)
+}
@@ -0,0 +1,176 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptimizeConstantFolding(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+const expectedGroupSize = 2
+const prefix = "ab"
+function logic() {
+	let a = (1 + 2) / 3
+	let b = txn.Fee * 1 + 0
+	let c = concat(prefix, "cd")
+	let d = ~0 == 18446744073709551615 && !(5 % 4 != 1)
+	return global.GroupSize == expectedGroupSize - 1 && c == "abcd" && b + a > d
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{OptimizationLevel: 1})
+	a.NoError(err)
+
+	expected := `#pragma version 6
+bytecblock 0x61626364
+// const
+// const
+fun_main:
+pushint 1
+store 0
+txn Fee
+store 1
+bytec_0
+store 2
+pushint 1
+store 3
+global GroupSize
+pushint 1
+==
+load 2
+bytec_0
+==
+&&
+load 1
+load 0
++
+load 3
+>
+&&
+return
+end_main:
+`
+	CompareTEAL(a, expected, result.TEAL)
+
+	// no folding without optimizations
+	result, err = Compile(InputDesc{Source: source}, Options{})
+	a.NoError(err)
+	a.Contains(result.TEAL, "intc_0\nintc_2\n+\npushint 3\n/\n")
+}
+
+func TestOptimizeConstantBranches(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+const debug = 0
+function logic() {
+	let a = 1
+	if debug {
+		log("debug")
+	}
+	if !debug {
+		a = 2
+	} else {
+		a = 3
+	}
+	for a < 10 {
+		if debug == 1 {
+			break
+		}
+		a = a + 1
+	}
+	return if debug { 0 } else { a }
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{OptimizationLevel: 1})
+	a.NoError(err)
+
+	expected := `#pragma version 6
+// const
+fun_main:
+pushint 1
+store 0
+pushint 2
+store 0
+fun_main_loop1_start:
+load 0
+pushint 10
+<
+bz fun_main_loop1_end
+load 0
+pushint 1
++
+store 0
+b fun_main_loop1_start
+fun_main_loop1_end:
+load 0
+return
+end_main:
+`
+	CompareTEAL(a, expected, result.TEAL)
+}
+
+// TestOptimizePrunedParents checks blocks of pruned if statements are attached to the enclosing block
+func TestOptimizePrunedParents(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+const debug = 0
+function logic() {
+	let a = 1
+	if !debug {
+		a = 2
+	} else {
+		a = 3
+	}
+	return a
+}
+`
+	result, errors := Parse(source)
+	a.Empty(errors)
+	optimizeAST(result)
+
+	var check func(node TreeNodeIf)
+	check = func(node TreeNodeIf) {
+		for _, ch := range node.children() {
+			_, pruned := ch.(*ifStatementNode)
+			a.False(pruned)
+			if _, ok := ch.(*blockNode); ok {
+				a.True(ch.parent() == node, "%s parent is %s", ch, ch.parent())
+			}
+			check(ch)
+		}
+	}
+	check(result)
+}
+
+func TestOptimizeConstantErrors(t *testing.T) {
+	a := require.New(t)
+
+	tests := []struct {
+		source string
+		line   int
+		column int
+		msg    string
+	}{
+		{"function logic() {\n\tlet a = 1 - 2\n\treturn a\n}\n", 2, 11, "constant expression '1 - 2' results in negative value"},
+		{"function logic() {\n\treturn 18446744073709551615 + 1\n}\n", 2, 29, "constant expression '18446744073709551615 + 1' overflows uint64"},
+		{"const big = 4294967296\nfunction logic() {\n\treturn big * big\n}\n", 3, 12, "overflows uint64"},
+		{"function logic() {\n\treturn 5 / (2 - 2)\n}\n", 2, 10, "constant expression '5 / 0' divides by zero"},
+		{"function logic() {\n\treturn 5 % 0\n}\n", 2, 10, "divides by zero"},
+	}
+	for _, test := range tests {
+		result, err := Compile(InputDesc{Source: test.source}, Options{OptimizationLevel: 1})
+		a.Error(err, test.source)
+		a.NotEmpty(result.Diagnostics, test.source)
+		a.Equal(test.line, result.Diagnostics[0].line, test.source)
+		a.Equal(test.column, result.Diagnostics[0].column, test.source)
+		a.Contains(result.Diagnostics[0].String(), test.msg, test.source)
+
+		// reported at runtime otherwise
+		_, err = Compile(InputDesc{Source: test.source}, Options{})
+		a.NoError(err, test.source)
+	}
+}
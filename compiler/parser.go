@@ -29,6 +29,9 @@ import (
 
 var mainFuncName = "main"
 
+// mainFuncSourceName is how the main function is declared in sources
+var mainFuncSourceName = "logic"
+
 type treeNodeListener struct {
 	*gen.BaseTealangParserListener
 	ctx      *context
@@ -77,8 +80,13 @@ func (l *exprListener) getExpr() ExprNodeIf {
 type parseContext struct {
 	input          InputDesc
 	collector      *errorCollector
-	moduleResolver func(moduleName string, sourceDir string, currentDir string) (InputDesc, error)
+	moduleResolver ModuleResolver
 	loadedModules  map[string]TreeNodeIf
+	version        int
+	optimize       bool // run AST optimizations after parsing
+	symbols        *symbolTable
+	// collectors of imported modules keep getting warnings while function bodies are parsed at calls
+	modules []*errorCollector
 }
 
 func newParseContext(input InputDesc, collector *errorCollector) (ctx *parseContext) {
@@ -86,6 +94,7 @@ func newParseContext(input InputDesc, collector *errorCollector) (ctx *parseCont
 	ctx.input = input
 	ctx.collector = collector
 	ctx.loadedModules = make(map[string]TreeNodeIf)
+	ctx.version = defaultTealVersion()
 	return
 }
 
@@ -94,6 +103,13 @@ func reportError(msg string, parser antlr.Parser, token antlr.Token, rule antlr.
 	parser.NotifyErrorListeners(e.GetMessage(), e.GetOffendingToken(), e)
 }
 
+// reportWarning records a non-fatal diagnostic identified by a stable code
+func reportWarning(code string, msg string, parser antlr.Parser, token antlr.Token, rule antlr.RuleContext) {
+	e := newTealangBaseRecognitionException(msg, parser, token, rule)
+	e.code = code
+	parser.NotifyErrorListeners(e.GetMessage(), e.GetOffendingToken(), e)
+}
+
 func reportParserError(err ParserError, parser antlr.Parser, token antlr.Token, rule antlr.RuleContext) {
 	e := newTealangParserErrorException(err, parser, token, rule)
 	parser.NotifyErrorListeners(e.GetMessage(), e.GetOffendingToken(), e)
@@ -107,7 +123,7 @@ func reportParserError(err ParserError, parser antlr.Parser, token antlr.Token,
 
 // EnterProgram is an entry point to AST
 func (l *treeNodeListener) EnterProgram(ctx *gen.ProgramContext) {
-	root := newProgramNode(l.ctx, l.parent)
+	root := newProgramNode(l.ctx, l.parent, l.parseCtx.version)
 
 	stmts := ctx.AllGlobalStatement()
 	for _, stmt := range stmts {
@@ -115,6 +131,7 @@ func (l *treeNodeListener) EnterProgram(ctx *gen.ProgramContext) {
 		stmt.EnterRule(l)
 		node := l.getNode()
 		if node != nil {
+			node.locate(stmt)
 			root.append(node)
 		}
 	}
@@ -173,7 +190,7 @@ func (l *treeNodeListener) EnterGlobalStatement(ctx *gen.GlobalStatementContext)
 
 // EnterModule is an entry point to AST
 func (l *treeNodeListener) EnterModule(ctx *gen.ModuleContext) {
-	root := newProgramNode(l.ctx, l.parent)
+	root := newProgramNode(l.ctx, l.parent, l.parseCtx.version)
 
 	declarations := ctx.AllDeclaration()
 	for _, declaration := range declarations {
@@ -199,13 +216,23 @@ func parseFunCall(ctx *context, parent TreeNodeIf, name string, allExpr []gen.IE
 	return node
 }
 
-func parseFunDeclarationImpl(l *treeNodeListener, callNode *funCallNode, ctx *gen.DeclarationContext, inline bool, void bool) {
+func parseFunDeclarationImpl(l *treeNodeListener, callNode *funCallNode, ctx *gen.DeclarationContext, inline bool, void bool, vi *varInfo, declCtx *context) {
 	// start new scoped context
+	// subroutines with own stack frame only see names from the declaration scope,
+	// other functions are scoped to the call site
 	name := ctx.IDENT(0).GetText()
-	scopedContext := newContext(name, l.ctx)
+	argCount := len(ctx.AllIDENT()) - 1
+	var scopedContext *context
+	frameBased := !inline && declCtx.frameSubroutines()
+	if frameBased {
+		scopedContext = newContext(name, declCtx)
+		scopedContext.frame = &frame{args: argCount}
+	} else {
+		scopedContext = newContext(name, l.ctx)
+	}
+	scopedContext.function = true
 
 	// get arguments vars
-	argCount := len(ctx.AllIDENT()) - 1
 	args := make([]funArg, argCount)
 	actualArgs := callNode.children()
 	if len(args) != len(actualArgs) {
@@ -225,11 +252,17 @@ func parseFunDeclarationImpl(l *treeNodeListener, callNode *funCallNode, ctx *ge
 		// arguments are variables in a new scope
 		// for inline functions they are set when calling
 		// for regular functions they re popped from the stack inside a function
-		err = scopedContext.newVar(ident, theType)
+		// or addressed directly on the stack frame
+		if frameBased {
+			err = scopedContext.newFrameArg(ident, theType, i-argCount)
+		} else {
+			err = scopedContext.newVar(ident, theType)
+		}
 		if err != nil {
 			reportError(err.Error(), ctx.GetParser(), ctx.IDENT(i+1).GetSymbol(), ctx.GetRuleContext())
 			return
 		}
+		scopedContext.declareSymbol(ident, argumentSymbol, "", ctx.GetParser(), ctx.IDENT(i+1).GetSymbol())
 		args[i] = funArg{ident, theType}
 	}
 	node := newFunDefNode(scopedContext, l.parent)
@@ -237,6 +270,14 @@ func parseFunDeclarationImpl(l *treeNodeListener, callNode *funCallNode, ctx *ge
 	node.args = args
 	node.inline = inline
 	node.void = void
+	node.src = srcRef{ctx.GetParser(), ctx.IDENT(0).GetSymbol(), ctx.GetRuleContext()}
+	node.locate(ctx)
+
+	if !inline {
+		// register the definition before parsing the body so that recursive calls find it
+		vi.node = node
+		l.ctx.update(name, *vi)
+	}
 
 	// parse function body and add statements as children
 	listener := newTreeNodeListener(scopedContext, node)
@@ -260,11 +301,22 @@ func (l *treeNodeListener) EnterDeclaration(ctx *gen.DeclarationContext) {
 		if ctx.VOID() != nil {
 			void = true
 		}
+		if modifier := ctx.TestModifier(); modifier != nil {
+			if modifier.GetText() != "test" {
+				reportError(fmt.Sprintf("unexpected '%s' before function", modifier.GetText()), ctx.GetParser(), modifier.GetStart(), ctx.GetRuleContext())
+				return
+			}
+			if len(ctx.AllIDENT()) > 1 {
+				reportError("test function can't have arguments", ctx.GetParser(), ctx.IDENT(1).GetSymbol(), ctx.GetRuleContext())
+				return
+			}
+		}
 		// register now and parse it later just before the call
+		declCtx := l.ctx
 		defParserCb := func(context *context, callNode *funCallNode, vi *varInfo) *funDefNode {
 			if inline || vi.node == nil {
 				listener := newTreeNodeListener(context, callNode)
-				parseFunDeclarationImpl(listener, callNode, ctx, inline, void)
+				parseFunDeclarationImpl(listener, callNode, ctx, inline, void, vi, declCtx)
 				node := listener.node
 				if node == nil {
 					return nil
@@ -272,41 +324,16 @@ func (l *treeNodeListener) EnterDeclaration(ctx *gen.DeclarationContext) {
 				vi.node = node
 				return node.(*funDefNode)
 			}
-			// otherwise fixup internal variable indices
-			// the trick is to use scratch space slots that are not used yet
-			// in order to guarantee function args do not shadow global/main/parent variables.
-			// non-inline functions use stack for passing arguments, so remapping forward internal vars
-			// on invocation is safe and does not cause any problems because the func ends up using
-			// maximal addresses accross all invocations.
-			// the only problem is it requires remapping all way up to nested functions.
-			defNode := vi.node.(*funDefNode)
-			thisCtx := defNode.ctx
-			parentCtx := callNode.ctx
-			if thisCtx.EntryAddress() < parentCtx.LastAddress() {
-				thisCtx.remapTo(parentCtx.LastAddress())
-
-				var remapRec func(defNode *funDefNode)
-				remapRec = func(defNode *funDefNode) {
-					subfuncs := defNode.ctx.functions
-					for _, funCallExprNode := range subfuncs {
-						thisCtx := funCallExprNode.definition.ctx
-						parentCtx := thisCtx.parent
-						if thisCtx.EntryAddress() < parentCtx.LastAddress() {
-							thisCtx.remapTo(parentCtx.LastAddress())
-						}
-						remapRec(funCallExprNode.definition)
-					}
-				}
-
-				remapRec(defNode)
-			}
-			return defNode
+			// scratch slots of subroutines are assigned by allocateScratch after parsing
+			return vi.node.(*funDefNode)
 		}
 		err := l.ctx.newFunc(name, unknownType, defParserCb)
 		if err != nil {
 			reportError(err.Error(), ctx.GetParser(), ctx.FUNC().GetSymbol(), ctx.GetRuleContext())
 			return
 		}
+		l.ctx.declare(name, srcRef{ctx.GetParser(), ctx.IDENT(0).GetSymbol(), ctx.GetRuleContext()})
+		l.ctx.declareSymbol(name, functionSymbol, funcSignature(ctx), ctx.GetParser(), ctx.IDENT(0).GetSymbol())
 	} else if fun := ctx.IMPORT(); fun != nil {
 		moduleName := ctx.MODULENAME().GetText()
 		tree, err := parseModule(moduleName, l.parseCtx, l.parent, l.ctx)
@@ -334,14 +361,18 @@ func (l *treeNodeListener) EnterDeclaration(ctx *gen.DeclarationContext) {
 				return
 			}
 		}
+		l.ctx.referModule(moduleName, ctx.GetParser(), ctx.MODULENAME().GetSymbol())
 	}
 }
 
 func (l *treeNodeListener) EnterMain(ctx *gen.MainContext) {
 	scopedContext := newContext("main", l.ctx)
+	scopedContext.function = true
 
 	node := newFunDefNode(scopedContext, l.parent)
 	node.name = mainFuncName
+	node.src = srcRef{ctx.GetParser(), ctx.MAINFUNC().GetSymbol(), ctx.GetRuleContext()}
+	node.locate(ctx)
 
 	listener := newTreeNodeListener(scopedContext, node)
 	ctx.Block().EnterRule(listener)
@@ -370,6 +401,7 @@ func (l *treeNodeListener) EnterDeclareVar(ctx *gen.DeclareVarContext) {
 		reportError(err.Error(), ctx.GetParser(), ctx.IDENT().GetSymbol(), ctx.GetRuleContext())
 		return
 	}
+	declareVar(l.ctx, ctx.IDENT(), ctx.GetParser(), ctx.GetRuleContext())
 
 	node.setExpr(exprNode)
 
@@ -405,6 +437,9 @@ func (l *treeNodeListener) EnterDeclareVarTupleExpr(ctx *gen.DeclareVarTupleExpr
 		reportError(err.Error(), ctx.GetParser(), ctx.IDENT(0).GetSymbol(), ctx.GetRuleContext())
 		return
 	}
+	for _, ident := range ctx.AllIDENT() {
+		declareVar(l.ctx, ident, ctx.GetParser(), ctx.GetRuleContext())
+	}
 
 	node.setExpr(exprNode)
 
@@ -452,6 +487,9 @@ func (l *treeNodeListener) EnterDeclareQuadrupleExpr(ctx *gen.DeclareQuadrupleEx
 		reportError(err.Error(), ctx.GetParser(), ctx.IDENT(2).GetSymbol(), ctx.GetRuleContext())
 		return
 	}
+	for _, ident := range ctx.AllIDENT() {
+		declareVar(l.ctx, ident, ctx.GetParser(), ctx.GetRuleContext())
+	}
 
 	node.setExpr(exprNode)
 	l.node = node
@@ -467,6 +505,7 @@ func (l *treeNodeListener) EnterDeclareNumberConst(ctx *gen.DeclareNumberConstCo
 		reportError(err.Error(), ctx.GetParser(), ctx.IDENT().GetSymbol(), ctx.GetRuleContext())
 		return
 	}
+	l.ctx.declareSymbol(varName, constantSymbol, "", ctx.GetParser(), ctx.IDENT().GetSymbol())
 	l.node = node
 }
 
@@ -480,24 +519,48 @@ func (l *treeNodeListener) EnterDeclareStringConst(ctx *gen.DeclareStringConstCo
 		reportError(err.Error(), ctx.GetParser(), ctx.IDENT().GetSymbol(), ctx.GetRuleContext())
 		return
 	}
+	l.ctx.declareSymbol(varName, constantSymbol, "", ctx.GetParser(), ctx.IDENT().GetSymbol())
 	l.node = node
 }
 
 func (l *treeNodeListener) EnterBlock(ctx *gen.BlockContext) {
 	block := newBlockNode(l.ctx, l.parent)
 	statements := ctx.AllStatement()
+	terminated, reported := false, false
 	for _, stmt := range statements {
 		l := newTreeNodeListener(l.ctx, block)
 		stmt.EnterRule(l)
 		node := l.getNode()
 		if node != nil {
+			if terminated && !reported {
+				reportWarning(unreachableCodeWarning, "unreachable code", stmt.GetParser(), stmt.GetStart(), stmt)
+				reported = true
+			}
+			switch node.(type) {
+			case *returnNode, *errorNode, *breakNode, *continueNode:
+				terminated = true
+			}
+			node.locate(stmt)
 			block.append(node)
 		}
 		stmt.ExitRule(l)
+		if fun := enclosingFunction(block); fun != nil && fun.rejected {
+			break
+		}
 	}
 	l.node = block
 }
 
+// enclosingFunction returns definition of the function the node belongs to
+func enclosingFunction(node TreeNodeIf) *funDefNode {
+	for ; node != nil; node = node.parent() {
+		if fun, ok := node.(*funDefNode); ok {
+			return fun
+		}
+	}
+	return nil
+}
+
 func (l *treeNodeListener) EnterStatement(ctx *gen.StatementContext) {
 	if ctx.Decl() != nil {
 		ctx.Decl().EnterRule(l)
@@ -544,6 +607,9 @@ outer:
 		)
 		return
 	}
+	if definition.rejected {
+		return
+	}
 	// allow only void + empty value, non-void + non-empty value
 	if definition.void && node.value != nil {
 		reportError(
@@ -607,6 +673,10 @@ func (l *treeNodeListener) EnterInnerTxnAssign(ctx *gen.InnerTxnAssignContext) {
 		)
 		return
 	}
+	if err := checkOpVersion(l.ctx.version, "itxn_field", field); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.TXNFIELD().GetSymbol(), ctx.GetRuleContext())
+		return
+	}
 	if exprType != rhsType {
 		reportError(
 			fmt.Sprintf("incompatible types: (lhs) %s vs %s (expr)", exprType, rhsType),
@@ -639,6 +709,10 @@ func (l *treeNodeListener) EnterInnerTxnArrayAssign(ctx *gen.InnerTxnArrayAssign
 		)
 		return
 	}
+	if err := checkOpVersion(l.ctx.version, "itxn_field", field); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.TXNARRAYFIELD().GetSymbol(), ctx.GetRuleContext())
+		return
+	}
 	if exprType != exprToPushType {
 		reportError(
 			fmt.Sprintf("incompatible types: (lhs) %s vs %s (expr)", exprType, exprToPushType),
@@ -651,14 +725,26 @@ func (l *treeNodeListener) EnterInnerTxnArrayAssign(ctx *gen.InnerTxnArrayAssign
 }
 
 func (l *treeNodeListener) EnterInnerTxnBegin(ctx *gen.InnerTxnBeginContext) {
+	if err := checkOpVersion(l.ctx.version, "itxn_begin", ""); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
 	l.node = newInnertxnBeginNode(l.ctx, l.parent)
 }
 
 func (l *treeNodeListener) EnterInnerTxnNext(ctx *gen.InnerTxnNextContext) {
+	if err := checkOpVersion(l.ctx.version, "itxn_next", ""); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
 	l.node = newInnertxnNextNode(l.ctx, l.parent)
 }
 
 func (l *treeNodeListener) EnterInnerTxnEnd(ctx *gen.InnerTxnEndContext) {
+	if err := checkOpVersion(l.ctx.version, "itxn_submit", ""); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
 	l.node = newInnertxnEndNode(l.ctx, l.parent)
 }
 
@@ -679,39 +765,63 @@ func (l *treeNodeListener) EnterDoLog(ctx *gen.DoLogContext) {
 	l.node = exprNode
 }
 
-func (l *treeNodeListener) EnterBreak(ctx *gen.BreakContext) {
-	// check break is inside for loop
-	parentBlock, ok := l.parent.(*blockNode)
-	if !ok {
-		parser := ctx.GetParser()
-		token := ctx.BREAK().GetSymbol()
-		rule := ctx.GetRuleContext()
-		reportError("break is not inside block", parser, token, rule)
-		return
-	}
-
-	var parent TreeNodeIf = parentBlock
-	var forNode *forStatementNode
-outer:
-	for parent != nil && forNode == nil {
+// findLoop looks up an enclosing for loop within the current function.
+// Empty label selects the innermost loop
+func findLoop(parent TreeNodeIf, label string) *forStatementNode {
+	for parent != nil {
 		switch tt := parent.(type) {
 		case *forStatementNode:
-			forNode = tt
-			break outer
+			if label == "" || tt.label == label {
+				return tt
+			}
 		case *funDefNode:
-			break outer
+			return nil
 		}
 		parent = parent.parent()
 	}
+	return nil
+}
+
+// resolveLoop finds a target loop for break or continue statement and reports an error if not found
+func resolveLoop(parent TreeNodeIf, keyword antlr.TerminalNode, ident antlr.TerminalNode, parser antlr.Parser, rule antlr.RuleContext) *forStatementNode {
+	token := keyword.GetSymbol()
+	// check it is inside for loop
+	if _, ok := parent.(*blockNode); !ok {
+		reportError(fmt.Sprintf("%s is not inside block", token.GetText()), parser, token, rule)
+		return nil
+	}
+
+	label := ""
+	if ident != nil {
+		label = ident.GetText()
+	}
+
+	forNode := findLoop(parent, label)
+	if forNode == nil {
+		if label != "" {
+			reportError(fmt.Sprintf("loop label '%s' not defined", label), parser, ident.GetSymbol(), rule)
+		} else {
+			reportError(fmt.Sprintf("%s is not inside for block", token.GetText()), parser, token, rule)
+		}
+		return nil
+	}
+	return forNode
+}
+
+func (l *treeNodeListener) EnterBreak(ctx *gen.BreakContext) {
+	forNode := resolveLoop(l.parent, ctx.BREAK(), ctx.IDENT(), ctx.GetParser(), ctx.GetRuleContext())
 	if forNode == nil {
-		parser := ctx.GetParser()
-		token := ctx.BREAK().GetSymbol()
-		rule := ctx.GetRuleContext()
-		reportError("break is not inside for block", parser, token, rule)
 		return
 	}
+	l.node = newBreakNode(l.ctx, l.parent, forNode)
+}
 
-	l.node = newBreakNode(l.ctx, l.parent)
+func (l *treeNodeListener) EnterContinue(ctx *gen.ContinueContext) {
+	forNode := resolveLoop(l.parent, ctx.CONTINUE(), ctx.IDENT(), ctx.GetParser(), ctx.GetRuleContext())
+	if forNode == nil {
+		return
+	}
+	l.node = newContinueNode(l.ctx, l.parent, forNode)
 }
 
 func (l *treeNodeListener) EnterIfStatement(ctx *gen.IfStatementContext) {
@@ -720,6 +830,7 @@ func (l *treeNodeListener) EnterIfStatement(ctx *gen.IfStatementContext) {
 	exprlistener := newExprListener(l.ctx, node)
 	ctx.CondIfExpr().EnterRule(exprlistener)
 	node.condExpr = exprlistener.getExpr()
+	checkCondition("if", node.condExpr, ctx.GetParser(), ctx.CondIfExpr().GetStart(), ctx.GetRuleContext())
 
 	scopedContextTrue := newContext("if", l.ctx)
 
@@ -765,11 +876,24 @@ func getVarInfoForAssignment(ident string, ctx *context) (varInfo, error) {
 }
 
 func (l *treeNodeListener) EnterForStatement(ctx *gen.ForStatementContext) {
-	node := newForStatementNode(l.ctx, l.parent)
+	label := ""
+	if ctx.IDENT() != nil {
+		label = ctx.IDENT().GetText()
+		if findLoop(l.parent, label) != nil {
+			reportError(
+				fmt.Sprintf("loop label '%s' already defined", label),
+				ctx.GetParser(), ctx.IDENT().GetSymbol(), ctx.GetRuleContext(),
+			)
+			return
+		}
+	}
+
+	node := newForStatementNode(l.ctx, l.parent, label)
 
 	exprlistener := newExprListener(l.ctx, node)
 	ctx.CondForExpr().EnterRule(exprlistener)
 	node.condExpr = exprlistener.getExpr()
+	checkCondition("for", node.condExpr, ctx.GetParser(), ctx.CondForExpr().GetStart(), ctx.GetRuleContext())
 
 	scopedContextTrue := newContext("for", l.ctx)
 
@@ -788,6 +912,7 @@ func (l *treeNodeListener) EnterAssign(ctx *gen.AssignContext) {
 	}
 
 	node := newAssignNode(l.ctx, l.parent, ident)
+	l.ctx.referSymbol(ident, nil, ctx.GetParser(), ctx.IDENT().GetSymbol())
 	listener := newExprListener(l.ctx, node)
 	ctx.Expr().EnterRule(listener)
 	rhs := listener.getExpr()
@@ -935,8 +1060,10 @@ func (l *exprListener) EnterIdentifier(ctx *gen.IdentifierContext) {
 		reportError("ident not found", ctx.GetParser(), ctx.IDENT().GetSymbol(), ctx.GetRuleContext())
 		return
 	}
+	l.ctx.markRead(ident)
 
 	node := newExprIdentNode(l.ctx, l.parent, ident, variable.theType)
+	l.ctx.referSymbol(ident, node, ctx.GetParser(), ctx.IDENT().GetSymbol())
 	l.expr = node
 }
 
@@ -962,9 +1089,11 @@ func (l *exprListener) EnterStringLiteral(ctx *gen.StringLiteralContext) {
 	l.expr = node
 }
 
-func (l *exprListener) binOp(op string, lhs gen.IExprContext, rhs gen.IExprContext) {
+func (l *exprListener) binOp(src srcRef, lhs gen.IExprContext, rhs gen.IExprContext) {
 
-	node := newExprBinOpNode(l.ctx, l.parent, op)
+	node := newExprBinOpNode(l.ctx, l.parent, src.token.GetText())
+	node.src = src
+	node.locateToken(src.parser, src.token)
 
 	subExprListener := newExprListener(l.ctx, node)
 	lhs.EnterRule(subExprListener)
@@ -977,9 +1106,11 @@ func (l *exprListener) binOp(op string, lhs gen.IExprContext, rhs gen.IExprConte
 	l.expr = node
 }
 
-func (l *exprListener) unOp(op string, expr gen.IExprContext) {
+func (l *exprListener) unOp(src srcRef, expr gen.IExprContext) {
 
-	node := newExprUnOpNode(l.ctx, l.parent, op)
+	node := newExprUnOpNode(l.ctx, l.parent, src.token.GetText())
+	node.src = src
+	node.locateToken(src.parser, src.token)
 
 	subExprListener := newExprListener(l.ctx, node)
 	expr.EnterRule(subExprListener)
@@ -989,38 +1120,31 @@ func (l *exprListener) unOp(op string, expr gen.IExprContext) {
 }
 
 func (l *exprListener) EnterAddSub(ctx *gen.AddSubContext) {
-	op := ctx.GetOp().GetText()
-	l.binOp(op, ctx.Expr(0), ctx.Expr(1))
+	l.binOp(srcRef{ctx.GetParser(), ctx.GetOp(), ctx.GetRuleContext()}, ctx.Expr(0), ctx.Expr(1))
 }
 
 func (l *exprListener) EnterMulDivMod(ctx *gen.MulDivModContext) {
-	op := ctx.GetOp().GetText()
-	l.binOp(op, ctx.Expr(0), ctx.Expr(1))
+	l.binOp(srcRef{ctx.GetParser(), ctx.GetOp(), ctx.GetRuleContext()}, ctx.Expr(0), ctx.Expr(1))
 }
 
 func (l *exprListener) EnterRelation(ctx *gen.RelationContext) {
-	op := ctx.GetOp().GetText()
-	l.binOp(op, ctx.Expr(0), ctx.Expr(1))
+	l.binOp(srcRef{ctx.GetParser(), ctx.GetOp(), ctx.GetRuleContext()}, ctx.Expr(0), ctx.Expr(1))
 }
 
 func (l *exprListener) EnterBitOp(ctx *gen.BitOpContext) {
-	op := ctx.GetOp().GetText()
-	l.binOp(op, ctx.Expr(0), ctx.Expr(1))
+	l.binOp(srcRef{ctx.GetParser(), ctx.GetOp(), ctx.GetRuleContext()}, ctx.Expr(0), ctx.Expr(1))
 }
 
 func (l *exprListener) EnterAndOr(ctx *gen.AndOrContext) {
-	op := ctx.GetOp().GetText()
-	l.binOp(op, ctx.Expr(0), ctx.Expr(1))
+	l.binOp(srcRef{ctx.GetParser(), ctx.GetOp(), ctx.GetRuleContext()}, ctx.Expr(0), ctx.Expr(1))
 }
 
 func (l *exprListener) EnterBitNot(ctx *gen.BitNotContext) {
-	op := ctx.GetOp().GetText()
-	l.unOp(op, ctx.Expr())
+	l.unOp(srcRef{ctx.GetParser(), ctx.GetOp(), ctx.GetRuleContext()}, ctx.Expr())
 }
 
 func (l *exprListener) EnterNot(ctx *gen.NotContext) {
-	op := ctx.GetOp().GetText()
-	l.unOp(op, ctx.Expr())
+	l.unOp(srcRef{ctx.GetParser(), ctx.GetOp(), ctx.GetRuleContext()}, ctx.Expr())
 }
 
 func (l *exprListener) EnterGroup(ctx *gen.GroupContext) {
@@ -1099,6 +1223,9 @@ func (l *exprListener) EnterFunctionCallExpr(ctx *gen.FunctionCallExprContext) {
 	listener := newExprListener(l.ctx, l.parent)
 	ctx.FunctionCallExpresion().EnterRule(listener)
 	l.expr = listener.getExpr()
+	if l.expr != nil {
+		l.expr.locate(ctx)
+	}
 }
 
 func (l *exprListener) EnterBuiltinFunCall(ctx *gen.BuiltinFunCallContext) {
@@ -1235,9 +1362,38 @@ func (l *exprListener) EnterFunctionCall(ctx *gen.FunctionCallContext) {
 		reportError("not a function", parser, token, rule)
 		return
 	}
+	l.ctx.markRead(name)
 
 	argExprNodes := ctx.AllExpr()
 	funCallExprNode := parseFunCall(l.ctx, l.parent, name, argExprNodes)
+	l.ctx.referSymbol(name, funCallExprNode, parser, token)
+
+	// recursion needs arguments and locals to be kept on the stack frame
+	recursive := false
+	for current := l.parent; current != nil; current = current.parent() {
+		if def, ok := current.(*funDefNode); ok && def.name == name {
+			recursive = true
+			if def.inline {
+				reportError(fmt.Sprintf("inline function '%s' cannot be recursive", name), parser, token, rule)
+				return
+			}
+			if def.ctx.frame == nil {
+				reportError(
+					fmt.Sprintf("recursive call of '%s' requires TEAL v%d", name, langOps["proto"].IntroducedVersion),
+					parser, token, rule,
+				)
+				// stop analysing functions of the recursion cycle
+				for fun := l.parent; fun != def.parent(); fun = fun.parent() {
+					if fd, ok := fun.(*funDefNode); ok {
+						fd.rejected = true
+					}
+				}
+				return
+			}
+			break
+		}
+	}
+
 	// parse function body
 	defNode := info.parser(l.ctx, funCallExprNode, &info)
 	if defNode == nil {
@@ -1269,8 +1425,8 @@ func (l *exprListener) EnterFunctionCall(ctx *gen.FunctionCallContext) {
 	}
 
 	// both regular and void functions must return
-	block := defNode.children()[0]
-	if !ensureBlockReturns(block) {
+	// recursive calls are made while the body is being parsed so it is checked by the outermost call
+	if !recursive && !ensureBlockReturns(defNode.children()[0]) {
 		reportError(
 			fmt.Sprintf("function '%s' does not return", name),
 			parser, token, rule,
@@ -1279,6 +1435,13 @@ func (l *exprListener) EnterFunctionCall(ctx *gen.FunctionCallContext) {
 	}
 
 	if !defNode.inline {
+		if err := checkOpVersion(l.ctx.version, "callsub", ""); err != nil {
+			reportError(
+				fmt.Sprintf("function '%s' cannot be called as a subroutine: %s", name, err.Error()),
+				parser, token, rule,
+			)
+			return
+		}
 		var p *programNode
 		if p = defNode.root(); p == nil {
 			reportError(
@@ -1289,9 +1452,6 @@ func (l *exprListener) EnterFunctionCall(ctx *gen.FunctionCallContext) {
 		}
 		// save parsed functions at the root node to generate them
 		p.registerFunction(defNode)
-
-		// save functions in the current context in order to refresh local vars bindings
-		l.ctx.registerFunCall(defNode.name, funCallExprNode)
 	}
 
 	funCallExprNode.definition = defNode
@@ -1530,11 +1690,19 @@ func (l *exprListener) EnterBuiltinObject(ctx *gen.BuiltinObjectContext) {
 	listener := newExprListener(l.ctx, l.parent)
 	ctx.BuiltinVarExpr().EnterRule(listener)
 	l.expr = listener.getExpr()
+	if l.expr != nil {
+		l.expr.locate(ctx)
+	}
 }
 
 func (l *exprListener) EnterGlobalFieldExpr(ctx *gen.GlobalFieldExprContext) {
 	field := ctx.GLOBALFIELD().GetText()
 	node := newRuntimeFieldNode(l.ctx, l.parent, "global", field)
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1547,6 +1715,11 @@ func (l *exprListener) EnterTxnFieldExpr(ctx *gen.TxnFieldExprContext) {
 func (l *exprListener) EnterTxnSingleFieldExpr(ctx *gen.TxnSingleFieldExprContext) {
 	field := ctx.TXNFIELD().GetText()
 	node := newRuntimeFieldNode(l.ctx, l.parent, "txn", field)
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1586,6 +1759,11 @@ func (l *exprListener) EnterTxnArrayFieldExpr(ctx *gen.TxnArrayFieldExprContext)
 		return
 	}
 
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1598,6 +1776,11 @@ func (l *exprListener) EnterInnerTxnFieldExpr(ctx *gen.InnerTxnFieldExprContext)
 func (l *exprListener) EnterInnerTxnSingleFieldExpr(ctx *gen.InnerTxnSingleFieldExprContext) {
 	field := ctx.TXNFIELD().GetText()
 	node := newRuntimeFieldNode(l.ctx, l.parent, "itxn", field)
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1636,6 +1819,11 @@ func (l *exprListener) EnterInnerTxnArrayFieldExpr(ctx *gen.InnerTxnArrayFieldEx
 		return
 	}
 
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1680,6 +1868,11 @@ func (l *exprListener) EnterGroupTxnSingleFieldExpr(ctx *gen.GroupTxnSingleField
 		return
 	}
 
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1766,6 +1959,11 @@ func (l *exprListener) EnterGroupTxnArrayFieldExpr(ctx *gen.GroupTxnArrayFieldEx
 		}
 	}
 
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1808,6 +2006,11 @@ func (l *exprListener) EnterGroupInnerTxnSingleFieldExpr(ctx *gen.GroupInnerTxnS
 		return
 	}
 
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1884,6 +2087,11 @@ func (l *exprListener) EnterGroupInnerTxnArrayFieldExpr(ctx *gen.GroupInnerTxnAr
 		node.append(arrayIndexExprNode)
 	}
 
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
@@ -1921,11 +2129,16 @@ func (l *exprListener) EnterArgsExpr(ctx *gen.ArgsExprContext) {
 		return
 	}
 
+	if err := node.checkVersion(); err != nil {
+		reportError(err.Error(), ctx.GetParser(), ctx.GetStart(), ctx.GetRuleContext())
+		return
+	}
+
 	l.expr = node
 }
 
 func (l *treeNodeListener) EnterOnelinecond(ctx *gen.OnelinecondContext) {
-	root := newProgramNode(l.ctx, l.parent)
+	root := newProgramNode(l.ctx, l.parent, l.parseCtx.version)
 
 	listener := newExprListener(l.ctx, root)
 	ctx.Expr().EnterRule(listener)
@@ -1940,13 +2153,23 @@ func (l *treeNodeListener) EnterOnelinecond(ctx *gen.OnelinecondContext) {
 	l.node = root
 }
 
+// namedLexer names the source so that parsed nodes know which file they come from
+type namedLexer struct {
+	*gen.TealangLexer
+	name string
+}
+
+func (l *namedLexer) GetSourceName() string {
+	return l.name
+}
+
 func newParser(source string, collector *errorCollector) *gen.TealangParser {
 	is := antlr.NewInputStream(source)
 	lexer := gen.NewTealangLexer(is)
 	lexer.RemoveErrorListeners()
 	lexer.AddErrorListener(collector)
 
-	tokenStream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
+	tokenStream := antlr.NewCommonTokenStream(&namedLexer{lexer, collector.filename}, antlr.TokenDefaultChannel)
 	parser := gen.NewTealangParser(tokenStream)
 
 	parser.RemoveErrorListeners()
@@ -1981,6 +2204,9 @@ func parseModule(moduleName string, parseCtx *parseContext, parent TreeNodeIf, c
 	if err != nil {
 		return nil, err
 	}
+	if parseCtx.symbols != nil {
+		parseCtx.symbols.addModule(moduleName, input)
+	}
 
 	raw := md5.Sum([]byte(input.Source))
 	checksum := hex.EncodeToString(raw[:])
@@ -1990,6 +2216,7 @@ func parseModule(moduleName string, parseCtx *parseContext, parent TreeNodeIf, c
 
 	collector := newErrorCollector(input.Source, input.SourceFile)
 	parser := newParser(input.Source, collector)
+	parseCtx.modules = append(parseCtx.modules, collector)
 
 	tree := parser.Module()
 
@@ -2023,21 +2250,31 @@ func parseModule(moduleName string, parseCtx *parseContext, parent TreeNodeIf, c
 	return mod, nil
 }
 
-// ParseProgram accepts InputDesc that describes source location
-func ParseProgram(input InputDesc) (TreeNodeIf, []ParserError) {
+// parse runs the parser entry rule over the input and builds AST from it
+func parse(input InputDesc, entry func(*gen.TealangParser) antlr.ParserRuleContext, parseCtxSetup func(*parseContext)) (TreeNodeIf, []ParserError) {
 	collector := newErrorCollector(input.Source, input.SourceFile)
 	parser := newParser(input.Source, collector)
 
-	tree := parser.Program()
+	tree := entry(parser)
 
 	collector.filterAmbiguity()
 	if len(collector.errors) > 0 {
 		return nil, collector.errors
 	}
 
-	ctx := newContext("root", nil)
-
 	parseCtx := newParseContext(input, collector)
+	if parseCtxSetup != nil {
+		parseCtxSetup(parseCtx)
+	}
+	defer func() {
+		for _, module := range parseCtx.modules {
+			collector.copyWarnings(module)
+		}
+	}()
+
+	ctx := newContext("root", nil)
+	ctx.version = parseCtx.version
+	ctx.symbols = parseCtx.symbols
 	l := newRootTreeNodeListener(ctx, nil, parseCtx)
 
 	func() {
@@ -2056,10 +2293,34 @@ func ParseProgram(input InputDesc) (TreeNodeIf, []ParserError) {
 		return nil, collector.errors
 	}
 
+	checkUnused(ctx)
 	prog := l.getNode()
+	if parseCtx.optimize {
+		optimizeAST(prog)
+		if len(collector.errors) > 0 {
+			return nil, collector.errors
+		}
+	}
+	allocateScratch(prog)
+	if len(collector.errors) > 0 {
+		return nil, collector.errors
+	}
 	return prog, nil
 }
 
+func programRule(parser *gen.TealangParser) antlr.ParserRuleContext {
+	return parser.Program()
+}
+
+func oneLineCondRule(parser *gen.TealangParser) antlr.ParserRuleContext {
+	return parser.Onelinecond()
+}
+
+// ParseProgram accepts InputDesc that describes source location
+func ParseProgram(input InputDesc) (TreeNodeIf, []ParserError) {
+	return parse(input, programRule, nil)
+}
+
 // Parse function creates AST
 func Parse(source string) (TreeNodeIf, []ParserError) {
 	input := InputDesc{source, "", "", ""}
@@ -2068,78 +2329,16 @@ func Parse(source string) (TreeNodeIf, []ParserError) {
 
 func parseTestProgModule(progSource, moduleSource string) (TreeNodeIf, []ParserError) {
 	input := InputDesc{progSource, "test.tl", "", ""}
-	collector := newErrorCollector(input.Source, input.SourceFile)
-	parser := newParser(progSource, collector)
-
-	tree := parser.Program()
-
-	collector.filterAmbiguity()
-	if len(collector.errors) > 0 {
-		return nil, collector.errors
-	}
-
-	ctx := newContext("root", nil)
-	parseCtx := newParseContext(input, collector)
-	parseCtx.moduleResolver = func(moduleName string, sourceDir string, currentDir string) (InputDesc, error) {
-		input := InputDesc{moduleSource, moduleName, "", ""}
-		return input, nil
-	}
-	l := newRootTreeNodeListener(ctx, nil, parseCtx)
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				if len(collector.errors) == 0 {
-					fmt.Printf("unexpected error: %s\n", r)
-					fmt.Println("stacktrace from panic: \n" + string(debug.Stack()))
-				}
-			}
-		}()
-		tree.EnterRule(l)
-	}()
-
-	if len(collector.errors) > 0 {
-		return nil, collector.errors
-	}
-
-	prog := l.getNode()
-	return prog, nil
-
+	return parse(input, programRule, func(parseCtx *parseContext) {
+		parseCtx.moduleResolver = func(moduleName string, sourceDir string, currentDir string) (InputDesc, error) {
+			input := InputDesc{moduleSource, moduleName, "", ""}
+			return input, nil
+		}
+	})
 }
 
 // ParseOneLineCond is for parsing one-liners like "(txn.fee == 1) && (global.MinTxnFee < 2000)"
 func ParseOneLineCond(source string) (TreeNodeIf, []ParserError) {
 	input := InputDesc{source, "", "", ""}
-	collector := newErrorCollector(input.Source, input.SourceFile)
-	parser := newParser(input.Source, collector)
-
-	tree := parser.Onelinecond()
-
-	collector.filterAmbiguity()
-	if len(collector.errors) > 0 {
-		return nil, collector.errors
-	}
-
-	ctx := newContext("root", nil)
-	parseCtx := newParseContext(input, collector)
-	l := newRootTreeNodeListener(ctx, nil, parseCtx)
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				if len(collector.errors) == 0 {
-					fmt.Printf("unexpected error: %s\n", r)
-					fmt.Println("stacktrace from panic: \n" + string(debug.Stack()))
-				}
-			}
-		}()
-		tree.EnterRule(l)
-	}()
-
-	if len(collector.errors) > 0 {
-		return nil, collector.errors
-	}
-
-	expr := l.getNode()
-	return expr, nil
+	return parse(input, oneLineCondRule, nil)
 }
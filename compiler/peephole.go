@@ -0,0 +1,268 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Peephole optimizations of generated TEAL
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"strconv"
+	"strings"
+)
+
+// instruction is a single line of generated TEAL: an opcode with immediate args, a label or a comment
+type instruction struct {
+	op    string
+	args  []string
+	label string
+	text  string
+	// source is a location of the innermost statement or subexpression the instruction is generated for
+	source SourceLocation
+	// statement is a location of the statement the instruction is generated for
+	statement SourceLocation
+	// variable is a name of the variable loaded or stored by the instruction
+	variable string
+	// function is the function or inline expansion the instruction belongs to
+	function FunctionRef
+	// scope is the innermost scope of variables the instruction belongs to
+	scope *variableScope
+}
+
+// variableScope lists scratch slots of variables declared in a scope, nested scopes refer to the enclosing one
+type variableScope struct {
+	parent *variableScope
+	slots  map[string]int
+}
+
+func parseInstruction(line string) instruction {
+	switch {
+	case strings.HasPrefix(line, "//"):
+		return instruction{text: line}
+	case strings.HasSuffix(line, ":"):
+		return instruction{label: line[:len(line)-1], text: line}
+	}
+	fields := strings.Fields(line)
+	return instruction{op: fields[0], args: fields[1:], text: line}
+}
+
+func newInstruction(op string, args ...string) instruction {
+	return instruction{op: op, args: args, text: strings.Join(append([]string{op}, args...), " ")}
+}
+
+// replace makes a new instruction generated for the same source location and variable
+func (ins instruction) replace(op string, args ...string) instruction {
+	result := newInstruction(op, args...)
+	result.source = ins.source
+	result.statement = ins.statement
+	result.variable = ins.variable
+	result.function = ins.function
+	result.scope = ins.scope
+	return result
+}
+
+func (ins instruction) arg() string {
+	if len(ins.args) == 0 {
+		return ""
+	}
+	return ins.args[0]
+}
+
+// splitInstructions turns generated TEAL text into instruction list
+// and assigns source locations from markers emitted by codegenLocated and codegenExpr
+// and variable names from markers preceding loads and stores.
+// Scope markers give scratch slots of variables visible to instructions.
+// Function markers may nest since inline functions are expanded inside their callers
+func splitInstructions(teal string) []instruction {
+	lines := strings.Split(strings.TrimSuffix(teal, "\n"), "\n")
+	program := make([]instruction, 0, len(lines))
+	locations := make([]SourceLocation, 0, 8)
+	statements := make([]SourceLocation, 0, 8)
+	expressions := make([]bool, 0, 8) // kinds of open location markers
+	functions := make([]FunctionRef, 0, 4)
+	expansions := 0
+	var scope *variableScope
+	variable := ""
+	for _, line := range lines {
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, variableMarker+" "):
+			variable = strings.TrimPrefix(line, variableMarker+" ")
+			continue
+		case strings.HasPrefix(line, functionMarker+" "):
+			functions = append(functions, FunctionRef{Name: strings.TrimPrefix(line, functionMarker+" ")})
+			continue
+		case strings.HasPrefix(line, inlineMarker+" "):
+			expansions++
+			functions = append(functions, FunctionRef{Name: strings.TrimPrefix(line, inlineMarker+" "), Inline: true, Expansion: expansions})
+			continue
+		case line == functionEndMarker:
+			if len(functions) > 0 {
+				functions = functions[:len(functions)-1]
+			}
+			continue
+		case strings.HasPrefix(line, scopeMarker+" "):
+			scope = parseScopeMarker(line, scope)
+			continue
+		case line == scopeEndMarker:
+			if scope != nil {
+				scope = scope.parent
+			}
+			continue
+		case strings.HasPrefix(line, sourceMarker+" "):
+			loc := parseSourceMarker(line)
+			locations = append(locations, loc)
+			statements = append(statements, loc)
+			expressions = append(expressions, false)
+			continue
+		case strings.HasPrefix(line, expressionMarker+" "):
+			locations = append(locations, parseSourceMarker(line))
+			expressions = append(expressions, true)
+			continue
+		case line == sourceEndMarker:
+			if len(locations) > 0 {
+				if !expressions[len(expressions)-1] {
+					statements = statements[:len(statements)-1]
+				}
+				locations = locations[:len(locations)-1]
+				expressions = expressions[:len(expressions)-1]
+			}
+			continue
+		}
+		ins := parseInstruction(line)
+		if len(locations) > 0 {
+			ins.source = locations[len(locations)-1]
+		}
+		if len(statements) > 0 {
+			ins.statement = statements[len(statements)-1]
+		}
+		ins.variable, variable = variable, ""
+		if len(functions) > 0 {
+			ins.function = functions[len(functions)-1]
+		}
+		ins.scope = scope
+		program = append(program, ins)
+	}
+	return program
+}
+
+// parseScopeMarker parses "//@scope name=slot..." line opening a scope nested into the parent one
+func parseScopeMarker(line string, parent *variableScope) *variableScope {
+	scope := &variableScope{parent: parent, slots: make(map[string]int)}
+	for _, field := range strings.Fields(strings.TrimPrefix(line, scopeMarker+" ")) {
+		if idx := strings.IndexByte(field, '='); idx > 0 {
+			if slot, err := strconv.Atoi(field[idx+1:]); err == nil {
+				scope.slots[field[:idx]] = slot
+			}
+		}
+	}
+	return scope
+}
+
+// parseSourceMarker parses "//@source line column file" or "//@expr line column file" line
+func parseSourceMarker(line string) (loc SourceLocation) {
+	fields := strings.SplitN(line[strings.IndexByte(line, ' ')+1:], " ", 3)
+	if len(fields) > 0 {
+		loc.Line, _ = strconv.Atoi(fields[0])
+	}
+	if len(fields) > 1 {
+		loc.Column, _ = strconv.Atoi(fields[1])
+	}
+	if len(fields) > 2 {
+		loc.File = fields[2]
+	}
+	return
+}
+
+// renderInstructions builds TEAL text back from instruction list
+func renderInstructions(program []instruction) string {
+	var sb strings.Builder
+	for _, ins := range program {
+		sb.WriteString(ins.text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// peephole replaces short instruction sequences with cheaper equivalents until nothing changes:
+//
+//	store N; load N  => dup; store N
+//	frame_bury N; frame_dig N => dup; frame_bury N
+//	b L; L:          => L:
+//	!; bz L          => bnz L (and vice versa)
+//	intc <0>; ==     => !
+func peephole(program []instruction) []instruction {
+	intc := intConstants(program)
+	for {
+		changed := false
+		result := make([]instruction, 0, len(program))
+		for i := 0; i < len(program); i++ {
+			ins := program[i]
+			if i+1 == len(program) {
+				result = append(result, ins)
+				break
+			}
+			next := program[i+1]
+			switch {
+			// the value is still stored: slots are read by later transactions with gload and shown by traces
+			case (ins.op == "store" && next.op == "load" || ins.op == "frame_bury" && next.op == "frame_dig") && ins.arg() == next.arg():
+				result = append(result, ins.replace("dup"), ins)
+				i++
+			case ins.op == "b" && next.label != "" && ins.arg() == next.label:
+			case ins.op == "!" && next.op == "bz":
+				result = append(result, next.replace("bnz", next.args...))
+				i++
+			case ins.op == "!" && next.op == "bnz":
+				result = append(result, next.replace("bz", next.args...))
+				i++
+			case next.op == "==" && isZero(ins, intc):
+				result = append(result, next.replace("!"))
+				i++
+			default:
+				result = append(result, ins)
+				continue
+			}
+			changed = true
+		}
+		program = result
+		if !changed {
+			return program
+		}
+	}
+}
+
+// intConstants returns values from intcblock
+func intConstants(program []instruction) []string {
+	for _, ins := range program {
+		if ins.op == "intcblock" {
+			return ins.args
+		}
+	}
+	return nil
+}
+
+// isZero checks if the instruction pushes integer zero
+func isZero(ins instruction, intc []string) bool {
+	var value string
+	switch ins.op {
+	case "int", "pushint":
+		value = ins.arg()
+	case "intc":
+		idx, err := strconv.Atoi(ins.arg())
+		if err != nil || idx >= len(intc) {
+			return false
+		}
+		value = intc[idx]
+	case "intc_0", "intc_1", "intc_2", "intc_3":
+		idx := int(ins.op[len(ins.op)-1] - '0')
+		if idx >= len(intc) {
+			return false
+		}
+		value = intc[idx]
+	default:
+		return false
+	}
+	num, err := strconv.ParseUint(value, 0, 64)
+	return err == nil && num == 0
+}
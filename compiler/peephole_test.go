@@ -0,0 +1,79 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeephole(t *testing.T) {
+	a := require.New(t)
+
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"intcblock 0 1\nintc 1\nstore 2\nload 2\nreturn\n", "intcblock 0 1\nintc 1\ndup\nstore 2\nreturn\n"},
+		{"intcblock 0 1\nintc 1\nstore 2\nload 2\nload 2\n+\nreturn\n", "intcblock 0 1\nintc 1\ndup\ndup\nstore 2\n+\nreturn\n"},
+		{"intcblock 0 1\nintc 1\nstore 2\nlbl:\nload 2\nreturn\n", "intcblock 0 1\nintc 1\nstore 2\nlbl:\nload 2\nreturn\n"},
+		{"proto 1 1\nframe_dig -1\nframe_bury 0\nframe_dig 0\nretsub\n", "proto 1 1\nframe_dig -1\ndup\nframe_bury 0\nretsub\n"},
+		{"b lbl\nlbl:\nerr\n", "lbl:\nerr\n"},
+		{"b lbl\nother:\nlbl:\nerr\n", "b lbl\nother:\nlbl:\nerr\n"},
+		{"txn Fee\n!\nbz lbl\nerr\nlbl:\n", "txn Fee\nbnz lbl\nerr\nlbl:\n"},
+		{"txn Fee\n!\nbnz lbl\nerr\nlbl:\n", "txn Fee\nbz lbl\nerr\nlbl:\n"},
+		{"intcblock 1 0\ntxn Fee\nintc 1\n==\nreturn\n", "intcblock 1 0\ntxn Fee\n!\nreturn\n"},
+		{"intcblock 1 0\ntxn Fee\nintc 0\n==\nreturn\n", "intcblock 1 0\ntxn Fee\nintc 0\n==\nreturn\n"},
+		{"txn Fee\npushint 0\n==\nreturn\n", "txn Fee\n!\nreturn\n"},
+		// rewrites enable each other: == 0 becomes ! and then folded into the branch
+		{"intcblock 0\ntxn Fee\nintc 0\n==\nbz lbl\nerr\nlbl:\n", "intcblock 0\ntxn Fee\nbnz lbl\nerr\nlbl:\n"},
+	}
+	for _, test := range tests {
+		actual := renderInstructions(peephole(splitInstructions(test.source)))
+		a.Equal(test.expected, actual, test.source)
+	}
+
+	// replacements keep source locations and variables of the original instructions
+	program := peephole(splitInstructions("intc 1\n//@source 3 4 prog.tl\n//@var x\nstore 2\nload 2\nload 2\n//@end\n"))
+	a.Len(program, 4)
+	a.Equal("dup", program[1].op)
+	a.Equal(SourceLocation{File: "prog.tl", Line: 3, Column: 4}, program[1].source)
+	a.Equal("x", program[1].variable)
+	// the value still gets to the slot
+	a.Equal("store 2", program[3].text)
+	a.Equal("x", program[3].variable)
+}
+
+func TestPeepholeCompile(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	let a = txn.Fee
+	if a == 0 {
+		return 1
+	}
+	return a
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{OptimizationLevel: 1})
+	a.NoError(err)
+
+	expected := `#pragma version 6
+fun_main:
+txn Fee
+dup
+store 0
+bnz fun_main_if1_end
+pushint 1
+return
+fun_main_if1_end:
+load 0
+return
+end_main:
+`
+	CompareTEAL(a, expected, result.TEAL)
+
+	unoptimized, err := Compile(InputDesc{Source: source}, Options{})
+	a.NoError(err)
+	a.Less(len(result.TEAL), len(unoptimized.TEAL))
+}
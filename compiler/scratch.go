@@ -0,0 +1,189 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Scratch space allocation
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"fmt"
+)
+
+// maxScratchSlots is a number of scratch space slots available to a program
+const maxScratchSlots = 256
+
+// scratchAllocator assigns scratch slots to variables once the AST is built.
+// Slots are handed out like a stack: a variable takes the next free slot when declared
+// and all slots of a scope are released when the scope (block, if-branch, function) ends,
+// so sibling scopes and sequential inline expansions reuse the same slots.
+// Subroutines without own stack frame are placed above slots live at any of their call sites
+type scratchAllocator struct {
+	top     uint
+	current *funDefNode
+	main    *funDefNode
+
+	// bases holds the lowest slot a subroutine can start from
+	bases    map[*funDefNode]uint
+	changed  bool
+	reported map[string]bool
+}
+
+func allocateScratch(root TreeNodeIf) {
+	a := &scratchAllocator{bases: make(map[*funDefNode]uint), reported: make(map[string]bool)}
+	for _, ch := range root.children() {
+		if fun, ok := ch.(*funDefNode); ok && fun.name == mainFuncName {
+			a.main = fun
+		}
+	}
+	a.node(root)
+
+	prog, ok := root.(*programNode)
+	if !ok {
+		return
+	}
+	// global variables stay live in subroutines, and a subroutine base depends on bases of its callers.
+	// Recursion is only allowed for subroutines with stack frames so the bases settle down
+	globals := a.top
+	for {
+		a.changed = false
+		for _, fun := range prog.nonInlineFunc {
+			a.top = globals
+			if base := a.bases[fun]; base > a.top {
+				a.top = base
+			}
+			a.function(fun, nil)
+		}
+		if !a.changed {
+			return
+		}
+	}
+}
+
+// function allocates arguments and then the body of a function.
+// Inline expansion arguments are evaluated after argument slots are taken
+// so that nested inline calls do not overwrite them
+func (a *scratchAllocator) function(n *funDefNode, args []TreeNodeIf) {
+	outer, top := a.current, a.top
+	a.current = n
+	for _, arg := range n.args {
+		a.allocate(n.ctx, arg.n)
+	}
+	for _, ch := range args {
+		a.node(ch)
+	}
+	for _, ch := range n.children() {
+		a.node(ch)
+	}
+	a.current, a.top = outer, top
+}
+
+func (a *scratchAllocator) node(node TreeNodeIf) {
+	if node == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *funDefNode:
+		a.function(n, nil)
+		return
+	case *blockNode:
+		top := a.top
+		for _, ch := range n.children() {
+			a.node(ch)
+		}
+		a.top = top
+		return
+	case *funCallNode:
+		if n.definition != nil && n.definition.inline {
+			a.function(n.definition, n.children())
+			return
+		}
+		if n.definition != nil && a.top > a.bases[n.definition] {
+			a.bases[n.definition] = a.top
+			a.changed = true
+		}
+	case *varDeclNode:
+		a.node(n.value)
+		a.allocate(n.ctx, n.name)
+	case *varDeclTupleNode:
+		a.node(n.value)
+		a.allocate(n.ctx, n.low)
+		a.allocate(n.ctx, n.high)
+	case *varDeclQuadrupleNode:
+		a.node(n.value)
+		a.allocate(n.ctx, n.low)
+		a.allocate(n.ctx, n.high)
+		a.allocate(n.ctx, n.rlow)
+		a.allocate(n.ctx, n.rhigh)
+	case *returnNode:
+		if n.value != nil {
+			a.node(n.value)
+		}
+	case *assignNode:
+		a.node(n.value)
+	case *assignTupleNode:
+		a.node(n.value)
+	case *assignQuadrupleNode:
+		a.node(n.value)
+	case *assignInnerTxnNode:
+		a.node(n.value)
+	case *arrayAssignInnerTxnNode:
+		a.node(n.value)
+	case *ifStatementNode:
+		a.node(n.condExpr)
+	case *forStatementNode:
+		a.node(n.condExpr)
+	case *ifExprNode:
+		a.node(n.condExpr)
+		a.node(n.condTrueExpr)
+		a.node(n.condFalseExpr)
+	case *exprBinOpNode:
+		a.node(n.lhs)
+		a.node(n.rhs)
+	case *exprUnOpNode:
+		a.node(n.value)
+	case *exprGroupNode:
+		a.node(n.value)
+	case *typeCastNode:
+		a.node(n.expr)
+	}
+
+	for _, ch := range node.children() {
+		a.node(ch)
+	}
+}
+
+// allocate assigns the next free slot to a variable declared in the context
+func (a *scratchAllocator) allocate(ctx *context, name string) {
+	info, ok := ctx.vars[name]
+	if !ok || info.onFrame() {
+		return
+	}
+	if a.top >= maxScratchSlots {
+		a.overflow()
+	}
+	info.address = a.top
+	ctx.vars[name] = info
+	a.top++
+}
+
+// overflow reports the function running out of scratch space, once per function
+func (a *scratchAllocator) overflow() {
+	fun := a.current
+	if fun == nil {
+		fun = a.main
+	}
+	if fun == nil || fun.src.parser == nil {
+		return
+	}
+	// main function is named by its source name
+	msg := fmt.Sprintf("function '%s' needs more than %d scratch slots", fun.src.token.GetText(), maxScratchSlots)
+	if a.current == nil {
+		msg = fmt.Sprintf("global variables need more than %d scratch slots", maxScratchSlots)
+	}
+	if a.reported[msg] {
+		return
+	}
+	a.reported[msg] = true
+	reportError(msg, fun.src.parser, fun.src.token, fun.src.rule)
+}
@@ -0,0 +1,91 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScratchReuse(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+let g = 1
+inline function twice(x) { return x * 2; }
+function sum(x, y) { let r = x + y; return r; }
+function logic() {
+	let a = 1
+	if a {
+		let b = 2
+		a = b
+	} else {
+		let c = 3
+		let d = 4
+		a = c + d
+	}
+	let e = twice(a) + twice(g)
+	return sum(e, a)
+}
+`
+	result, errors := Parse(source)
+	a.Empty(errors)
+	a.NotEmpty(result)
+
+	prog := result.(*programNode)
+	a.Equal(uint(0), prog.ctx.vars["g"].address)
+
+	logic := prog.children()[1].(*funDefNode)
+	a.Equal(uint(1), logic.ctx.vars["a"].address)
+	a.Equal(uint(2), logic.ctx.vars["e"].address)
+
+	// branches reuse the same slots
+	ifStmt := logic.children()[0].children()[1].(*ifStatementNode)
+	trueBlock := ifStmt.children()[0].(*blockNode)
+	falseBlock := ifStmt.children()[1].(*blockNode)
+	a.Equal(uint(2), trueBlock.ctx.vars["b"].address)
+	a.Equal(uint(2), falseBlock.ctx.vars["c"].address)
+	a.Equal(uint(3), falseBlock.ctx.vars["d"].address)
+
+	// as well as sequential inline expansions
+	value := logic.children()[0].children()[2].(*varDeclNode).value.(*exprBinOpNode)
+	a.Equal(uint(2), value.lhs.(*funCallNode).definition.ctx.vars["x"].address)
+	a.Equal(uint(2), value.rhs.(*funCallNode).definition.ctx.vars["x"].address)
+
+	// subroutine goes above slots live at the call site
+	sum := prog.nonInlineFunc[0]
+	a.Equal(uint(3), sum.ctx.vars["x"].address)
+	a.Equal(uint(4), sum.ctx.vars["y"].address)
+	a.Equal(uint(5), sum.ctx.vars["r"].address)
+}
+
+func TestScratchLimit(t *testing.T) {
+	a := require.New(t)
+
+	declare := func(prefix string, count int) string {
+		var sb strings.Builder
+		for i := 0; i < count; i++ {
+			fmt.Fprintf(&sb, "\tlet %s%d = %d\n", prefix, i, i)
+		}
+		return sb.String()
+	}
+
+	// 300 variables fit since each branch releases its slots
+	source := fmt.Sprintf("function logic() {\n\tif 1 {\n%s\t} else {\n%s\t}\n\treturn 1\n}\n", declare("a", 150), declare("b", 150))
+	_, errors := Parse(source)
+	a.Empty(errors)
+
+	source = fmt.Sprintf("function logic() {\n%s\treturn 1\n}\n", declare("a", 257))
+	_, errors = Parse(source)
+	a.Len(errors, 1)
+	a.Equal(1, errors[0].line)
+	a.Contains(errors[0].msg, "function 'logic' needs more than 256 scratch slots")
+
+	source = fmt.Sprintf("function big() {\n%s\treturn 1\n}\nfunction logic() {\n\tlet a = 1\n\treturn big()\n}\n", declare("a", 256))
+	_, errors = Parse(source)
+	a.Len(errors, 1)
+	a.Equal(1, errors[0].line)
+	a.Equal(9, errors[0].column)
+	a.Contains(errors[0].msg, "function 'big' needs more than 256 scratch slots")
+}
@@ -0,0 +1,79 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Source map encoding
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// sourceMapJSON is a source map v3 as used by TEAL debuggers:
+// every bytecode offset is a "line" of the generated program
+type sourceMapJSON struct {
+	Version  int      `json:"version"`
+	Sources  []string `json:"sources"`
+	Names    []string `json:"names"`
+	Mappings string   `json:"mappings"`
+}
+
+const base64VLQChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// encodeVLQ appends base64 VLQ encoded value as defined by source map v3
+func encodeVLQ(sb *strings.Builder, value int) {
+	vlq := value << 1
+	if value < 0 {
+		vlq = (-value << 1) | 1
+	}
+	for {
+		digit := vlq & 0x1f
+		vlq >>= 5
+		if vlq > 0 {
+			digit |= 0x20
+		}
+		sb.WriteByte(base64VLQChars[digit])
+		if vlq == 0 {
+			return
+		}
+	}
+}
+
+// SourceMapJSON encodes the source map of assembled program in source map v3 format
+// mapping every bytecode offset to tealang file, line and column
+func (r *Result) SourceMapJSON() ([]byte, error) {
+	if r.Bytecode == nil {
+		return nil, fmt.Errorf("source map requires assembled program")
+	}
+
+	sm := sourceMapJSON{Version: 3, Sources: []string{}, Names: []string{}}
+	sources := make(map[string]int)
+	var sb strings.Builder
+	prevSource, prevLine, prevColumn := 0, 0, 0
+	for pc := 0; pc < len(r.Bytecode); pc++ {
+		if pc > 0 {
+			sb.WriteByte(';')
+		}
+		loc, ok := r.SourceMap.Location(pc)
+		if !ok {
+			continue
+		}
+		idx, ok := sources[loc.File]
+		if !ok {
+			idx = len(sm.Sources)
+			sources[loc.File] = idx
+			sm.Sources = append(sm.Sources, loc.File)
+		}
+		// generated column, then source index, line and column relative to the previous segment
+		encodeVLQ(&sb, 0)
+		encodeVLQ(&sb, idx-prevSource)
+		encodeVLQ(&sb, loc.Line-1-prevLine)
+		encodeVLQ(&sb, loc.Column-prevColumn)
+		prevSource, prevLine, prevColumn = idx, loc.Line-1, loc.Column
+	}
+	sm.Mappings = sb.String()
+	return json.Marshal(sm)
+}
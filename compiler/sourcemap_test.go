@@ -0,0 +1,297 @@
+package compiler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceMapLocations(t *testing.T) {
+	a := require.New(t)
+
+	source := `import mymodule
+function logic() {
+	let a = 1
+	return test() + a
+}
+`
+	resolver := func(moduleName string, sourceDir string, currentDir string) (InputDesc, error) {
+		return InputDesc{Source: "function test() {\n\treturn 2\n}\n", SourceFile: "mymodule.tl"}, nil
+	}
+	result, err := Compile(InputDesc{Source: source, SourceFile: "main.tl"}, Options{Resolver: resolver, Assemble: true})
+	a.NoError(err)
+
+	lines := strings.Split(result.TEAL, "\n")
+	locations := make(map[string]SourceLocation)
+	for line, loc := range result.SourceMap.LineToLocation {
+		locations[lines[line]] = loc
+	}
+	a.Equal(SourceLocation{"main.tl", 3, 1}, locations["store 0"])
+	// calls and operators point at the subexpression, other instructions at the statement
+	a.Equal(SourceLocation{"main.tl", 4, 8}, locations["callsub fun_test"])
+	a.Equal(SourceLocation{"main.tl", 4, 15}, locations["+"])
+	a.Equal(SourceLocation{"main.tl", 4, 1}, locations["return"])
+	a.Equal(SourceLocation{"mymodule.tl", 2, 1}, locations["pushint 2"])
+	a.Equal("main.tl:3:1", locations["store 0"].String())
+	a.Equal("3:1", SourceLocation{Line: 3, Column: 1}.String())
+
+	// every offset of an instruction generated for a statement is located
+	located := 0
+	for pc := range result.SourceMap.OffsetToLine {
+		if loc, ok := result.SourceMap.Location(pc); ok {
+			a.NotEmpty(loc.File)
+			located++
+		}
+	}
+	a.NotZero(located)
+	_, ok := result.SourceMap.Location(len(result.Bytecode) + 1)
+	a.False(ok)
+}
+
+func TestSourceMapExpressions(t *testing.T) {
+	a := require.New(t)
+
+	source := `function logic() {
+	let a = txn.Fee + global.MinTxnFee
+	return a > 0 && !(a == 1)
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{Assemble: true})
+	a.NoError(err)
+
+	lines := strings.Split(result.TEAL, "\n")
+	locations := make(map[string]SourceLocation)
+	for line, loc := range result.SourceMap.LineToLocation {
+		locations[lines[line]] = loc
+	}
+	a.Equal(SourceLocation{Line: 2, Column: 9}, locations["txn Fee"])
+	a.Equal(SourceLocation{Line: 2, Column: 19}, locations["global MinTxnFee"])
+	a.Equal(SourceLocation{Line: 2, Column: 17}, locations["+"])
+	a.Equal(SourceLocation{Line: 2, Column: 1}, locations["store 0"])
+	a.Equal(SourceLocation{Line: 3, Column: 10}, locations[">"])
+	a.Equal(SourceLocation{Line: 3, Column: 21}, locations["=="])
+	a.Equal(SourceLocation{Line: 3, Column: 17}, locations["!"])
+	a.Equal(SourceLocation{Line: 3, Column: 14}, locations["&&"])
+
+	// statements enclosing subexpressions are kept for stepping
+	for line, loc := range result.SourceMap.LineToLocation {
+		a.Equal(loc.Line, result.SourceMap.LineToStatement[line].Line)
+		if lines[line] == "+" || lines[line] == "==" {
+			a.Equal(1, result.SourceMap.LineToStatement[line].Column)
+		}
+	}
+}
+
+func TestSourceMapOptimized(t *testing.T) {
+	a := require.New(t)
+
+	source := `function logic() {
+	let a = 1 + 2
+	if a == 3 {
+		a = a * 1
+	}
+	return a
+}
+`
+	result, err := Compile(InputDesc{Source: source, SourceFile: "opt.tl"}, Options{OptimizationLevel: 1, Assemble: true})
+	a.NoError(err)
+
+	// folded and rewritten instructions keep their statement locations
+	lines := strings.Split(result.TEAL, "\n")
+	for line, loc := range result.SourceMap.LineToLocation {
+		a.NotEqual("", strings.TrimSpace(lines[line]))
+		a.False(strings.HasPrefix(lines[line], sourceMarker))
+		a.Equal("opt.tl", loc.File)
+	}
+	a.NotContains(result.TEAL, sourceMarker)
+	a.NotContains(result.TEAL, sourceEndMarker)
+}
+
+func TestSourceMapVariables(t *testing.T) {
+	a := require.New(t)
+
+	source := `const c = 5
+function double(x) {
+	return x * 2
+}
+function logic() {
+	let a = double(c)
+	let b = a + 1
+	return b
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{Assemble: true})
+	a.NoError(err)
+	a.NotContains(result.TEAL, variableMarker)
+
+	// slots are reused by variables of different functions
+	lines := strings.Split(result.TEAL, "\n")
+	variables := make([]string, 0, len(result.SourceMap.LineToVariable))
+	for line := range lines {
+		if name, ok := result.SourceMap.LineToVariable[line]; ok {
+			variables = append(variables, lines[line]+" "+name)
+		}
+	}
+	a.Equal([]string{"store 0 a", "load 0 a", "store 1 b", "load 1 b", "store 0 x", "load 0 x"}, variables)
+
+	named := 0
+	for pc := range result.SourceMap.OffsetToLine {
+		if _, ok := result.SourceMap.Variable(pc); ok {
+			named++
+		}
+	}
+	a.Equal(len(variables), named)
+}
+
+func TestSourceMapJSON(t *testing.T) {
+	a := require.New(t)
+
+	source := `function logic() {
+	let a = 1
+	return a
+}
+`
+	result, err := Compile(InputDesc{Source: source, SourceFile: "test.tl"}, Options{})
+	a.NoError(err)
+	_, err = result.SourceMapJSON()
+	a.Error(err)
+
+	result, err = Compile(InputDesc{Source: source, SourceFile: "test.tl"}, Options{Assemble: true})
+	a.NoError(err)
+	data, err := result.SourceMapJSON()
+	a.NoError(err)
+
+	var sm sourceMapJSON
+	a.NoError(json.Unmarshal(data, &sm))
+	a.Equal(3, sm.Version)
+	a.Equal([]string{"test.tl"}, sm.Sources)
+	// one segment group per bytecode offset
+	segments := strings.Split(sm.Mappings, ";")
+	a.Len(segments, len(result.Bytecode))
+	a.Empty(segments[0])
+	// pushint 1 at offset 1 maps to line 2 (zero-based 1), column 1
+	a.Equal("AACC", segments[1])
+
+	var sb strings.Builder
+	for _, v := range []int{0, 1, -1, 15, 16, -16, 1000} {
+		encodeVLQ(&sb, v)
+		sb.WriteByte(',')
+	}
+	a.Equal("A,C,D,e,gB,hB,w+B,", sb.String())
+}
+
+func TestSourceMapFunctions(t *testing.T) {
+	a := require.New(t)
+
+	source := `inline function inc(x) {
+	return x + 1
+}
+function double(x) {
+	return x * 2
+}
+function logic() {
+	return double(inc(txn.Fee)) > 0
+}
+`
+	result, err := Compile(InputDesc{Source: source}, Options{Assemble: true})
+	a.NoError(err)
+	a.NotContains(result.TEAL, functionMarker)
+	a.NotContains(result.TEAL, inlineMarker)
+	a.NotContains(result.TEAL, functionEndMarker)
+
+	lines := strings.Split(result.TEAL, "\n")
+	functions := make(map[string]FunctionRef)
+	for line, ref := range result.SourceMap.LineToFunction {
+		functions[strings.TrimSpace(lines[line])] = ref
+	}
+	a.Equal(FunctionRef{Name: "logic"}, functions["txn Fee"])
+	a.Equal(FunctionRef{Name: "inc", Inline: true, Expansion: 1}, functions["+"])
+	a.Equal(FunctionRef{Name: "logic"}, functions["callsub fun_double"])
+	a.Equal(FunctionRef{Name: "double"}, functions["*"])
+	a.Equal(FunctionRef{Name: "double"}, functions["retsub"])
+
+	for pc := range result.SourceMap.OffsetToLine {
+		_, ok := result.SourceMap.Function(pc)
+		a.True(ok)
+	}
+}
+
+func TestSourceMapScopes(t *testing.T) {
+	a := require.New(t)
+
+	source := `let g = 1
+inline function inc(x) {
+	let t = x + 1
+	return t
+}
+function double(y) {
+	let d = y * 2
+	return d
+}
+function logic() {
+	let a = double(g)
+	if a > 0 {
+		let b = inc(a)
+		a = b
+	}
+	return a
+}
+`
+	result, err := Compile(InputDesc{Source: source, SourceFile: "main.tl"}, Options{Assemble: true})
+	a.NoError(err)
+
+	// pc of the first instruction with the given text following the label
+	lines := strings.Split(result.TEAL, "\n")
+	pcOf := func(label, text string) int {
+		found := -1
+		for pc, line := range result.SourceMap.OffsetToLine {
+			for i := line - 1; i >= 0; i-- {
+				if lines[i] == label {
+					if lines[line] == text && (found == -1 || pc < found) {
+						found = pc
+					}
+					break
+				}
+			}
+		}
+		a.NotEqual(-1, found, text)
+		return found
+	}
+	slot := func(name string, pc int) int {
+		slot, ok := result.SourceMap.Slot(name, pc)
+		if !ok {
+			return -1
+		}
+		return slot
+	}
+
+	// inline expansion sees its own locals, the enclosing block and globals
+	pc := pcOf("fun_main:", "store 3")
+	a.Equal(3, slot("t", pc))
+	a.Equal(2, slot("x", pc))
+	a.Equal(2, slot("b", pc))
+	a.Equal(1, slot("a", pc))
+	a.Equal(0, slot("g", pc))
+
+	// inline locals are gone after the expansion, block locals are still there
+	pc = pcOf("fun_inc_inline1_end:", "store 1")
+	a.Equal(-1, slot("t", pc))
+	a.Equal(2, slot("b", pc))
+
+	// block locals are gone after the block
+	pc = pcOf("fun_main_if1_end:", "return")
+	a.Equal(-1, slot("b", pc))
+	a.Equal(1, slot("a", pc))
+
+	// function scope does not see the caller's locals
+	pc = pcOf("fun_double:", "*")
+	a.Equal(2, slot("d", pc))
+	a.Equal(1, slot("y", pc))
+	a.Equal(-1, slot("a", pc))
+	a.Equal(0, slot("g", pc))
+
+	a.True(result.SourceMap.Declared("t"))
+	a.False(result.SourceMap.Declared("missing"))
+}
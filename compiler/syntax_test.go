@@ -393,3 +393,127 @@ function logic() {
 break is not inside for block`
 	a.Equal(msg, errors[0].String())
 }
+
+func TestContinueError(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	if 1 {
+		continue
+	}
+	return 0
+}
+`
+	result, errors := Parse(source)
+	a.Empty(result)
+	a.NotEmpty(errors)
+	a.Equal(1, len(errors))
+	msg := `error at line 4, col 2 near token 'continue'
+        continue
+   -----^-----
+continue is not inside for block`
+	a.Equal(msg, errors[0].String())
+}
+
+func TestLoopLabelError(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	outer: for 1 {
+		break inner
+	}
+	return 0
+}
+`
+	result, errors := Parse(source)
+	a.Empty(result)
+	a.NotEmpty(errors)
+	a.Equal(1, len(errors))
+	a.Contains(errors[0].String(), "loop label 'inner' not defined")
+
+	source = `
+function logic() {
+	outer: for 1 {
+		outer: for 1 {
+			break outer
+		}
+	}
+	return 0
+}
+`
+	result, errors = Parse(source)
+	a.Empty(result)
+	a.NotEmpty(errors)
+	a.Equal(1, len(errors))
+	a.Contains(errors[0].String(), "loop label 'outer' already defined")
+
+	source = `
+inline function test() {
+	continue outer
+	return 1
+}
+function logic() {
+	outer: for 1 {
+		let x = test()
+	}
+	return 0
+}
+`
+	result, errors = Parse(source)
+	a.Empty(result)
+	a.NotEmpty(errors)
+	a.Contains(errors[0].String(), "loop label 'outer' not defined")
+}
+
+func TestRecursionError(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function fact(n) {
+	if n <= 1 { return 1; }
+	return n * fact(n - 1)
+}
+function logic() {
+	return fact(5)
+}
+`
+	result, errors := Parse(source)
+	a.Empty(result)
+	a.NotEmpty(errors)
+	a.Contains(errors[0].String(), "recursive call of 'fact' requires TEAL v8")
+
+	// functions of the recursion cycle are not analysed further
+	source = `
+function even(n) {
+	if n == 0 { return 1; }
+	return odd(n - 1)
+}
+function odd(n) {
+	if n == 0 { return 0; }
+	return even(n - 1)
+}
+function logic() {
+	return even(5)
+}
+`
+	result, errors = Parse(source)
+	a.Empty(result)
+	a.Len(errors, 1)
+	a.Contains(errors[0].String(), "recursive call of 'even' requires TEAL v8")
+
+	source = `
+inline function fact(n) {
+	if n <= 1 { return 1; }
+	return n * fact(n - 1)
+}
+function logic() {
+	return fact(5)
+}
+`
+	result, errors = Parse(source)
+	a.Empty(result)
+	a.NotEmpty(errors)
+	a.Contains(errors[0].String(), "inline function 'fact' cannot be recursive")
+}
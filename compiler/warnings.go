@@ -0,0 +1,92 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Warnings
+//
+//--------------------------------------------------------------------------------------------------
+
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+
+	gen "github.com/pzbitskiy/tealang/gen/go"
+)
+
+// Warning codes are stable and can be used in "// tealang:ignore" comments
+const (
+	unusedVariableWarning    = "TL1001"
+	unusedFunctionWarning    = "TL1002"
+	shadowedNameWarning      = "TL1003"
+	unreachableCodeWarning   = "TL1004"
+	constantConditionWarning = "TL1005"
+)
+
+// declareVar checks a new variable for shadowing and registers it for unused variables check and editor features
+func declareVar(ctx *context, ident antlr.TerminalNode, parser antlr.Parser, rule antlr.RuleContext) {
+	name := ident.GetText()
+	if ctx.shadowed(name) {
+		reportWarning(
+			shadowedNameWarning, fmt.Sprintf("variable '%s' shadows a declaration in an outer scope", name),
+			parser, ident.GetSymbol(), rule,
+		)
+	}
+	ctx.declare(name, srcRef{parser, ident.GetSymbol(), rule})
+	ctx.declareSymbol(name, variableSymbol, "", parser, ident.GetSymbol())
+}
+
+// checkCondition warns about if and for statements with literal or constant conditions
+func checkCondition(statement string, cond ExprNodeIf, parser antlr.Parser, token antlr.Token, rule antlr.RuleContext) {
+	for {
+		group, ok := cond.(*exprGroupNode)
+		if !ok {
+			break
+		}
+		cond = group.value
+	}
+	value, ok := intValue(cond)
+	if !ok {
+		return
+	}
+	msg := fmt.Sprintf("%s condition is always true", statement)
+	if value == 0 {
+		msg = fmt.Sprintf("%s condition is always false", statement)
+	}
+	reportWarning(constantConditionWarning, msg, parser, token, rule)
+}
+
+// checkUnused walks scopes and warns about variables and functions never read.
+// Function bodies are only parsed when called so unused functions do not produce other warnings.
+// Names starting with underscore are exempt, this is handy for parts of tuples
+func checkUnused(ctx *context) {
+	names := make([]string, 0, len(ctx.declarations))
+	for name := range ctx.declarations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ctx.reads[name] || strings.HasPrefix(name, "_") {
+			continue
+		}
+		src := ctx.declarations[name]
+		info, ok := ctx.vars[name]
+		if !ok {
+			continue
+		}
+		if decl, ok := src.rule.(*gen.DeclarationContext); ok && decl.TestModifier() != nil {
+			// test functions are called by the test runner
+			continue
+		}
+		if info.function() {
+			reportWarning(unusedFunctionWarning, fmt.Sprintf("function '%s' is never called", name), src.parser, src.token, src.rule)
+		} else {
+			reportWarning(unusedVariableWarning, fmt.Sprintf("variable '%s' declared but not used", name), src.parser, src.token, src.rule)
+		}
+	}
+	for _, scope := range ctx.scopes {
+		checkUnused(scope)
+	}
+}
@@ -0,0 +1,171 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func compileWarnings(t *testing.T, source string) []ParserError {
+	result, err := Compile(InputDesc{Source: source, SourceFile: "test.tl"}, Options{})
+	require.NoError(t, err)
+	return result.Diagnostics
+}
+
+func TestWarnings(t *testing.T) {
+	a := require.New(t)
+
+	tests := []struct {
+		source string
+		code   string
+		line   int
+		column int
+		msg    string
+	}{
+		{"function logic() {\n\tlet a = 1\n\treturn 1\n}\n", "TL1001", 2, 5, "variable 'a' declared but not used"},
+		{"let g = 1\nfunction logic() {\n\treturn 1\n}\n", "TL1001", 1, 4, "variable 'g' declared but not used"},
+		{"function logic() {\n\tlet a, b = mulw(1, 2)\n\treturn a\n}\n", "TL1001", 2, 8, "variable 'b' declared but not used"},
+		{"function f() { return 1; }\nfunction logic() {\n\treturn 1\n}\n", "TL1002", 1, 9, "function 'f' is never called"},
+		{"inline function f() { return 1; }\nfunction logic() {\n\treturn 1\n}\n", "TL1002", 1, 16, "function 'f' is never called"},
+		{"let a = 1\nfunction logic() {\n\tlet b = a\n\tif b {\n\t\tlet a = 2\n\t\treturn a\n\t}\n\treturn b\n}\n", "TL1003", 5, 6, "variable 'a' shadows a declaration in an outer scope"},
+		{"function logic() {\n\tlet a = 1\n\tif a {\n\t\tlet a = 2\n\t\treturn a\n\t}\n\treturn a\n}\n", "TL1003", 4, 6, "variable 'a' shadows a declaration in an outer scope"},
+		{"function logic() {\n\treturn 1\n\tlet a = 1\n\treturn a\n}\n", "TL1004", 3, 1, "unreachable code"},
+		{"function logic() {\n\tif txn.Fee > 1 {\n\t\terror\n\t\treturn 0\n\t}\n\treturn 1\n}\n", "TL1004", 4, 2, "unreachable code"},
+		{"function logic() {\n\tif 1 {\n\t\treturn 0\n\t}\n\treturn 1\n}\n", "TL1005", 2, 4, "if condition is always true"},
+		{"const zero = 0\nfunction logic() {\n\tfor (zero) {\n\t}\n\treturn 1\n}\n", "TL1005", 3, 5, "for condition is always false"},
+	}
+	for _, test := range tests {
+		diagnostics := compileWarnings(t, test.source)
+		a.Len(diagnostics, 1, test.source)
+		w := diagnostics[0]
+		a.Equal("warning", w.Severity(), test.source)
+		a.Equal(test.code, w.Code(), test.source)
+		a.Equal(test.line, w.Line(), test.source)
+		a.Equal(test.column, w.Column(), test.source)
+		a.Equal(test.msg, w.Message(), test.source)
+		a.Contains(w.String(), "warning at test.tl")
+		a.Contains(w.String(), "["+test.code+"]")
+	}
+}
+
+func TestNoWarnings(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+let g = 1
+const c = 2
+inline function twice(x) { return x * 2; }
+function sum(x, y) { return x + y; }
+function logic() {
+	let a = twice(g)
+	let _, low = mulw(a, c)
+	if a > 1 {
+		let b = sum(a, low)
+		return b
+	} else {
+		let b = 3
+		a = b
+	}
+	return a
+}
+`
+	a.Empty(compileWarnings(t, source))
+
+	// inline function arguments and call site variables share names without shadowing
+	source = `
+inline function twice(x) { let a = x * 2; return a; }
+function logic() {
+	let a = 1
+	let x = twice(a)
+	return twice(x)
+}
+`
+	a.Empty(compileWarnings(t, source))
+
+	// test functions are called by the test runner
+	source = `
+test function check() {
+	return 1
+}
+function logic() {
+	return 1
+}
+`
+	a.Empty(compileWarnings(t, source))
+
+	// module functions are not reported
+	resolver := func(moduleName string, sourceDir string, currentDir string) (InputDesc, error) {
+		return InputDesc{Source: "function unused() { return 1; }\nfunction used() { let _a = 1; return 2; }\n", SourceFile: moduleName}, nil
+	}
+	result, err := Compile(InputDesc{Source: "import mymodule\nfunction logic() {\n\treturn used()\n}\n"}, Options{Resolver: resolver})
+	a.NoError(err)
+	a.Empty(result.Diagnostics)
+}
+
+func TestWarningsModules(t *testing.T) {
+	a := require.New(t)
+
+	// warnings in bodies of module functions are reported with the module file and fail --Werror
+	resolver := func(moduleName string, sourceDir string, currentDir string) (InputDesc, error) {
+		return InputDesc{Source: "function used() {\n\tlet a = 1\n\treturn 2\n}\n", SourceFile: moduleName + ".tl"}, nil
+	}
+	input := InputDesc{Source: "import mymodule\nfunction logic() {\n\tlet b = 1\n\treturn used()\n}\n", SourceFile: "main.tl"}
+	result, err := Compile(input, Options{Resolver: resolver})
+	a.NoError(err)
+	a.Len(result.Diagnostics, 2)
+	a.Equal("main.tl", result.Diagnostics[0].filename)
+	a.Equal("mymodule.tl", result.Diagnostics[1].filename)
+	a.Equal(unusedVariableWarning, result.Diagnostics[1].code)
+	a.Equal(2, result.Diagnostics[1].line)
+
+	_, err = Compile(input, Options{Resolver: resolver, WarningsAsErrors: true})
+	a.Error(err)
+	a.Contains(err.Error(), "variable 'a' declared but not used")
+}
+
+func TestWarningsIgnore(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	let a = 1 // tealang:ignore TL1001
+	// tealang:ignore TL1003, TL1001
+	let b = 1
+	let c = 1 // tealang:ignore
+	let d = 1 // tealang:ignore TL1003
+	if 1 { // tealang:ignore TL1004 TL1005
+		return 1
+	}
+	return 0
+}
+`
+	diagnostics := compileWarnings(t, source)
+	a.Len(diagnostics, 1)
+	a.Equal("TL1001", diagnostics[0].Code())
+	a.Equal(7, diagnostics[0].Line())
+
+	// directive above applies to the next line only
+	source = "// tealang:ignore\nfunction logic() {\n\tlet a = 1\n\treturn 1\n}\n"
+	a.Len(compileWarnings(t, source), 1)
+}
+
+func TestWarningsAsErrors(t *testing.T) {
+	a := require.New(t)
+
+	source := "function logic() {\n\tlet a = 1\n\treturn 1\n}\n"
+	result, err := Compile(InputDesc{Source: source}, Options{WarningsAsErrors: true})
+	a.Error(err)
+	a.Empty(result.TEAL)
+	a.Len(result.Diagnostics, 1)
+	a.Equal("error", result.Diagnostics[0].Severity())
+	a.Equal("TL1001", result.Diagnostics[0].Code())
+	a.Contains(err.Error(), "error at line 2, col 5 near token 'a'")
+	a.Contains(err.Error(), "variable 'a' declared but not used [TL1001]")
+
+	// warnings follow errors
+	source = "function logic() {\n\tlet a = 1\n\treturn b\n}\n"
+	result, err = Compile(InputDesc{Source: source}, Options{})
+	a.Error(err)
+	a.NotEmpty(result.Diagnostics)
+	a.Equal("error", result.Diagnostics[0].Severity())
+}
@@ -0,0 +1,366 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Line and branch coverage of dryrun executions
+//
+//--------------------------------------------------------------------------------------------------
+
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/algorand/go-algorand/data/transactions/logic"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/stdlib"
+)
+
+// Profile is line and branch coverage of tealang sources accumulated over dryrun executions
+type Profile struct {
+	// Files are keyed by source path
+	Files map[string]*FileCoverage `json:"files"`
+}
+
+// FileCoverage is coverage of a single source file
+type FileCoverage struct {
+	// Lines maps 1-based lines with code to execution counts, zero for not executed lines
+	Lines    map[int]int `json:"lines"`
+	Branches []*Branch   `json:"branches,omitempty"`
+}
+
+// Branch is a conditional jump of if, else or for statement.
+// Taken counts the arm executed when the condition holds and the one executed otherwise
+type Branch struct {
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Taken  [2]int `json:"taken"`
+}
+
+// NewProfile creates an empty profile
+func NewProfile() *Profile {
+	return &Profile{Files: make(map[string]*FileCoverage)}
+}
+
+// ReadProfile loads profile written by WriteFile
+func ReadProfile(file string) (*Profile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := NewProfile()
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err.Error())
+	}
+	if p.Files == nil {
+		p.Files = make(map[string]*FileCoverage)
+	}
+	return p, nil
+}
+
+// WriteFile saves the profile as JSON
+func (p *Profile) WriteFile(file string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
+
+func (p *Profile) file(path string) *FileCoverage {
+	fc, ok := p.Files[path]
+	if !ok {
+		fc = &FileCoverage{Lines: make(map[int]int)}
+		p.Files[path] = fc
+	}
+	return fc
+}
+
+// Merge adds counts of other profile
+func (p *Profile) Merge(other *Profile) {
+	for path, ofc := range other.Files {
+		fc := p.file(path)
+		for line, count := range ofc.Lines {
+			fc.Lines[line] += count
+		}
+		for _, ob := range ofc.Branches {
+			b := fc.branch(ob.Line, ob.Column)
+			b.Taken[0] += ob.Taken[0]
+			b.Taken[1] += ob.Taken[1]
+		}
+	}
+}
+
+func (fc *FileCoverage) branch(line, column int) *Branch {
+	for _, b := range fc.Branches {
+		if b.Line == line && b.Column == column {
+			return b
+		}
+	}
+	b := &Branch{Line: line, Column: column}
+	fc.Branches = append(fc.Branches, b)
+	sort.Slice(fc.Branches, func(i, j int) bool {
+		bi, bj := fc.Branches[i], fc.Branches[j]
+		return bi.Line < bj.Line || bi.Line == bj.Line && bi.Column < bj.Column
+	})
+	return b
+}
+
+// lineBranches returns branches of the line ordered by column
+func (fc *FileCoverage) lineBranches(line int) []*Branch {
+	var result []*Branch
+	for _, b := range fc.Branches {
+		if b.Line == line {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// Paths returns sorted source paths of the profile
+func (p *Profile) Paths() []string {
+	paths := make([]string, 0, len(p.Files))
+	for path := range p.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Resolver returns a function locating sources by file names used in source locations.
+// Modules are searched next to the main file and in the current directory like the compiler does,
+// standard modules are not covered and resolve to empty path
+func Resolver(mainFile string, currentDir string) func(name string) string {
+	dir := filepath.Dir(mainFile)
+	return func(name string) string {
+		if name == "" || strings.HasPrefix(name, stdlib.StdLibName+".") {
+			return ""
+		}
+		candidate := name
+		if name == filepath.Base(mainFile) {
+			candidate = mainFile
+		} else {
+			for _, d := range []string{dir, currentDir} {
+				if _, err := os.Stat(filepath.Join(d, name)); err == nil {
+					candidate = filepath.Join(d, name)
+					break
+				}
+			}
+		}
+		if abs, err := filepath.Abs(candidate); err == nil {
+			return abs
+		}
+		return candidate
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Collection
+//
+//--------------------------------------------------------------------------------------------------
+
+// Collector is a debugger hook recording executed instructions and conditional jumps of a program
+type Collector struct {
+	sourceMap compiler.SourceMap
+	pcs       []int          // offsets of all instructions
+	ops       map[int]string // opcodes by offset
+	next      map[int]int    // offset of the following instruction
+
+	hits  map[int]int
+	jumps map[int]int
+	prev  int
+}
+
+// NewCollector creates a collector for the compiled program
+func NewCollector(result *compiler.Result) *Collector {
+	c := &Collector{
+		sourceMap: result.SourceMap,
+		ops:       make(map[int]string),
+		next:      make(map[int]int),
+		hits:      make(map[int]int),
+		jumps:     make(map[int]int),
+		prev:      -1,
+	}
+	lines := strings.Split(result.TEAL, "\n")
+	for pc, line := range result.SourceMap.OffsetToLine {
+		c.pcs = append(c.pcs, pc)
+		if line < len(lines) {
+			if fields := strings.Fields(lines[line]); len(fields) > 0 {
+				c.ops[pc] = fields[0]
+			}
+		}
+	}
+	sort.Ints(c.pcs)
+	for i := 0; i+1 < len(c.pcs); i++ {
+		c.next[c.pcs[i]] = c.pcs[i+1]
+	}
+	return c
+}
+
+func isBranch(op string) bool {
+	return op == "bz" || op == "bnz"
+}
+
+// Register is fired on program start
+func (c *Collector) Register(state *logic.DebugState) error {
+	c.prev = -1
+	return nil
+}
+
+// Update is fired before every step, a conditional jump is taken if the step does not follow it
+func (c *Collector) Update(state *logic.DebugState) error {
+	if c.prev >= 0 && isBranch(c.ops[c.prev]) && state.PC != c.next[c.prev] {
+		c.jumps[c.prev]++
+	}
+	c.hits[state.PC]++
+	c.prev = state.PC
+	return nil
+}
+
+// Complete is called when the program exits
+func (c *Collector) Complete(state *logic.DebugState) error {
+	return nil
+}
+
+// AddTo adds collected counts to the profile, files resolved to empty path are skipped.
+// Lines count executions of their most executed instruction within every inline expansion,
+// so a line of an inline function expanded at several calls sums hits of all copies
+func (c *Collector) AddTo(p *Profile, resolve func(name string) string) {
+	type expandedLine struct {
+		path      string
+		line      int
+		expansion int
+	}
+	run := NewProfile()
+	lineHits := make(map[expandedLine]int)
+	for _, pc := range c.pcs {
+		loc, ok := c.sourceMap.Location(pc)
+		if !ok {
+			continue
+		}
+		path := resolve(loc.File)
+		if path == "" {
+			continue
+		}
+		fc := run.file(path)
+		ref, _ := c.sourceMap.Function(pc)
+		key := expandedLine{path, loc.Line, ref.Expansion}
+		hits := c.hits[pc]
+		if prev, ok := lineHits[key]; !ok || hits > prev {
+			lineHits[key] = hits
+		}
+		if op := c.ops[pc]; isBranch(op) {
+			b := fc.branch(loc.Line, loc.Column)
+			jumped, fell := c.jumps[pc], hits-c.jumps[pc]
+			// bz jumps when the condition does not hold, bnz when it does
+			if op == "bz" {
+				b.Taken[0] += fell
+				b.Taken[1] += jumped
+			} else {
+				b.Taken[0] += jumped
+				b.Taken[1] += fell
+			}
+		}
+	}
+	for key, hits := range lineHits {
+		run.Files[key.path].Lines[key.line] += hits
+	}
+	p.Merge(run)
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Reports
+//
+//--------------------------------------------------------------------------------------------------
+
+// Summary is a number of covered lines and branch arms of a file
+type Summary struct {
+	Lines, LinesHit       int
+	Branches, BranchesHit int
+}
+
+// Summary counts covered lines and branch arms of the file
+func (fc *FileCoverage) Summary() (s Summary) {
+	for _, count := range fc.Lines {
+		s.Lines++
+		if count > 0 {
+			s.LinesHit++
+		}
+	}
+	for _, b := range fc.Branches {
+		for _, taken := range b.Taken {
+			s.Branches++
+			if taken > 0 {
+				s.BranchesHit++
+			}
+		}
+	}
+	return
+}
+
+func percent(hit, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(hit)*100/float64(total))
+}
+
+// WriteSummary prints covered lines and branches per file
+func (p *Profile) WriteSummary(w io.Writer) {
+	for _, path := range p.Paths() {
+		s := p.Files[path].Summary()
+		fmt.Fprintf(w, "%s: lines %d/%d %s, branches %d/%d %s\n", path,
+			s.LinesHit, s.Lines, percent(s.LinesHit, s.Lines),
+			s.BranchesHit, s.Branches, percent(s.BranchesHit, s.Branches))
+	}
+}
+
+func sortedLines(fc *FileCoverage) []int {
+	lines := make([]int, 0, len(fc.Lines))
+	for line := range fc.Lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// WriteLCOV writes the profile in LCOV tracefile format.
+// Branches of a line are numbered as blocks by column, arm 0 is taken when the condition holds
+func (p *Profile) WriteLCOV(w io.Writer) error {
+	var sb strings.Builder
+	for _, path := range p.Paths() {
+		fc := p.Files[path]
+		fmt.Fprintf(&sb, "TN:\nSF:%s\n", path)
+		block, prevLine := 0, 0
+		for _, b := range fc.Branches {
+			if b.Line != prevLine {
+				block, prevLine = 0, b.Line
+			}
+			for arm, taken := range b.Taken {
+				count := fmt.Sprint(taken)
+				if fc.Lines[b.Line] == 0 {
+					// the line was not executed
+					count = "-"
+				}
+				fmt.Fprintf(&sb, "BRDA:%d,%d,%d,%s\n", b.Line, block, arm, count)
+			}
+			block++
+		}
+		s := fc.Summary()
+		fmt.Fprintf(&sb, "BRF:%d\nBRH:%d\n", s.Branches, s.BranchesHit)
+		for _, line := range sortedLines(fc) {
+			fmt.Fprintf(&sb, "DA:%d,%d\n", line, fc.Lines[line])
+		}
+		fmt.Fprintf(&sb, "LF:%d\nLH:%d\nend_of_record\n", s.Lines, s.LinesHit)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
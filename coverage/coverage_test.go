@@ -0,0 +1,117 @@
+package coverage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+const coverageSource = `function logic() {
+    let a = txn.Fee
+    if a > 1 {
+        a = 2
+    } else {
+        a = 3
+    }
+    for a < 5 {
+        a = a + 1
+    }
+    return a
+}
+`
+
+// run evaluates the program once adding its coverage to the profile
+func run(t *testing.T, source string, file string, p *Profile) {
+	a := require.New(t)
+	input := compiler.InputDesc{Source: source, SourceFile: path.Base(file), SourceDir: path.Dir(file)}
+	result, err := compiler.Compile(input, compiler.Options{Assemble: true})
+	a.NoError(err)
+
+	c := NewCollector(result)
+	txn, err := dryrun.LoadTxn("")
+	a.NoError(err)
+	pass, err := dryrun.RunTxn(result.Bytecode, txn, nil, c)
+	a.NoError(err)
+	a.True(pass)
+	c.AddTo(p, Resolver(file, ""))
+}
+
+func TestCoverage(t *testing.T) {
+	a := require.New(t)
+
+	dir, err := ioutil.TempDir("", "tealang-coverage")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "prog.tl")
+	a.NoError(ioutil.WriteFile(file, []byte(coverageSource), 0644))
+
+	p := NewProfile()
+	run(t, coverageSource, file, p)
+	a.Equal([]string{file}, p.Paths())
+	fc := p.Files[file]
+	a.Equal(map[int]int{2: 1, 3: 1, 4: 1, 6: 0, 8: 4, 9: 3, 11: 1}, fc.Lines)
+	a.Equal([]*Branch{{Line: 3, Column: 4, Taken: [2]int{1, 0}}, {Line: 8, Column: 4, Taken: [2]int{3, 1}}}, fc.Branches)
+	a.Equal(Summary{Lines: 7, LinesHit: 6, Branches: 4, BranchesHit: 3}, fc.Summary())
+
+	// counts are added up by merge
+	covFile := path.Join(dir, "out.cov")
+	a.NoError(p.WriteFile(covFile))
+	loaded, err := ReadProfile(covFile)
+	a.NoError(err)
+	a.Equal(p, loaded)
+	run(t, coverageSource, file, loaded)
+	a.Equal(2, loaded.Files[file].Lines[2])
+	a.Equal([2]int{6, 2}, loaded.Files[file].Branches[1].Taken)
+
+	var out bytes.Buffer
+	a.NoError(p.WriteLCOV(&out))
+	a.Equal("TN:\nSF:"+file+"\n"+
+		"BRDA:3,0,0,1\nBRDA:3,0,1,0\nBRDA:8,0,0,3\nBRDA:8,0,1,1\nBRF:4\nBRH:3\n"+
+		"DA:2,1\nDA:3,1\nDA:4,1\nDA:6,0\nDA:8,4\nDA:9,3\nDA:11,1\nLF:7\nLH:6\nend_of_record\n", out.String())
+
+	out.Reset()
+	p.WriteSummary(&out)
+	a.Equal(file+": lines 6/7 85.7%, branches 3/4 75.0%\n", out.String())
+
+	out.Reset()
+	a.NoError(p.WriteHTML(&out))
+	html := out.String()
+	a.Contains(html, `<tr class="partial"><td class="num">3</td><td class="count">1</td>`)
+	a.Contains(html, `<tr class="uncovered"><td class="num">6</td><td class="count">0</td>`)
+	a.Contains(html, `<td class="code">    for a &lt; 5 {</td>`)
+}
+
+func TestCoverageInline(t *testing.T) {
+	a := require.New(t)
+
+	source := `inline function inc(x) {
+    return x + 1
+}
+function logic() {
+    return inc(txn.Fee) + inc(1) > 0
+}
+`
+	p := NewProfile()
+	run(t, source, "inline.tl", p)
+	a.Len(p.Paths(), 1)
+	// every expansion of the inline function adds its hits
+	a.Equal(map[int]int{2: 2, 5: 1}, p.Files[p.Paths()[0]].Lines)
+}
+
+func TestResolver(t *testing.T) {
+	a := require.New(t)
+
+	resolve := Resolver("/src/prog.tl", "/work")
+	a.Equal("/src/prog.tl", resolve("prog.tl"))
+	a.Empty(resolve("stdlib.const"))
+	a.Empty(resolve(""))
+	// missing modules keep their names
+	a.Equal("missing.tl", path.Base(resolve("missing.tl")))
+}
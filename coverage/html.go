@@ -0,0 +1,113 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var htmlTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Tealang coverage</title>
+<style>
+body { font-family: sans-serif; }
+table.source { border-collapse: collapse; font-family: monospace; white-space: pre; }
+table.source td { padding: 0 8px; }
+td.num, td.count { text-align: right; color: #777; }
+tr.covered td.code { background: #d7f5d7; }
+tr.uncovered td.code { background: #f8d0d0; }
+tr.partial td.code { background: #f8ecc0; }
+td.branch { color: #777; }
+</style>
+</head>
+<body>
+<h1>Tealang coverage</h1>
+<table>
+<tr><th>File</th><th>Lines</th><th>Branches</th></tr>
+{{range .}}<tr><td><a href="#{{.ID}}">{{.Path}}</a></td><td>{{.Lines}}</td><td>{{.Branches}}</td></tr>
+{{end}}</table>
+{{range .}}
+<h2 id="{{.ID}}">{{.Path}}</h2>
+{{if .Error}}<p>{{.Error}}</p>{{else}}<table class="source">
+{{range .Source}}<tr class="{{.Class}}"><td class="num">{{.Num}}</td><td class="count">{{.Count}}</td><td class="branch" title="{{.BranchTitle}}">{{.Branch}}</td><td class="code">{{.Text}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+type htmlFile struct {
+	ID       string
+	Path     string
+	Lines    string
+	Branches string
+	Error    string
+	Source   []htmlLine
+}
+
+type htmlLine struct {
+	Num         int
+	Count       string
+	Class       string
+	Branch      string
+	BranchTitle string
+	Text        string
+}
+
+// WriteHTML writes sources of the profile annotated with execution counts,
+// lines are highlighted as covered, not covered or partially covered if a branch arm was never taken.
+// Branch arms are shown as + or - for taken and not taken ones, condition holding arm goes first
+func (p *Profile) WriteHTML(w io.Writer) error {
+	var files []htmlFile
+	for i, path := range p.Paths() {
+		fc := p.Files[path]
+		s := fc.Summary()
+		f := htmlFile{
+			ID:       fmt.Sprintf("file%d", i),
+			Path:     path,
+			Lines:    fmt.Sprintf("%d/%d %s", s.LinesHit, s.Lines, percent(s.LinesHit, s.Lines)),
+			Branches: fmt.Sprintf("%d/%d %s", s.BranchesHit, s.Branches, percent(s.BranchesHit, s.Branches)),
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Error = err.Error()
+			files = append(files, f)
+			continue
+		}
+		for n, text := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			line := htmlLine{Num: n + 1, Text: text}
+			if count, ok := fc.Lines[n+1]; ok {
+				line.Count = fmt.Sprint(count)
+				line.Class = "uncovered"
+				if count > 0 {
+					line.Class = "covered"
+				}
+			}
+			var arms, titles []string
+			for _, b := range fc.lineBranches(n + 1) {
+				mark := ""
+				for _, taken := range b.Taken {
+					if taken > 0 {
+						mark += "+"
+					} else {
+						mark += "-"
+						if line.Class == "covered" {
+							line.Class = "partial"
+						}
+					}
+				}
+				arms = append(arms, mark)
+				titles = append(titles, fmt.Sprintf("column %d: condition held %d times, failed %d times", b.Column, b.Taken[0], b.Taken[1]))
+			}
+			line.Branch = strings.Join(arms, " ")
+			line.BranchTitle = strings.Join(titles, "; ")
+			f.Source = append(f.Source, line)
+		}
+		files = append(files, f)
+	}
+	return htmlTemplate.Execute(w, files)
+}
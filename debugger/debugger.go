@@ -0,0 +1,531 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Step debugger
+//
+//--------------------------------------------------------------------------------------------------
+
+package debugger
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+const helpText = `Commands:
+  break [file:]line   stop at the statement on the line, no line lists breakpoints (b)
+  delete [N]          delete breakpoint N or all of them (d)
+  continue            run until a breakpoint (c)
+  step                step to the next statement entering called functions (s)
+  next                step to the next statement over called functions (n)
+  out                 run until the current function returns (o)
+  stepi               execute a single instruction (si)
+  print expr          print a variable, scratch slot like s3 or expression like x + s3 > 1 (p)
+  watch expr          print the expression on every stop, no expression lists watches (w)
+  unwatch expr        stop watching the expression
+  stack               print the evaluation stack
+  scratch             print non-empty scratch slots
+  where               print the call stack and the current instruction
+  list                print source around the current line (l)
+  help                show this help (h)
+  quit                abort the evaluation (q)
+`
+
+// ErrQuit is returned by evaluation aborted with quit command
+var ErrQuit = errors.New("debugger quit")
+
+type stepMode int
+
+const (
+	runMode stepMode = iota
+	stepIntoMode
+	stepOverMode
+	stepOutMode
+	stepInstructionMode
+)
+
+type breakpoint struct {
+	file string
+	line int
+}
+
+// ReadSource returns text of a source file named as in source locations
+type ReadSource func(file string) (string, error)
+
+// Session is a debugger hook stopping evaluation at breakpoints and steps
+// and reading commands until the evaluation is resumed
+type Session struct {
+	out        io.Writer
+	in         *bufio.Scanner
+	sourceMap  compiler.SourceMap
+	mainFile   string
+	readSource ReadSource
+	sources    map[string][]string
+
+	breakpoints []breakpoint
+	watches     []string
+
+	mode        stepMode
+	targetDepth int
+	interactive bool
+	quit        bool
+
+	state  *logic.DebugState
+	lines  []string // disassembly
+	loc    compiler.SourceLocation
+	lastOp string
+	calls  []compiler.SourceLocation
+	// location and call depth of the last stop
+	stopLoc   compiler.SourceLocation
+	stopDepth int
+}
+
+// NewSession creates a session for a program compiled with the source map, commands are read from in.
+// mainFile names the program source for breakpoints given by line only
+func NewSession(in io.Reader, out io.Writer, sourceMap compiler.SourceMap, mainFile string, readSource ReadSource) *Session {
+	return &Session{
+		out:         out,
+		in:          bufio.NewScanner(in),
+		sourceMap:   sourceMap,
+		mainFile:    mainFile,
+		readSource:  readSource,
+		sources:     make(map[string][]string),
+		mode:        stepIntoMode,
+		interactive: true,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// logic.DebuggerHook implementation
+//
+//--------------------------------------------------------------------------------------------------
+
+// Register is fired on program start
+func (s *Session) Register(state *logic.DebugState) error {
+	s.lines = strings.Split(state.Disassembly, "\n")
+	s.state = state
+	fmt.Fprintf(s.out, "program started, %d bytes, type help for commands\n", len(state.PCOffset))
+	return nil
+}
+
+// Update is fired before every step, it stops and reads commands when a breakpoint is hit or a step is done
+func (s *Session) Update(state *logic.DebugState) error {
+	s.state = state
+	// call depth changes once callsub or retsub is executed
+	switch s.lastOp {
+	case "callsub":
+		s.calls = append(s.calls, s.loc)
+	case "retsub":
+		if len(s.calls) > 0 {
+			s.calls = s.calls[:len(s.calls)-1]
+		}
+	}
+	s.lastOp = ""
+	if fields := strings.Fields(s.op()); len(fields) > 0 {
+		s.lastOp = fields[0]
+	}
+
+	prev := s.loc
+	loc, located := s.sourceMap.Statement(state.PC)
+	if located {
+		s.loc = loc
+	}
+	// a statement is entered once the location changes, a line is entered once the line changes
+	statement := located && loc != prev
+	line := statement && (loc.File != prev.File || loc.Line != prev.Line)
+
+	if !s.shouldStop(statement, line) {
+		return nil
+	}
+	s.stopLoc, s.stopDepth = s.loc, len(s.calls)
+	s.stop()
+	return s.commands()
+}
+
+// Quit tells if the evaluation was aborted with quit command
+func (s *Session) Quit() bool {
+	return s.quit
+}
+
+// Complete is called when the program exits
+func (s *Session) Complete(state *logic.DebugState) error {
+	s.state = state
+	if s.quit {
+		return nil
+	}
+	if state.Error != "" {
+		fmt.Fprintf(s.out, "program failed at %s: %s\n", s.loc, state.Error)
+	} else {
+		fmt.Fprintln(s.out, "program finished")
+	}
+	if s.interactive && len(state.Stack) > 0 {
+		s.printStack()
+	}
+	return nil
+}
+
+func (s *Session) op() string {
+	if s.state.Line >= 0 && s.state.Line < len(s.lines) {
+		return strings.TrimSpace(s.lines[s.state.Line])
+	}
+	return ""
+}
+
+func (s *Session) shouldStop(statement bool, line bool) bool {
+	if !s.interactive {
+		return false
+	}
+	depth := len(s.calls)
+	// steps do not stop at the statement they started from, e.g. on return from a call made by it
+	moved := s.loc != s.stopLoc || depth != s.stopDepth
+	switch s.mode {
+	case stepInstructionMode:
+		return true
+	case stepIntoMode:
+		return statement && moved
+	case stepOverMode:
+		return statement && depth <= s.targetDepth && (s.loc != s.stopLoc || depth < s.stopDepth)
+	case stepOutMode:
+		return statement && depth < s.targetDepth
+	}
+	if !line {
+		return false
+	}
+	for _, bp := range s.breakpoints {
+		if bp.line == s.loc.Line && bp.file == s.loc.File {
+			fmt.Fprintf(s.out, "breakpoint at %s:%d\n", bp.file, bp.line)
+			return true
+		}
+	}
+	return false
+}
+
+// stop prints the current location and watched variables
+func (s *Session) stop() {
+	text := s.sourceLine(s.loc.File, s.loc.Line)
+	if s.mode == stepInstructionMode {
+		fmt.Fprintf(s.out, "%s pc=%d %s\n", s.loc, s.state.PC, s.op())
+	} else {
+		fmt.Fprintf(s.out, "%s\t%s\n", s.loc, strings.TrimSpace(text))
+	}
+	for _, name := range s.watches {
+		s.printVariable(name)
+	}
+}
+
+// commands reads commands until one resumes the evaluation
+func (s *Session) commands() error {
+	for {
+		fmt.Fprint(s.out, "(debug) ")
+		if !s.in.Scan() {
+			// no more input, run to the end
+			fmt.Fprintln(s.out)
+			s.interactive = false
+			return nil
+		}
+		fields := strings.Fields(s.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		arg := ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+		// expressions take the rest of the line
+		expr := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s.in.Text()), fields[0]))
+		depth := len(s.calls)
+		switch fields[0] {
+		case "continue", "c":
+			s.mode = runMode
+			return nil
+		case "step", "s":
+			s.mode = stepIntoMode
+			return nil
+		case "next", "n":
+			s.mode, s.targetDepth = stepOverMode, depth
+			return nil
+		case "out", "o":
+			if depth == 0 {
+				fmt.Fprintln(s.out, "not in a function call")
+				continue
+			}
+			s.mode, s.targetDepth = stepOutMode, depth
+			return nil
+		case "stepi", "si":
+			s.mode = stepInstructionMode
+			return nil
+		case "break", "b":
+			s.breakCommand(arg)
+		case "delete", "d":
+			s.deleteCommand(arg)
+		case "print", "p":
+			if expr == "" {
+				fmt.Fprintln(s.out, "usage: print expression")
+				continue
+			}
+			s.printVariable(expr)
+		case "watch", "w":
+			s.watchCommand(expr)
+		case "unwatch":
+			s.unwatchCommand(expr)
+		case "stack":
+			s.printStack()
+		case "scratch":
+			s.printScratch()
+		case "where", "bt":
+			s.printWhere()
+		case "list", "l":
+			s.printList()
+		case "help", "h":
+			fmt.Fprint(s.out, helpText)
+		case "quit", "q":
+			s.interactive, s.quit = false, true
+			return ErrQuit
+		default:
+			fmt.Fprintf(s.out, "unknown command %s, type help for commands\n", fields[0])
+		}
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Commands
+//
+//--------------------------------------------------------------------------------------------------
+
+func (s *Session) breakCommand(arg string) {
+	if arg == "" {
+		if len(s.breakpoints) == 0 {
+			fmt.Fprintln(s.out, "no breakpoints")
+		}
+		for i, bp := range s.breakpoints {
+			fmt.Fprintf(s.out, "%d: %s:%d\n", i+1, bp.file, bp.line)
+		}
+		return
+	}
+	bp := breakpoint{file: s.mainFile}
+	lineText := arg
+	if idx := strings.LastIndex(arg, ":"); idx >= 0 {
+		bp.file, lineText = arg[:idx], arg[idx+1:]
+	}
+	line, err := strconv.Atoi(lineText)
+	if err != nil || line <= 0 {
+		fmt.Fprintf(s.out, "invalid line %s\n", lineText)
+		return
+	}
+	bp.line = line
+	if !s.hasStatement(bp) {
+		fmt.Fprintf(s.out, "no code at %s:%d\n", bp.file, bp.line)
+		return
+	}
+	s.breakpoints = append(s.breakpoints, bp)
+	fmt.Fprintf(s.out, "breakpoint %d at %s:%d\n", len(s.breakpoints), bp.file, bp.line)
+}
+
+// hasStatement tells if any bytecode is generated for the line, declarations like constants have none
+func (s *Session) hasStatement(bp breakpoint) bool {
+	for _, line := range s.sourceMap.OffsetToLine {
+		loc := s.sourceMap.LineToStatement[line]
+		if loc.File == bp.file && loc.Line == bp.line {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Session) deleteCommand(arg string) {
+	if arg == "" {
+		s.breakpoints = nil
+		fmt.Fprintln(s.out, "all breakpoints deleted")
+		return
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 || n > len(s.breakpoints) {
+		fmt.Fprintf(s.out, "no breakpoint %s\n", arg)
+		return
+	}
+	s.breakpoints = append(s.breakpoints[:n-1], s.breakpoints[n:]...)
+}
+
+func (s *Session) watchCommand(name string) {
+	if name == "" {
+		for _, name := range s.watches {
+			s.printVariable(name)
+		}
+		return
+	}
+	for _, w := range s.watches {
+		if w == name {
+			return
+		}
+	}
+	s.watches = append(s.watches, name)
+	s.printVariable(name)
+}
+
+func (s *Session) unwatchCommand(name string) {
+	for i, w := range s.watches {
+		if w == name {
+			s.watches = append(s.watches[:i], s.watches[i+1:]...)
+			return
+		}
+	}
+	fmt.Fprintf(s.out, "%s is not watched\n", name)
+}
+
+// slot finds scratch slot of a variable visible at the current instruction or a slot given as sN
+func (s *Session) slot(name string) (int, bool) {
+	if slot, ok := s.sourceMap.Slot(name, s.state.PC); ok {
+		return slot, true
+	}
+	if strings.HasPrefix(name, "s") {
+		if slot, err := strconv.Atoi(name[1:]); err == nil && slot >= 0 && slot < len(s.state.Scratch) {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+// value reads a variable or a scratch slot, slots not set yet are zero
+func (s *Session) value(name string) (basics.TealValue, error) {
+	slot, ok := s.slot(name)
+	if !ok && s.sourceMap.Declared(name) {
+		return basics.TealValue{}, fmt.Errorf("%s: not in scope", name)
+	}
+	if !ok {
+		return basics.TealValue{}, fmt.Errorf("%s: unknown variable", name)
+	}
+	if slot >= len(s.state.Scratch) {
+		return basics.TealValue{Type: basics.TealUintType}, nil
+	}
+	return s.state.Scratch[slot], nil
+}
+
+// printVariable prints a variable, a scratch slot or an expression over them
+func (s *Session) printVariable(expr string) {
+	value, err := evalExpr(expr, s.value)
+	if err != nil {
+		msg := err.Error()
+		// errors of a single name already mention it
+		if !strings.HasPrefix(msg, expr+":") {
+			msg = fmt.Sprintf("%s: %s", expr, msg)
+		}
+		fmt.Fprintln(s.out, msg)
+		return
+	}
+	fmt.Fprintf(s.out, "%s = %s\n", expr, formatValue(value))
+}
+
+func (s *Session) printStack() {
+	if len(s.state.Stack) == 0 {
+		fmt.Fprintln(s.out, "stack is empty")
+		return
+	}
+	// the top of the stack goes first
+	for i := len(s.state.Stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(s.out, "[%d] %s\n", i, formatValue(s.state.Stack[i]))
+	}
+}
+
+func (s *Session) printScratch() {
+	// variables of nested scopes take slots over from enclosing ones
+	names := make(map[int]string)
+	for _, scope := range s.sourceMap.Scopes {
+		if s.state.PC < scope.First || s.state.PC > scope.Last {
+			continue
+		}
+		for name, slot := range scope.Slots {
+			names[slot] = name
+		}
+	}
+	empty := true
+	for slot, v := range s.state.Scratch {
+		if v.Type == basics.TealUintType && v.Uint == 0 {
+			continue
+		}
+		empty = false
+		name := fmt.Sprintf("s%d", slot)
+		if varName, ok := names[slot]; ok {
+			name = fmt.Sprintf("%s (%s)", name, varName)
+		}
+		fmt.Fprintf(s.out, "%s = %s\n", name, formatValue(v))
+	}
+	if empty {
+		fmt.Fprintln(s.out, "scratch space is empty")
+	}
+}
+
+func (s *Session) printWhere() {
+	fmt.Fprintf(s.out, "#0 %s pc=%d %s\n", s.loc, s.state.PC, s.op())
+	for i := len(s.calls) - 1; i >= 0; i-- {
+		fmt.Fprintf(s.out, "#%d %s\n", len(s.calls)-i, s.calls[i])
+	}
+}
+
+// listContext is a number of lines printed around the current one
+const listContext = 3
+
+func (s *Session) printList() {
+	lines := s.source(s.loc.File)
+	if lines == nil {
+		fmt.Fprintf(s.out, "no source for %s\n", s.loc.File)
+		return
+	}
+	first, last := s.loc.Line-listContext, s.loc.Line+listContext
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	for n := first; n <= last; n++ {
+		marker := "  "
+		if n == s.loc.Line {
+			marker = "=>"
+		}
+		fmt.Fprintf(s.out, "%s %4d  %s\n", marker, n, lines[n-1])
+	}
+}
+
+// source returns lines of a source file, nil if not available
+func (s *Session) source(file string) []string {
+	if lines, ok := s.sources[file]; ok {
+		return lines
+	}
+	var lines []string
+	if s.readSource != nil {
+		if text, err := s.readSource(file); err == nil {
+			lines = strings.Split(text, "\n")
+		}
+	}
+	s.sources[file] = lines
+	return lines
+}
+
+func (s *Session) sourceLine(file string, line int) string {
+	lines := s.source(file)
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return lines[line-1]
+}
+
+// formatValue prints debugger state value with its type, state keeps bytes base64-encoded
+func formatValue(v basics.TealValue) string {
+	if v.Type == basics.TealBytesType {
+		data, _ := base64.StdEncoding.DecodeString(v.Bytes)
+		return fmt.Sprintf("%s: []byte", dryrun.FormatBytes(data))
+	}
+	return fmt.Sprintf("%d: uint64", v.Uint)
+}
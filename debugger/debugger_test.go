@@ -0,0 +1,124 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+const debugSource = `function double(a) {
+    let twice = a * 2
+    return twice
+}
+
+function logic() {
+    let x = txn.Fee
+    let y = double(x)
+    let z = y + 1
+    return z > 0
+}
+`
+
+// debug runs the program with commands from the script and returns the session output
+func debug(t *testing.T, script string) (string, bool, error) {
+	a := require.New(t)
+	input := compiler.InputDesc{Source: debugSource, SourceFile: "prog.tl"}
+	result, err := compiler.Compile(input, compiler.Options{Assemble: true})
+	a.NoError(err)
+
+	var out bytes.Buffer
+	readSource := func(file string) (string, error) {
+		return debugSource, nil
+	}
+	s := NewSession(strings.NewReader(script), &out, result.SourceMap, "prog.tl", readSource)
+	txn, err := dryrun.LoadTxn("")
+	a.NoError(err)
+	pass, err := dryrun.RunTxn(result.Bytecode, txn, nil, s)
+	return out.String(), pass, err
+}
+
+func TestDebugBreakpoints(t *testing.T) {
+	a := require.New(t)
+
+	out, pass, err := debug(t, "break 9\nbreak 42\nwatch y\ncontinue\nprint x\nprint z\nlist\ncontinue\n")
+	a.NoError(err)
+	a.True(pass)
+	// starts at the first statement
+	a.Contains(out, "prog.tl:7:4\tlet x = txn.Fee")
+	a.Contains(out, "breakpoint 1 at prog.tl:9")
+	a.Contains(out, "no code at prog.tl:42")
+	a.Contains(out, "breakpoint at prog.tl:9\nprog.tl:9:4\tlet z = y + 1\ny = 2000: uint64\n")
+	a.Contains(out, "x = 1000: uint64")
+	// declared variables resolve before they are set, the slot still keeps a value of a returned function
+	a.Contains(out, "z = 2000: uint64")
+	a.Contains(out, "=>    9      let z = y + 1")
+	a.Contains(out, "program finished\n[0] 1: uint64\n")
+}
+
+func TestDebugSteps(t *testing.T) {
+	a := require.New(t)
+
+	// next steps over the call
+	out, _, err := debug(t, "next\nnext\nnext\n")
+	a.NoError(err)
+	a.NotContains(out, "let twice")
+	a.Contains(out, "prog.tl:8:4\tlet y = double(x)")
+	a.Contains(out, "prog.tl:9:4\tlet z = y + 1")
+
+	// step enters the call, out returns to the caller
+	out, _, err = debug(t, "step\nstep\nstack\nstep\nwhere\nout\nwhere\n")
+	a.NoError(err)
+	// arguments are stored at the function header
+	a.Contains(out, "prog.tl:1:0\tfunction double(a) {\n(debug) [0] 1000: uint64")
+	a.Contains(out, "prog.tl:2:4\tlet twice = a * 2")
+	a.Contains(out, "#1 prog.tl:8:4")
+	a.Contains(out, "prog.tl:8:4\tlet y = double(x)\n(debug) #0 prog.tl:8:4")
+
+	out, _, err = debug(t, "stepi\nstack\n")
+	a.NoError(err)
+	a.Contains(out, "prog.tl:7:4 pc=3 store 0\n(debug) [0] 1000: uint64")
+}
+
+func TestDebugQuit(t *testing.T) {
+	a := require.New(t)
+
+	out, _, err := debug(t, "out\nbogus\nquit\n")
+	a.Error(err)
+	a.Contains(err.Error(), ErrQuit.Error())
+	a.Contains(out, "not in a function call")
+	a.Contains(out, "unknown command bogus")
+	a.NotContains(out, "program finished")
+}
+
+func TestDebugScopes(t *testing.T) {
+	a := require.New(t)
+
+	out, _, err := debug(t, "break 3\nbreak 9\ncontinue\nprint a\nprint twice\nprint x\nscratch\ncontinue\nprint a\nprint twice\nprint y\n")
+	a.NoError(err)
+	// variables of the caller are not visible in the function
+	a.Contains(out, "prog.tl:3:4\treturn twice\n(debug) a = 1000: uint64\n(debug) twice = 2000: uint64\n(debug) x: not in scope\n")
+	a.Contains(out, "s2 (twice) = 2000: uint64")
+	// and the function ones are dropped once it returns, their slots may be reused
+	a.Contains(out, "prog.tl:9:4\tlet z = y + 1\n(debug) a: not in scope\n(debug) twice: not in scope\n(debug) y = 2000: uint64\n")
+}
+
+func TestDebugExpressions(t *testing.T) {
+	a := require.New(t)
+
+	out, _, err := debug(t, "print y\nprint bogus\nbreak 9\ncontinue\nprint x * 2 + 1\nprint x > 999 && y == 2000\nprint (x + 2) % 7\nprint x - 2000\nprint bogus + 1\nprint \"ab\" == \"ab\"\nwatch y + z\nnext\n")
+	a.NoError(err)
+	// declared variables resolve before they are accessed
+	a.Contains(out, "(debug) y = 0: uint64\n(debug) bogus: unknown variable\n")
+	a.Contains(out, "x * 2 + 1 = 2001: uint64")
+	a.Contains(out, "x > 999 && y == 2000 = 1: uint64")
+	a.Contains(out, "(x + 2) % 7 = 1: uint64")
+	a.Contains(out, "x - 2000: - would result negative")
+	a.Contains(out, "bogus + 1: bogus: unknown variable")
+	a.Contains(out, `"ab" == "ab" = 1: uint64`)
+	a.Contains(out, "prog.tl:10:4\treturn z > 0\ny + z = 4001: uint64\n")
+}
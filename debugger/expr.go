@@ -0,0 +1,239 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Watch expressions
+//
+//--------------------------------------------------------------------------------------------------
+
+package debugger
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/algorand/go-algorand/data/basics"
+)
+
+// binaryPrecedence orders operators of watch expressions from the loosest binding one
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// exprParser evaluates expressions over variables while parsing them
+type exprParser struct {
+	tokens []string
+	pos    int
+	lookup func(name string) (basics.TealValue, error)
+}
+
+// evalExpr evaluates an expression of variables, scratch slots, numbers and strings
+// combined by arithmetic, comparison and logical operators following AVM rules
+func evalExpr(text string, lookup func(name string) (basics.TealValue, error)) (basics.TealValue, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return basics.TealValue{}, err
+	}
+	p := &exprParser{tokens: tokens, lookup: lookup}
+	value, err := p.binary(1)
+	if err != nil {
+		return basics.TealValue{}, err
+	}
+	if p.pos < len(p.tokens) {
+		return basics.TealValue{}, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return value, nil
+}
+
+func tokenize(text string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case isIdentByte(c):
+			j := i
+			for j < len(text) && isIdentByte(text[j]) {
+				j++
+			}
+			tokens = append(tokens, text[i:j])
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(text) && text[j] != '"' {
+				if text[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(text) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, text[i:j+1])
+			i = j + 1
+		default:
+			if i+1 < len(text) {
+				if _, ok := binaryPrecedence[text[i:i+2]]; ok {
+					tokens = append(tokens, text[i:i+2])
+					i += 2
+					continue
+				}
+			}
+			if _, ok := binaryPrecedence[text[i:i+1]]; !ok && !strings.ContainsRune("!()", rune(c)) {
+				return nil, fmt.Errorf("unexpected %c", c)
+			}
+			tokens = append(tokens, text[i:i+1])
+			i++
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return tokens, nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *exprParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) binary(minPrec int) (basics.TealValue, error) {
+	lhs, err := p.unary()
+	if err != nil {
+		return lhs, err
+	}
+	for {
+		op := p.next()
+		prec, ok := binaryPrecedence[op]
+		if !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.pos++
+		rhs, err := p.binary(prec + 1)
+		if err != nil {
+			return rhs, err
+		}
+		if lhs, err = applyBinary(op, lhs, rhs); err != nil {
+			return lhs, err
+		}
+	}
+}
+
+func (p *exprParser) unary() (basics.TealValue, error) {
+	token := p.next()
+	p.pos++
+	switch {
+	case token == "":
+		return basics.TealValue{}, fmt.Errorf("unexpected end of expression")
+	case token == "!":
+		value, err := p.unary()
+		if err != nil {
+			return value, err
+		}
+		if value.Type != basics.TealUintType {
+			return value, fmt.Errorf("! expects uint64")
+		}
+		return boolValue(value.Uint == 0), nil
+	case token == "(":
+		value, err := p.binary(1)
+		if err != nil {
+			return value, err
+		}
+		if p.next() != ")" {
+			return value, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return value, nil
+	case token[0] == '"':
+		text, err := strconv.Unquote(token)
+		if err != nil {
+			return basics.TealValue{}, err
+		}
+		// debugger state keeps bytes base64-encoded
+		return basics.TealValue{Type: basics.TealBytesType, Bytes: base64.StdEncoding.EncodeToString([]byte(text))}, nil
+	case token[0] >= '0' && token[0] <= '9':
+		n, err := strconv.ParseUint(token, 0, 64)
+		if err != nil {
+			return basics.TealValue{}, fmt.Errorf("invalid number %s", token)
+		}
+		return basics.TealValue{Type: basics.TealUintType, Uint: n}, nil
+	case isIdentByte(token[0]):
+		return p.lookup(token)
+	}
+	return basics.TealValue{}, fmt.Errorf("unexpected %s", token)
+}
+
+func boolValue(b bool) basics.TealValue {
+	if b {
+		return basics.TealValue{Type: basics.TealUintType, Uint: 1}
+	}
+	return basics.TealValue{Type: basics.TealUintType}
+}
+
+// applyBinary evaluates an operator, only equality accepts bytes
+func applyBinary(op string, lhs basics.TealValue, rhs basics.TealValue) (basics.TealValue, error) {
+	if lhs.Type != rhs.Type {
+		return basics.TealValue{}, fmt.Errorf("%s: type mismatch", op)
+	}
+	switch op {
+	case "==":
+		return boolValue(lhs == rhs), nil
+	case "!=":
+		return boolValue(lhs != rhs), nil
+	}
+	if lhs.Type != basics.TealUintType {
+		return basics.TealValue{}, fmt.Errorf("%s expects uint64", op)
+	}
+	a, b := lhs.Uint, rhs.Uint
+	switch op {
+	case "||":
+		return boolValue(a != 0 || b != 0), nil
+	case "&&":
+		return boolValue(a != 0 && b != 0), nil
+	case "<":
+		return boolValue(a < b), nil
+	case "<=":
+		return boolValue(a <= b), nil
+	case ">":
+		return boolValue(a > b), nil
+	case ">=":
+		return boolValue(a >= b), nil
+	case "+":
+		if a > math.MaxUint64-b {
+			return basics.TealValue{}, fmt.Errorf("+ overflowed")
+		}
+		return basics.TealValue{Type: basics.TealUintType, Uint: a + b}, nil
+	case "-":
+		if a < b {
+			return basics.TealValue{}, fmt.Errorf("- would result negative")
+		}
+		return basics.TealValue{Type: basics.TealUintType, Uint: a - b}, nil
+	case "*":
+		if a != 0 && b > math.MaxUint64/a {
+			return basics.TealValue{}, fmt.Errorf("* overflowed")
+		}
+		return basics.TealValue{Type: basics.TealUintType, Uint: a * b}, nil
+	case "/", "%":
+		if b == 0 {
+			return basics.TealValue{}, fmt.Errorf("%s 0", op)
+		}
+		if op == "/" {
+			return basics.TealValue{Type: basics.TealUintType, Uint: a / b}, nil
+		}
+		return basics.TealValue{Type: basics.TealUintType, Uint: a % b}, nil
+	}
+	return basics.TealValue{}, fmt.Errorf("unknown operator %s", op)
+}
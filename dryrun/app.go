@@ -0,0 +1,198 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Application calls evaluation
+//
+//--------------------------------------------------------------------------------------------------
+
+package dryrun
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/algorand/go-algorand/config"
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+	"github.com/algorand/go-algorand/protocol"
+)
+
+// feeSink receives fees of inner transactions, it is the mainnet one
+var feeSink, _ = basics.UnmarshalChecksumAddress("Y76M3MSY6DKBRHBL7C3NNDXGS5IIMQVQVUAB6MP4XEMMGVF2QWNPL226CA")
+
+// AppResult is an outcome of an application call
+type AppResult struct {
+	Pass  bool
+	AppID basics.AppIndex
+	Txn   transactions.Transaction
+	// Delta lists state changes, logs and inner transactions made by the program
+	Delta transactions.EvalDelta
+}
+
+// RunApp evaluates bytecode as approval program of the app called by txn.
+// The app is registered in the ledger if it is not there, zero ApplicationID creates a new one.
+// Programs change the ledger in place unless the call is rejected, the changes are also listed in the result
+func RunApp(bytecode []byte, txn transactions.Transaction, ledger *Ledger, trace *strings.Builder, debugger logic.DebuggerHook) (*AppResult, error) {
+	txn.Type = protocol.ApplicationCallTx
+	proto := config.Consensus[protocol.ConsensusCurrentVersion]
+	stxns := []transactions.SignedTxnWithAD{{SignedTxn: transactions.SignedTxn{Txn: txn}}}
+	ep := logic.NewEvalParams(stxns, &proto, &transactions.SpecialAddresses{FeeSink: feeSink})
+	ep.Ledger = ledger
+	ep.Trace = trace
+	ep.Debugger = debugger
+	return ledger.evalApp(bytecode, 0, ep)
+}
+
+// evalApp runs the app called by group transaction gi and applies its on completion action if approved.
+// Non-empty bytecode replaces approval program of the app, it is registered in the ledger if needed,
+// otherwise the stored approval or clear state program runs
+func (l *Ledger) evalApp(bytecode []byte, gi int, ep *logic.EvalParams) (*AppResult, error) {
+	stxn := &ep.TxnGroup[gi]
+	txn := stxn.Txn
+	aid := txn.ApplicationID
+	if aid == 0 {
+		aid = l.createApp(txn.Sender, txn.ApplicationCallTxnFields)
+		stxn.ApplyData.ApplicationID = aid
+	} else if _, ok := l.apps[aid]; !ok && len(bytecode) > 0 {
+		l.apps[aid] = &appState{creator: txn.Sender, params: basics.AppParams{GlobalState: make(basics.TealKeyValue)}}
+	}
+	app, ok := l.apps[aid]
+	if !ok {
+		return nil, fmt.Errorf("no such app %d", aid)
+	}
+	if len(bytecode) > 0 {
+		app.params.ApprovalProgram = bytecode
+	}
+	// rejected or failed calls leave no state changes, inner transactions included
+	saved := l.snapshot()
+	if txn.OnCompletion == transactions.OptInOC {
+		l.account(txn.Sender).locals[aid] = make(basics.TealKeyValue)
+	}
+
+	program := app.params.ApprovalProgram
+	if txn.OnCompletion == transactions.ClearStateOC && len(bytecode) == 0 {
+		program = app.params.ClearStateProgram
+	}
+	if err := logic.CheckContract(program, ep); err != nil {
+		l.restore(saved)
+		return nil, err
+	}
+	result := &AppResult{AppID: aid, Txn: txn}
+	pass, cx, err := logic.EvalContract(program, gi, aid, ep)
+	if cx != nil {
+		result.Delta = cx.Txn.EvalDelta
+	}
+	result.Pass = pass && err == nil
+	if result.Pass {
+		stxn.EvalDelta = result.Delta
+		err = l.complete(txn.Sender, aid, txn.ApplicationCallTxnFields)
+	}
+	if !result.Pass || err != nil {
+		l.restore(saved)
+	}
+	return result, err
+}
+
+// String lists state changes, logs and inner transactions
+func (r *AppResult) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "app %d\n", r.AppID)
+	if len(r.Delta.GlobalDelta) > 0 {
+		sb.WriteString("global state:\n")
+		writeStateDelta(&sb, r.Delta.GlobalDelta)
+	}
+	accounts := make([]uint64, 0, len(r.Delta.LocalDeltas))
+	for idx := range r.Delta.LocalDeltas {
+		accounts = append(accounts, idx)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i] < accounts[j] })
+	for _, idx := range accounts {
+		// local deltas are indexed by txn.Sender followed by txn.Accounts
+		addr := r.Txn.Sender
+		if idx > 0 && int(idx) <= len(r.Txn.Accounts) {
+			addr = r.Txn.Accounts[idx-1]
+		}
+		fmt.Fprintf(&sb, "local state of %s:\n", addr)
+		writeStateDelta(&sb, r.Delta.LocalDeltas[idx])
+	}
+	if len(r.Delta.Logs) > 0 {
+		sb.WriteString("logs:\n")
+		for _, log := range r.Delta.Logs {
+			fmt.Fprintf(&sb, "    %s\n", FormatBytes([]byte(log)))
+		}
+	}
+	if len(r.Delta.InnerTxns) > 0 {
+		sb.WriteString("inner transactions:\n")
+		for i, stxn := range r.Delta.InnerTxns {
+			fmt.Fprintf(&sb, "    %d: %s\n", i, describeTxn(stxn))
+		}
+	}
+	return sb.String()
+}
+
+func writeStateDelta(sb *strings.Builder, delta basics.StateDelta) {
+	keys := make([]string, 0, len(delta))
+	for key := range delta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		vd := delta[key]
+		switch vd.Action {
+		case basics.SetUintAction:
+			fmt.Fprintf(sb, "    %s = %d\n", FormatBytes([]byte(key)), vd.Uint)
+		case basics.SetBytesAction:
+			fmt.Fprintf(sb, "    %s = %s\n", FormatBytes([]byte(key)), FormatBytes([]byte(vd.Bytes)))
+		case basics.DeleteAction:
+			fmt.Fprintf(sb, "    %s deleted\n", FormatBytes([]byte(key)))
+		}
+	}
+}
+
+// describeTxn summarizes inner transaction in a line
+func describeTxn(stxn transactions.SignedTxnWithAD) string {
+	txn := stxn.Txn
+	var desc string
+	switch txn.Type {
+	case protocol.PaymentTx:
+		desc = fmt.Sprintf("pay %d to %s", txn.Amount.Raw, txn.Receiver)
+		if !txn.CloseRemainderTo.IsZero() {
+			desc += fmt.Sprintf(" close to %s", txn.CloseRemainderTo)
+		}
+	case protocol.AssetTransferTx:
+		desc = fmt.Sprintf("axfer %d of asset %d to %s", txn.AssetAmount, txn.XferAsset, txn.AssetReceiver)
+		if !txn.AssetCloseTo.IsZero() {
+			desc += fmt.Sprintf(" close to %s", txn.AssetCloseTo)
+		}
+	case protocol.AssetConfigTx:
+		if txn.ConfigAsset == 0 {
+			desc = fmt.Sprintf("acfg create asset %d", stxn.ConfigAsset)
+		} else {
+			desc = fmt.Sprintf("acfg asset %d", txn.ConfigAsset)
+		}
+	case protocol.AssetFreezeTx:
+		desc = fmt.Sprintf("afrz asset %d of %s frozen=%t", txn.FreezeAsset, txn.FreezeAccount, txn.AssetFrozen)
+	case protocol.ApplicationCallTx:
+		aid := txn.ApplicationID
+		if aid == 0 {
+			aid = stxn.ApplicationID
+		}
+		desc = fmt.Sprintf("appl app %d %s", aid, txn.OnCompletion)
+	default:
+		desc = string(txn.Type)
+	}
+	return fmt.Sprintf("%s from %s fee %d", desc, txn.Sender, txn.Fee.Raw)
+}
+
+// FormatBytes prints printable byte strings quoted and other ones in hex
+func FormatBytes(data []byte) string {
+	for _, b := range data {
+		if b > unicode.MaxASCII || !unicode.IsPrint(rune(b)) {
+			return fmt.Sprintf("0x%x", data)
+		}
+	}
+	return strconv.Quote(string(data))
+}
@@ -8,21 +8,25 @@ package dryrun
 
 var sampleTxnData []byte
 
+// txnDesc lists transaction fields by their names in tealang, see txn fields in GUIDE.md.
+// Type is derived from TypeEnum if not set, GroupIndex is given by position in a group
 type txnDesc struct {
 	Sender 			string		// Algorand Address, base32-encoded string with checksum
 	Fee 			uint64
 	FirstValid 		uint64
 	LastValid 		uint64
 	Note 			string		// base64-encoded
-	Lease 			string		// base64-encoded
+	Lease 			string		// base64-encoded, up to 32 bytes
 	Receiver 		string		// Algorand Address, base32-encoded string with checksum
 	Amount 			uint64
 	CloseRemainderTo string		// Algorand Address, base32-encoded string with checksum
-	VotePK 			string		// base64-encoded
-	SelectionPK 	string		// base64-encoded
+	VotePK 			string		// base64-encoded, 32 bytes
+	SelectionPK 	string		// base64-encoded, 32 bytes
+	StateProofPK 	string		// base64-encoded, 64 bytes
 	VoteFirst 		uint64
 	VoteLast 		uint64
 	VoteKeyDilution uint64
+	Nonparticipation uint64		// 0 or 1
 	Type 			string		// string
 	TypeEnum 		uint64
 	XferAsset 		uint64
@@ -31,6 +35,35 @@ type txnDesc struct {
 	AssetReceiver 	string		// Algorand Address, base32-encoded string with checksum
 	AssetCloseTo 	string		// Algorand Address, base32-encoded string with checksum
 	GroupIndex 		uint64
+	ApplicationID 	uint64
+	OnCompletion 	uint64
+	ApplicationArgs []string	// base64-encoded
+	Accounts 		[]string	// Algorand Addresses, base32-encoded strings with checksum
+	ApprovalProgram string		// base64-encoded
+	ClearStateProgram string	// base64-encoded
+	RekeyTo 		string		// Algorand Address, base32-encoded string with checksum
+	ConfigAsset 	uint64
+	ConfigAssetTotal uint64
+	ConfigAssetDecimals uint64
+	ConfigAssetDefaultFrozen uint64	// 0 or 1
+	ConfigAssetUnitName string
+	ConfigAssetName string
+	ConfigAssetURL 	string
+	ConfigAssetMetadataHash string	// base64-encoded, up to 32 bytes
+	ConfigAssetManager string	// Algorand Address, base32-encoded string with checksum
+	ConfigAssetReserve string	// Algorand Address, base32-encoded string with checksum
+	ConfigAssetFreeze string	// Algorand Address, base32-encoded string with checksum
+	ConfigAssetClawback string	// Algorand Address, base32-encoded string with checksum
+	FreezeAsset 	uint64
+	FreezeAssetAccount string	// Algorand Address, base32-encoded string with checksum
+	FreezeAssetFrozen uint64	// 0 or 1
+	Assets 			[]uint64
+	Applications 	[]uint64
+	GlobalNumUint 	uint64
+	GlobalNumByteSlice uint64
+	LocalNumUint 	uint64
+	LocalNumByteSlice uint64
+	ExtraProgramPages uint64
 }
 
 func init() {
@@ -0,0 +1,202 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Transaction groups evaluation
+//
+//--------------------------------------------------------------------------------------------------
+
+package dryrun
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/algorand/go-algorand/config"
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+	"github.com/algorand/go-algorand/protocol"
+)
+
+// groupTxnDesc is a group transaction with optional .tl or .tok programs
+type groupTxnDesc struct {
+	txnDesc
+	LogicSig        string
+	LogicSigArgs    []string // base64-encoded
+	ApprovalProgram string
+}
+
+// GroupTxn is a transaction of a group with programs evaluated for it
+type GroupTxn struct {
+	Txn transactions.Transaction
+	// LogicSigFile and ApprovalFile name .tl or .tok programs, empty if not set
+	LogicSigFile string
+	ApprovalFile string
+	Args         [][]byte
+	// LogicSig and Approval are bytecode of the programs, set by caller
+	LogicSig []byte
+	Approval []byte
+}
+
+// TxnResult is an outcome of a group transaction evaluation
+type TxnResult struct {
+	Pass bool
+	// Err is set if evaluation failed or the transaction cannot be applied
+	Err           error
+	LogicSigTrace string
+	AppTrace      string
+	// App is set for application calls
+	App *AppResult
+}
+
+// LoadGroup reads transaction group from JSON array of transactions.
+// Program file names are relative to the group file.
+// Signed transactions written by goal clerk are accepted as well, either msgpack-encoded
+// or as JSON array elements, their logic signatures are evaluated
+func LoadGroup(groupFile string) ([]GroupTxn, error) {
+	data, err := ioutil.ReadFile(groupFile)
+	if err != nil {
+		return nil, err
+	}
+	if !isJSON(data) {
+		stxns, err := decodeSignedTxns(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", groupFile, err.Error())
+		}
+		group := make([]GroupTxn, len(stxns))
+		for i, stxn := range stxns {
+			group[i] = signedGroupTxn(stxn)
+		}
+		return group, nil
+	}
+
+	var elems []json.RawMessage
+	if err = json.Unmarshal(data, &elems); err != nil {
+		return nil, err
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("%s: empty transaction group", groupFile)
+	}
+
+	dir := path.Dir(groupFile)
+	group := make([]GroupTxn, len(elems))
+	for i, elem := range elems {
+		if err = loadGroupTxn(elem, dir, &group[i]); err != nil {
+			return nil, fmt.Errorf("%s: transaction %d: %s", groupFile, i, err.Error())
+		}
+	}
+	return group, nil
+}
+
+// signedGroupTxn takes transaction and logic signature program of the signed transaction
+func signedGroupTxn(stxn transactions.SignedTxn) GroupTxn {
+	return GroupTxn{Txn: stxn.Txn, LogicSig: stxn.Lsig.Logic, Args: stxn.Lsig.Args}
+}
+
+func loadGroupTxn(data []byte, dir string, gt *GroupTxn) (err error) {
+	if isSignedTxnJSON(data) {
+		var stxn transactions.SignedTxn
+		if err = protocol.DecodeJSON(data, &stxn); err != nil {
+			return
+		}
+		*gt = signedGroupTxn(stxn)
+		return
+	}
+
+	var desc groupTxnDesc
+	if err = json.Unmarshal(data, &desc); err != nil {
+		return
+	}
+	if gt.Txn, err = desc.transaction(); err != nil {
+		return
+	}
+	for _, arg := range desc.LogicSigArgs {
+		var data []byte
+		if data, err = base64.StdEncoding.DecodeString(arg); err != nil {
+			return
+		}
+		gt.Args = append(gt.Args, data)
+	}
+	if desc.LogicSig != "" {
+		gt.LogicSigFile = path.Join(dir, desc.LogicSig)
+	}
+	if desc.ApprovalProgram != "" {
+		if gt.Txn.Type != protocol.ApplicationCallTx {
+			return fmt.Errorf("approval program set for %s transaction", gt.Txn.Type)
+		}
+		gt.ApprovalFile = path.Join(dir, desc.ApprovalProgram)
+	}
+	return
+}
+
+// RunGroup evaluates logic signatures of the group and then runs app calls in order sharing the ledger.
+// Effects of other transactions are applied to the ledger as well unless it is nil,
+// fees are not charged. The group is approved only if every transaction passes
+func RunGroup(group []GroupTxn, ledger *Ledger) []TxnResult {
+	apply := ledger != nil
+	if ledger == nil {
+		ledger = NewLedger()
+	}
+
+	proto := config.Consensus[protocol.ConsensusCurrentVersion]
+	stxns := make([]transactions.SignedTxnWithAD, len(group))
+	for i, gt := range group {
+		stxns[i].Txn = gt.Txn
+		stxns[i].Lsig = transactions.LogicSig{Logic: gt.LogicSig, Args: gt.Args}
+	}
+	ep := logic.NewEvalParams(stxns, &proto, &transactions.SpecialAddresses{FeeSink: feeSink})
+	ep.Ledger = ledger
+
+	results := make([]TxnResult, len(group))
+	// logic signatures do not depend on the state, they are checked before the group is applied
+	for gi, gt := range group {
+		results[gi].Pass = true
+		if len(gt.LogicSig) == 0 {
+			continue
+		}
+		sb := strings.Builder{}
+		ep.Trace = &sb
+		pass, err := evalSignature(gi, ep)
+		results[gi].LogicSigTrace = sb.String()
+		results[gi].Pass, results[gi].Err = pass, err
+	}
+
+	for gi, gt := range group {
+		result := &results[gi]
+		if !result.Pass || result.Err != nil {
+			continue
+		}
+		if gt.Txn.Type != protocol.ApplicationCallTx {
+			if apply {
+				result.Err = ledger.transfer(&ep.TxnGroup[gi])
+			}
+			result.Pass = result.Err == nil
+			continue
+		}
+		sb := strings.Builder{}
+		ep.Trace = &sb
+		result.App, result.Err = ledger.evalApp(gt.Approval, gi, ep)
+		result.AppTrace = sb.String()
+		result.Pass = result.Err == nil && result.App.Pass
+	}
+	return results
+}
+
+func evalSignature(gi int, ep *logic.EvalParams) (bool, error) {
+	if err := logic.CheckSignature(gi, ep); err != nil {
+		return false, err
+	}
+	return logic.EvalSignature(gi, ep)
+}
+
+// GroupPassed tells if every transaction of the group passed
+func GroupPassed(results []TxnResult) bool {
+	for _, r := range results {
+		if !r.Pass {
+			return false
+		}
+	}
+	return true
+}
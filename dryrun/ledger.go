@@ -0,0 +1,674 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Ledger state for application calls
+//
+//--------------------------------------------------------------------------------------------------
+
+package dryrun
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+	"github.com/algorand/go-algorand/protocol"
+)
+
+// firstCreatableID is the smallest id given to apps and assets created during evaluation
+const firstCreatableID = 1000
+
+// valueDesc is a state value, either Uint or Bytes is set
+type valueDesc struct {
+	Uint  *uint64
+	Bytes *string // base64-encoded
+}
+
+type holdingDesc struct {
+	AssetID uint64
+	Amount  uint64
+	Frozen  bool
+}
+
+type localsDesc struct {
+	AppID uint64
+	State map[string]valueDesc // by key
+}
+
+type accountDesc struct {
+	Address  string // Algorand Address, base32-encoded string with checksum
+	Balance  uint64
+	AuthAddr string // rekeyed to, Algorand Address
+	Assets   []holdingDesc
+	Locals   []localsDesc // apps opted in
+}
+
+type schemaDesc struct {
+	NumUint      uint64
+	NumByteSlice uint64
+}
+
+type appDesc struct {
+	AppID             uint64
+	Creator           string
+	ApprovalProgram   string // base64-encoded bytecode
+	ClearStateProgram string // base64-encoded bytecode
+	GlobalSchema      schemaDesc
+	LocalSchema       schemaDesc
+	Global            map[string]valueDesc // by key
+}
+
+type assetDesc struct {
+	AssetID       uint64
+	Creator       string
+	Total         uint64
+	Decimals      uint32
+	DefaultFrozen bool
+	UnitName      string
+	AssetName     string
+	URL           string
+	MetadataHash  string // base64-encoded
+	Manager       string
+	Reserve       string
+	Freeze        string
+	Clawback      string
+}
+
+type ledgerDesc struct {
+	Round           uint64
+	LatestTimestamp int64
+	Accounts        []accountDesc
+	Apps            []appDesc
+	Assets          []assetDesc
+}
+
+type accountState struct {
+	balance  uint64
+	auth     basics.Address
+	holdings map[basics.AssetIndex]basics.AssetHolding
+	locals   map[basics.AppIndex]basics.TealKeyValue
+}
+
+type appState struct {
+	creator basics.Address
+	params  basics.AppParams
+}
+
+type assetState struct {
+	creator basics.Address
+	params  basics.AssetParams
+}
+
+// Ledger keeps accounts, apps and assets for application calls evaluation.
+// Programs change it in place, changes of rejected calls are undone
+type Ledger struct {
+	round     basics.Round
+	timestamp int64
+	accounts  map[basics.Address]*accountState
+	apps      map[basics.AppIndex]*appState
+	assets    map[basics.AssetIndex]*assetState
+}
+
+// NewLedger creates an empty ledger
+func NewLedger() *Ledger {
+	return &Ledger{
+		round:     1,
+		timestamp: 1,
+		accounts:  make(map[basics.Address]*accountState),
+		apps:      make(map[basics.AppIndex]*appState),
+		assets:    make(map[basics.AssetIndex]*assetState),
+	}
+}
+
+// LoadLedger reads ledger state from JSON file, empty name gives an empty ledger
+func LoadLedger(ledgerFile string) (*Ledger, error) {
+	l := NewLedger()
+	if ledgerFile == "" {
+		return l, nil
+	}
+	data, err := ioutil.ReadFile(ledgerFile)
+	if err != nil {
+		return nil, err
+	}
+	var desc ledgerDesc
+	if err = json.Unmarshal(data, &desc); err != nil {
+		return nil, err
+	}
+	if err = l.load(desc); err != nil {
+		return nil, fmt.Errorf("%s: %s", ledgerFile, err.Error())
+	}
+	return l, nil
+}
+
+// parseAddress accepts empty string as zero address
+func parseAddress(addr string) (basics.Address, error) {
+	if addr == "" {
+		return basics.Address{}, nil
+	}
+	return basics.UnmarshalChecksumAddress(addr)
+}
+
+func parseState(state map[string]valueDesc) (basics.TealKeyValue, error) {
+	kv := make(basics.TealKeyValue, len(state))
+	for key, value := range state {
+		switch {
+		case value.Uint != nil && value.Bytes == nil:
+			kv[key] = basics.TealValue{Type: basics.TealUintType, Uint: *value.Uint}
+		case value.Bytes != nil && value.Uint == nil:
+			data, err := base64.StdEncoding.DecodeString(*value.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s", key, err.Error())
+			}
+			kv[key] = basics.TealValue{Type: basics.TealBytesType, Bytes: string(data)}
+		default:
+			return nil, fmt.Errorf("key %q: either Uint or Bytes must be set", key)
+		}
+	}
+	return kv, nil
+}
+
+func (l *Ledger) load(desc ledgerDesc) (err error) {
+	if desc.Round != 0 {
+		l.round = basics.Round(desc.Round)
+	}
+	if desc.LatestTimestamp != 0 {
+		l.timestamp = desc.LatestTimestamp
+	}
+	for _, ad := range desc.Accounts {
+		var addr basics.Address
+		if addr, err = basics.UnmarshalChecksumAddress(ad.Address); err != nil {
+			return
+		}
+		account := l.account(addr)
+		account.balance = ad.Balance
+		if account.auth, err = parseAddress(ad.AuthAddr); err != nil {
+			return
+		}
+		for _, h := range ad.Assets {
+			account.holdings[basics.AssetIndex(h.AssetID)] = basics.AssetHolding{Amount: h.Amount, Frozen: h.Frozen}
+		}
+		for _, locals := range ad.Locals {
+			var kv basics.TealKeyValue
+			if kv, err = parseState(locals.State); err != nil {
+				return
+			}
+			account.locals[basics.AppIndex(locals.AppID)] = kv
+		}
+	}
+	for _, ad := range desc.Apps {
+		app := &appState{}
+		if app.creator, err = parseAddress(ad.Creator); err != nil {
+			return
+		}
+		if app.params.ApprovalProgram, err = base64.StdEncoding.DecodeString(ad.ApprovalProgram); err != nil {
+			return
+		}
+		if app.params.ClearStateProgram, err = base64.StdEncoding.DecodeString(ad.ClearStateProgram); err != nil {
+			return
+		}
+		app.params.GlobalStateSchema = basics.StateSchema{NumUint: ad.GlobalSchema.NumUint, NumByteSlice: ad.GlobalSchema.NumByteSlice}
+		app.params.LocalStateSchema = basics.StateSchema{NumUint: ad.LocalSchema.NumUint, NumByteSlice: ad.LocalSchema.NumByteSlice}
+		if app.params.GlobalState, err = parseState(ad.Global); err != nil {
+			return
+		}
+		l.apps[basics.AppIndex(ad.AppID)] = app
+	}
+	for _, ad := range desc.Assets {
+		asset := &assetState{}
+		if asset.creator, err = parseAddress(ad.Creator); err != nil {
+			return
+		}
+		p := &asset.params
+		p.Total, p.Decimals, p.DefaultFrozen = ad.Total, ad.Decimals, ad.DefaultFrozen
+		p.UnitName, p.AssetName, p.URL = ad.UnitName, ad.AssetName, ad.URL
+		var hash []byte
+		if hash, err = base64.StdEncoding.DecodeString(ad.MetadataHash); err != nil {
+			return
+		}
+		copy(p.MetadataHash[:], hash)
+		for _, a := range []struct {
+			dst *basics.Address
+			src string
+		}{{&p.Manager, ad.Manager}, {&p.Reserve, ad.Reserve}, {&p.Freeze, ad.Freeze}, {&p.Clawback, ad.Clawback}} {
+			if *a.dst, err = parseAddress(a.src); err != nil {
+				return
+			}
+		}
+		l.assets[basics.AssetIndex(ad.AssetID)] = asset
+	}
+	return nil
+}
+
+// ledgerState is a copy of accounts, apps and assets taken to undo changes of rejected app calls
+type ledgerState struct {
+	accounts map[basics.Address]*accountState
+	apps     map[basics.AppIndex]*appState
+	assets   map[basics.AssetIndex]*assetState
+}
+
+// snapshot copies state that app calls and their inner transactions can change
+func (l *Ledger) snapshot() ledgerState {
+	s := ledgerState{
+		accounts: make(map[basics.Address]*accountState, len(l.accounts)),
+		apps:     make(map[basics.AppIndex]*appState, len(l.apps)),
+		assets:   make(map[basics.AssetIndex]*assetState, len(l.assets)),
+	}
+	for addr, account := range l.accounts {
+		copied := &accountState{
+			balance:  account.balance,
+			auth:     account.auth,
+			holdings: make(map[basics.AssetIndex]basics.AssetHolding, len(account.holdings)),
+			locals:   make(map[basics.AppIndex]basics.TealKeyValue, len(account.locals)),
+		}
+		for aid, holding := range account.holdings {
+			copied.holdings[aid] = holding
+		}
+		for aid, kv := range account.locals {
+			copied.locals[aid] = kv.Clone()
+		}
+		s.accounts[addr] = copied
+	}
+	for aid, app := range l.apps {
+		copied := *app
+		copied.params.GlobalState = app.params.GlobalState.Clone()
+		s.apps[aid] = &copied
+	}
+	for aid, asset := range l.assets {
+		copied := *asset
+		s.assets[aid] = &copied
+	}
+	return s
+}
+
+// restore brings back state saved by snapshot
+func (l *Ledger) restore(s ledgerState) {
+	l.accounts, l.apps, l.assets = s.accounts, s.apps, s.assets
+}
+
+// account returns the account state creating an empty one if needed
+func (l *Ledger) account(addr basics.Address) *accountState {
+	account, ok := l.accounts[addr]
+	if !ok {
+		account = &accountState{
+			holdings: make(map[basics.AssetIndex]basics.AssetHolding),
+			locals:   make(map[basics.AppIndex]basics.TealKeyValue),
+		}
+		l.accounts[addr] = account
+	}
+	return account
+}
+
+// nextID returns an id not used by apps and assets
+func (l *Ledger) nextID() uint64 {
+	id := uint64(firstCreatableID)
+	for {
+		_, app := l.apps[basics.AppIndex(id)]
+		_, asset := l.assets[basics.AssetIndex(id)]
+		if !app && !asset {
+			return id
+		}
+		id++
+	}
+}
+
+// createApp registers a new app created by sender
+func (l *Ledger) createApp(sender basics.Address, appl transactions.ApplicationCallTxnFields) basics.AppIndex {
+	aid := basics.AppIndex(l.nextID())
+	l.apps[aid] = &appState{
+		creator: sender,
+		params: basics.AppParams{
+			ApprovalProgram:   appl.ApprovalProgram,
+			ClearStateProgram: appl.ClearStateProgram,
+			GlobalState:       make(basics.TealKeyValue),
+			StateSchemas: basics.StateSchemas{
+				LocalStateSchema:  appl.LocalStateSchema,
+				GlobalStateSchema: appl.GlobalStateSchema,
+			},
+			ExtraProgramPages: appl.ExtraProgramPages,
+		},
+	}
+	return aid
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// logic.LedgerForLogic implementation
+//
+//--------------------------------------------------------------------------------------------------
+
+// AccountData returns balance, holdings and created resources of the account
+func (l *Ledger) AccountData(addr basics.Address) (basics.AccountData, error) {
+	account := l.account(addr)
+	data := basics.AccountData{
+		MicroAlgos:     basics.MicroAlgos{Raw: account.balance},
+		AuthAddr:       account.auth,
+		Assets:         account.holdings,
+		AssetParams:    make(map[basics.AssetIndex]basics.AssetParams),
+		AppParams:      make(map[basics.AppIndex]basics.AppParams),
+		AppLocalStates: make(map[basics.AppIndex]basics.AppLocalState),
+	}
+	for aid, asset := range l.assets {
+		if asset.creator == addr {
+			data.AssetParams[aid] = asset.params
+		}
+	}
+	for aid, app := range l.apps {
+		if app.creator == addr {
+			data.AppParams[aid] = app.params
+			data.TotalAppSchema = data.TotalAppSchema.AddSchema(app.params.GlobalStateSchema)
+			data.TotalExtraAppPages += app.params.ExtraProgramPages
+		}
+	}
+	for aid, kv := range account.locals {
+		data.AppLocalStates[aid] = basics.AppLocalState{KeyValue: kv}
+		if app, ok := l.apps[aid]; ok {
+			data.TotalAppSchema = data.TotalAppSchema.AddSchema(app.params.LocalStateSchema)
+		}
+	}
+	return data, nil
+}
+
+// Authorizer returns the address account is rekeyed to or the account itself
+func (l *Ledger) Authorizer(addr basics.Address) (basics.Address, error) {
+	if account, ok := l.accounts[addr]; ok && !account.auth.IsZero() {
+		return account.auth, nil
+	}
+	return addr, nil
+}
+
+// Round returns the current round
+func (l *Ledger) Round() basics.Round {
+	return l.round
+}
+
+// LatestTimestamp returns timestamp of the latest block
+func (l *Ledger) LatestTimestamp() int64 {
+	return l.timestamp
+}
+
+// AssetHolding returns asset balance of the account
+func (l *Ledger) AssetHolding(addr basics.Address, aidx basics.AssetIndex) (basics.AssetHolding, error) {
+	if account, ok := l.accounts[addr]; ok {
+		if holding, ok := account.holdings[aidx]; ok {
+			return holding, nil
+		}
+	}
+	return basics.AssetHolding{}, fmt.Errorf("account %s is not opted in asset %d", addr, aidx)
+}
+
+// AssetParams returns asset parameters and its creator
+func (l *Ledger) AssetParams(aidx basics.AssetIndex) (basics.AssetParams, basics.Address, error) {
+	if asset, ok := l.assets[aidx]; ok {
+		return asset.params, asset.creator, nil
+	}
+	return basics.AssetParams{}, basics.Address{}, fmt.Errorf("no such asset %d", aidx)
+}
+
+// AppParams returns app parameters and its creator
+func (l *Ledger) AppParams(aidx basics.AppIndex) (basics.AppParams, basics.Address, error) {
+	if app, ok := l.apps[aidx]; ok {
+		return app.params, app.creator, nil
+	}
+	return basics.AppParams{}, basics.Address{}, fmt.Errorf("no such app %d", aidx)
+}
+
+// OptedIn tells if the account has local state of the app
+func (l *Ledger) OptedIn(addr basics.Address, appIdx basics.AppIndex) (bool, error) {
+	if account, ok := l.accounts[addr]; ok {
+		_, ok = account.locals[appIdx]
+		return ok, nil
+	}
+	return false, nil
+}
+
+func (l *Ledger) locals(addr basics.Address, appIdx basics.AppIndex) (basics.TealKeyValue, error) {
+	if account, ok := l.accounts[addr]; ok {
+		if kv, ok := account.locals[appIdx]; ok {
+			return kv, nil
+		}
+	}
+	return nil, fmt.Errorf("account %s is not opted in app %d", addr, appIdx)
+}
+
+// GetLocal returns local state value of the account
+func (l *Ledger) GetLocal(addr basics.Address, appIdx basics.AppIndex, key string, accountIdx uint64) (basics.TealValue, bool, error) {
+	kv, err := l.locals(addr, appIdx)
+	if err != nil {
+		return basics.TealValue{}, false, err
+	}
+	value, ok := kv[key]
+	return value, ok, nil
+}
+
+// SetLocal changes local state value of the account
+func (l *Ledger) SetLocal(addr basics.Address, appIdx basics.AppIndex, key string, value basics.TealValue, accountIdx uint64) error {
+	kv, err := l.locals(addr, appIdx)
+	if err != nil {
+		return err
+	}
+	kv[key] = value
+	return nil
+}
+
+// DelLocal removes local state value of the account
+func (l *Ledger) DelLocal(addr basics.Address, appIdx basics.AppIndex, key string, accountIdx uint64) error {
+	kv, err := l.locals(addr, appIdx)
+	if err != nil {
+		return err
+	}
+	delete(kv, key)
+	return nil
+}
+
+// GetGlobal returns global state value of the app
+func (l *Ledger) GetGlobal(appIdx basics.AppIndex, key string) (basics.TealValue, bool, error) {
+	app, ok := l.apps[appIdx]
+	if !ok {
+		return basics.TealValue{}, false, fmt.Errorf("no such app %d", appIdx)
+	}
+	value, ok := app.params.GlobalState[key]
+	return value, ok, nil
+}
+
+// SetGlobal changes global state value of the app
+func (l *Ledger) SetGlobal(appIdx basics.AppIndex, key string, value basics.TealValue) error {
+	app, ok := l.apps[appIdx]
+	if !ok {
+		return fmt.Errorf("no such app %d", appIdx)
+	}
+	app.params.GlobalState[key] = value
+	return nil
+}
+
+// DelGlobal removes global state value of the app
+func (l *Ledger) DelGlobal(appIdx basics.AppIndex, key string) error {
+	app, ok := l.apps[appIdx]
+	if !ok {
+		return fmt.Errorf("no such app %d", appIdx)
+	}
+	delete(app.params.GlobalState, key)
+	return nil
+}
+
+// Counter is used for ids of created apps and assets
+func (l *Ledger) Counter() uint64 {
+	return l.nextID()
+}
+
+// Perform applies inner transaction to the ledger
+func (l *Ledger) Perform(gi int, ep *logic.EvalParams) error {
+	stxn := &ep.TxnGroup[gi]
+	txn := &stxn.Txn
+	if err := l.move(txn.Sender, ep.Specials.FeeSink, txn.Fee.Raw); err != nil {
+		return err
+	}
+	if !txn.RekeyTo.IsZero() {
+		account := l.account(txn.Sender)
+		account.auth = txn.RekeyTo
+		if txn.RekeyTo == txn.Sender {
+			account.auth = basics.Address{}
+		}
+	}
+
+	if txn.Type == protocol.ApplicationCallTx {
+		return l.callApp(gi, ep)
+	}
+	return l.transfer(stxn)
+}
+
+// transfer applies effects of transactions other than app calls
+func (l *Ledger) transfer(stxn *transactions.SignedTxnWithAD) error {
+	txn := &stxn.Txn
+	switch txn.Type {
+	case protocol.PaymentTx:
+		return l.pay(txn.Sender, txn.PaymentTxnFields)
+	case protocol.AssetTransferTx:
+		return l.transferAsset(txn.Sender, txn.AssetTransferTxnFields)
+	case protocol.AssetConfigTx:
+		return l.configAsset(txn.Sender, txn.AssetConfigTxnFields, &stxn.ApplyData)
+	case protocol.AssetFreezeTx:
+		return l.freezeAsset(txn.Sender, txn.AssetFreezeTxnFields)
+	case protocol.KeyRegistrationTx:
+		return nil
+	default:
+		return fmt.Errorf("%s transaction is not supported", txn.Type)
+	}
+}
+
+func (l *Ledger) move(from basics.Address, to basics.Address, amount uint64) error {
+	sender := l.account(from)
+	if sender.balance < amount {
+		return fmt.Errorf("account %s balance %d is below %d", from, sender.balance, amount)
+	}
+	sender.balance -= amount
+	l.account(to).balance += amount
+	return nil
+}
+
+func (l *Ledger) pay(from basics.Address, pay transactions.PaymentTxnFields) error {
+	if err := l.move(from, pay.Receiver, pay.Amount.Raw); err != nil {
+		return err
+	}
+	if !pay.CloseRemainderTo.IsZero() {
+		return l.move(from, pay.CloseRemainderTo, l.account(from).balance)
+	}
+	return nil
+}
+
+func (l *Ledger) transferAsset(from basics.Address, xfer transactions.AssetTransferTxnFields) error {
+	aid := xfer.XferAsset
+	if !xfer.AssetSender.IsZero() {
+		// clawback
+		from = xfer.AssetSender
+	}
+	sender := l.account(from)
+	holding, ok := sender.holdings[aid]
+	if !ok {
+		if from == xfer.AssetReceiver && xfer.AssetAmount == 0 {
+			// opt in
+			asset, ok := l.assets[aid]
+			if !ok {
+				return fmt.Errorf("no such asset %d", aid)
+			}
+			sender.holdings[aid] = basics.AssetHolding{Frozen: asset.params.DefaultFrozen}
+			return nil
+		}
+		return fmt.Errorf("account %s is not opted in asset %d", from, aid)
+	}
+	if holding.Amount < xfer.AssetAmount {
+		return fmt.Errorf("account %s asset %d balance %d is below %d", from, aid, holding.Amount, xfer.AssetAmount)
+	}
+	receiver := l.account(xfer.AssetReceiver)
+	received, ok := receiver.holdings[aid]
+	if !ok && xfer.AssetAmount > 0 {
+		return fmt.Errorf("account %s is not opted in asset %d", xfer.AssetReceiver, aid)
+	}
+	holding.Amount -= xfer.AssetAmount
+	sender.holdings[aid] = holding
+	received.Amount += xfer.AssetAmount
+	receiver.holdings[aid] = received
+
+	if !xfer.AssetCloseTo.IsZero() {
+		closeTo := l.account(xfer.AssetCloseTo)
+		closed, ok := closeTo.holdings[aid]
+		if !ok {
+			return fmt.Errorf("account %s is not opted in asset %d", xfer.AssetCloseTo, aid)
+		}
+		closed.Amount += sender.holdings[aid].Amount
+		closeTo.holdings[aid] = closed
+		delete(sender.holdings, aid)
+	}
+	return nil
+}
+
+func (l *Ledger) configAsset(from basics.Address, cfg transactions.AssetConfigTxnFields, ad *transactions.ApplyData) error {
+	if cfg.ConfigAsset == 0 {
+		aid := basics.AssetIndex(l.nextID())
+		l.assets[aid] = &assetState{creator: from, params: cfg.AssetParams}
+		l.account(from).holdings[aid] = basics.AssetHolding{Amount: cfg.AssetParams.Total, Frozen: cfg.AssetParams.DefaultFrozen}
+		ad.ConfigAsset = aid
+		return nil
+	}
+	asset, ok := l.assets[cfg.ConfigAsset]
+	if !ok {
+		return fmt.Errorf("no such asset %d", cfg.ConfigAsset)
+	}
+	if (cfg.AssetParams == basics.AssetParams{}) {
+		delete(l.assets, cfg.ConfigAsset)
+		delete(l.account(asset.creator).holdings, cfg.ConfigAsset)
+		return nil
+	}
+	asset.params.Manager = cfg.AssetParams.Manager
+	asset.params.Reserve = cfg.AssetParams.Reserve
+	asset.params.Freeze = cfg.AssetParams.Freeze
+	asset.params.Clawback = cfg.AssetParams.Clawback
+	return nil
+}
+
+func (l *Ledger) freezeAsset(from basics.Address, frz transactions.AssetFreezeTxnFields) error {
+	asset, ok := l.assets[frz.FreezeAsset]
+	if !ok {
+		return fmt.Errorf("no such asset %d", frz.FreezeAsset)
+	}
+	if asset.params.Freeze != from {
+		return fmt.Errorf("asset %d can't be frozen by %s", frz.FreezeAsset, from)
+	}
+	account := l.account(frz.FreezeAccount)
+	holding, ok := account.holdings[frz.FreezeAsset]
+	if !ok {
+		return fmt.Errorf("account %s is not opted in asset %d", frz.FreezeAccount, frz.FreezeAsset)
+	}
+	holding.Frozen = frz.AssetFrozen
+	account.holdings[frz.FreezeAsset] = holding
+	return nil
+}
+
+// callApp evaluates program of the app call and applies on completion action
+func (l *Ledger) callApp(gi int, ep *logic.EvalParams) error {
+	result, err := l.evalApp(nil, gi, ep)
+	if err != nil {
+		return err
+	}
+	if !result.Pass {
+		return fmt.Errorf("app %d rejected the call", result.AppID)
+	}
+	return nil
+}
+
+// complete applies on completion action of app call
+func (l *Ledger) complete(sender basics.Address, aid basics.AppIndex, appl transactions.ApplicationCallTxnFields) error {
+	switch appl.OnCompletion {
+	case transactions.CloseOutOC, transactions.ClearStateOC:
+		delete(l.account(sender).locals, aid)
+	case transactions.DeleteApplicationOC:
+		delete(l.apps, aid)
+	case transactions.UpdateApplicationOC:
+		app := l.apps[aid]
+		app.params.ApprovalProgram = appl.ApprovalProgram
+		app.params.ClearStateProgram = appl.ClearStateProgram
+	}
+	return nil
+}
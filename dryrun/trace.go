@@ -0,0 +1,287 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Structured evaluation traces
+//
+//--------------------------------------------------------------------------------------------------
+
+package dryrun
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+)
+
+// TraceValue is a typed stack or scratch value, bytes are base64-encoded in JSON
+type TraceValue struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// ScratchAccess is a scratch slot read or written by an instruction, Value is the slot content after the step
+type ScratchAccess struct {
+	Slot     int        `json:"slot"`
+	Variable string     `json:"variable,omitempty"`
+	Value    TraceValue `json:"value"`
+	Write    bool       `json:"write"`
+}
+
+// TraceStep describes a single evaluated instruction, stack and budget are taken after the step
+type TraceStep struct {
+	PC       int             `json:"pc"`
+	Op       string          `json:"op"`
+	Location string          `json:"location,omitempty"`
+	Stack    []TraceValue    `json:"stack"`
+	Scratch  []ScratchAccess `json:"scratch,omitempty"`
+	Budget   int             `json:"budget"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// SourceInfo returns source location and the variable loaded or stored by an instruction at pc,
+// empty strings mean unknown
+type SourceInfo func(pc int) (location string, variable string)
+
+// Tracer is a debugger hook recording evaluation steps
+type Tracer struct {
+	Steps []TraceStep
+
+	source    SourceInfo
+	lines     []string
+	scratch   []basics.TealValue
+	pending   *TraceStep
+	readSlot  int
+	writeSlot int
+	slotNames map[int]string
+}
+
+// NewTracer makes a tracer annotating steps with source info if provided
+func NewTracer(source SourceInfo) *Tracer {
+	return &Tracer{source: source, slotNames: make(map[int]string)}
+}
+
+// Register is fired on program start
+func (t *Tracer) Register(state *logic.DebugState) error {
+	t.lines = strings.Split(state.Disassembly, "\n")
+	t.scratch = nil
+	t.pending = nil
+	return nil
+}
+
+// Update is fired before every step, it completes the previous one
+func (t *Tracer) Update(state *logic.DebugState) error {
+	t.finish(state)
+
+	step := TraceStep{PC: state.PC}
+	if state.Line >= 0 && state.Line < len(t.lines) {
+		step.Op = strings.TrimSpace(t.lines[state.Line])
+	}
+	variable := ""
+	if t.source != nil {
+		step.Location, variable = t.source(state.PC)
+	}
+
+	// remember slots accessed by the instruction, other writes are found by comparing scratch space after the step
+	t.readSlot, t.writeSlot = -1, -1
+	fields := strings.Fields(step.Op)
+	stack := state.Stack
+	if len(fields) > 0 {
+		switch fields[0] {
+		case "load", "store":
+			if len(fields) > 1 {
+				if slot, err := strconv.Atoi(fields[1]); err == nil {
+					if variable != "" {
+						t.slotNames[slot] = variable
+					}
+					if fields[0] == "load" {
+						t.readSlot = slot
+					} else {
+						t.writeSlot = slot
+					}
+				}
+			}
+		case "loads":
+			if n := len(stack); n > 0 && stack[n-1].Type == basics.TealUintType {
+				t.readSlot = int(stack[n-1].Uint)
+			}
+		case "stores":
+			if n := len(stack); n > 1 && stack[n-2].Type == basics.TealUintType {
+				t.writeSlot = int(stack[n-2].Uint)
+			}
+		}
+	}
+	t.scratch = state.Scratch
+	t.pending = &step
+	return nil
+}
+
+// Complete is called when the program exits
+func (t *Tracer) Complete(state *logic.DebugState) error {
+	t.finish(state)
+	if state.Error != "" && len(t.Steps) > 0 {
+		t.Steps[len(t.Steps)-1].Error = state.Error
+	}
+	return nil
+}
+
+// finish fills results of the pending step from the state after it
+func (t *Tracer) finish(state *logic.DebugState) {
+	step := t.pending
+	if step == nil {
+		return
+	}
+	t.pending = nil
+	step.Budget = state.OpcodeBudget
+	step.Stack = make([]TraceValue, len(state.Stack))
+	for i, v := range state.Stack {
+		step.Stack[i] = traceValue(v)
+	}
+	for slot, v := range state.Scratch {
+		write := slot == t.writeSlot || slot >= len(t.scratch) || t.scratch[slot] != v
+		if !write && slot != t.readSlot {
+			continue
+		}
+		step.Scratch = append(step.Scratch, ScratchAccess{Slot: slot, Variable: t.slotNames[slot], Value: traceValue(v), Write: write})
+	}
+	t.Steps = append(t.Steps, *step)
+}
+
+// traceValue converts debugger state value, its bytes are base64-encoded
+func traceValue(v basics.TealValue) TraceValue {
+	if v.Type == basics.TealBytesType {
+		data, _ := base64.StdEncoding.DecodeString(v.Bytes)
+		return TraceValue{Type: "bytes", Value: data}
+	}
+	return TraceValue{Type: "uint64", Value: v.Uint}
+}
+
+// String formats the value like tealang literals
+func (v TraceValue) String() string {
+	if data, ok := v.Value.([]byte); ok {
+		return FormatBytes(data)
+	}
+	return fmt.Sprintf("%v", v.Value)
+}
+
+// WriteJSON writes steps as JSON array, a step per line
+func (t *Tracer) WriteJSON(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i, step := range t.Steps {
+		data, err := json.Marshal(step)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\n  ")
+		sb.Write(data)
+	}
+	sb.WriteString("\n]\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// tableStackDepth limits stack values shown in table, the top ones are kept
+const tableStackDepth = 4
+
+// tableValueWidth limits width of values shown in table, longer ones are cut in the middle
+const tableValueWidth = 24
+
+func shortValue(v TraceValue) string {
+	text := v.String()
+	if len(text) <= tableValueWidth {
+		return text
+	}
+	half := (tableValueWidth - 3) / 2
+	return text[:half] + "..." + text[len(text)-half:]
+}
+
+// WriteTable writes steps as a compact table naming scratch slots after tealang variables
+func (t *Tracer) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PC\tOP\tSTACK\tSCRATCH\tBUDGET\tSOURCE")
+	for _, step := range t.Steps {
+		values := make([]string, 0, tableStackDepth+1)
+		start := 0
+		if len(step.Stack) > tableStackDepth {
+			start = len(step.Stack) - tableStackDepth
+			values = append(values, "...")
+		}
+		for _, v := range step.Stack[start:] {
+			values = append(values, shortValue(v))
+		}
+		slots := make([]string, 0, len(step.Scratch))
+		for _, s := range step.Scratch {
+			name := s.Variable
+			if name == "" {
+				name = fmt.Sprintf("s%d", s.Slot)
+			}
+			if s.Write {
+				slots = append(slots, fmt.Sprintf("%s=%s", name, shortValue(s.Value)))
+			} else {
+				slots = append(slots, name)
+			}
+		}
+		location := step.Location
+		if step.Error != "" {
+			location = strings.TrimSpace(location + " ERROR: " + step.Error)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t[%s]\t%s\t%d\t%s\n", step.PC, step.Op, strings.Join(values, " "), strings.Join(slots, " "), step.Budget, location)
+	}
+	return tw.Flush()
+}
+
+// hooks forwards debugger events to several hooks
+type hooks []logic.DebuggerHook
+
+// CombineHooks makes a hook notifying every non-nil hook, nil if there is none
+func CombineHooks(list ...logic.DebuggerHook) logic.DebuggerHook {
+	var hs hooks
+	for _, h := range list {
+		if h != nil {
+			hs = append(hs, h)
+		}
+	}
+	switch len(hs) {
+	case 0:
+		return nil
+	case 1:
+		return hs[0]
+	}
+	return hs
+}
+
+func (hs hooks) Register(state *logic.DebugState) error {
+	for _, h := range hs {
+		if err := h.Register(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hooks) Update(state *logic.DebugState) error {
+	for _, h := range hs {
+		if err := h.Update(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hooks) Complete(state *logic.DebugState) error {
+	for _, h := range hs {
+		if err := h.Complete(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Transaction fixtures
+//
+//--------------------------------------------------------------------------------------------------
+
+package dryrun
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+	"github.com/algorand/go-algorand/protocol"
+)
+
+// LoadTxn reads transaction data from txnFile, empty name selects a sample payment transaction.
+// Besides tealang JSON format the file may contain signed transactions written by goal clerk
+// as msgpack or JSON, the first one is taken then
+func LoadTxn(txnFile string) (txn transactions.Transaction, err error) {
+	var txnData []byte
+	if txnFile != "" {
+		txnData, err = ioutil.ReadFile(txnFile)
+		if err != nil {
+			return
+		}
+	} else {
+		txnData = sampleTxnData
+	}
+
+	if !isJSON(txnData) {
+		var stxns []transactions.SignedTxn
+		if stxns, err = decodeSignedTxns(txnData); err != nil {
+			return
+		}
+		return stxns[0].Txn, nil
+	}
+	if isSignedTxnJSON(txnData) {
+		var stxn transactions.SignedTxn
+		err = protocol.DecodeJSON(txnData, &stxn)
+		return stxn.Txn, err
+	}
+
+	var sampleTxn txnDesc
+	err = json.Unmarshal(txnData, &sampleTxn)
+	if err != nil {
+		return
+	}
+	return sampleTxn.transaction()
+}
+
+// isJSON tells JSON data from msgpack one
+func isJSON(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && (data[0] == '{' || data[0] == '[')
+}
+
+// isSignedTxnJSON detects signed transaction written by goal, it keeps transaction fields under "txn" key
+func isSignedTxnJSON(data []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false
+	}
+	_, ok := fields["txn"]
+	return ok
+}
+
+// decodeSignedTxns reads concatenated msgpack-encoded signed transactions like goal clerk writes
+func decodeSignedTxns(data []byte) ([]transactions.SignedTxn, error) {
+	var stxns []transactions.SignedTxn
+	dec := protocol.NewDecoderBytes(data)
+	for {
+		var stxn transactions.SignedTxn
+		err := dec.Decode(&stxn)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		stxns = append(stxns, stxn)
+	}
+	if len(stxns) == 0 {
+		return nil, fmt.Errorf("no transactions found")
+	}
+	return stxns, nil
+}
+
+// decodeFixed decodes base64 value into fixed size field,
+// shorter values are allowed for fields like lease that are padded with zeros
+func decodeFixed(dst []byte, value string, name string, padded bool) error {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err.Error())
+	}
+	if len(data) > len(dst) || (!padded && len(data) != 0 && len(data) != len(dst)) {
+		return fmt.Errorf("%s must be %d bytes long, got %d", name, len(dst), len(data))
+	}
+	copy(dst, data)
+	return nil
+}
+
+// transaction converts JSON transaction description
+func (sampleTxn *txnDesc) transaction() (txn transactions.Transaction, err error) {
+	txn.Type = protocol.TxType(sampleTxn.Type)
+	if txn.Type == "" && sampleTxn.TypeEnum > 0 {
+		if sampleTxn.TypeEnum >= uint64(len(logic.TxnTypeNames)) {
+			return txn, fmt.Errorf("invalid TypeEnum %d", sampleTxn.TypeEnum)
+		}
+		txn.Type = protocol.TxType(logic.TxnTypeNames[sampleTxn.TypeEnum])
+	}
+
+	if txn.Sender, err = parseAddress(sampleTxn.Sender); err != nil {
+		return
+	}
+	txn.Fee = basics.MicroAlgos{Raw: sampleTxn.Fee}
+	txn.FirstValid = basics.Round(sampleTxn.FirstValid)
+	txn.LastValid = basics.Round(sampleTxn.LastValid)
+	if txn.Note, err = base64.StdEncoding.DecodeString(sampleTxn.Note); err != nil {
+		return
+	}
+	if err = decodeFixed(txn.Lease[:], sampleTxn.Lease, "Lease", true); err != nil {
+		return
+	}
+	if txn.RekeyTo, err = parseAddress(sampleTxn.RekeyTo); err != nil {
+		return
+	}
+
+	txn.Amount = basics.MicroAlgos{Raw: sampleTxn.Amount}
+	if txn.Receiver, err = parseAddress(sampleTxn.Receiver); err != nil {
+		return
+	}
+	if txn.CloseRemainderTo, err = parseAddress(sampleTxn.CloseRemainderTo); err != nil {
+		return
+	}
+
+	if err = decodeFixed(txn.VotePK[:], sampleTxn.VotePK, "VotePK", false); err != nil {
+		return
+	}
+	if err = decodeFixed(txn.SelectionPK[:], sampleTxn.SelectionPK, "SelectionPK", false); err != nil {
+		return
+	}
+	if err = decodeFixed(txn.StateProofPK[:], sampleTxn.StateProofPK, "StateProofPK", false); err != nil {
+		return
+	}
+	txn.VoteFirst = basics.Round(sampleTxn.VoteFirst)
+	txn.VoteLast = basics.Round(sampleTxn.VoteLast)
+	txn.VoteKeyDilution = sampleTxn.VoteKeyDilution
+	txn.Nonparticipation = sampleTxn.Nonparticipation != 0
+
+	txn.XferAsset = basics.AssetIndex(sampleTxn.XferAsset)
+	txn.AssetAmount = sampleTxn.AssetAmount
+	if txn.AssetSender, err = parseAddress(sampleTxn.AssetSender); err != nil {
+		return
+	}
+	if txn.AssetReceiver, err = parseAddress(sampleTxn.AssetReceiver); err != nil {
+		return
+	}
+	if txn.AssetCloseTo, err = parseAddress(sampleTxn.AssetCloseTo); err != nil {
+		return
+	}
+
+	txn.ConfigAsset = basics.AssetIndex(sampleTxn.ConfigAsset)
+	params := &txn.AssetParams
+	params.Total = sampleTxn.ConfigAssetTotal
+	params.Decimals = uint32(sampleTxn.ConfigAssetDecimals)
+	params.DefaultFrozen = sampleTxn.ConfigAssetDefaultFrozen != 0
+	params.UnitName = sampleTxn.ConfigAssetUnitName
+	params.AssetName = sampleTxn.ConfigAssetName
+	params.URL = sampleTxn.ConfigAssetURL
+	if err = decodeFixed(params.MetadataHash[:], sampleTxn.ConfigAssetMetadataHash, "ConfigAssetMetadataHash", true); err != nil {
+		return
+	}
+	if params.Manager, err = parseAddress(sampleTxn.ConfigAssetManager); err != nil {
+		return
+	}
+	if params.Reserve, err = parseAddress(sampleTxn.ConfigAssetReserve); err != nil {
+		return
+	}
+	if params.Freeze, err = parseAddress(sampleTxn.ConfigAssetFreeze); err != nil {
+		return
+	}
+	if params.Clawback, err = parseAddress(sampleTxn.ConfigAssetClawback); err != nil {
+		return
+	}
+
+	txn.FreezeAsset = basics.AssetIndex(sampleTxn.FreezeAsset)
+	if txn.FreezeAccount, err = parseAddress(sampleTxn.FreezeAssetAccount); err != nil {
+		return
+	}
+	txn.AssetFrozen = sampleTxn.FreezeAssetFrozen != 0
+
+	txn.ApplicationID = basics.AppIndex(sampleTxn.ApplicationID)
+	txn.OnCompletion = transactions.OnCompletion(sampleTxn.OnCompletion)
+	for _, arg := range sampleTxn.ApplicationArgs {
+		var data []byte
+		if data, err = base64.StdEncoding.DecodeString(arg); err != nil {
+			return
+		}
+		txn.ApplicationArgs = append(txn.ApplicationArgs, data)
+	}
+	for _, account := range sampleTxn.Accounts {
+		var addr basics.Address
+		if addr, err = basics.UnmarshalChecksumAddress(account); err != nil {
+			return
+		}
+		txn.Accounts = append(txn.Accounts, addr)
+	}
+	for _, app := range sampleTxn.Applications {
+		txn.ForeignApps = append(txn.ForeignApps, basics.AppIndex(app))
+	}
+	for _, asset := range sampleTxn.Assets {
+		txn.ForeignAssets = append(txn.ForeignAssets, basics.AssetIndex(asset))
+	}
+	if txn.ApprovalProgram, err = base64.StdEncoding.DecodeString(sampleTxn.ApprovalProgram); err != nil {
+		return
+	}
+	if txn.ClearStateProgram, err = base64.StdEncoding.DecodeString(sampleTxn.ClearStateProgram); err != nil {
+		return
+	}
+	txn.GlobalStateSchema = basics.StateSchema{NumUint: sampleTxn.GlobalNumUint, NumByteSlice: sampleTxn.GlobalNumByteSlice}
+	txn.LocalStateSchema = basics.StateSchema{NumUint: sampleTxn.LocalNumUint, NumByteSlice: sampleTxn.LocalNumByteSlice}
+	txn.ExtraProgramPages = uint32(sampleTxn.ExtraProgramPages)
+
+	return txn, nil
+}
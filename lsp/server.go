@@ -0,0 +1,251 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Language server
+//
+//--------------------------------------------------------------------------------------------------
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+
+	"github.com/pzbitskiy/tealang/compiler"
+)
+
+// Server answers Language Server Protocol requests about tealang documents.
+// Documents are synchronized in full and analyzed on every change
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	opts        compiler.Options
+	documents   map[string]*compiler.Analysis
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer creates a server reading client messages from in and writing responses to out.
+// Options select target TEAL version and module resolver used for analysis
+func NewServer(in io.Reader, out io.Writer, opts compiler.Options) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		opts:      opts,
+		documents: make(map[string]*compiler.Analysis),
+	}
+}
+
+// Run serves the client until exit notification or the end of input.
+// It fails if the client leaves without shutdown request
+func (s *Server) Run() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF || msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("client exited without shutdown")
+			}
+			return nil
+		}
+		if err != nil {
+			// malformed content is reported to the client, broken framing stops the server
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) {
+				return err
+			}
+			null := json.RawMessage("null")
+			err = s.reply(&null, nil, &responseError{parseError, err.Error()})
+		} else {
+			err = s.handle(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg message) error {
+	if !s.initialized && msg.Method != "initialize" {
+		if msg.ID == nil {
+			return nil
+		}
+		return s.reply(msg.ID, nil, &responseError{serverNotInitialized, "server not initialized"})
+	}
+
+	var result interface{}
+	var err error
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		result = initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   textDocumentSyncOptions{OpenClose: true, Change: 1},
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				CompletionProvider: completionOptions{TriggerCharacters: []string{"."}},
+			},
+			ServerInfo: serverInfo{Name: "tealang"},
+		}
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			return s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			delete(s.documents, params.TextDocument.URI)
+			return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{params.TextDocument.URI, []diagnostic{}})
+		}
+	case "textDocument/hover":
+		result, err = s.hover(msg.Params)
+	case "textDocument/definition":
+		result, err = s.definition(msg.Params)
+	case "textDocument/completion":
+		result, err = s.completion(msg.Params)
+	default:
+		// unsupported notifications like $/cancelRequest are ignored
+		if msg.ID == nil {
+			return nil
+		}
+		return s.reply(msg.ID, nil, &responseError{methodNotFound, fmt.Sprintf("method %s not supported", msg.Method)})
+	}
+	if msg.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return s.reply(msg.ID, nil, &responseError{invalidParams, err.Error()})
+	}
+	return s.reply(msg.ID, result, nil)
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, respErr *responseError) error {
+	msg := message{ID: id, Error: respErr}
+	if respErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		msg.Result = data
+	}
+	return writeMessage(s.out, msg)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, message{Method: method, Params: data})
+}
+
+// update analyzes new document text and publishes its diagnostics
+func (s *Server) update(uri string, text string) error {
+	input := compiler.InputDesc{Source: text}
+	if file, ok := uriToPath(uri); ok {
+		input.SourceFile = path.Base(file)
+		input.SourceDir = path.Dir(file)
+		input.CurrentDir = input.SourceDir
+	}
+	analysis := compiler.Analyze(input, s.opts)
+	s.documents[uri] = analysis
+
+	diagnostics := make([]diagnostic, 0, len(analysis.Diagnostics))
+	for _, e := range analysis.Diagnostics {
+		d := e.Diagnostic()
+		item := diagnostic{Severity: severityError, Code: d.Code, Source: "tealang", Message: d.Message}
+		if d.Severity == "warning" {
+			item.Severity = severityWarning
+		}
+		if d.File == input.SourceFile && d.Line > 0 {
+			item.Range = textRange{position{d.Line - 1, d.Column}, position{d.EndLine - 1, d.EndColumn}}
+		} else if d.Line > 0 {
+			// errors in imported modules are shown at the beginning of the document
+			item.Message = fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+		}
+		diagnostics = append(diagnostics, item)
+	}
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{uri, diagnostics})
+}
+
+// lookup finds analyzed document and converts LSP 0-based line to 1-based one
+func (s *Server) lookup(data json.RawMessage) (*compiler.Analysis, int, int, error) {
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, 0, 0, err
+	}
+	analysis, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, 0, 0, fmt.Errorf("document %s is not open", params.TextDocument.URI)
+	}
+	return analysis, params.Position.Line + 1, params.Position.Character, nil
+}
+
+func (s *Server) hover(data json.RawMessage) (interface{}, error) {
+	analysis, line, column, err := s.lookup(data)
+	if err != nil {
+		return nil, err
+	}
+	text, ok := analysis.Hover(line, column)
+	if !ok {
+		return nil, nil
+	}
+	return hover{markupContent{"markdown", text}}, nil
+}
+
+func (s *Server) definition(data json.RawMessage) (interface{}, error) {
+	analysis, line, column, err := s.lookup(data)
+	if err != nil {
+		return nil, err
+	}
+	loc, ok := analysis.Definition(line, column)
+	if !ok {
+		return nil, nil
+	}
+	start := position{loc.Line - 1, loc.Column}
+	return location{pathToURI(loc.File), textRange{start, start}}, nil
+}
+
+func (s *Server) completion(data json.RawMessage) (interface{}, error) {
+	analysis, line, column, err := s.lookup(data)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]completionItem, 0)
+	for _, c := range analysis.Completion(line, column) {
+		item := completionItem{Label: c.Label, Kind: completionField, Detail: c.Detail}
+		if c.Method {
+			item.Kind = completionMethod
+		}
+		if c.Documentation != "" {
+			item.Documentation = &markupContent{"markdown", c.Documentation}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func uriToPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+func pathToURI(file string) string {
+	u := url.URL{Scheme: "file", Path: file}
+	return u.String()
+}
@@ -0,0 +1,206 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+)
+
+// client is a minimal JSON-RPC client talking to the server over pipes
+type client struct {
+	t      *testing.T
+	in     *bufio.Reader
+	out    io.WriteCloser
+	nextID int
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, in: bufio.NewReader(clientIn), out: clientOut, done: make(chan error, 1)}
+	server := NewServer(serverIn, serverOut, compiler.Options{})
+	go func() {
+		c.done <- server.Run()
+		serverOut.Close()
+	}()
+	return c
+}
+
+func (c *client) send(method string, params interface{}, id *int) {
+	data, err := json.Marshal(params)
+	require.NoError(c.t, err)
+	msg := message{Method: method, Params: data}
+	if id != nil {
+		raw := json.RawMessage(fmt.Sprintf("%d", *id))
+		msg.ID = &raw
+	}
+	require.NoError(c.t, writeMessage(c.out, msg))
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.send(method, params, nil)
+}
+
+// call sends a request and decodes the result of the response into result
+func (c *client) call(method string, params interface{}, result interface{}) *responseError {
+	c.nextID++
+	id := c.nextID
+	c.send(method, params, &id)
+	msg := c.receive()
+	require.NotNil(c.t, msg.ID)
+	require.Equal(c.t, fmt.Sprintf("%d", id), string(*msg.ID))
+	if msg.Error != nil {
+		return msg.Error
+	}
+	require.NoError(c.t, json.Unmarshal(msg.Result, result))
+	return nil
+}
+
+func (c *client) receive() message {
+	msg, err := readMessage(c.in)
+	require.NoError(c.t, err)
+	return msg
+}
+
+func (c *client) diagnostics() publishDiagnosticsParams {
+	msg := c.receive()
+	require.Equal(c.t, "textDocument/publishDiagnostics", msg.Method)
+	var params publishDiagnosticsParams
+	require.NoError(c.t, json.Unmarshal(msg.Params, &params))
+	return params
+}
+
+func textPosition(uri string, line int, character int) textDocumentPositionParams {
+	return textDocumentPositionParams{textDocumentIdentifier{uri}, position{line, character}}
+}
+
+func TestServer(t *testing.T) {
+	a := require.New(t)
+
+	dir, err := ioutil.TempDir("", "tealang-lsp")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	modulePath := path.Join(dir, "mymodule.tl")
+	a.NoError(ioutil.WriteFile(modulePath, []byte("function test() {\n\treturn 2\n}\n"), 0644))
+	uri := pathToURI(path.Join(dir, "main.tl"))
+
+	c := newClient(t)
+
+	var init initializeResult
+	a.Nil(c.call("initialize", map[string]interface{}{"processId": nil, "rootUri": nil, "capabilities": map[string]interface{}{}}, &init))
+	a.True(init.Capabilities.HoverProvider)
+	a.True(init.Capabilities.DefinitionProvider)
+	a.Equal([]string{"."}, init.Capabilities.CompletionProvider.TriggerCharacters)
+	c.notify("initialized", map[string]interface{}{})
+
+	// errors and warnings are published on open and on every change
+	source := "import mymodule\nfunction logic() {\n\tif 1 {\n\t\treturn b\n\t}\n\treturn 1\n}\n"
+	c.notify("textDocument/didOpen", didOpenParams{textDocumentItem{uri, 1, source}})
+	published := c.diagnostics()
+	a.Equal(uri, published.URI)
+	a.NotEmpty(published.Diagnostics)
+	d := published.Diagnostics[0]
+	a.Equal(severityError, d.Severity)
+	a.Equal("TL0003", d.Code)
+	a.Equal(textRange{position{3, 9}, position{3, 10}}, d.Range)
+	// warnings follow errors
+	w := published.Diagnostics[len(published.Diagnostics)-1]
+	a.Equal(severityWarning, w.Severity)
+	a.Equal("TL1005", w.Code)
+	a.Equal(textRange{position{2, 4}, position{2, 5}}, w.Range)
+
+	source = "import mymodule\nconst c = 3\nfunction logic() {\n\tlet a = test() + c\n\treturn a + txn.Fee\n}\n"
+	change := didChangeParams{TextDocument: textDocumentIdentifier{uri}}
+	change.ContentChanges = append(change.ContentChanges, struct {
+		Text string `json:"text"`
+	}{source})
+	c.notify("textDocument/didChange", change)
+	a.Empty(c.diagnostics().Diagnostics)
+
+	var loc location
+	a.Nil(c.call("textDocument/definition", textPosition(uri, 3, 10), &loc))
+	a.Equal(pathToURI(modulePath), loc.URI)
+	a.Equal(position{0, 9}, loc.Range.Start)
+
+	a.Nil(c.call("textDocument/definition", textPosition(uri, 3, 18), &loc))
+	a.Equal(uri, loc.URI)
+	a.Equal(position{1, 6}, loc.Range.Start)
+
+	a.Nil(c.call("textDocument/definition", textPosition(uri, 0, 8), &loc))
+	a.Equal(pathToURI(modulePath), loc.URI)
+
+	var h *hover
+	a.Nil(c.call("textDocument/hover", textPosition(uri, 3, 5), &h))
+	a.NotNil(h)
+	a.Equal("markdown", h.Contents.Kind)
+	a.Contains(h.Contents.Value, "let a: uint64")
+
+	a.Nil(c.call("textDocument/hover", textPosition(uri, 4, 16), &h))
+	a.Contains(h.Contents.Value, "txn.Fee: uint64")
+
+	h = nil
+	a.Nil(c.call("textDocument/hover", textPosition(uri, 2, 0), &h))
+	a.Nil(h)
+
+	// completion works on incomplete code
+	source = "function logic() {\n\treturn global.\n}\n"
+	change.ContentChanges[0].Text = source
+	c.notify("textDocument/didChange", change)
+	a.NotEmpty(c.diagnostics().Diagnostics)
+	var items []completionItem
+	a.Nil(c.call("textDocument/completion", textPosition(uri, 1, 15), &items))
+	a.NotEmpty(items)
+	labels := make([]string, 0, len(items))
+	for _, item := range items {
+		labels = append(labels, item.Label)
+		a.Equal(completionField, item.Kind)
+	}
+	a.Contains(labels, "MinTxnFee")
+
+	c.notify("textDocument/didClose", didCloseParams{textDocumentIdentifier{uri}})
+	a.Empty(c.diagnostics().Diagnostics)
+
+	respErr := c.call("textDocument/hover", textPosition(uri, 1, 1), &h)
+	a.NotNil(respErr)
+	a.Equal(invalidParams, respErr.Code)
+
+	respErr = c.call("workspace/symbol", map[string]interface{}{}, &h)
+	a.NotNil(respErr)
+	a.Equal(methodNotFound, respErr.Code)
+
+	var result interface{}
+	a.Nil(c.call("shutdown", nil, &result))
+	a.Nil(result)
+	c.notify("exit", nil)
+	a.NoError(<-c.done)
+}
+
+func TestServerLifecycle(t *testing.T) {
+	a := require.New(t)
+
+	c := newClient(t)
+	var result interface{}
+	respErr := c.call("textDocument/hover", textPosition("file:///a.tl", 0, 0), &result)
+	a.NotNil(respErr)
+	a.Equal(serverNotInitialized, respErr.Code)
+
+	a.Nil(c.call("initialize", map[string]interface{}{}, &result))
+	c.out.Write([]byte("Content-Length: 5\r\n\r\n{abc}"))
+	msg := c.receive()
+	a.NotNil(msg.Error)
+	a.Equal(parseError, msg.Error.Code)
+
+	// exit without shutdown is an error
+	c.notify("exit", nil)
+	a.Error(<-c.done)
+}
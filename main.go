@@ -2,18 +2,29 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 
+	"github.com/algorand/go-algorand/data/transactions"
 	"github.com/algorand/go-algorand/data/transactions/logic"
 	"github.com/spf13/cobra"
 
 	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/coverage"
+	"github.com/pzbitskiy/tealang/debugger"
 	dr "github.com/pzbitskiy/tealang/dryrun"
+	"github.com/pzbitskiy/tealang/lsp"
+	"github.com/pzbitskiy/tealang/profiler"
+	"github.com/pzbitskiy/tealang/repl"
+	"github.com/pzbitskiy/tealang/stdlib"
+	"github.com/pzbitskiy/tealang/testrunner"
 )
 
 var outFile string
@@ -25,6 +36,23 @@ var oneliner string
 var stdout bool
 var raw bool
 var dryrun string
+var tealVersion int
+var optimizationLevel int
+var cost bool
+var sourceMapFile string
+var diagnostics string
+var werror bool
+var fmtWrite bool
+var fmtDiff bool
+var allowUnrecognized bool
+var replTxnFile string
+var ledgerFile string
+var traceFormat string
+var testRun string
+var testJUnit string
+var coverageFile string
+var lcovFile string
+var htmlFile string
 
 var currentDir string
 var sourceDir string
@@ -87,47 +115,64 @@ Syntax highlighter for vscode: https://github.com/pzbitskiy/tealang-syntax-highl
 			os.Exit(1)
 		}
 
-		var prog compiler.TreeNodeIf
-		var parseErrors []compiler.ParserError
-		var teal string
-		var bytecode []byte
-		var err error
-		var op *logic.OpStream
-		if len(oneliner) > 0 {
-			prog, parseErrors = compiler.ParseOneLineCond(source)
-			if len(parseErrors) > 0 {
-				for _, e := range parseErrors {
-					fmt.Printf("%s\n", e.String())
-				}
+		input := compiler.InputDesc{
+			Source:     source,
+			SourceFile: sourceFile,
+			SourceDir:  sourceDir,
+			CurrentDir: currentDir,
+		}
+		opts := compiler.Options{
+			TEALVersion:       tealVersion,
+			OptimizationLevel: optimizationLevel,
+			Assemble:          !compileOnly || runDryrun(cmd) || sourceMapFile != "",
+			OneLiner:          len(oneliner) > 0,
+			WarningsAsErrors:  werror,
+		}
+		if traceFormat != "text" && traceFormat != "json" && traceFormat != "table" {
+			fmt.Fprintln(os.Stderr, "[--trace] must be text, json or table")
+			os.Exit(1)
+		}
+		if diagnostics != "text" && diagnostics != "json" {
+			fmt.Fprintln(os.Stderr, "[--diagnostics] must be text or json")
+			os.Exit(1)
+		}
+		result, err := compiler.Compile(input, opts)
+		if diagnostics == "json" {
+			printDiagnosticsJSON(result, err)
+		}
+		if err != nil {
+			if diagnostics == "json" {
 				os.Exit(1)
 			}
-		} else {
-			input := compiler.InputDesc{
-				Source:     source,
-				SourceFile: sourceFile,
-				SourceDir:  sourceDir,
-				CurrentDir: currentDir,
-			}
-			prog, parseErrors = compiler.ParseProgram(input)
-			if len(parseErrors) > 0 {
-				for _, e := range parseErrors {
+			var parserErrors compiler.ParserErrors
+			if errors.As(err, &parserErrors) {
+				for _, e := range result.Diagnostics {
 					fmt.Printf("%s\n", e.String())
 				}
-				os.Exit(1)
+			} else {
+				fmt.Println(err.Error())
+			}
+			os.Exit(1)
+		}
+		if diagnostics == "text" {
+			// warnings go to stderr not to mix with the program written to stdout
+			for _, e := range result.Diagnostics {
+				fmt.Fprintf(os.Stderr, "%s\n", e.String())
 			}
 		}
-		teal = compiler.Codegen(prog)
 
-		if !compileOnly {
-			op, err = logic.AssembleString(teal)
-			if err != nil {
-				for _, err := range op.Errors {
-					fmt.Println(err)
-				}
-				fmt.Println(err.Error())
+		if cost {
+			fmt.Print(result.Cost.String())
+			if !result.Cost.FitsLogicSig() && !result.Cost.FitsApp() {
 				os.Exit(1)
 			}
-			bytecode = op.Program
+			return
+		}
+
+		teal := result.TEAL
+		var bytecode []byte
+		if !compileOnly {
+			bytecode = result.Bytecode
 		}
 
 		if stdout {
@@ -160,31 +205,640 @@ Syntax highlighter for vscode: https://github.com/pzbitskiy/tealang-syntax-highl
 			ioutil.WriteFile(outFile, output, 0644)
 		}
 
-		if cmd.Flags().Changed("dryrun") {
-			if bytecode == nil {
-				op, err = logic.AssembleString(teal)
-				if err != nil {
-					fmt.Println(err.Error())
-					os.Exit(1)
-				}
-				bytecode = op.Program
+		if sourceMapFile != "" {
+			data, err := result.SourceMapJSON()
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if verbose {
+				fmt.Printf("Writing source map to %s\n", sourceMapFile)
 			}
+			ioutil.WriteFile(sourceMapFile, data, 0644)
+		}
+
+		if runDryrun(cmd) {
 			sb := strings.Builder{}
-			pass, err := dr.Run(bytecode, dryrun, &sb)
-			fmt.Printf("trace:\n%s\n", sb.String())
+			var tracer *dr.Tracer
+			var debugger logic.DebuggerHook
+			var collector *coverage.Collector
+			if traceFormat != "text" {
+				tracer = dr.NewTracer(sourceInfo(result.SourceMap))
+				debugger = tracer
+			}
+			if coverageFile != "" {
+				collector = coverage.NewCollector(result)
+				debugger = dr.CombineHooks(debugger, collector)
+			}
+			var pass bool
+			var appResult *dr.AppResult
+			if cmd.Flags().Changed("ledger") {
+				appResult, err = runApp(result.Bytecode, &sb, debugger)
+				pass = appResult != nil && appResult.Pass
+			} else {
+				var txn transactions.Transaction
+				if txn, err = dr.LoadTxn(dryrun); err == nil {
+					pass, err = dr.RunTxn(result.Bytecode, txn, &sb, debugger)
+				}
+			}
+			switch traceFormat {
+			case "json":
+				// json trace goes to stderr like json diagnostics so that stdout stays for the program
+				tracer.WriteJSON(os.Stderr)
+			case "table":
+				fmt.Println("trace:")
+				tracer.WriteTable(os.Stdout)
+			default:
+				fmt.Printf("trace:\n%s\n", result.SourceMap.AnnotateTrace(sb.String()))
+			}
+			if appResult != nil {
+				fmt.Print(appResult.String())
+			}
 			if pass {
 				fmt.Printf(" - pass -\n")
 			} else {
 				fmt.Printf("REJECT\n")
 			}
 			if err != nil {
-				fmt.Printf("ERROR: %s\n", err.Error())
+				fmt.Printf("ERROR: %s\n", result.SourceMap.AnnotateError(err.Error()))
+			}
+			if collector != nil {
+				run := coverage.NewProfile()
+				collector.AddTo(run, coverage.Resolver(path.Join(sourceDir, sourceFile), currentDir))
+				if err := updateCoverage(coverageFile, run); err != nil {
+					fmt.Fprintln(os.Stderr, err.Error())
+					os.Exit(1)
+				}
+			}
+		}
+	},
+}
+
+// updateCoverage merges coverage of the run into the file, the file is created if missing
+func updateCoverage(file string, run *coverage.Profile) error {
+	profile, err := coverage.ReadProfile(file)
+	if os.IsNotExist(err) {
+		profile, err = coverage.NewProfile(), nil
+	}
+	if err != nil {
+		return err
+	}
+	profile.Merge(run)
+	return profile.WriteFile(file)
+}
+
+// runDryrun tells if the program is evaluated after compilation
+func runDryrun(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("dryrun") || cmd.Flags().Changed("ledger")
+}
+
+// sourceInfo links trace steps to tealang sources and variables
+func sourceInfo(sm compiler.SourceMap) dr.SourceInfo {
+	return func(pc int) (string, string) {
+		location := ""
+		if loc, ok := sm.Location(pc); ok {
+			location = loc.String()
+		}
+		variable, _ := sm.Variable(pc)
+		return location, variable
+	}
+}
+
+// runApp evaluates the program as application call against the ledger fixture
+func runApp(bytecode []byte, trace *strings.Builder, debugger logic.DebuggerHook) (*dr.AppResult, error) {
+	txn, err := dr.LoadTxn(dryrun)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := dr.LoadLedger(ledgerFile)
+	if err != nil {
+		return nil, err
+	}
+	return dr.RunApp(bytecode, txn, ledger, trace, debugger)
+}
+
+var groupCmd = &cobra.Command{
+	Use:   "group [flags] group.json",
+	Short: "Dry run transaction group, transactions may point to .tl or .tok programs of their logic sigs and apps",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		passed, err := runGroup(args[0], cmd.Flags().Changed("ledger"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		if err != nil || !passed {
+			os.Exit(1)
+		}
+	},
+}
+
+// runGroup compiles programs of the group, evaluates it and prints outcome of every transaction.
+// Traces are printed for rejected transactions or all of them in verbose mode
+func runGroup(groupFile string, useLedger bool) (bool, error) {
+	group, err := dr.LoadGroup(groupFile)
+	if err != nil {
+		return false, err
+	}
+	var ledger *dr.Ledger
+	if useLedger {
+		if ledger, err = dr.LoadLedger(ledgerFile); err != nil {
+			return false, err
+		}
+	}
+	sourceMaps := make(map[string]compiler.SourceMap)
+	for i := range group {
+		gt := &group[i]
+		if gt.LogicSigFile != "" {
+			if gt.LogicSig, err = loadProgram(gt.LogicSigFile, sourceMaps); err != nil {
+				return false, err
+			}
+		}
+		if gt.ApprovalFile != "" {
+			if gt.Approval, err = loadProgram(gt.ApprovalFile, sourceMaps); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	results := dr.RunGroup(group, ledger)
+	for i, r := range results {
+		gt := group[i]
+		status := "pass"
+		if !r.Pass {
+			status = "REJECT"
+		}
+		fmt.Printf("txn %d: %s from %s: %s\n", i, gt.Txn.Type, gt.Txn.Sender, status)
+		if r.Err != nil {
+			sm := sourceMaps[gt.LogicSigFile]
+			if r.App != nil || r.AppTrace != "" {
+				sm = sourceMaps[gt.ApprovalFile]
+			}
+			fmt.Printf("    ERROR: %s\n", sm.AnnotateError(r.Err.Error()))
+		}
+		if verbose || !r.Pass {
+			if r.LogicSigTrace != "" {
+				fmt.Printf("    logic sig trace:\n%s\n", indentLines(sourceMaps[gt.LogicSigFile].AnnotateTrace(r.LogicSigTrace)))
+			}
+			if r.AppTrace != "" {
+				fmt.Printf("    app trace:\n%s\n", indentLines(sourceMaps[gt.ApprovalFile].AnnotateTrace(r.AppTrace)))
+			}
+		}
+		if r.App != nil {
+			fmt.Print(indentLines(r.App.String()), "\n")
+		}
+	}
+	passed := dr.GroupPassed(results)
+	if passed {
+		fmt.Printf(" - pass -\n")
+	} else {
+		fmt.Printf("REJECT\n")
+	}
+	return passed, nil
+}
+
+// loadProgram reads .tok bytecode or compiles tealang source remembering its source map
+func loadProgram(file string, sourceMaps map[string]compiler.SourceMap) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if path.Ext(file) == ".tok" {
+		return data, nil
+	}
+	input := compiler.InputDesc{Source: string(data), SourceFile: path.Base(file), SourceDir: path.Dir(file), CurrentDir: currentDir}
+	result, err := compiler.Compile(input, compiler.Options{TEALVersion: tealVersion, Assemble: true})
+	if err != nil {
+		if result != nil && len(result.Diagnostics) > 0 {
+			messages := make([]string, 0, len(result.Diagnostics))
+			for _, e := range result.Diagnostics {
+				messages = append(messages, e.String())
+			}
+			return nil, errors.New(strings.Join(messages, "\n"))
+		}
+		return nil, fmt.Errorf("%s: %s", file, err.Error())
+	}
+	sourceMaps[file] = result.SourceMap
+	return result.Bytecode, nil
+}
+
+// indentLines shifts text right to nest it under a transaction header
+func indentLines(text string) string {
+	return "        " + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n        ")
+}
+
+var debugCmd = &cobra.Command{
+	Use:   "debug [flags] prog.tl",
+	Short: "Step through dryrun of the program with breakpoints by source line and watches over variables",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := debugProgram(args[0], cmd.Flags().Changed("ledger")); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// debugProgram compiles the program and runs it in dryrun reading debugger commands from stdin.
+// Evaluation outcome is printed unless the session is aborted, returned errors are the ones preventing the run
+func debugProgram(file string, useLedger bool) error {
+	result, err := compileProgram(file)
+	if err != nil {
+		return err
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	// modules are resolved the same way as by the compiler, locations keep base names of files
+	readSource := func(name string) (string, error) {
+		if module, ok := stdlib.LoadModule(name); ok {
+			return module, nil
+		}
+		for _, d := range []string{path.Dir(file), workDir} {
+			if data, err := ioutil.ReadFile(path.Join(d, name)); err == nil {
+				return string(data), nil
 			}
+		}
+		return "", fmt.Errorf("source %s not found", name)
+	}
+	session := debugger.NewSession(os.Stdin, os.Stdout, result.SourceMap, path.Base(file), readSource)
 
+	outcome, err := evalProgram(result.Bytecode, useLedger, session)
+	if err != nil {
+		return err
+	}
+	if !session.Quit() {
+		outcome.print(result.SourceMap)
+	}
+	return nil
+}
+
+var profileCmd = &cobra.Command{
+	Use:   "profile [flags] prog.tl",
+	Short: "Run the program in dryrun and report opcode cost per function and source line",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := profileProgram(args[0], cmd.Flags().Changed("ledger")); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
 		}
 	},
 }
 
+// profileProgram runs the program printing cost tables and the outcome, pprof profile is written if requested
+func profileProgram(file string, useLedger bool) error {
+	result, err := compileProgram(file)
+	if err != nil {
+		return err
+	}
+	prof := profiler.New(result)
+	outcome, err := evalProgram(result.Bytecode, useLedger, prof)
+	if err != nil {
+		return err
+	}
+	if err = prof.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	fmt.Println()
+	outcome.print(result.SourceMap)
+	if outFile != "" {
+		f, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return prof.WritePprof(f)
+	}
+	return nil
+}
+
+// compileProgram compiles the program file printing diagnostics to stderr on failure
+func compileProgram(file string) (*compiler.Result, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	input := compiler.InputDesc{Source: string(data), SourceFile: path.Base(file), SourceDir: path.Dir(file), CurrentDir: workDir}
+	result, err := compiler.Compile(input, compiler.Options{TEALVersion: tealVersion, Assemble: true})
+	if err != nil && result != nil {
+		for _, e := range result.Diagnostics {
+			fmt.Fprintln(os.Stderr, e.String())
+		}
+	}
+	return result, err
+}
+
+// dryrunOutcome is a result of the program evaluation
+type dryrunOutcome struct {
+	pass bool
+	app  *dr.AppResult
+	err  error
+}
+
+// evalProgram runs the program against the transaction from --dryrun, as an app call if --ledger is set.
+// Returned errors are the ones preventing the run, evaluation errors are kept in the outcome
+func evalProgram(bytecode []byte, useLedger bool, hook logic.DebuggerHook) (outcome dryrunOutcome, err error) {
+	txn, err := dr.LoadTxn(dryrun)
+	if err != nil {
+		return
+	}
+	if !useLedger {
+		outcome.pass, outcome.err = dr.RunTxn(bytecode, txn, nil, hook)
+		return
+	}
+	ledger, err := dr.LoadLedger(ledgerFile)
+	if err != nil {
+		return
+	}
+	outcome.app, outcome.err = dr.RunApp(bytecode, txn, ledger, nil, hook)
+	outcome.pass = outcome.app != nil && outcome.app.Pass
+	return
+}
+
+// print reports app state changes, pass or reject and the evaluation error
+func (o dryrunOutcome) print(sm compiler.SourceMap) {
+	if o.app != nil {
+		fmt.Print(o.app.String())
+	}
+	if o.pass {
+		fmt.Printf(" - pass -\n")
+	} else {
+		fmt.Printf("REJECT\n")
+	}
+	if o.err != nil {
+		fmt.Printf("ERROR: %s\n", sm.AnnotateError(o.err.Error()))
+	}
+}
+
+var lspCmd = &cobra.Command{
+	Use:   "lsp",
+	Short: "Run language server speaking LSP over stdin and stdout",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		server := lsp.NewServer(os.Stdin, os.Stdout, compiler.Options{TEALVersion: tealVersion})
+		if err := server.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var replCmd = &cobra.Command{
+	Use:   "repl",
+	Short: "Evaluate declarations, statements and expressions interactively in dryrun",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		session, err := repl.NewSession(os.Stdout, compiler.Options{TEALVersion: tealVersion}, replTxnFile)
+		if err == nil {
+			err = session.Run(os.Stdin)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [flags] [source-files]",
+	Short: "Format tealang sources, reads stdin if no files given",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			args = []string{"-"}
+		}
+		failed := false
+		for _, file := range args {
+			if err := formatFile(file); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, err.Error())
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// formatFile prints formatted source, its diff or rewrites the file in place
+func formatFile(file string) error {
+	var data []byte
+	var err error
+	if file == "-" {
+		if fmtWrite {
+			return fmt.Errorf("cannot write formatted stdin in place")
+		}
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+	formatted, err := compiler.Format(string(data))
+	if err != nil {
+		return err
+	}
+	changed := formatted != string(data)
+	if fmtDiff && changed {
+		fmt.Print(unifiedDiff(file, string(data), formatted))
+	}
+	if fmtWrite && changed {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(file, []byte(formatted), info.Mode())
+	}
+	if !fmtDiff && !fmtWrite {
+		fmt.Print(formatted)
+	}
+	return nil
+}
+
+var decompileCmd = &cobra.Command{
+	Use:   "decompile [flags] prog.tok|prog.teal",
+	Short: "Recover tealang source from bytecode or TEAL assembler",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := decompileFile(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// decompileFile writes tealang source recovered from the file to the output file or stdout.
+// Files with .teal extension or starting with version pragma are treated as TEAL assembler
+func decompileFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var result *compiler.DecompileResult
+	if path.Ext(file) == ".teal" || strings.HasPrefix(string(data), "#pragma") {
+		result, err = compiler.DecompileTEAL(string(data))
+	} else {
+		result, err = compiler.Decompile(data)
+	}
+	if err != nil {
+		return err
+	}
+	if outFile == "" {
+		fmt.Print(result.Source)
+	} else if err = ioutil.WriteFile(outFile, []byte(result.Source), 0644); err != nil {
+		return err
+	}
+	if len(result.Unrecognized) > 0 {
+		// stubs of unrecognized functions always fail, the output does not behave as the input
+		if !allowUnrecognized {
+			return fmt.Errorf("unrecognized functions replaced by failing stubs: %s", strings.Join(result.Unrecognized, ", "))
+		}
+		fmt.Fprintf(os.Stderr, "warning: unrecognized functions replaced by failing stubs: %s\n", strings.Join(result.Unrecognized, ", "))
+	}
+	return nil
+}
+
+var coverageCmd = &cobra.Command{
+	Use:   "coverage [flags] file.cov...",
+	Short: "Merge coverage files written by --coverage, print summary or export them as LCOV and annotated HTML sources",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportCoverage(args); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// exportCoverage merges coverage files and writes requested reports, summary is printed if none is requested
+func exportCoverage(files []string) error {
+	profile := coverage.NewProfile()
+	for _, file := range files {
+		p, err := coverage.ReadProfile(file)
+		if err != nil {
+			return err
+		}
+		profile.Merge(p)
+	}
+	if outFile != "" {
+		if err := profile.WriteFile(outFile); err != nil {
+			return err
+		}
+	}
+	reports := []struct {
+		file  string
+		write func(io.Writer) error
+	}{{lcovFile, profile.WriteLCOV}, {htmlFile, profile.WriteHTML}}
+	for _, r := range reports {
+		if r.file == "" {
+			continue
+		}
+		f, err := os.Create(r.file)
+		if err != nil {
+			return err
+		}
+		err = r.write(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if outFile == "" && lcovFile == "" && htmlFile == "" {
+		profile.WriteSummary(os.Stdout)
+	}
+	return nil
+}
+
+var testCmd = &cobra.Command{
+	Use:   "test [flags] [paths]",
+	Short: "Run test functions from *_test.tl files in dryrun, searches the current directory if no paths given",
+	Run: func(cmd *cobra.Command, args []string) {
+		failed, err := runTests(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		if err != nil || failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// runTests runs test files found in paths, prints results and writes JUnit report if requested.
+// It reports if any test did not pass
+func runTests(paths []string) (bool, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	opts := testrunner.Options{Compiler: compiler.Options{TEALVersion: tealVersion}}
+	if coverageFile != "" {
+		opts.Coverage = coverage.NewProfile()
+	}
+	if testRun != "" {
+		re, err := regexp.Compile(testRun)
+		if err != nil {
+			return false, fmt.Errorf("invalid --run pattern: %s", err.Error())
+		}
+		opts.Run = re
+	}
+	files, err := testrunner.FindFiles(paths)
+	if err != nil {
+		return false, err
+	}
+	if len(files) == 0 {
+		return false, fmt.Errorf("no test files found")
+	}
+
+	var results []testrunner.Result
+	for _, file := range files {
+		fileResults, err := testrunner.RunFile(file, opts)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, fileResults...)
+	}
+	testrunner.Report(os.Stdout, results, verbose)
+
+	if opts.Coverage != nil {
+		if err := updateCoverage(coverageFile, opts.Coverage); err != nil {
+			return false, err
+		}
+	}
+
+	if testJUnit != "" {
+		f, err := os.Create(testJUnit)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		if err := testrunner.WriteJUnit(f, results); err != nil {
+			return false, err
+		}
+	}
+	return testrunner.Failed(results), nil
+}
+
+// printDiagnosticsJSON writes compilation diagnostics to stderr as JSON array
+// so that stdout stays available for the program output
+func printDiagnosticsJSON(result *compiler.Result, err error) {
+	list := make([]compiler.Diagnostic, 0)
+	if result != nil {
+		for _, e := range result.Diagnostics {
+			list = append(list, e.Diagnostic())
+		}
+	}
+	if err != nil && len(list) == 0 {
+		// errors not related to sources like unsupported options
+		list = append(list, compiler.Diagnostic{Severity: "error", Code: "TL0000", File: sourceFile, Message: err.Error()})
+	}
+	data, _ := json.Marshal(list)
+	fmt.Fprintln(os.Stderr, string(data))
+}
+
 func setRootCmdFlags() {
 	rootCmd.Flags().StringVarP(&outFile, "output", "o", "", "write output to this file")
 	rootCmd.Flags().BoolVarP(&compileOnly, "compile", "c", false, "compile to TEAL assembler, do not produce bytecode")
@@ -193,6 +847,58 @@ func setRootCmdFlags() {
 	rootCmd.Flags().BoolVarP(&stdout, "stdout", "s", false, "write output to stdout instead of a file")
 	rootCmd.Flags().BoolVarP(&raw, "raw", "r", false, "do not hex-encode bytecode when outputting to stdout")
 	rootCmd.Flags().StringVarP(&dryrun, "dryrun", "d", "", "dry run program with transaction data from the file provided")
+	rootCmd.Flags().StringVar(&ledgerFile, "ledger", "", "dry run program as application call against accounts, apps and assets from the file provided, empty name starts with no state")
+	rootCmd.Flags().StringVar(&traceFormat, "trace", "text", "dryrun trace format: text, json with stack and scratch of every step written to stderr, or table naming scratch slots after variables")
+	rootCmd.Flags().IntVar(&tealVersion, "teal-version", 0, "target TEAL version, defaults to the latest supported")
+	rootCmd.Flags().IntVarP(&optimizationLevel, "optimize", "O", 0, "optimization level, 1 folds constant expressions, prunes branches with constant conditions and runs peephole optimizations over generated TEAL, constant blocks are ordered by use count at any level")
+	rootCmd.Flags().StringVar(&sourceMapFile, "sourcemap", "", "write source map linking bytecode offsets to tealang sources to this file")
+	rootCmd.Flags().StringVar(&diagnostics, "diagnostics", "text", "diagnostics format: text or json, json is written to stderr")
+	rootCmd.Flags().BoolVar(&werror, "Werror", false, "treat warnings as errors")
+	rootCmd.Flags().StringVar(&coverageFile, "coverage", "", "merge line and branch coverage of the dry run into this file")
+	rootCmd.Flags().BoolVar(&cost, "cost", false, "print worst-case cost and size estimate instead of the output, fail if the program exceeds both logicsig and app limits")
+
+	lspCmd.Flags().IntVar(&tealVersion, "teal-version", 0, "target TEAL version for diagnostics and completion, defaults to the latest supported")
+	rootCmd.AddCommand(lspCmd)
+
+	fmtCmd.Flags().BoolVarP(&fmtWrite, "write", "w", false, "write result to the source file instead of stdout")
+	fmtCmd.Flags().BoolVarP(&fmtDiff, "diff", "d", false, "print diff of formatting changes instead of the result")
+	rootCmd.AddCommand(fmtCmd)
+
+	replCmd.Flags().IntVar(&tealVersion, "teal-version", 0, "target TEAL version, defaults to the latest supported")
+	replCmd.Flags().StringVar(&replTxnFile, "txn", "", "evaluate against transaction data from the file provided, defaults to a sample payment")
+	rootCmd.AddCommand(replCmd)
+
+	decompileCmd.Flags().StringVarP(&outFile, "output", "o", "", "write output to this file instead of stdout")
+	decompileCmd.Flags().BoolVar(&allowUnrecognized, "allow-unrecognized", false, "exit with success even if some functions are replaced by failing stubs")
+	rootCmd.AddCommand(decompileCmd)
+
+	testCmd.Flags().StringVar(&testRun, "run", "", "run only tests with names matching the regular expression")
+	testCmd.Flags().StringVar(&testJUnit, "junit", "", "write JUnit XML report to this file")
+	testCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "list passed tests as well")
+	testCmd.Flags().IntVar(&tealVersion, "teal-version", 0, "target TEAL version, defaults to the latest supported")
+	testCmd.Flags().StringVar(&coverageFile, "coverage", "", "merge line and branch coverage of sources under test into this file")
+	rootCmd.AddCommand(testCmd)
+
+	groupCmd.Flags().StringVar(&ledgerFile, "ledger", "", "run app calls and apply transfers against accounts, apps and assets from the file provided, empty name starts with no state")
+	groupCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print traces of passed transactions as well")
+	groupCmd.Flags().IntVar(&tealVersion, "teal-version", 0, "target TEAL version, defaults to the latest supported")
+	rootCmd.AddCommand(groupCmd)
+
+	debugCmd.Flags().StringVarP(&dryrun, "dryrun", "d", "", "debug with transaction data from the file provided, defaults to a sample payment")
+	debugCmd.Flags().StringVar(&ledgerFile, "ledger", "", "debug as application call against accounts, apps and assets from the file provided, empty name starts with no state")
+	debugCmd.Flags().IntVar(&tealVersion, "teal-version", 0, "target TEAL version, defaults to the latest supported")
+	rootCmd.AddCommand(debugCmd)
+
+	profileCmd.Flags().StringVarP(&dryrun, "dryrun", "d", "", "profile with transaction data from the file provided, defaults to a sample payment")
+	profileCmd.Flags().StringVar(&ledgerFile, "ledger", "", "profile as application call against accounts, apps and assets from the file provided, empty name starts with no state")
+	profileCmd.Flags().StringVarP(&outFile, "output", "o", "", "write pprof profile to this file for go tool pprof")
+	profileCmd.Flags().IntVar(&tealVersion, "teal-version", 0, "target TEAL version, defaults to the latest supported")
+	rootCmd.AddCommand(profileCmd)
+
+	coverageCmd.Flags().StringVarP(&outFile, "output", "o", "", "write merged coverage to this file")
+	coverageCmd.Flags().StringVar(&lcovFile, "lcov", "", "write LCOV tracefile to this file")
+	coverageCmd.Flags().StringVar(&htmlFile, "html", "", "write HTML report with annotated sources to this file")
+	rootCmd.AddCommand(coverageCmd)
 }
 
 func main() {
@@ -28,3 +28,33 @@ func TestMainBasic(t *testing.T) {
 	require.NoError(t, err)
 	require.Contains(t, string(out), "end_main")
 }
+
+func TestUnifiedDiff(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("--- x.tl.orig\n+++ x.tl\n", unifiedDiff("x.tl", "a\nb\n", "a\nb\n"))
+
+	before := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	after := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n12\n13\n"
+	expected := `--- x.tl.orig
++++ x.tl
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -8,5 +8,5 @@
+ 8
+ 9
+ 10
+-11
+ 12
++13
+`
+	a.Equal(expected, unifiedDiff("x.tl", before, after))
+
+	a.Equal("--- x.tl.orig\n+++ x.tl\n@@ -0,0 +1,1 @@\n+a\n", unifiedDiff("x.tl", "", "a\n"))
+}
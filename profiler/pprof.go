@@ -0,0 +1,144 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// protoBuffer encodes protocol buffers messages of pprof profile.proto
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// uint64 writes varint field, zero values are omitted as in proto3
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.bytes(field, m.data)
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	var p protoBuffer
+	for _, v := range values {
+		p.varint(v)
+	}
+	b.bytes(field, p.data)
+}
+
+// pprofBuilder interns strings, functions and locations of a profile
+type pprofBuilder struct {
+	strings   []string
+	stringIDs map[string]int64
+	functions map[string]uint64
+	locations map[Frame]uint64
+	profile   protoBuffer
+}
+
+func (pb *pprofBuilder) str(s string) int64 {
+	if id, ok := pb.stringIDs[s]; ok {
+		return id
+	}
+	id := int64(len(pb.strings))
+	pb.strings = append(pb.strings, s)
+	pb.stringIDs[s] = id
+	return id
+}
+
+func (pb *pprofBuilder) function(f Frame) uint64 {
+	name := f.Name()
+	if id, ok := pb.functions[name]; ok {
+		return id
+	}
+	id := uint64(len(pb.functions) + 1)
+	pb.functions[name] = id
+	var m protoBuffer
+	m.uint64(1, id)
+	m.int64(2, pb.str(name))
+	m.int64(3, pb.str(name))
+	m.int64(4, pb.str(f.File))
+	pb.profile.message(5, &m)
+	return id
+}
+
+func (pb *pprofBuilder) location(f Frame) uint64 {
+	if id, ok := pb.locations[f]; ok {
+		return id
+	}
+	fid := pb.function(f)
+	id := uint64(len(pb.locations) + 1)
+	pb.locations[f] = id
+	var line protoBuffer
+	line.uint64(1, fid)
+	line.int64(2, int64(f.Line))
+	var m protoBuffer
+	m.uint64(1, id)
+	m.message(4, &line)
+	pb.profile.message(4, &m)
+	return id
+}
+
+func (pb *pprofBuilder) valueType(field int, typ, unit string) {
+	var m protoBuffer
+	m.int64(1, pb.str(typ))
+	m.int64(2, pb.str(unit))
+	pb.profile.message(field, &m)
+}
+
+// WritePprof writes samples as gzipped pprof profile with instructions and cost sample types, cost is the default one.
+// Inline functions are separate frames so that pprof shows them apart from their callers
+func (p *Profiler) WritePprof(w io.Writer) error {
+	pb := &pprofBuilder{
+		strings:   []string{""},
+		stringIDs: map[string]int64{"": 0},
+		functions: make(map[string]uint64),
+		locations: make(map[Frame]uint64),
+	}
+	pb.valueType(1, "instructions", "count")
+	pb.valueType(1, "cost", "count")
+	for _, s := range p.Samples() {
+		ids := make([]uint64, len(s.Stack))
+		for i, f := range s.Stack {
+			ids[i] = pb.location(f)
+		}
+		var m protoBuffer
+		m.packed(1, ids)
+		m.packed(2, []uint64{uint64(s.Instructions), uint64(s.Cost)})
+		pb.profile.message(2, &m)
+	}
+	pb.valueType(11, "cost", "count")
+	pb.profile.int64(12, 1)
+	pb.profile.int64(14, pb.str("cost"))
+	for _, s := range pb.strings {
+		pb.profile.bytes(6, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(pb.profile.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,333 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Execution profiler
+//
+//--------------------------------------------------------------------------------------------------
+
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/algorand/go-algorand/data/transactions/logic"
+
+	"github.com/pzbitskiy/tealang/compiler"
+)
+
+// Frame is a function in a sampled call stack with the source line executed in it.
+// Line of a caller frame is the line of the call
+type Frame struct {
+	Function string
+	Inline   bool
+	File     string
+	Line     int
+}
+
+// Name of the function as shown in reports, inline functions are marked
+func (f Frame) Name() string {
+	if f.Inline {
+		return f.Function + " (inline)"
+	}
+	return f.Function
+}
+
+// Sample is a cost of instructions executed with the same call stack
+type Sample struct {
+	// Stack starts with the innermost frame
+	Stack        []Frame
+	Cost         int
+	Instructions int
+}
+
+// callFrame is a subroutine being executed and the last line executed by its own code
+type callFrame struct {
+	function string
+	loc      compiler.SourceLocation
+}
+
+// Profiler is a debugger hook attributing opcode cost of evaluation steps to functions and source lines
+type Profiler struct {
+	sourceMap compiler.SourceMap
+	ops       map[int]string
+	main      string
+
+	samples map[string]*Sample
+	calls   []callFrame
+	budget  int
+	pending *Sample
+	lastOp  string
+}
+
+// New creates profiler of the compiled program
+func New(result *compiler.Result) *Profiler {
+	p := &Profiler{
+		sourceMap: result.SourceMap,
+		ops:       make(map[int]string),
+		samples:   make(map[string]*Sample),
+	}
+	lines := strings.Split(result.TEAL, "\n")
+	for pc, line := range result.SourceMap.OffsetToLine {
+		if line < len(lines) {
+			if fields := strings.Fields(lines[line]); len(fields) > 0 {
+				p.ops[pc] = fields[0]
+			}
+		}
+	}
+	// the main function goes first in the program
+	first := -1
+	for line, ref := range result.SourceMap.LineToFunction {
+		if !ref.Inline && (first < 0 || line < first) {
+			first, p.main = line, ref.Name
+		}
+	}
+	return p
+}
+
+// Register is fired on program start
+func (p *Profiler) Register(state *logic.DebugState) error {
+	p.calls = []callFrame{{function: p.main}}
+	p.budget = state.OpcodeBudget
+	p.pending = nil
+	p.lastOp = ""
+	return nil
+}
+
+// Update is fired before every step, the cost of the previous step is known by then
+func (p *Profiler) Update(state *logic.DebugState) error {
+	p.finish(state)
+
+	switch p.lastOp {
+	case "callsub":
+		ref, _ := p.sourceMap.Function(state.PC)
+		p.calls = append(p.calls, callFrame{function: ref.Name})
+	case "retsub":
+		if len(p.calls) > 1 {
+			p.calls = p.calls[:len(p.calls)-1]
+		}
+	}
+	p.lastOp = p.ops[state.PC]
+	p.pending = p.sample(state.PC)
+	return nil
+}
+
+// Complete is called when the program exits
+func (p *Profiler) Complete(state *logic.DebugState) error {
+	p.finish(state)
+	return nil
+}
+
+// finish charges the pending step with budget spent on it.
+// Inner app calls extend the budget, such steps are accounted as free
+func (p *Profiler) finish(state *logic.DebugState) {
+	if p.pending != nil {
+		if cost := p.budget - state.OpcodeBudget; cost > 0 {
+			p.pending.Cost += cost
+		}
+		p.pending.Instructions++
+		p.pending = nil
+	}
+	p.budget = state.OpcodeBudget
+}
+
+// sample finds the sample of the instruction call stack, code of inline functions is a frame on top of its caller
+func (p *Profiler) sample(pc int) *Sample {
+	loc, _ := p.sourceMap.Location(pc)
+	ref, _ := p.sourceMap.Function(pc)
+	top := &p.calls[len(p.calls)-1]
+
+	stack := make([]Frame, 0, len(p.calls)+1)
+	if ref.Inline {
+		stack = append(stack, Frame{Function: ref.Name, Inline: true, File: loc.File, Line: loc.Line})
+	} else if loc.Line != 0 {
+		top.loc = loc
+	}
+	for i := len(p.calls) - 1; i >= 0; i-- {
+		c := p.calls[i]
+		stack = append(stack, Frame{Function: c.function, File: c.loc.File, Line: c.loc.Line})
+	}
+
+	parts := make([]string, len(stack))
+	for i, f := range stack {
+		parts[i] = fmt.Sprintf("%s@%s:%d", f.Name(), f.File, f.Line)
+	}
+	key := strings.Join(parts, ";")
+	s, ok := p.samples[key]
+	if !ok {
+		s = &Sample{Stack: stack}
+		p.samples[key] = s
+	}
+	return s
+}
+
+// Samples returns collected samples ordered by cost
+func (p *Profiler) Samples() []*Sample {
+	samples := make([]*Sample, 0, len(p.samples))
+	for _, s := range p.samples {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Cost != samples[j].Cost {
+			return samples[i].Cost > samples[j].Cost
+		}
+		return stackKey(samples[i]) < stackKey(samples[j])
+	})
+	return samples
+}
+
+func stackKey(s *Sample) string {
+	var sb strings.Builder
+	for _, f := range s.Stack {
+		fmt.Fprintf(&sb, "%s@%s:%d;", f.Name(), f.File, f.Line)
+	}
+	return sb.String()
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// Reports
+//
+//--------------------------------------------------------------------------------------------------
+
+// FunctionCost is cost of a function own code and cost including functions it calls
+type FunctionCost struct {
+	Name       string
+	Flat       int
+	Cumulative int
+}
+
+// LineCost is cost of instructions generated for a source line
+type LineCost struct {
+	File         string
+	Line         int
+	Function     string
+	Cost         int
+	Instructions int
+}
+
+// Location of the line as file:line
+func (l LineCost) Location() string {
+	if l.Line == 0 {
+		return "(no source)"
+	}
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// Total returns cost and number of executed instructions
+func (p *Profiler) Total() (cost int, instructions int) {
+	for _, s := range p.samples {
+		cost += s.Cost
+		instructions += s.Instructions
+	}
+	return
+}
+
+// Functions returns costs by function ordered by own cost, inline functions are separate from their callers
+func (p *Profiler) Functions() []FunctionCost {
+	costs := make(map[string]*FunctionCost)
+	get := func(name string) *FunctionCost {
+		fc, ok := costs[name]
+		if !ok {
+			fc = &FunctionCost{Name: name}
+			costs[name] = fc
+		}
+		return fc
+	}
+	for _, s := range p.samples {
+		get(s.Stack[0].Name()).Flat += s.Cost
+		// recursive functions appear in the stack several times but are charged once
+		seen := make(map[string]bool)
+		for _, f := range s.Stack {
+			if !seen[f.Name()] {
+				seen[f.Name()] = true
+				get(f.Name()).Cumulative += s.Cost
+			}
+		}
+	}
+	result := make([]FunctionCost, 0, len(costs))
+	for _, fc := range costs {
+		result = append(result, *fc)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Flat != result[j].Flat {
+			return result[i].Flat > result[j].Flat
+		}
+		if result[i].Cumulative != result[j].Cumulative {
+			return result[i].Cumulative > result[j].Cumulative
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Lines returns costs by source line ordered by cost
+func (p *Profiler) Lines() []LineCost {
+	type lineKey struct {
+		file     string
+		line     int
+		function string
+	}
+	costs := make(map[lineKey]*LineCost)
+	for _, s := range p.samples {
+		f := s.Stack[0]
+		key := lineKey{f.File, f.Line, f.Name()}
+		lc, ok := costs[key]
+		if !ok {
+			lc = &LineCost{File: f.File, Line: f.Line, Function: f.Name()}
+			costs[key] = lc
+		}
+		lc.Cost += s.Cost
+		lc.Instructions += s.Instructions
+	}
+	result := make([]LineCost, 0, len(costs))
+	for _, lc := range costs {
+		result = append(result, *lc)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Cost != b.Cost {
+			return a.Cost > b.Cost
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Function < b.Function
+	})
+	return result
+}
+
+func percent(part, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
+
+// WriteTable prints function and line costs sorted by cost
+func (p *Profiler) WriteTable(w io.Writer) error {
+	total, instructions := p.Total()
+	fmt.Fprintf(w, "total cost: %d, instructions executed: %d\n\n", total, instructions)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tFLAT\tFLAT%\tCUM\tCUM%")
+	for _, fc := range p.Functions() {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n", fc.Name, fc.Flat, percent(fc.Flat, total), fc.Cumulative, percent(fc.Cumulative, total))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tFUNCTION\tCOST\tCOST%\tINSTRUCTIONS")
+	for _, lc := range p.Lines() {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\n", lc.Location(), lc.Function, lc.Cost, percent(lc.Cost, total), lc.Instructions)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,107 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+const profileSource = `inline function hash(x) {
+    return sha256(x)
+}
+
+function rounds(n) {
+    let h = "seed"
+    for n > 0 {
+        h = hash(h)
+        n = n - 1
+    }
+    return h
+}
+
+function logic() {
+    let h = rounds(3)
+    return len(h) == 32
+}
+`
+
+func profile(t *testing.T) *Profiler {
+	a := require.New(t)
+	result, err := compiler.Compile(compiler.InputDesc{Source: profileSource, SourceFile: "prog.tl"}, compiler.Options{Assemble: true})
+	a.NoError(err)
+
+	p := New(result)
+	txn, err := dryrun.LoadTxn("")
+	a.NoError(err)
+	pass, err := dryrun.RunTxn(result.Bytecode, txn, nil, p)
+	a.NoError(err)
+	a.True(pass)
+	return p
+}
+
+func TestProfiler(t *testing.T) {
+	a := require.New(t)
+	p := profile(t)
+
+	total, instructions := p.Total()
+	a.Greater(total, instructions)
+
+	functions := p.Functions()
+	a.Len(functions, 3)
+	// sha256 dominates the cost, inline function is reported apart from its caller
+	a.Equal("hash (inline)", functions[0].Name)
+	a.Equal("rounds", functions[1].Name)
+	a.Equal("logic", functions[2].Name)
+	a.Equal(functions[0].Flat, functions[0].Cumulative)
+	a.Equal(functions[0].Flat+functions[1].Flat, functions[1].Cumulative)
+	a.Equal(total, functions[2].Cumulative)
+	a.Equal(total, functions[0].Flat+functions[1].Flat+functions[2].Flat)
+
+	lines := p.Lines()
+	a.Equal(LineCost{File: "prog.tl", Line: 2, Function: "hash (inline)", Cost: functions[0].Flat, Instructions: 9}, lines[0])
+	sum := 0
+	for _, lc := range lines {
+		sum += lc.Cost
+	}
+	a.Equal(total, sum)
+
+	// inline code is sampled on top of the caller line it is expanded at
+	found := false
+	for _, s := range p.Samples() {
+		if s.Stack[0].Inline {
+			a.Equal([]Frame{
+				{Function: "hash", Inline: true, File: "prog.tl", Line: 2},
+				{Function: "rounds", File: "prog.tl", Line: 8},
+				{Function: "logic", File: "prog.tl", Line: 15},
+			}, s.Stack)
+			found = true
+		}
+	}
+	a.True(found)
+
+	var out bytes.Buffer
+	a.NoError(p.WriteTable(&out))
+	a.Contains(out.String(), "FUNCTION       FLAT")
+	a.Contains(out.String(), "prog.tl:2   hash (inline)  111")
+}
+
+func TestPprof(t *testing.T) {
+	a := require.New(t)
+	p := profile(t)
+
+	var out bytes.Buffer
+	a.NoError(p.WritePprof(&out))
+	gz, err := gzip.NewReader(&out)
+	a.NoError(err)
+	data, err := ioutil.ReadAll(gz)
+	a.NoError(err)
+	for _, s := range []string{"cost", "instructions", "count", "hash (inline)", "rounds", "logic", "prog.tl"} {
+		a.Contains(string(data), s)
+	}
+}
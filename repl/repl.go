@@ -0,0 +1,331 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Interactive evaluation
+//
+//--------------------------------------------------------------------------------------------------
+
+package repl
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+// sourceFile is a name of the program assembled from the session inputs
+const sourceFile = "repl.tl"
+
+// valueVar holds the evaluated expression, underscore exempts it from unused variable warning
+const valueVar = "_repl"
+
+const helpText = `Enter declarations, statements or expressions, unbalanced braces continue the input.
+Expressions are evaluated against the transaction and their value and type are printed.
+Commands:
+  :txn [file.json]  use transaction from the file, no file selects the sample one
+  :import module    import a module like stdlib.const
+  :teal             show TEAL generated for the last input
+  :cost             show executed and estimated cost of the last input
+  :reset            forget all declarations and statements
+  :help             show this help
+  :quit             exit
+`
+
+var assignRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\s*,\s*[A-Za-z_][A-Za-z0-9_]*)*\s*=[^=]`)
+
+// statementKeywords start inputs that can't be expressions
+var statementKeywords = map[string]bool{
+	"let": true, "for": true, "assert": true, "error": true, "return": true, "log": true,
+	"break": true, "continue": true,
+}
+
+// Session keeps declarations and statements entered so far.
+// Every input is compiled into a program together with them and run in dryrun
+type Session struct {
+	out        io.Writer
+	opts       compiler.Options
+	currentDir string
+	txn        transactions.Transaction
+
+	imports []string
+	globals []string // constants and functions
+	stmts   []string // main function body
+
+	last     *compiler.Result
+	lastCost int
+}
+
+// NewSession creates a session printing results to out.
+// Transaction is loaded from txnFile, empty name selects the sample one
+func NewSession(out io.Writer, opts compiler.Options, txnFile string) (*Session, error) {
+	txn, err := dryrun.LoadTxn(txnFile)
+	if err != nil {
+		return nil, err
+	}
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	opts.Assemble = true
+	opts.OneLiner = false
+	return &Session{out: out, opts: opts, currentDir: currentDir, txn: txn}, nil
+}
+
+// Run reads inputs line by line until the end of input or :quit command
+func (s *Session) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	var pending []string
+	depth := 0
+	fmt.Fprint(s.out, "> ")
+	for scanner.Scan() {
+		line := scanner.Text()
+		pending = append(pending, line)
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+		if depth > 0 {
+			fmt.Fprint(s.out, "... ")
+			continue
+		}
+		input := strings.TrimSpace(strings.Join(pending, "\n"))
+		pending = pending[:0]
+		depth = 0
+		if input == ":quit" || input == ":q" {
+			return nil
+		}
+		s.Eval(input)
+		fmt.Fprint(s.out, "> ")
+	}
+	fmt.Fprintln(s.out)
+	return scanner.Err()
+}
+
+// Eval handles a single input: a command, a declaration, a statement or an expression
+func (s *Session) Eval(input string) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return
+	}
+	if strings.HasPrefix(input, ":") {
+		s.command(input)
+		return
+	}
+
+	switch firstWord(input) {
+	case "import":
+		s.addImport(strings.TrimSpace(strings.TrimPrefix(input, "import")))
+	case "const", "function", "inline":
+		if result := s.compile(s.imports, append(s.globals, input), s.stmts); result != nil {
+			s.globals = append(s.globals, input)
+		}
+	default:
+		s.evaluate(input)
+	}
+}
+
+func (s *Session) command(input string) {
+	fields := strings.Fields(input)
+	switch fields[0] {
+	case ":txn":
+		file := ""
+		if len(fields) > 1 {
+			file = fields[1]
+		}
+		txn, err := dryrun.LoadTxn(file)
+		if err != nil {
+			fmt.Fprintf(s.out, "error: %s\n", err.Error())
+			return
+		}
+		s.txn = txn
+	case ":import":
+		if len(fields) != 2 {
+			fmt.Fprintln(s.out, "error: :import requires a module name")
+			return
+		}
+		s.addImport(fields[1])
+	case ":teal":
+		if s.last == nil {
+			fmt.Fprintln(s.out, "nothing evaluated yet")
+			return
+		}
+		fmt.Fprint(s.out, s.last.TEAL)
+	case ":cost":
+		if s.last == nil {
+			fmt.Fprintln(s.out, "nothing evaluated yet")
+			return
+		}
+		fmt.Fprintf(s.out, "executed cost %d\n", s.lastCost)
+		fmt.Fprint(s.out, s.last.Cost.String())
+	case ":reset":
+		s.imports, s.globals, s.stmts = nil, nil, nil
+		s.last, s.lastCost = nil, 0
+	case ":help":
+		fmt.Fprint(s.out, helpText)
+	default:
+		fmt.Fprintf(s.out, "error: unknown command %s, try :help\n", fields[0])
+	}
+}
+
+func (s *Session) addImport(module string) {
+	imports := append(s.imports, module)
+	if result := s.compile(imports, s.globals, s.stmts); result != nil {
+		s.imports = imports
+	}
+}
+
+// evaluate tries the input as an expression first and then as a statement
+func (s *Session) evaluate(input string) {
+	stmts := append(append([]string{}, s.stmts...), fmt.Sprintf("let %s = %s", valueVar, input))
+	source, valueLine := s.program(s.imports, s.globals, stmts)
+	exprResult, exprErr := compiler.Compile(s.input(source), s.opts)
+	if exprErr == nil {
+		s.run(exprResult, valueLine)
+		return
+	}
+
+	stmts[len(stmts)-1] = input
+	source, _ = s.program(s.imports, s.globals, stmts)
+	stmtResult, stmtErr := compiler.Compile(s.input(source), s.opts)
+	if stmtErr == nil {
+		if s.run(stmtResult, 0) {
+			s.stmts = stmts
+		}
+		return
+	}
+
+	if statementKeywords[firstWord(input)] || assignRe.MatchString(input) {
+		s.printError(stmtErr)
+	} else {
+		s.printError(exprErr)
+	}
+}
+
+// compile checks the program made of the parts and prints errors if any
+func (s *Session) compile(imports []string, globals []string, stmts []string) *compiler.Result {
+	source, _ := s.program(imports, globals, stmts)
+	result, err := compiler.Compile(s.input(source), s.opts)
+	if err != nil {
+		s.printError(err)
+		return nil
+	}
+	s.last = result
+	return result
+}
+
+// program assembles the source and returns the line of the last statement
+func (s *Session) program(imports []string, globals []string, stmts []string) (string, int) {
+	lines := make([]string, 0, len(imports)+len(globals)+len(stmts)+3)
+	for _, module := range imports {
+		lines = append(lines, "import "+module)
+	}
+	lines = append(lines, globals...)
+	lines = append(lines, "function logic() {")
+	lines = append(lines, stmts...)
+	lastLine := strings.Count(strings.Join(lines, "\n"), "\n") + 1
+	lines = append(lines, "return 1", "}")
+	return strings.Join(lines, "\n") + "\n", lastLine
+}
+
+func (s *Session) input(source string) compiler.InputDesc {
+	return compiler.InputDesc{Source: source, SourceFile: sourceFile, SourceDir: s.currentDir, CurrentDir: s.currentDir}
+}
+
+// run evaluates the program and prints the value stored at valueLine if it is not zero.
+// It reports if the program succeeded
+func (s *Session) run(result *compiler.Result, valueLine int) bool {
+	s.last = result
+	hook := &valueHook{pc: -1}
+	for pc := range result.SourceMap.OffsetToLine {
+		// the value is on top of the stack right before the last instruction of its statement
+		loc, ok := result.SourceMap.Location(pc)
+		if ok && loc.File == sourceFile && loc.Line == valueLine && pc > hook.pc {
+			hook.pc = pc
+		}
+	}
+
+	_, err := dryrun.RunTxn(result.Bytecode, s.txn, nil, hook)
+	s.lastCost = hook.cost
+	if err != nil {
+		fmt.Fprintf(s.out, "error: %s\n", err.Error())
+		return false
+	}
+	if valueLine != 0 && hook.value != nil {
+		fmt.Fprintln(s.out, formatValue(*hook.value))
+	}
+	return true
+}
+
+func (s *Session) printError(err error) {
+	var parserErrors compiler.ParserErrors
+	if !errors.As(err, &parserErrors) {
+		fmt.Fprintf(s.out, "error: %s\n", err.Error())
+		return
+	}
+	for _, e := range parserErrors {
+		d := e.Diagnostic()
+		if d.File != sourceFile && d.File != "" {
+			fmt.Fprintf(s.out, "error: %s:%d:%d: %s\n", d.File, d.Line, d.Column, d.Message)
+		} else {
+			fmt.Fprintf(s.out, "error: %s\n", d.Message)
+		}
+	}
+}
+
+// valueHook captures the stack at the given program counter and the cost of evaluation
+type valueHook struct {
+	pc      int
+	value   *basics.TealValue
+	maxCost int
+	cost    int
+}
+
+func (h *valueHook) Register(state *logic.DebugState) error {
+	h.maxCost = int(state.Proto.LogicSigMaxCost)
+	return nil
+}
+
+func (h *valueHook) Update(state *logic.DebugState) error {
+	if state.PC == h.pc && len(state.Stack) > 0 {
+		value := state.Stack[len(state.Stack)-1]
+		h.value = &value
+	}
+	return nil
+}
+
+func (h *valueHook) Complete(state *logic.DebugState) error {
+	h.cost = h.maxCost - state.OpcodeBudget
+	return nil
+}
+
+// formatValue prints a value with its type.
+// Debugger state keeps bytes base64-encoded
+func formatValue(value basics.TealValue) string {
+	if value.Type == basics.TealUintType {
+		return fmt.Sprintf("%d: uint64", value.Uint)
+	}
+	data, err := base64.StdEncoding.DecodeString(value.Bytes)
+	if err != nil {
+		data = []byte(value.Bytes)
+	}
+	return fmt.Sprintf("%s: byte[]", dryrun.FormatBytes(data))
+}
+
+func firstWord(input string) string {
+	end := strings.IndexFunc(input, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	if end < 0 {
+		return input
+	}
+	return input[:end]
+}
@@ -0,0 +1,81 @@
+package repl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+)
+
+func TestSession(t *testing.T) {
+	a := require.New(t)
+
+	var out bytes.Buffer
+	s, err := NewSession(&out, compiler.Options{}, "")
+	a.NoError(err)
+
+	eval := func(input string) string {
+		out.Reset()
+		s.Eval(input)
+		return out.String()
+	}
+
+	a.Equal("3: uint64\n", eval("1 + 2"))
+	a.Equal("\"abc\": byte[]\n", eval(`"abc"`))
+	a.Equal("0x0102: byte[]\n", eval(`"\x01\x02"`))
+	a.Equal("1000: uint64\n", eval("txn.Fee"))
+
+	// statements and declarations are kept for later inputs
+	a.Empty(eval("let x = 5"))
+	a.Empty(eval("x = x + 1"))
+	a.Empty(eval("function double(a) {\n\treturn a * 2\n}"))
+	a.Empty(eval("const c = 10"))
+	a.Equal("22: uint64\n", eval("double(x) + c"))
+
+	a.Empty(eval(":import stdlib.const"))
+	a.Equal("1: uint64\n", eval("TxTypePayment"))
+
+	a.Contains(eval(":teal"), "pushint 5")
+	a.Contains(eval(":cost"), "executed cost")
+
+	a.Contains(eval("y + 1"), "error:")
+	a.Contains(eval("let z = "), "error:")
+	// failed statements are not kept
+	a.Contains(eval("assert(x == 1)"), "assert failed")
+	a.Equal("6: uint64\n", eval("x"))
+
+	dir, err := ioutil.TempDir("", "tealang-repl")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	txnFile := path.Join(dir, "txn.json")
+	sample, err := ioutil.ReadFile("../dryrun/sampletxn.json")
+	a.NoError(err)
+	data := strings.Replace(string(sample), `"Fee": 1000`, `"Fee": 2500`, 1)
+	a.NoError(ioutil.WriteFile(txnFile, []byte(data), 0644))
+	a.Empty(eval(":txn " + txnFile))
+	a.Equal("2500: uint64\n", eval("txn.Fee"))
+	a.Contains(eval(":txn missing.json"), "error:")
+
+	a.Empty(eval(":reset"))
+	a.Contains(eval("x"), "error:")
+	a.Equal("nothing evaluated yet\n", eval(":teal"))
+	a.Contains(eval(":unknown"), "unknown command")
+}
+
+func TestSessionRun(t *testing.T) {
+	a := require.New(t)
+
+	var out bytes.Buffer
+	s, err := NewSession(&out, compiler.Options{}, "")
+	a.NoError(err)
+
+	input := "function f() {\nreturn 7\n}\nf()\n:quit\n1\n"
+	a.NoError(s.Run(strings.NewReader(input)))
+	a.Equal("> ... ... > 7: uint64\n> ", out.String())
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+func compileApp(t *testing.T, input compiler.InputDesc) []byte {
+	t.Helper()
+	result, err := compiler.Compile(input, compiler.Options{Assemble: true})
+	require.NoError(t, err)
+	return result.Bytecode
+}
+
+func TestAppCall(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	let count = apps[0].get("count")
+	apps[0].put("count", toint(count) + 1)
+	apps[0].del("old")
+	accounts[0].put("seen", "yes")
+	log("hello")
+	log("\x01\x02")
+
+	itxn.begin()
+	itxn.TypeEnum = 1
+	itxn.Amount = 5000
+	itxn.Receiver = txn.Sender
+	itxn.submit()
+	return accounts[0].Balance > 0
+}
+`
+	bytecode := compileApp(t, compiler.InputDesc{Source: source})
+
+	dir, err := ioutil.TempDir("", "tealang-app")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	txn, err := dryrun.LoadTxn("")
+	a.NoError(err)
+	appAddr := basics.AppIndex(1000).Address()
+	ledgerData := fmt.Sprintf(`{
+	"Accounts": [
+		{"Address": "%s", "Balance": 1000000, "Locals": [{"AppID": 1000, "State": {}}]},
+		{"Address": "%s", "Balance": 100000}
+	],
+	"Apps": [{"AppID": 1000, "Global": {"count": {"Uint": 41}, "old": {"Bytes": "AQI="}}}]
+}`, txn.Sender, appAddr)
+	ledgerFile := path.Join(dir, "ledger.json")
+	a.NoError(ioutil.WriteFile(ledgerFile, []byte(ledgerData), 0644))
+
+	ledger, err := dryrun.LoadLedger(ledgerFile)
+	a.NoError(err)
+	txn.ApplicationID = 1000
+	result, err := dryrun.RunApp(bytecode, txn, ledger, nil, nil)
+	a.NoError(err)
+	a.True(result.Pass)
+	a.Equal(basics.ValueDelta{Action: basics.SetUintAction, Uint: 42}, result.Delta.GlobalDelta["count"])
+	a.Equal(basics.DeleteAction, result.Delta.GlobalDelta["old"].Action)
+	a.Equal(basics.ValueDelta{Action: basics.SetBytesAction, Bytes: "yes"}, result.Delta.LocalDeltas[0]["seen"])
+	a.Equal([]string{"hello", "\x01\x02"}, result.Delta.Logs)
+	a.Len(result.Delta.InnerTxns, 1)
+
+	report := result.String()
+	a.Contains(report, "app 1000\n")
+	a.Contains(report, "global state:\n    \"count\" = 42\n    \"old\" deleted\n")
+	a.Contains(report, fmt.Sprintf("local state of %s:\n    \"seen\" = \"yes\"\n", txn.Sender))
+	a.Contains(report, "logs:\n    \"hello\"\n    0x0102\n")
+	a.Contains(report, fmt.Sprintf("0: pay 5000 to %s from %s fee 1000", txn.Sender, appAddr))
+
+	// the ledger keeps changes for the next call
+	result, err = dryrun.RunApp(bytecode, txn, ledger, nil, nil)
+	a.NoError(err)
+	a.Equal(uint64(43), result.Delta.GlobalDelta["count"].Uint)
+
+	// app account can't pay anymore
+	ledger, err = dryrun.LoadLedger("")
+	a.NoError(err)
+	_, err = dryrun.RunApp(bytecode, txn, ledger, nil, nil)
+	a.Error(err)
+}
+
+func TestAppCallNFT(t *testing.T) {
+	a := require.New(t)
+
+	fullPath := path.Join("..", "examples", "nft", "approval.tl")
+	source, err := ioutil.ReadFile(fullPath)
+	a.NoError(err)
+	bytecode := compileApp(t, compiler.InputDesc{Source: string(source), SourceFile: path.Base(fullPath), SourceDir: path.Dir(fullPath)})
+
+	txn, err := dryrun.LoadTxn(path.Join("..", "examples", "nft", "create.json"))
+	a.NoError(err)
+	ledger := dryrun.NewLedger()
+	trace := strings.Builder{}
+	result, err := dryrun.RunApp(bytecode, txn, ledger, &trace, nil)
+	a.NoError(err, trace.String())
+	a.True(result.Pass)
+	a.Equal(basics.ValueDelta{Action: basics.SetBytesAction, Bytes: string(txn.Sender[:])}, result.Delta.GlobalDelta["c"])
+	a.Equal(uint64(result.AppID), result.Delta.GlobalDelta["a"].Uint)
+
+	txn, err = dryrun.LoadTxn(path.Join("..", "examples", "nft", "shard_create.json"))
+	a.NoError(err)
+	ledger, err = dryrun.LoadLedger(path.Join("..", "examples", "nft", "ledger.json"))
+	a.NoError(err)
+	result, err = dryrun.RunApp(bytecode, txn, ledger, nil, nil)
+	a.NoError(err)
+	a.True(result.Pass)
+	a.Equal(uint64(7), result.Delta.GlobalDelta["s\x00\x00\x00\x00\x00\x00\x00\x00"].Uint)
+
+	// only the creator makes shards
+	txn.Sender = basics.Address{1}
+	result, err = dryrun.RunApp(bytecode, txn, ledger, nil, nil)
+	a.NoError(err)
+	a.False(result.Pass)
+	a.Equal(transactions.EvalDelta{GlobalDelta: basics.StateDelta{}, LocalDeltas: map[uint64]basics.StateDelta{}}, result.Delta)
+}
@@ -126,3 +126,28 @@ function logic() {
 `
 	performTest(t, source)
 }
+
+func TestFuncSlotsAlloc4(t *testing.T) {
+	source := `
+inline function double(x) { return x * 2; }
+inline function sub(a, b) { return a - b; }
+
+function logic() {
+	let a = 10
+	if a > 5 {
+		let b = 1
+		a = a + b
+	} else {
+		let c = 2
+		a = a + c
+	}
+	// nested inline call in arguments must not overwrite the first argument
+	let d = sub(a, double(3))
+	assert(d == 5)
+	let e = sub(double(4), sub(a, 9))
+	assert(e == 6)
+	return 1
+}
+`
+	performTest(t, source)
+}
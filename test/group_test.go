@@ -0,0 +1,163 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/algorand/go-algorand/protocol"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+func TestGroupDynamicFee(t *testing.T) {
+	a := require.New(t)
+
+	group, err := dryrun.LoadGroup(path.Join("..", "examples", "group", "dynamic_fee.json"))
+	a.NoError(err)
+	a.Len(group, 2)
+	a.Empty(group[0].LogicSigFile)
+	a.Equal(path.Join("..", "examples", "group", "dynamic_fee.tl"), group[1].LogicSigFile)
+
+	source, err := ioutil.ReadFile(group[1].LogicSigFile)
+	a.NoError(err)
+	group[1].LogicSig = compileApp(t, compiler.InputDesc{Source: string(source)})
+
+	results := dryrun.RunGroup(group, nil)
+	a.Len(results, 2)
+	a.True(results[0].Pass)
+	a.Empty(results[0].LogicSigTrace)
+	a.True(results[1].Pass, results[1].LogicSigTrace)
+	a.NoError(results[1].Err)
+	a.True(dryrun.GroupPassed(results))
+
+	// reimbursement does not match the fee
+	group[0].Txn.Amount.Raw = 999
+	results = dryrun.RunGroup(group, nil)
+	a.True(results[0].Pass)
+	a.False(results[1].Pass)
+	a.NoError(results[1].Err)
+	a.False(dryrun.GroupPassed(results))
+
+	// the contract requires two transactions
+	results = dryrun.RunGroup(group[1:], nil)
+	a.False(results[0].Pass)
+	a.Error(results[0].Err)
+}
+
+func TestGroupAppCall(t *testing.T) {
+	a := require.New(t)
+
+	source := `
+function logic() {
+	assert(global.GroupSize == 3 && txn.GroupIndex == 1)
+	assert(gtxn[0].TypeEnum == TxTypePayment && gtxn[0].Receiver == global.CurrentApplicationAddress)
+	let paid = gtxn[0].Amount
+	apps[0].put("paid", paid)
+
+	itxn.begin()
+	itxn.TypeEnum = TxTypePayment
+	itxn.Amount = paid / 2
+	itxn.Receiver = txn.Sender
+	itxn.submit()
+	return 1
+}
+`
+	approval := compileApp(t, compiler.InputDesc{Source: "import stdlib.const\n" + source})
+	check := compileApp(t, compiler.InputDesc{Source: `
+function logic() {
+	return toint(apps[0].get("paid")) == 100000 && gtxn[1].Type == "appl"
+}
+`})
+
+	sender := basics.Address{1}
+	appAddr := basics.AppIndex(1000).Address()
+	pay := transactions.Transaction{Type: protocol.PaymentTx, Header: transactions.Header{Sender: sender}}
+	pay.Receiver = appAddr
+	pay.Amount.Raw = 100000
+	call := transactions.Transaction{Type: protocol.ApplicationCallTx, Header: transactions.Header{Sender: sender}}
+	call.ApplicationID = 1000
+	group := []dryrun.GroupTxn{{Txn: pay}, {Txn: call, Approval: approval}, {Txn: call, Approval: check}}
+
+	// group size check fails in the middle transaction
+	results := dryrun.RunGroup(group[:2], dryrun.NewLedger())
+	a.False(results[0].Pass)
+	a.Error(results[0].Err)
+
+	// transfers are applied so the app can pay back half of the payment
+	dir, err := ioutil.TempDir("", "tealang-group")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	ledgerFile := path.Join(dir, "ledger.json")
+	a.NoError(ioutil.WriteFile(ledgerFile, []byte(fmt.Sprintf(`{"Accounts": [{"Address": "%s", "Balance": 1000000}]}`, sender)), 0644))
+	ledger, err := dryrun.LoadLedger(ledgerFile)
+	a.NoError(err)
+	results = dryrun.RunGroup(group, ledger)
+	for i, r := range results {
+		a.NoError(r.Err, "%d: %s", i, r.AppTrace)
+		a.True(r.Pass, i)
+	}
+	a.Nil(results[0].App)
+	a.Equal(uint64(100000), results[1].App.Delta.GlobalDelta["paid"].Uint)
+	a.Len(results[1].App.Delta.InnerTxns, 1)
+	a.True(results[2].App.Pass)
+}
+
+func TestGroupRejectedAppCall(t *testing.T) {
+	a := require.New(t)
+
+	rejected := compileApp(t, compiler.InputDesc{Source: `
+import stdlib.const
+function logic() {
+	apps[0].put("paid", gtxn[0].Amount)
+	itxn.begin()
+	itxn.TypeEnum = TxTypePayment
+	itxn.Amount = gtxn[0].Amount / 2
+	itxn.Receiver = txn.Sender
+	itxn.submit()
+	return 0
+}
+`})
+	check := compileApp(t, compiler.InputDesc{Source: `
+function logic() {
+	return toint(apps[0].get("paid")) == 0
+}
+`})
+
+	sender := basics.Address{1}
+	appAddr := basics.AppIndex(1000).Address()
+	pay := transactions.Transaction{Type: protocol.PaymentTx, Header: transactions.Header{Sender: sender}}
+	pay.Receiver = appAddr
+	pay.Amount.Raw = 100000
+	call := transactions.Transaction{Type: protocol.ApplicationCallTx, Header: transactions.Header{Sender: sender}}
+	call.ApplicationID = 1000
+	group := []dryrun.GroupTxn{{Txn: pay}, {Txn: call, Approval: rejected}, {Txn: call, Approval: check}}
+
+	dir, err := ioutil.TempDir("", "tealang-group")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	ledgerFile := path.Join(dir, "ledger.json")
+	a.NoError(ioutil.WriteFile(ledgerFile, []byte(fmt.Sprintf(`{"Accounts": [{"Address": "%s", "Balance": 1000000}]}`, sender)), 0644))
+	ledger, err := dryrun.LoadLedger(ledgerFile)
+	a.NoError(err)
+
+	// the rejected call writes global state and pays back but none of it is applied
+	results := dryrun.RunGroup(group, ledger)
+	a.True(results[0].Pass)
+	a.NoError(results[1].Err, results[1].AppTrace)
+	a.False(results[1].Pass)
+	a.Equal(uint64(100000), results[1].App.Delta.GlobalDelta["paid"].Uint)
+	a.Len(results[1].App.Delta.InnerTxns, 1)
+	a.NoError(results[2].Err, results[2].AppTrace)
+	a.True(results[2].Pass, results[2].AppTrace)
+
+	data, err := ledger.AccountData(appAddr)
+	a.NoError(err)
+	a.Equal(uint64(100000), data.MicroAlgos.Raw)
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"testing"
+)
+
+func TestLoopBreakContinue(t *testing.T) {
+	source := `
+function logic() {
+	let i = 0
+	let sum = 0
+	for 1 {
+		i = i + 1
+		if i > 10 {
+			break
+		}
+		if i % 2 == 0 {
+			continue
+		}
+		if i == 7 {
+			break
+		}
+		sum = sum + i
+	}
+	assert(i == 7)
+	assert(sum == 1 + 3 + 5)
+	return 1
+}
+`
+	performTest(t, source)
+}
+
+func TestLoopLabeled(t *testing.T) {
+	source := `
+function logic() {
+	let i = 0
+	let count = 0
+	outer: for i < 5 {
+		i = i + 1
+		let j = 0
+		for j < 5 {
+			j = j + 1
+			if j == 2 {
+				continue outer
+			}
+			if i == 4 {
+				break outer
+			}
+			count = count + 1
+		}
+		count = count + 100
+	}
+	assert(i == 4)
+	assert(count == 3)
+	return 1
+}
+`
+	performTest(t, source)
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+func traceProgram(t *testing.T, source string) (*dryrun.Tracer, bool, error) {
+	t.Helper()
+	result, err := compiler.Compile(compiler.InputDesc{Source: source, SourceFile: "trace.tl"}, compiler.Options{Assemble: true})
+	require.NoError(t, err)
+	tracer := dryrun.NewTracer(func(pc int) (string, string) {
+		location := ""
+		if loc, ok := result.SourceMap.Location(pc); ok {
+			location = loc.String()
+		}
+		variable, _ := result.SourceMap.Variable(pc)
+		return location, variable
+	})
+	txn, err := dryrun.LoadTxn("")
+	require.NoError(t, err)
+	pass, err := dryrun.RunTxn(result.Bytecode, txn, nil, tracer)
+	return tracer, pass, err
+}
+
+func TestTraceSteps(t *testing.T) {
+	a := require.New(t)
+
+	tracer, pass, err := traceProgram(t, `function logic() {
+	let fee = txn.Fee
+	let note = "abc"
+	return fee == 1000 && len(note) == 3
+}
+`)
+	a.NoError(err)
+	a.True(pass)
+
+	steps := tracer.Steps
+	a.Equal("txn Fee", steps[0].Op)
+	a.Equal("trace.tl:2:11", steps[0].Location)
+	a.Equal([]dryrun.TraceValue{{Type: "uint64", Value: uint64(1000)}}, steps[0].Stack)
+	a.Equal("store 0", steps[1].Op)
+	a.Empty(steps[1].Stack)
+	a.Equal([]dryrun.ScratchAccess{{Slot: 0, Variable: "fee", Value: dryrun.TraceValue{Type: "uint64", Value: uint64(1000)}, Write: true}}, steps[1].Scratch)
+	a.Equal(steps[0].Budget-1, steps[1].Budget)
+
+	var load *dryrun.TraceStep
+	for i := range steps {
+		if steps[i].Op == "load 1" {
+			load = &steps[i]
+		}
+	}
+	a.NotNil(load)
+	a.Equal([]dryrun.ScratchAccess{{Slot: 1, Variable: "note", Value: dryrun.TraceValue{Type: "bytes", Value: []byte("abc")}}}, load.Scratch)
+
+	var sb strings.Builder
+	a.NoError(tracer.WriteJSON(&sb))
+	var records []map[string]interface{}
+	a.NoError(json.Unmarshal([]byte(sb.String()), &records))
+	a.Len(records, len(steps))
+	for _, record := range records {
+		if record["op"] == "load 1" {
+			stack := record["stack"].([]interface{})
+			a.Equal(map[string]interface{}{"type": "bytes", "value": "YWJj"}, stack[len(stack)-1])
+		}
+	}
+
+	sb.Reset()
+	a.NoError(tracer.WriteTable(&sb))
+	table := sb.String()
+	a.Contains(table, "fee=1000")
+	a.Contains(table, `note="abc"`)
+	a.True(strings.HasPrefix(table, "PC  OP"))
+}
+
+func TestTraceError(t *testing.T) {
+	a := require.New(t)
+
+	tracer, pass, err := traceProgram(t, `function logic() {
+	let x = txn.Fee
+	return x - 2000
+}
+`)
+	a.Error(err)
+	a.False(pass)
+	last := tracer.Steps[len(tracer.Steps)-1]
+	a.Equal("-", last.Op)
+	a.Contains(last.Error, "would result negative")
+
+	var sb strings.Builder
+	a.NoError(tracer.WriteTable(&sb))
+	// the failed instruction points at its operator
+	a.Contains(sb.String(), "trace.tl:3:10 ERROR: - would result negative")
+}
@@ -0,0 +1,189 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand/data/basics"
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/algorand/go-algorand/protocol"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+func writeFixture(t *testing.T, dir string, name string, data []byte) string {
+	t.Helper()
+	file := path.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(file, data, 0644))
+	return file
+}
+
+func TestTxnFixtureFields(t *testing.T) {
+	a := require.New(t)
+	dir, err := ioutil.TempDir("", "tealang-txn")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	const sender = "47YPQTIGQEO7T4Y4RWDYWEKV6RTR2UNBQXBABEEGM72ESWDQNCQ52OPASU"
+	const other = "PNWOET7LLOWMBMLE4KOCELCX6X3D3Q4H2Q4QJASYIEOF7YIPPQBG3YQ5YI"
+	file := writeFixture(t, dir, "acfg.json", []byte(`{
+	"Sender": "`+sender+`",
+	"Fee": 1000,
+	"FirstValid": 10,
+	"LastValid": 20,
+	"TypeEnum": 3,
+	"RekeyTo": "`+other+`",
+	"ConfigAssetTotal": 1000000,
+	"ConfigAssetDecimals": 2,
+	"ConfigAssetDefaultFrozen": 1,
+	"ConfigAssetUnitName": "TL",
+	"ConfigAssetName": "tealang coin",
+	"ConfigAssetURL": "https://example.com",
+	"ConfigAssetMetadataHash": "AQID",
+	"ConfigAssetManager": "`+sender+`",
+	"ConfigAssetClawback": "`+other+`"
+}`))
+	txn, err := dryrun.LoadTxn(file)
+	a.NoError(err)
+	a.Equal(protocol.AssetConfigTx, txn.Type)
+	a.Equal("tealang coin", txn.AssetParams.AssetName)
+	a.True(txn.AssetParams.DefaultFrozen)
+	a.Equal(byte(3), txn.AssetParams.MetadataHash[2])
+	a.Equal(other, txn.AssetParams.Clawback.String())
+	a.True(txn.AssetParams.Reserve.IsZero())
+
+	source := `
+function logic() {
+	let ok = txn.TypeEnum == 3 && txn.ConfigAssetTotal == 1000000 && txn.ConfigAssetDecimals == 2
+	ok = ok && txn.ConfigAssetDefaultFrozen == 1 && txn.ConfigAssetUnitName == "TL" && txn.ConfigAssetName == "tealang coin"
+	ok = ok && txn.ConfigAssetURL == "https://example.com" && substring(txn.ConfigAssetMetadataHash, 0, 3) == "\x01\x02\x03"
+	ok = ok && txn.RekeyTo == addr"` + other + `" && txn.ConfigAssetManager == txn.Sender
+	return ok
+}
+`
+	bytecode := compileApp(t, compiler.InputDesc{Source: source})
+	sb := strings.Builder{}
+	pass, err := dryrun.RunTxn(bytecode, txn, &sb, nil)
+	a.NoError(err, sb.String())
+	a.True(pass, sb.String())
+
+	file = writeFixture(t, dir, "appl.json", []byte(`{
+	"Type": "appl",
+	"Sender": "`+sender+`",
+	"ApplicationID": 5,
+	"OnCompletion": 1,
+	"ApplicationArgs": ["AQ==", "Ag=="],
+	"Accounts": ["`+other+`"],
+	"Assets": [10, 11],
+	"Applications": [7],
+	"ApprovalProgram": "BoEBQw==",
+	"GlobalNumUint": 1,
+	"GlobalNumByteSlice": 2,
+	"LocalNumUint": 3,
+	"LocalNumByteSlice": 4,
+	"ExtraProgramPages": 1
+}`))
+	txn, err = dryrun.LoadTxn(file)
+	a.NoError(err)
+	a.Equal(transactions.OptInOC, txn.OnCompletion)
+	a.Equal([]basics.AssetIndex{10, 11}, txn.ForeignAssets)
+	a.Equal([]basics.AppIndex{7}, txn.ForeignApps)
+	a.Equal([]byte{6, 0x81, 1, 0x43}, txn.ApprovalProgram)
+	a.Equal(basics.StateSchema{NumUint: 3, NumByteSlice: 4}, txn.LocalStateSchema)
+	a.Equal(uint32(1), txn.ExtraProgramPages)
+
+	source = `
+function logic() {
+	let ok = txn.NumAppArgs == 2 && txn.NumAssets == 2 && txn.NumApplications == 1
+	ok = ok && txn.Assets[1] == 11 && txn.Applications[1] == 7 && txn.GlobalNumByteSlice == 2 && txn.ExtraProgramPages == 1
+	return ok
+}
+`
+	bytecode = compileApp(t, compiler.InputDesc{Source: source})
+	pass, err = dryrun.RunTxn(bytecode, txn, nil, nil)
+	a.NoError(err)
+	a.True(pass)
+
+	file = writeFixture(t, dir, "keyreg.json", []byte(`{
+	"Type": "keyreg",
+	"Sender": "`+sender+`",
+	"VotePK": "AQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyA=",
+	"SelectionPK": "AQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyA=",
+	"VoteFirst": 1,
+	"VoteLast": 100,
+	"VoteKeyDilution": 10,
+	"Nonparticipation": 1
+}`))
+	txn, err = dryrun.LoadTxn(file)
+	a.NoError(err)
+	a.Equal(byte(32), txn.VotePK[31])
+	a.Equal(byte(1), txn.SelectionPK[0])
+	a.Equal(basics.Round(100), txn.VoteLast)
+	a.True(txn.Nonparticipation)
+
+	file = writeFixture(t, dir, "short.json", []byte(`{"Type": "keyreg", "VotePK": "AQID"}`))
+	_, err = dryrun.LoadTxn(file)
+	a.EqualError(err, "VotePK must be 32 bytes long, got 3")
+
+	file = writeFixture(t, dir, "lease.json", []byte(`{"Lease": "`+strings.Repeat("A", 44)+`"}`))
+	_, err = dryrun.LoadTxn(file)
+	a.EqualError(err, "Lease must be 32 bytes long, got 33")
+}
+
+func TestTxnFixtureGoal(t *testing.T) {
+	a := require.New(t)
+	dir, err := ioutil.TempDir("", "tealang-txn")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	sender, err := basics.UnmarshalChecksumAddress("47YPQTIGQEO7T4Y4RWDYWEKV6RTR2UNBQXBABEEGM72ESWDQNCQ52OPASU")
+	a.NoError(err)
+	pay := transactions.SignedTxn{Txn: transactions.Transaction{Type: protocol.PaymentTx, Header: transactions.Header{Sender: sender, Fee: basics.MicroAlgos{Raw: 1000}}}}
+	pay.Txn.Receiver = basics.Address{1}
+	pay.Txn.Amount.Raw = 5000
+
+	file := writeFixture(t, dir, "pay.txn", protocol.Encode(&pay))
+	txn, err := dryrun.LoadTxn(file)
+	a.NoError(err)
+	a.Equal(pay.Txn, txn)
+
+	file = writeFixture(t, dir, "pay.json", protocol.EncodeJSON(&pay))
+	txn, err = dryrun.LoadTxn(file)
+	a.NoError(err)
+	a.Equal(pay.Txn, txn)
+
+	// group of signed transactions with a logic sig
+	bytecode := compileApp(t, compiler.InputDesc{Source: `
+function logic() {
+	return global.GroupSize == 2 && gtxn[0].Amount == 5000 && args[0] == "secret"
+}
+`})
+	escrow := transactions.SignedTxn{Txn: pay.Txn, Lsig: transactions.LogicSig{Logic: bytecode, Args: [][]byte{[]byte("secret")}}}
+	escrow.Txn.Amount.Raw = 100
+	data := append(protocol.Encode(&pay), protocol.Encode(&escrow)...)
+	file = writeFixture(t, dir, "group.txn", data)
+	group, err := dryrun.LoadGroup(file)
+	a.NoError(err)
+	a.Len(group, 2)
+	a.Equal(bytecode, group[1].LogicSig)
+	a.Empty(group[1].LogicSigFile)
+	results := dryrun.RunGroup(group, nil)
+	a.True(dryrun.GroupPassed(results), results[1].LogicSigTrace)
+
+	// goal JSON and tealang fixtures mix in a group
+	file = writeFixture(t, dir, "group.json", []byte(`[
+	{"Type": "pay", "Sender": "47YPQTIGQEO7T4Y4RWDYWEKV6RTR2UNBQXBABEEGM72ESWDQNCQ52OPASU", "Amount": 5000},
+	`+string(protocol.EncodeJSON(&escrow))+`
+]`))
+	group, err = dryrun.LoadGroup(file)
+	a.NoError(err)
+	a.Equal(uint64(5000), group[0].Txn.Amount.Raw)
+	a.Equal([][]byte{[]byte("secret")}, group[1].Args)
+	results = dryrun.RunGroup(group, nil)
+	a.True(dryrun.GroupPassed(results), results[1].LogicSigTrace)
+}
@@ -35,4 +35,15 @@ func performTest(t *testing.T, source string) {
 
 	a.NoError(err)
 	a.True(pass)
+
+	// optimized program must not grow and must behave the same way
+	result, err := compiler.Compile(compiler.InputDesc{Source: source}, compiler.Options{Assemble: true, OptimizationLevel: 1})
+	a.NoError(err)
+	a.LessOrEqual(len(result.Bytecode), len(op.Program))
+	a.Equal(len(result.Bytecode), result.Cost.Size)
+
+	sb = strings.Builder{}
+	pass, err = dryrun.Run(result.Bytecode, "", &sb)
+	a.NoError(err, sb.String())
+	a.True(pass, sb.String())
 }
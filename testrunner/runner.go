@@ -0,0 +1,319 @@
+//--------------------------------------------------------------------------------------------------
+//
+// Unit tests for tealang sources
+//
+//--------------------------------------------------------------------------------------------------
+
+package testrunner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/algorand/go-algorand/data/transactions"
+	"github.com/algorand/go-algorand/data/transactions/logic"
+
+	"github.com/pzbitskiy/tealang/compiler"
+	"github.com/pzbitskiy/tealang/coverage"
+	"github.com/pzbitskiy/tealang/dryrun"
+)
+
+// FileSuffix marks files with test functions
+const FileSuffix = "_test.tl"
+
+// txnDirective matches comments like "// tealang:txn fixtures/transfer.json" selecting transaction of the test below
+var txnDirective = regexp.MustCompile(`^\s*//\s*tealang:txn\s+(\S+)`)
+
+// Status is an outcome of a test
+type Status string
+
+// Test outcomes: the program approved the transaction, evaluation or compilation failed or the program rejected it
+const (
+	Pass   Status = "PASS"
+	Fail   Status = "FAIL"
+	Reject Status = "REJECT"
+)
+
+// Result of a single test function
+type Result struct {
+	File     string
+	Name     string
+	Line     int
+	Status   Status
+	Message  string
+	Trace    string
+	Duration time.Duration
+}
+
+// Options controls test runs
+type Options struct {
+	Compiler compiler.Options
+	// Run selects tests by name, nil runs all of them
+	Run *regexp.Regexp
+	// Coverage accumulates line and branch coverage of sources under test if set, test files are not covered
+	Coverage *coverage.Profile
+}
+
+// FindFiles returns test files among the paths, directories are searched recursively
+func FindFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(file, FileSuffix) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// RunFile compiles every selected test function of the file into a program calling it
+// and evaluates the program in dryrun against the test transaction.
+// Transaction is set by "// tealang:txn file.json" comment above the test function,
+// otherwise a companion file like transfer_test.json is used if exists or the sample one
+func RunFile(file string, opts Options) ([]Result, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	source := string(data)
+	lines := strings.Split(source, "\n")
+	dir := path.Dir(file)
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	defaultTxn := ""
+	companion := strings.TrimSuffix(file, ".tl") + ".json"
+	if _, err := os.Stat(companion); err == nil {
+		defaultTxn = companion
+	}
+
+	opts.Compiler.Assemble = true
+	opts.Compiler.OneLiner = false
+	resolve := coverage.Resolver(file, currentDir)
+	sources := func(name string) string {
+		if strings.HasSuffix(name, FileSuffix) {
+			return ""
+		}
+		return resolve(name)
+	}
+	var results []Result
+	for _, test := range compiler.FindTests(source) {
+		if opts.Run != nil && !opts.Run.MatchString(test.Name) {
+			continue
+		}
+		txnFile := defaultTxn
+		for i := test.Line - 2; i >= 0 && strings.HasPrefix(strings.TrimSpace(lines[i]), "//"); i-- {
+			if m := txnDirective.FindStringSubmatch(lines[i]); m != nil {
+				txnFile = path.Join(dir, m[1])
+				break
+			}
+		}
+
+		start := time.Now()
+		result := Result{File: file, Name: test.Name, Line: test.Line}
+		input := compiler.InputDesc{Source: source + entry(test), SourceFile: path.Base(file), SourceDir: dir, CurrentDir: currentDir}
+		runTest(input, txnFile, opts, sources, &result)
+		result.Duration = time.Since(start)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// entry makes the main function calling the test
+func entry(test compiler.TestFunction) string {
+	if test.Void {
+		return fmt.Sprintf("\nfunction logic() {\n    %s()\n    return 1\n}\n", test.Name)
+	}
+	return fmt.Sprintf("\nfunction logic() {\n    return %s()\n}\n", test.Name)
+}
+
+func runTest(input compiler.InputDesc, txnFile string, opts Options, sources func(string) string, result *Result) {
+	var txn transactions.Transaction
+	var err error
+	if txn, err = dryrun.LoadTxn(txnFile); err != nil {
+		result.Status, result.Message = Fail, fmt.Sprintf("%s: %s", txnFile, err.Error())
+		return
+	}
+	compiled, err := compiler.Compile(input, opts.Compiler)
+	if err != nil {
+		result.Status, result.Message = Fail, compileError(compiled, err)
+		return
+	}
+
+	sb := strings.Builder{}
+	var debugger logic.DebuggerHook
+	var collector *coverage.Collector
+	if opts.Coverage != nil {
+		collector = coverage.NewCollector(compiled)
+		debugger = collector
+	}
+	pass, err := dryrun.RunTxn(compiled.Bytecode, txn, &sb, debugger)
+	if collector != nil {
+		collector.AddTo(opts.Coverage, sources)
+	}
+	result.Trace = compiled.SourceMap.AnnotateTrace(sb.String())
+	switch {
+	case err != nil:
+		result.Status, result.Message = Fail, compiled.SourceMap.AnnotateError(err.Error())
+	case !pass:
+		result.Status, result.Message = Reject, "program rejected the transaction"
+	default:
+		result.Status = Pass
+	}
+}
+
+func compileError(result *compiler.Result, err error) string {
+	if result == nil || len(result.Diagnostics) == 0 {
+		return err.Error()
+	}
+	messages := make([]string, 0, len(result.Diagnostics))
+	for _, e := range result.Diagnostics {
+		messages = append(messages, e.String())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Failed tells if any test did not pass
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status != Pass {
+			return true
+		}
+	}
+	return false
+}
+
+// Report prints results in "go test" style, traces are printed for failed tests only.
+// Passed tests are listed in verbose mode
+func Report(w io.Writer, results []Result, verbose bool) {
+	for _, r := range results {
+		if r.Status == Pass && !verbose {
+			continue
+		}
+		fmt.Fprintf(w, "--- %s: %s (%s:%d) (%.2fs)\n", r.Status, r.Name, r.File, r.Line, r.Duration.Seconds())
+		if r.Status == Pass {
+			continue
+		}
+		fmt.Fprintln(w, indent(r.Message, "    "))
+		if r.Trace != "" {
+			fmt.Fprintln(w, "    trace:")
+			fmt.Fprintln(w, indent(strings.TrimRight(r.Trace, "\n"), "    "))
+		}
+	}
+
+	counts := make(map[Status]int)
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	summary := fmt.Sprintf("%d passed, %d failed, %d rejected", counts[Pass], counts[Fail], counts[Reject])
+	if Failed(results) {
+		fmt.Fprintf(w, "FAIL\t%s\n", summary)
+	} else {
+		fmt.Fprintf(w, "ok\t%s\n", summary)
+	}
+}
+
+func indent(text string, prefix string) string {
+	return prefix + strings.ReplaceAll(text, "\n", "\n"+prefix)
+}
+
+//--------------------------------------------------------------------------------------------------
+//
+// JUnit report
+//
+//--------------------------------------------------------------------------------------------------
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes results as JUnit XML report with a test suite per file,
+// both failed and rejected tests are reported as failures
+func WriteJUnit(w io.Writer, results []Result) error {
+	var report junitSuites
+	suites := make(map[string]int)
+	var durations []time.Duration
+	for _, r := range results {
+		idx, ok := suites[r.File]
+		if !ok {
+			idx = len(report.Suites)
+			suites[r.File] = idx
+			report.Suites = append(report.Suites, junitSuite{Name: r.File})
+			durations = append(durations, 0)
+		}
+		suite := &report.Suites[idx]
+		tc := junitCase{Name: r.Name, ClassName: r.File, Time: seconds(r.Duration)}
+		if r.Status != Pass {
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: r.Message, Type: strings.ToLower(string(r.Status)), Text: r.Trace}
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+		durations[idx] += r.Duration
+	}
+	for i := range report.Suites {
+		report.Suites[i].Time = seconds(durations[i])
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
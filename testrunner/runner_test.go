@@ -0,0 +1,136 @@
+package testrunner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzbitskiy/tealang/coverage"
+)
+
+func TestRunFile(t *testing.T) {
+	a := require.New(t)
+
+	dir, err := ioutil.TempDir("", "tealang-test")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	source := `const fee = 2500
+
+test function approves() {
+    return 1
+}
+
+test function rejects() {
+    return 0
+}
+
+test function fails() void {
+    assert(txn.Fee == fee)
+    return
+}
+
+// fee is changed by the fixture
+// tealang:txn fixtures/fee.json
+test function fixture() {
+    return txn.Fee == fee
+}
+
+test function uses_companion() {
+    return txn.Amount == 10
+}
+
+function test(x) {
+    return x
+}
+
+test function broken() {
+    return test(unknown)
+}
+`
+	file := path.Join(dir, "sample_test.tl")
+	a.NoError(ioutil.WriteFile(file, []byte(source), 0644))
+
+	sample, err := ioutil.ReadFile("../dryrun/sampletxn.json")
+	a.NoError(err)
+	a.NoError(os.Mkdir(path.Join(dir, "fixtures"), 0755))
+	fee := strings.Replace(string(sample), `"Fee": 1000`, `"Fee": 2500`, 1)
+	a.NoError(ioutil.WriteFile(path.Join(dir, "fixtures", "fee.json"), []byte(fee), 0644))
+	amount := strings.Replace(string(sample), `"Amount": 2000000`, `"Amount": 10`, 1)
+	a.NoError(ioutil.WriteFile(path.Join(dir, "sample_test.json"), []byte(amount), 0644))
+
+	files, err := FindFiles([]string{dir})
+	a.NoError(err)
+	a.Equal([]string{file}, files)
+
+	results, err := RunFile(file, Options{})
+	a.NoError(err)
+	statuses := make(map[string]Status)
+	for _, r := range results {
+		statuses[r.Name] = r.Status
+	}
+	expected := map[string]Status{
+		"approves": Pass, "rejects": Reject, "fails": Fail, "fixture": Pass, "uses_companion": Pass, "broken": Fail,
+	}
+	a.Equal(expected, statuses)
+	a.True(Failed(results))
+
+	for _, r := range results {
+		switch r.Name {
+		case "fails":
+			a.Contains(r.Message, "sample_test.tl:12")
+			a.Contains(r.Trace, "// sample_test.tl:12")
+		case "broken":
+			a.Contains(r.Message, "unknown")
+		}
+	}
+
+	var out bytes.Buffer
+	Report(&out, results, false)
+	a.NotContains(out.String(), "--- PASS")
+	a.Contains(out.String(), "--- REJECT: rejects ("+file+":7)")
+	a.Contains(out.String(), "trace:")
+	a.Contains(out.String(), "FAIL\t3 passed, 2 failed, 1 rejected")
+
+	out.Reset()
+	a.NoError(WriteJUnit(&out, results))
+	a.Contains(out.String(), `<testsuite name="`+file+`" tests="6" failures="3"`)
+	a.Contains(out.String(), `<failure message="program rejected the transaction" type="reject">`)
+
+	results, err = RunFile(file, Options{Run: regexp.MustCompile("^app")})
+	a.NoError(err)
+	a.Len(results, 1)
+	a.False(Failed(results))
+}
+
+func TestRunExamples(t *testing.T) {
+	a := require.New(t)
+
+	files, err := FindFiles([]string{"../examples"})
+	a.NoError(err)
+	a.NotEmpty(files)
+	profile := coverage.NewProfile()
+	for _, file := range files {
+		results, err := RunFile(file, Options{Coverage: profile})
+		a.NoError(err)
+		a.NotEmpty(results, file)
+		var out bytes.Buffer
+		Report(&out, results, true)
+		a.False(Failed(results), out.String())
+	}
+
+	// modules under test are covered, test files are not
+	module, err := filepath.Abs("../examples/mymodule.tl")
+	a.NoError(err)
+	a.Contains(profile.Files, module)
+	for file := range profile.Files {
+		a.False(strings.HasSuffix(file, FileSuffix), file)
+	}
+}